@@ -0,0 +1,97 @@
+// Команда migrate применяет, откатывает и показывает миграции схемы Postgres.
+//
+//	go run ./cmd/migrate -d "host=localhost port=5432 user=postgres password=example dbname=godb sslmode=disable" up
+//
+// Строка подключения берется так же, как у сервера: флаг -d, файл конфигурации или DATABASE_DSN.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/theheadmen/urlShort/internal/dbconnector/migrations"
+	"github.com/theheadmen/urlShort/internal/logger"
+	config "github.com/theheadmen/urlShort/internal/serverconfig"
+
+	_ "github.com/lib/pq"
+)
+
+const usage = "usage: migrate [flags] up|down|status"
+
+func main() {
+	configStore := config.NewConfigStore()
+	configStore.ParseFlags()
+
+	if err := logger.Initialize(configStore.FlagLogLevel); err != nil {
+		panic(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, configStore.FlagDB, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run выполняет одну команду миграций.
+func run(ctx context.Context, psqlInfo string, args []string) error {
+	if len(args) != 1 {
+		return errors.New(usage)
+	}
+	if psqlInfo == "" {
+		return errors.New("database dsn is not set")
+	}
+
+	db, err := sql.Open("postgres", psqlInfo)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrations.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migrations\n", applied)
+	case "down":
+		reverted, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %d_%s\n", reverted.Version, reverted.Name)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05 -0700")
+			}
+			fmt.Fprintf(writer, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return writer.Flush()
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
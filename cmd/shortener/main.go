@@ -3,20 +3,31 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/theheadmen/urlShort/internal/analytics"
+	"github.com/theheadmen/urlShort/internal/auth"
 	"github.com/theheadmen/urlShort/internal/dbconnector"
+	"github.com/theheadmen/urlShort/internal/deletion"
+	"github.com/theheadmen/urlShort/internal/janitor"
 	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/metrics"
 	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/ratelimit"
 	"github.com/theheadmen/urlShort/internal/serverapi"
 	config "github.com/theheadmen/urlShort/internal/serverconfig"
 	"github.com/theheadmen/urlShort/internal/storage"
 	"github.com/theheadmen/urlShort/internal/storage/database"
 	"github.com/theheadmen/urlShort/internal/storage/file"
+	"github.com/theheadmen/urlShort/internal/storage/memory"
+	"github.com/theheadmen/urlShort/internal/tracing"
 	"go.uber.org/zap"
 )
 
@@ -41,19 +52,96 @@ func main() {
 	if err := logger.Initialize(configStore.FlagLogLevel); err != nil {
 		panic(err)
 	}
-	logger.Log.Info("Running server", zap.String("address", configStore.FlagRunAddr), zap.String("short address", configStore.FlagShortRunAddr), zap.String("file", configStore.FlagFile), zap.String("db", configStore.FlagDB))
-	dbConnector, err := dbconnector.NewDBConnector(ctx, configStore.FlagDB)
+	logger.Log.Info("Running server", zap.String("address", configStore.FlagRunAddr), zap.String("short address", configStore.FlagShortRunAddr), zap.String("file", configStore.FlagFile), zap.String("db", configStore.FlagDB), zap.String("storage", configStore.FlagStorage))
+	storager, err := newStorage(ctx, configStore)
 	if err != nil {
-		logger.Log.Debug("Can't open stable connection with DB", zap.String("error", err.Error()))
+		logger.Log.Error("Can't create storage", zap.String("storage", configStore.FlagStorage), zap.Error(err))
+		return
 	}
-	var storager storage.Storage
-	if dbConnector != nil {
-		storager = database.NewDatabaseStorage(make(map[storage.URLMapKey]models.SavedURL), dbConnector, ctx)
-	} else {
-		storager = file.NewFileStorage(configStore.FlagFile, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+
+	tracer, err := newTracer(configStore)
+	if err != nil {
+		logger.Log.Error("Can't create tracer", zap.String("exporter", configStore.FlagTraceExporter), zap.Error(err))
+		return
 	}
+	if tracer != nil {
+		tracing.SetTracer(tracer)
+	}
+
+	// все хранилища отдают одинаковые метрики и спаны через декораторы, исходное хранилище нужно для Close и компактификации
+	registry := metrics.NewRegistry()
+	rawStorager := storager
+	storager = metrics.NewStorage(tracing.NewStorage(rawStorager), registry)
+
+	// фоновая очистка просроченных ссылок, останавливается вместе с основным контекстом
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		janitor.Run(ctx, storager, configStore.FlagJanitorInterval, configStore.FlagPurgeAfter)
+	}()
+
+	// запись переходов останавливается после остановки сервера, чтобы дописать последние клики
+	recorderCtx, stopRecorder := context.WithCancel(context.Background())
+	recorder := analytics.NewRecorder(storager, analytics.DefaultBufferSize)
+	recorderDone := make(chan struct{})
+	go func() {
+		defer close(recorderDone)
+		recorder.Run(recorderCtx)
+	}()
 
-	router := serverapi.MakeChiServ(configStore, storager)
+	// задачи удаления принимаются до остановки сервера, а оставшиеся в очереди дообрабатываются после нее
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	deleteQueue := deletion.NewQueue(storager, configStore.FlagDeleteWorkers, deletion.DefaultBufferSize)
+	jobsDone := make(chan struct{})
+	go func() {
+		defer close(jobsDone)
+		deleteQueue.Run(jobsCtx)
+	}()
+
+	// компактификация файла хранилища по порогам и по таймеру
+	if fileStorager, ok := rawStorager.(*file.FileStorage); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fileStorager.RunCompaction(ctx, configStore.FlagCompactInterval)
+		}()
+	}
+
+	opts := []serverapi.Option{serverapi.WithClickRecorder(recorder), serverapi.WithMetrics(registry), serverapi.WithDeleteQueue(deleteQueue)}
+	if configStore.FlagJWTKeys != "" || configStore.FlagJWTKeyFile != "" {
+		keys, err := auth.LoadKeySet(configStore.FlagJWTKeys, configStore.FlagJWTKeyFile)
+		if err != nil {
+			logger.Log.Error("Can't load jwt keys", zap.Error(err))
+			return
+		}
+		logger.Log.Info("Cookies are signed with configured key", zap.String("kid", keys.ActiveKeyID()))
+		opts = append(opts, serverapi.WithSigningKeys(keys))
+	} else {
+		logger.Log.Warn("JWT keys are not configured, cookies are signed with a random key and won't survive restart")
+	}
+	if configStore.FlagRateLimits != "" {
+		policies, err := ratelimit.ParsePolicies(configStore.FlagRateLimits)
+		if err != nil {
+			logger.Log.Error("Can't parse rate limits", zap.Error(err))
+			return
+		}
+		proxies, err := ratelimit.ParseTrustedProxies(configStore.FlagTrustedProxies)
+		if err != nil {
+			logger.Log.Error("Can't parse trusted proxies", zap.Error(err))
+			return
+		}
+		opts = append(opts, serverapi.WithRateLimiter(ratelimit.NewLimiter(ratelimit.NewMemoryBackend(), policies, proxies)))
+	}
+	if configStore.FlagTrustedSubnet != "" {
+		_, subnet, err := net.ParseCIDR(configStore.FlagTrustedSubnet)
+		if err != nil {
+			logger.Log.Error("Can't parse trusted subnet", zap.Error(err))
+			return
+		}
+		opts = append(opts, serverapi.WithTrustedSubnet(subnet))
+	}
+	router := serverapi.MakeChiServ(configStore, storager, opts...)
 
 	server := &http.Server{
 		Addr:    configStore.FlagRunAddr,
@@ -83,6 +171,81 @@ func main() {
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		logger.Log.Info("Server forced to shutdown", zap.String("error", err.Error()))
 	}
+	wg.Wait()
+	stopJobs()
+	<-jobsDone
+	stopRecorder()
+	<-recorderDone
+
+	// сбрасываем на диск все, что еще не успело туда попасть
+	if closer, ok := rawStorager.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Log.Error("Failed to close storage", zap.Error(err))
+		}
+	}
+
+	// отправляем спаны, накопившиеся к моменту остановки
+	if tracer != nil {
+		if err := tracer.Shutdown(shutdownCtx); err != nil {
+			logger.Log.Error("Failed to shutdown tracer", zap.Error(err))
+		}
+	}
 
 	logger.Log.Info("Server exiting")
 }
+
+// newTracer создает Tracer с экспортером из FlagTraceExporter.
+// Если экспортер не задан, трассировка выключена и возвращается nil.
+func newTracer(configStore *config.ConfigStore) (*tracing.Tracer, error) {
+	switch configStore.FlagTraceExporter {
+	case "":
+		return nil, nil
+	case "stdout":
+		return tracing.NewTracer(tracing.NewStdoutExporter(os.Stdout)), nil
+	case "otlp":
+		return tracing.NewTracer(tracing.NewOTLPExporter(configStore.FlagTraceEndpoint)), nil
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: %s", configStore.FlagTraceExporter)
+	}
+}
+
+// newStorage создает хранилище, выбранное в FlagStorage.
+// Без явного выбора используется база данных, если к ней удалось подключиться, иначе файл.
+func newStorage(ctx context.Context, configStore *config.ConfigStore) (storage.Storage, error) {
+	switch configStore.FlagStorage {
+	case config.StorageMemory:
+		return memory.NewMemoryStorage(), nil
+	case config.StorageFile:
+		return newFileStorage(ctx, configStore)
+	case config.StorageDatabase:
+		dbConnector, err := dbconnector.NewDBConnector(ctx, configStore.FlagDB)
+		if err != nil {
+			return nil, err
+		}
+		return database.NewDatabaseStorage(dbConnector), nil
+	case "":
+		dbConnector, err := dbconnector.NewDBConnector(ctx, configStore.FlagDB)
+		if err != nil {
+			logger.Log.Debug("Can't open stable connection with DB", zap.String("error", err.Error()))
+			return newFileStorage(ctx, configStore)
+		}
+		return database.NewDatabaseStorage(dbConnector), nil
+	default:
+		return nil, fmt.Errorf("unknown storage: %s", configStore.FlagStorage)
+	}
+}
+
+// newFileStorage создает файловое хранилище с политикой fsync и порогами компактификации из конфигурации.
+func newFileStorage(ctx context.Context, configStore *config.ConfigStore) (*file.FileStorage, error) {
+	syncPolicy, err := file.ParseSyncPolicy(configStore.FlagFsync)
+	if err != nil {
+		return nil, err
+	}
+	storager := file.NewFileStorage(configStore.FlagFile, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	storager.SetSyncPolicy(syncPolicy, configStore.FlagFsyncInterval)
+	policy := file.DefaultCompactionPolicy
+	policy.MaxStaleRatio = configStore.FlagCompactRatio
+	policy.MaxFileSize = configStore.FlagCompactMaxSize
+	storager.SetCompactionPolicy(policy)
+	return storager, nil
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"compress/gzip"
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -252,3 +254,58 @@ func BenchmarkTestCompressWithoutAcceptResponse(t *testing.B) {
 		resp.Body.Close()
 	}
 }
+
+// benchStorageSizes размеры хранилища, на которых видно, что время чтения не зависит от количества записей.
+var benchStorageSizes = []int{1000, 100000}
+
+// benchUsers количество пользователей, между которыми распределяются записи.
+const benchUsers = 100
+
+// newBenchFileStorage создает FileStorage с count записями, распределенными между benchUsers пользователями.
+func newBenchFileStorage(count int) *file.FileStorage {
+	urlMap := make(map[storage.URLMapKey]models.SavedURL, count)
+	for i := 0; i < count; i++ {
+		savedURL := models.SavedURL{
+			UUID:        i,
+			ShortURL:    "code" + strconv.Itoa(i),
+			OriginalURL: "https://example.com/" + strconv.Itoa(i),
+			UserID:      i%benchUsers + 1,
+		}
+		urlMap[storage.URLMapKey{ShortURL: savedURL.ShortURL, UserID: savedURL.UserID}] = savedURL
+	}
+	return file.NewFileStoragerWithoutReadingData("", false /*isWithFile*/, urlMap)
+}
+
+func BenchmarkFileStorageRedirect(b *testing.B) {
+	configStore := NewTestConfigStore()
+	for _, count := range benchStorageSizes {
+		b.Run(strconv.Itoa(count), func(b *testing.B) {
+			b.ReportAllocs()
+			storager := newBenchFileStorage(count)
+			dataStore := serverapi.NewServerDataStore(configStore, storager)
+			handlerFunc := http.HandlerFunc(dataStore.GetHandler)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				req := httptest.NewRequest("GET", "/code"+strconv.Itoa(i%count), nil)
+				recorder := httptest.NewRecorder()
+				handlerFunc.ServeHTTP(recorder, req)
+			}
+		})
+	}
+}
+
+func BenchmarkFileStorageUserURLs(b *testing.B) {
+	ctx := context.Background()
+	for _, count := range benchStorageSizes {
+		b.Run(strconv.Itoa(count), func(b *testing.B) {
+			b.ReportAllocs()
+			storager := newBenchFileStorage(count)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				storager.ReadAllDataForUserID(ctx, i%benchUsers+1, models.URLQuery{})
+			}
+		})
+	}
+}
@@ -1,18 +1,34 @@
 package main
 
 import (
+	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/theheadmen/urlShort/internal/analytics"
+	"github.com/theheadmen/urlShort/internal/auth"
+	"github.com/theheadmen/urlShort/internal/deletion"
+	"github.com/theheadmen/urlShort/internal/metrics"
 	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/ratelimit"
 	"github.com/theheadmen/urlShort/internal/serverapi"
 	config "github.com/theheadmen/urlShort/internal/serverconfig"
 	"github.com/theheadmen/urlShort/internal/storage"
@@ -93,6 +109,260 @@ func TestSimpleHandler(t *testing.T) {
 	}
 }
 
+func TestPostContentNegotiation(t *testing.T) {
+	configStore := NewTestConfigStore()
+	storager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+	ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager))
+	defer ts.Close()
+
+	var multipartBody bytes.Buffer
+	mw := multipart.NewWriter(&multipartBody)
+	require.NoError(t, mw.WriteField("url", "ya.ru"))
+	require.NoError(t, mw.Close())
+
+	testCases := []struct {
+		name                string
+		contentType         string
+		accept              string
+		body                string
+		expectedCode        int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "form_as_sent_by_client",
+			contentType:         "application/x-www-form-urlencoded",
+			body:                url.Values{"url": {"google.com"}}.Encode(),
+			expectedCode:        http.StatusCreated,
+			expectedContentType: "text/html",
+			expectedBody:        "http://localhost:8080/1MnZAnMm",
+		},
+		{
+			name:                "json_body_and_json_response",
+			contentType:         "application/json",
+			accept:              "application/json",
+			body:                `{"url":"yandex.ru"}`,
+			expectedCode:        http.StatusCreated,
+			expectedContentType: "application/json",
+			expectedBody:        `{"result":"http://localhost:8080/eeILJFID"}` + "\n",
+		},
+		{
+			name:                "multipart_body_and_plain_response",
+			contentType:         mw.FormDataContentType(),
+			accept:              "text/plain, application/json;q=0.5",
+			body:                multipartBody.String(),
+			expectedCode:        http.StatusCreated,
+			expectedContentType: "text/plain; charset=utf-8",
+			expectedBody:        "http://localhost:8080/fE54KN4v",
+		},
+		{
+			name:                "plain_body_and_html_response",
+			contentType:         "text/plain; charset=utf-8",
+			accept:              "text/html,application/xhtml+xml,*/*;q=0.8",
+			body:                "google.com",
+			expectedCode:        http.StatusConflict,
+			expectedContentType: "text/html; charset=utf-8",
+			expectedBody:        `<a href="http://localhost:8080/1MnZAnMm">http://localhost:8080/1MnZAnMm</a>`,
+		},
+		{
+			name:         "form_without_url",
+			contentType:  "application/x-www-form-urlencoded",
+			body:         "link=google.com",
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "unsupported_content_type",
+			contentType:  "application/xml",
+			body:         "<url>google.com</url>",
+			expectedCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:         "unacceptable_response",
+			accept:       "image/png",
+			body:         "google.com",
+			expectedCode: http.StatusNotAcceptable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/", strings.NewReader(tc.body))
+			require.NoError(t, err)
+			req.AddCookie(serverapi.GetTestCookie())
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+			if tc.expectedBody != "" {
+				assert.Equal(t, tc.expectedContentType, resp.Header.Get("Content-Type"))
+				assert.Equal(t, tc.expectedBody, string(body), "Тело ответа не совпадает с ожидаемым")
+			}
+		})
+	}
+}
+
+func TestBatchImport(t *testing.T) {
+	configStore := NewTestConfigStore()
+	storager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+	ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager))
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("yandex.ru"), serverapi.GetTestCookie())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	importBatch := func(contentType string, body string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/shorten/batch", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", contentType)
+		req.AddCookie(serverapi.GetTestCookie())
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(respBody)
+	}
+
+	t.Run("ndjson", func(t *testing.T) {
+		resp, body := importBatch("application/x-ndjson", strings.Join([]string{
+			`{"correlation_id":"1","original_url":"google.com"}`,
+			`{"correlation_id":"2","original_url":"google.com"}`,
+			``,
+			`{"correlation_id":"3","original_url":"yandex.ru"}`,
+			`{"correlation_id":"4","original_url":""}`,
+			`{"correlation_id":"5","original_url":`,
+			`{"correlation_id":"6","original_url":"ya.ru","ttl_seconds":-1}`,
+		}, "\n"))
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+		lines := strings.Split(strings.TrimSpace(body), "\n")
+		require.Len(t, lines, 6)
+		var results []models.BatchItemResult
+		for _, line := range lines {
+			var result models.BatchItemResult
+			require.NoError(t, json.Unmarshal([]byte(line), &result))
+			results = append(results, result)
+		}
+		assert.Equal(t, models.BatchItemResult{CorrelationID: "1", ShortURL: "http://localhost:8080/1MnZAnMm", Status: models.BatchItemCreated}, results[0])
+		assert.Equal(t, models.BatchItemResult{CorrelationID: "2", ShortURL: "http://localhost:8080/1MnZAnMm", Status: models.BatchItemConflict}, results[1])
+		assert.Equal(t, models.BatchItemResult{CorrelationID: "3", ShortURL: "http://localhost:8080/eeILJFID", Status: models.BatchItemConflict}, results[2])
+		for _, result := range results[3:] {
+			assert.Equal(t, models.BatchItemInvalid, result.Status)
+			assert.NotEmpty(t, result.Error)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		resp, body := importBatch("text/csv", "original_url,correlation_id\nya.ru,a\ngoogle.com,b\n,c\n")
+		require.Equal(t, http.StatusOK, resp.StatusCode, body)
+		assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+		assert.Equal(t, "correlation_id,short_url,status,error\n"+
+			"a,http://localhost:8080/fE54KN4v,created,\n"+
+			"b,http://localhost:8080/1MnZAnMm,conflict,\n"+
+			"c,,invalid,url is empty\n", body)
+
+		resp, _ = importBatch("text/csv", "correlation_id,url\n1,google.com\n")
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	})
+
+	t.Run("chunks", func(t *testing.T) {
+		var input strings.Builder
+		const count = 2500
+		for i := 0; i < count; i++ {
+			fmt.Fprintf(&input, "{\"correlation_id\":\"%d\",\"original_url\":\"https://example.com/%d\"}\n", i, i)
+		}
+		resp, body := importBatch("application/x-ndjson", input.String())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, count, strings.Count(body, `"status":"created"`))
+
+		urls, err := storager.ReadAllDataForUserID(context.Background(), 1, models.URLQuery{})
+		require.NoError(t, err)
+		assert.Len(t, urls, count+3)
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	configStore := NewTestConfigStore()
+	storager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+	policies, err := ratelimit.ParsePolicies("shorten=2/1m")
+	require.NoError(t, err)
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryBackend(), policies, nil)
+	ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager, serverapi.WithRateLimiter(limiter)))
+	defer ts.Close()
+
+	for i, remaining := range []string{"1", "0"} {
+		resp, _ := testRequest(t, ts, http.MethodPost, "/", strings.NewReader(fmt.Sprintf("https://example.com/%d", i)), serverapi.GetTestCookie())
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+		assert.Equal(t, remaining, resp.Header.Get("RateLimit-Remaining"))
+	}
+	resp, _ := testRequest(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com/2"}`), serverapi.GetTestCookie())
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "30", resp.Header.Get("Retry-After"))
+	assert.Equal(t, "60", resp.Header.Get("RateLimit-Reset"))
+
+	// у запросов без куки лимит по адресу, и для отклоненных пользователь не создается
+	for i := 0; i < 2; i++ {
+		resp, _ := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("google.com"), nil)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	resp, _ = testRequest(t, ts, http.MethodPost, "/", strings.NewReader("google.com"), nil)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Empty(t, resp.Cookies(), "Отклоненному запросу не должна выдаваться кука")
+
+	// для групп без лимита заголовков нет
+	resp, _ = testRequest(t, ts, http.MethodGet, "/api/user/urls", nil, serverapi.GetTestCookie())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("RateLimit-Limit"))
+}
+
+func TestRateLimitIgnoresUnknownAPIKeys(t *testing.T) {
+	configStore := NewTestConfigStore()
+	storager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+	policies, err := ratelimit.ParsePolicies("redirect=2/1m,shorten=2/1m")
+	require.NoError(t, err)
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryBackend(), policies, nil)
+	ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager, serverapi.WithRateLimiter(limiter)))
+	defer ts.Close()
+
+	client := ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	// каждый запрос с новым выдуманным ключом правильного формата, лимит все равно считается по адресу
+	request := func(method, path string) *http.Response {
+		id := make([]byte, 8)
+		_, err := rand.Read(id)
+		require.NoError(t, err)
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader("google.com"))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer sk_"+hex.EncodeToString(id)+"_x")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	for i := 0; i < 2; i++ {
+		assert.NotEqual(t, http.StatusTooManyRequests, request(http.MethodGet, "/1MnZAnMm").StatusCode)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, request(http.MethodGet, "/1MnZAnMm").StatusCode)
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/").StatusCode)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, request(http.MethodPost, "/").StatusCode)
+}
+
 func TestJsonPost(t *testing.T) {
 	configStore := NewTestConfigStore()
 
@@ -220,6 +490,14 @@ func TestJsonBatchPost(t *testing.T) {
 			expectedCode: http.StatusCreated,
 			expectedBody: `[{"correlation_id":"u1","short_url":"http://localhost:8080/1MnZAnMm"},{"correlation_id":"u2","short_url":"http://localhost:8080/fE54KN4v"}]`,
 		},
+		{
+			// JSON-массив сохраняется целиком или не сохраняется вовсе, статусы по URL есть только у NDJSON и CSV
+			name:         "method_post_empty_url",
+			method:       http.MethodPost,
+			body:         `[{"correlation_id":"u1","original_url":""},{"correlation_id":"u2","original_url":"yandex.ru"}]`,
+			expectedCode: http.StatusUnprocessableEntity,
+			expectedBody: "",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -237,6 +515,762 @@ func TestJsonBatchPost(t *testing.T) {
 	}
 }
 
+func TestCustomAlias(t *testing.T) {
+	configStore := NewTestConfigStore()
+
+	storager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+	ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager))
+	defer ts.Close()
+
+	testCases := []struct {
+		name         string
+		path         string
+		body         string
+		cookie       *http.Cookie
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "alias_created",
+			path:         "/api/shorten",
+			body:         `{"url": "https://example.com/spring", "custom_alias": "spring-sale"}`,
+			cookie:       serverapi.GetTestCookie(),
+			expectedCode: http.StatusCreated,
+			expectedBody: `{"result":"http://localhost:8080/spring-sale"}`,
+		},
+		{
+			name:         "same_user_same_url",
+			path:         "/api/shorten",
+			body:         `{"url": "https://example.com/spring", "custom_alias": "spring-sale"}`,
+			cookie:       serverapi.GetTestCookie(),
+			expectedCode: http.StatusConflict,
+			expectedBody: `{"result":"http://localhost:8080/spring-sale"}`,
+		},
+		{
+			name:         "second_alias_same_url",
+			path:         "/api/shorten",
+			body:         `{"url": "https://example.com/spring", "custom_alias": "spring-promo"}`,
+			cookie:       serverapi.GetTestCookie(),
+			expectedCode: http.StatusConflict,
+			expectedBody: `{"result":"http://localhost:8080/spring-sale"}`,
+		},
+		{
+			name:         "generated_code_after_alias",
+			path:         "/api/shorten",
+			body:         `{"url": "https://example.com/spring"}`,
+			cookie:       serverapi.GetTestCookie(),
+			expectedCode: http.StatusConflict,
+			expectedBody: `{"result":"http://localhost:8080/spring-sale"}`,
+		},
+		{
+			name:         "other_user",
+			path:         "/api/shorten",
+			body:         `{"url": "https://example.com/spring", "custom_alias": "spring-sale"}`,
+			expectedCode: http.StatusConflict,
+		},
+		{
+			name:         "reserved",
+			path:         "/api/shorten",
+			body:         `{"url": "https://example.com/spring", "custom_alias": "ping"}`,
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "invalid_chars",
+			path:         "/api/shorten",
+			body:         `{"url": "https://example.com/spring", "custom_alias": "spring sale"}`,
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "batch_with_alias",
+			path:         "/api/shorten/batch",
+			body:         `[{"correlation_id":"u1","original_url":"https://example.com/summer","custom_alias":"summer-sale"},{"correlation_id":"u2","original_url":"ya.ru"}]`,
+			expectedCode: http.StatusCreated,
+			expectedBody: `[{"correlation_id":"u1","short_url":"http://localhost:8080/summer-sale"},{"correlation_id":"u2","short_url":"http://localhost:8080/fE54KN4v"}]`,
+		},
+		{
+			name:         "batch_alias_taken",
+			path:         "/api/shorten/batch",
+			body:         `[{"correlation_id":"u1","original_url":"https://example.com/other","custom_alias":"spring-sale"}]`,
+			expectedCode: http.StatusConflict,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, get := testRequest(t, ts, http.MethodPost, tc.path, strings.NewReader(tc.body), tc.cookie)
+			get = strings.TrimSuffix(string(get), "\n")
+			defer resp.Body.Close()
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+			if tc.expectedBody != "" {
+				assert.Equal(t, tc.expectedBody, get, "Тело ответа не совпадает с ожидаемым")
+			}
+		})
+	}
+
+	client := ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Get(ts.URL + "/spring-sale")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+	assert.Equal(t, "https://example.com/spring", resp.Header.Get("Location"), "Location не совпадает с ожидаемым")
+}
+
+func TestExpiration(t *testing.T) {
+	configStore := NewTestConfigStore()
+
+	storager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+	dataStore := serverapi.NewServerDataStore(configStore, storager)
+	ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager))
+	defer ts.Close()
+
+	testCases := []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{name: "ttl", body: `{"url": "https://example.com/ttl", "ttl_seconds": 3600}`, expectedCode: http.StatusCreated},
+		{name: "expires_at", body: `{"url": "https://example.com/at", "expires_at": "2100-01-01T00:00:00Z"}`, expectedCode: http.StatusCreated},
+		{name: "negative_ttl", body: `{"url": "https://example.com/neg", "ttl_seconds": -1}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "past_expires_at", body: `{"url": "https://example.com/past", "expires_at": "2000-01-01T00:00:00Z"}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "both", body: `{"url": "https://example.com/both", "ttl_seconds": 10, "expires_at": "2100-01-01T00:00:00Z"}`, expectedCode: http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, _ := testRequest(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(tc.body), nil)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+		})
+	}
+
+	// просроченную ссылку можно получить только в обход API
+	past := time.Now().Add(-time.Minute)
+	_, err := storager.StoreURL(context.Background(), models.SavedURL{ShortURL: "expired", OriginalURL: "https://example.com/expired", UserID: 1, ExpiresAt: &past})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/expired", nil)
+	recorder := httptest.NewRecorder()
+	http.HandlerFunc(dataStore.GetHandler).ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusGone, recorder.Code, "Код ответа не совпадает с ожидаемым")
+
+	count, err := storager.DeleteExpired(context.Background(), time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "Должна быть помечена одна просроченная ссылка")
+}
+
+func TestClickStats(t *testing.T) {
+	configStore := NewTestConfigStore()
+
+	storager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+	recorder := analytics.NewRecorder(storager, analytics.DefaultBufferSize)
+	ctx, cancel := context.WithCancel(context.Background())
+	recorderDone := make(chan struct{})
+	go func() {
+		defer close(recorderDone)
+		recorder.Run(ctx)
+	}()
+
+	ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager, serverapi.WithClickRecorder(recorder)))
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("google.com"), serverapi.GetTestCookie())
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	// второй владелец того же короткого URL, редирект обслуживает первый
+	resp, _ = testRequest(t, ts, http.MethodPost, "/", strings.NewReader("google.com"), nil)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.NotEmpty(t, resp.Cookies(), "Новому пользователю должна выдаваться кука")
+	coOwnerCookie := resp.Cookies()[0]
+
+	client := ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(ts.URL + "/1MnZAnMm")
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	}
+
+	// останавливаем запись, чтобы все переходы гарантированно попали в хранилище
+	cancel()
+	<-recorderDone
+
+	resp, get := testRequest(t, ts, http.MethodGet, "/api/user/urls/1MnZAnMm/stats", nil, serverapi.GetTestCookie())
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+
+	var stats models.ClickStats
+	require.NoError(t, json.Unmarshal([]byte(get), &stats))
+	assert.Equal(t, 3, stats.TotalClicks, "Неверное количество переходов")
+	assert.Equal(t, 1, stats.UniqueVisitors, "Неверное количество уникальных посетителей")
+	require.Len(t, stats.Daily, 1, "Все переходы должны попасть в один день")
+	assert.Equal(t, 3, stats.Daily[0].Clicks)
+
+	resp, get = testRequest(t, ts, http.MethodGet, "/api/user/urls/1MnZAnMm/stats", nil, coOwnerCookie)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+	require.NoError(t, json.Unmarshal([]byte(get), &stats))
+	assert.Equal(t, 0, stats.TotalClicks, "Совладелец не должен видеть чужие переходы")
+
+	resp, _ = testRequest(t, ts, http.MethodGet, "/api/user/urls/unknown/stats", nil, serverapi.GetTestCookie())
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Статистика чужих ссылок не должна отдаваться")
+}
+
+func TestUserURLsPagination(t *testing.T) {
+	configStore := NewTestConfigStore()
+	storager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+	ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager))
+	defer ts.Close()
+
+	for _, url := range []string{"google.com", "yandex.ru", "ya.ru"} {
+		resp, _ := testRequest(t, ts, http.MethodPost, "/", strings.NewReader(url), serverapi.GetTestCookie())
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	// страницы по две ссылки в порядке короткого кода: 1MnZAnMm, eeILJFID, fE54KN4v
+	var pages [][]models.BatchByUserIDResponse
+	path := "/api/user/urls?limit=2&sort=short_url"
+	for path != "" {
+		resp, body := testRequest(t, ts, http.MethodGet, path, nil, serverapi.GetTestCookie())
+		require.Equal(t, http.StatusOK, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+		var page []models.BatchByUserIDResponse
+		require.NoError(t, json.Unmarshal([]byte(body), &page))
+		pages = append(pages, page)
+
+		path = ""
+		if link := resp.Header.Get("Link"); link != "" {
+			require.True(t, strings.HasSuffix(link, `>; rel="next"`), link)
+			path = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+			assert.Contains(t, path, "sort=short_url", "Параметры запроса должны сохраняться в ссылке")
+		}
+	}
+	require.Len(t, pages, 2)
+	require.Len(t, pages[0], 2)
+	require.Len(t, pages[1], 1)
+	assert.Equal(t, "http://localhost:8080/1MnZAnMm", pages[0][0].ShortURL)
+	assert.Equal(t, "http://localhost:8080/eeILJFID", pages[0][1].ShortURL)
+	assert.Equal(t, "http://localhost:8080/fE54KN4v", pages[1][0].ShortURL)
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/api/user/urls?q=yandex", nil, serverapi.GetTestCookie())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `[{"short_url":"http://localhost:8080/eeILJFID","original_url":"yandex.ru"}]`, body)
+	assert.Empty(t, resp.Header.Get("Link"))
+
+	for _, query := range []string{"limit=0", "limit=abc", "sort=name", "order=up", "deleted=maybe", "cursor=!!!"} {
+		resp, _ := testRequest(t, ts, http.MethodGet, "/api/user/urls?"+query, nil, serverapi.GetTestCookie())
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+}
+
+func TestDeleteJobs(t *testing.T) {
+	configStore := NewTestConfigStore()
+	storager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+	queue := deletion.NewQueue(storager, 1, deletion.DefaultBufferSize)
+	ctx, cancel := context.WithCancel(context.Background())
+	queueDone := make(chan struct{})
+	go func() {
+		defer close(queueDone)
+		queue.Run(ctx)
+	}()
+	ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager, serverapi.WithDeleteQueue(queue)))
+	defer ts.Close()
+
+	for _, url := range []string{"google.com", "yandex.ru"} {
+		resp, _ := testRequest(t, ts, http.MethodPost, "/", strings.NewReader(url), serverapi.GetTestCookie())
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	resp, body := testRequest(t, ts, http.MethodDelete, "/api/user/urls", strings.NewReader(`["1MnZAnMm","eeILJFID","unknown"]`), serverapi.GetTestCookie())
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var job models.DeleteJob
+	require.NoError(t, json.Unmarshal([]byte(body), &job))
+	assert.Equal(t, 3, job.Total)
+	assert.Equal(t, "/api/user/jobs/"+job.ID, resp.Header.Get("Location"))
+
+	// остановка очереди дожидается обработки принятых задач
+	cancel()
+	<-queueDone
+
+	resp, body = testRequest(t, ts, http.MethodGet, resp.Header.Get("Location"), nil, serverapi.GetTestCookie())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.Unmarshal([]byte(body), &job))
+	assert.Equal(t, models.DeleteJobDone, job.Status)
+	assert.Equal(t, 2, job.Deleted)
+	assert.Equal(t, 1, job.Skipped)
+
+	resp, _ = testRequest(t, ts, http.MethodGet, "/api/user/jobs/unknown", nil, serverapi.GetTestCookie())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = testRequest(t, ts, http.MethodDelete, "/api/user/urls", strings.NewReader(`["1MnZAnMm"]`), serverapi.GetTestCookie())
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "Остановленная очередь не принимает задачи")
+
+	// без очереди сервер не запускает собственную, а маршруты удаления не регистрируются
+	withoutQueue := httptest.NewServer(serverapi.MakeChiServ(configStore, storager))
+	defer withoutQueue.Close()
+	resp, _ = testRequest(t, withoutQueue, http.MethodDelete, "/api/user/urls", strings.NewReader(`["1MnZAnMm"]`), serverapi.GetTestCookie())
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestUpdateURL(t *testing.T) {
+	configStore := NewTestConfigStore()
+	storager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+	ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager))
+	defer ts.Close()
+
+	patch := func(body string, ifMatch string, cookie *http.Cookie) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodPatch, ts.URL+"/api/user/urls/1MnZAnMm", strings.NewReader(body))
+		require.NoError(t, err)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		req.AddCookie(cookie)
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(respBody)
+	}
+
+	for _, url := range []string{"google.com", "yandex.ru"} {
+		resp, _ := testRequest(t, ts, http.MethodPost, "/", strings.NewReader(url), serverapi.GetTestCookie())
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	resp, _ := testRequest(t, ts, http.MethodGet, "/api/user/urls/1MnZAnMm", nil, serverapi.GetTestCookie())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	assert.Equal(t, `"0"`, etag)
+
+	resp, body := patch(`{"original_url":"https://www.google.com"}`, etag, serverapi.GetTestCookie())
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.Equal(t, `"1"`, resp.Header.Get("ETag"))
+	assert.JSONEq(t, `{"short_url":"http://localhost:8080/1MnZAnMm","original_url":"https://www.google.com","version":1}`, body)
+
+	// клиент со старой версией не затирает изменение
+	resp, _ = patch(`{"original_url":"https://google.ru"}`, etag, serverapi.GetTestCookie())
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, _ = patch(`{"original_url":"yandex.ru"}`, "", serverapi.GetTestCookie())
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp, _ = patch(`{"original_url":""}`, "", serverapi.GetTestCookie())
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	// чужую ссылку изменить нельзя
+	resp, _ = testRequest(t, ts, http.MethodPost, "/", strings.NewReader("ya.ru"), nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.NotEmpty(t, resp.Cookies(), "Новому пользователю должна выдаваться кука")
+	resp, _ = patch(`{"original_url":"https://evil.example"}`, "", resp.Cookies()[0])
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// общую с другим пользователем ссылку не может изменить ни один из владельцев
+	resp, _ = testRequest(t, ts, http.MethodPost, "/", strings.NewReader("yandex.ru"), nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.NotEmpty(t, resp.Cookies(), "Новому пользователю должна выдаваться кука")
+	for _, cookie := range []*http.Cookie{resp.Cookies()[0], serverapi.GetTestCookie()} {
+		resp, _ = testRequest(t, ts, http.MethodPatch, "/api/user/urls/eeILJFID", strings.NewReader(`{"original_url":"https://evil.example"}`), cookie)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	}
+
+	client := ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	redirect, err := client.Get(ts.URL + "/1MnZAnMm")
+	require.NoError(t, err)
+	redirect.Body.Close()
+	assert.Equal(t, "https://www.google.com", redirect.Header.Get("Location"))
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/api/user/urls/1MnZAnMm/history", nil, serverapi.GetTestCookie())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var edits []models.URLEdit
+	require.NoError(t, json.Unmarshal([]byte(body), &edits))
+	require.Len(t, edits, 1)
+	assert.Equal(t, "google.com", edits[0].PreviousURL)
+	assert.Equal(t, "https://www.google.com", edits[0].OriginalURL)
+	assert.Equal(t, 1, edits[0].UserID)
+	assert.Equal(t, 1, edits[0].Version)
+}
+
+func TestRestoreAndPurge(t *testing.T) {
+	configStore := NewTestConfigStore()
+	storager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+	ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager))
+	defer ts.Close()
+
+	for _, url := range []string{"google.com", "yandex.ru"} {
+		resp, _ := testRequest(t, ts, http.MethodPost, "/", strings.NewReader(url), serverapi.GetTestCookie())
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	_, err := storager.DeleteURLs(context.Background(), []storage.URLMapKey{{ShortURL: "1MnZAnMm", UserID: 1}})
+	require.NoError(t, err)
+
+	resp, body := testRequest(t, ts, http.MethodPost, "/api/user/urls/restore", strings.NewReader(`["1MnZAnMm","eeILJFID","missing","1MnZAnMm"]`), serverapi.GetTestCookie())
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.JSONEq(t, `{"restored":["1MnZAnMm"],"skipped":["eeILJFID","missing"]}`, body)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/api/user/urls/1MnZAnMm", nil, serverapi.GetTestCookie())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"short_url":"http://localhost:8080/1MnZAnMm","original_url":"google.com"}`, body)
+
+	resp, body = testRequest(t, ts, http.MethodPost, "/api/user/urls/purge", strings.NewReader(`["1MnZAnMm","missing"]`), serverapi.GetTestCookie())
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.JSONEq(t, `{"purged":["1MnZAnMm"],"skipped":["missing"]}`, body)
+
+	resp, _ = testRequest(t, ts, http.MethodGet, "/api/user/urls/1MnZAnMm", nil, serverapi.GetTestCookie())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = testRequest(t, ts, http.MethodPost, "/api/user/urls/restore", strings.NewReader(`{"short":"eeILJFID"}`), serverapi.GetTestCookie())
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+}
+
+func TestAdminCompact(t *testing.T) {
+	configStore := NewTestConfigStore()
+	configStore.FlagAdminToken = "secret"
+
+	storager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+	ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager))
+	defer ts.Close()
+
+	testCases := []struct {
+		name         string
+		token        string
+		expectedCode int
+	}{
+		{name: "without_token", token: "", expectedCode: http.StatusForbidden},
+		{name: "wrong_token", token: "wrong", expectedCode: http.StatusForbidden},
+		{name: "success", token: "secret", expectedCode: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/admin/compact", nil)
+			require.NoError(t, err)
+			if tc.token != "" {
+				req.Header.Set("X-Admin-Token", tc.token)
+			}
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+		})
+	}
+}
+
+func TestInternalStats(t *testing.T) {
+	configStore := NewTestConfigStore()
+	storager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+	_, subnet, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+	ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager, serverapi.WithTrustedSubnet(subnet)))
+	defer ts.Close()
+	// без доверенной сети статистика закрыта для всех
+	closed := httptest.NewServer(serverapi.MakeChiServ(configStore, storager))
+	defer closed.Close()
+
+	for _, url := range []string{"google.com", "yandex.ru"} {
+		resp, _ := testRequest(t, ts, http.MethodPost, "/", strings.NewReader(url), nil)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	users, err := storager.CountUsers(context.Background())
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name         string
+		server       *httptest.Server
+		realIP       string
+		expectedCode int
+	}{
+		{name: "trusted", server: ts, realIP: "10.1.2.3", expectedCode: http.StatusOK},
+		{name: "untrusted", server: ts, realIP: "192.168.1.1", expectedCode: http.StatusForbidden},
+		{name: "without_header", server: ts, realIP: "", expectedCode: http.StatusForbidden},
+		{name: "invalid_header", server: ts, realIP: "10.1.2.3, 192.168.1.1", expectedCode: http.StatusForbidden},
+		{name: "without_subnet", server: closed, realIP: "10.1.2.3", expectedCode: http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tc.server.URL+"/api/internal/stats", nil)
+			require.NoError(t, err)
+			if tc.realIP != "" {
+				req.Header.Set("X-Real-IP", tc.realIP)
+			}
+			resp, err := tc.server.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+			assert.Empty(t, resp.Cookies())
+			if tc.expectedCode == http.StatusOK {
+				assert.JSONEq(t, fmt.Sprintf(`{"urls":2,"users":%d}`, users), string(body))
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	configStore := NewTestConfigStore()
+
+	registry := metrics.NewRegistry()
+	rawStorager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+	storager := metrics.NewStorage(rawStorager, registry)
+	ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager, serverapi.WithMetrics(registry)))
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("google.com"), serverapi.GetTestCookie())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	// клиент идет по редиректу на /google.com, которого нет, поэтому 400 будет два раза
+	testRequest(t, ts, http.MethodGet, "/1MnZAnMm", nil, serverapi.GetTestCookie())
+	resp, _ = testRequest(t, ts, http.MethodGet, "/unknown", nil, serverapi.GetTestCookie())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/metrics", nil, serverapi.GetTestCookie())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+
+	// ряды размечены шаблоном маршрута, а не путем запроса
+	assert.Contains(t, body, `shortener_http_requests_total{method="POST",route="/",status="201"} 1`)
+	assert.Contains(t, body, `shortener_http_requests_total{method="GET",route="/{shortUrl}",status="307"} 1`)
+	assert.Contains(t, body, `shortener_http_requests_total{method="GET",route="/{shortUrl}",status="400"} 2`)
+	assert.NotContains(t, body, "1MnZAnMm")
+	assert.Contains(t, body, `shortener_storage_operation_duration_seconds_count{method="StoreURL"} 1`)
+	assert.Contains(t, body, "shortener_urls 1\n")
+	assert.Contains(t, body, "shortener_pending_deletions 0\n")
+}
+
+func TestAuthCookie(t *testing.T) {
+	oldKey := auth.Key{ID: "2025-01", Secret: []byte(strings.Repeat("o", auth.MinSecretLength))}
+	newKey := auth.Key{ID: "2025-02", Secret: []byte(strings.Repeat("n", auth.MinSecretLength))}
+	rotated, err := auth.NewKeySet(newKey, oldKey)
+	require.NoError(t, err)
+	previous, err := auth.NewKeySet(oldKey)
+	require.NoError(t, err)
+	unknown, err := auth.NewKeySet(auth.Key{ID: "2024-12", Secret: []byte(strings.Repeat("u", auth.MinSecretLength))})
+	require.NoError(t, err)
+
+	signUserCookie := func(keys *auth.KeySet, userID string, issuedAt time.Time) *http.Cookie {
+		token, err := keys.Sign(serverapi.UserClaims{UserID: userID, RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(24 * time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+		}})
+		require.NoError(t, err)
+		return &http.Cookie{Name: "token", Value: token}
+	}
+	signCookie := func(keys *auth.KeySet, issuedAt time.Time) *http.Cookie {
+		return signUserCookie(keys, "1", issuedAt)
+	}
+	// так выглядела кука, подписанная раньше зашитым в код секретом
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, serverapi.UserClaims{UserID: "1", RegisteredClaims: jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+	}}).SignedString([]byte("my-jwt-secret-key"))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		lts           bool
+		cookie        *http.Cookie
+		expectedCode  int
+		expectRefresh bool
+		invalid       bool // недействительная кука: запись создает нового пользователя, а маршруты пользователя отвечают 401
+	}{
+		{name: "new_user", cookie: nil, expectedCode: http.StatusCreated, expectRefresh: true},
+		{name: "new_user_https", lts: true, cookie: nil, expectedCode: http.StatusCreated, expectRefresh: true},
+		{name: "fresh_cookie", cookie: signCookie(rotated, time.Now()), expectedCode: http.StatusCreated, expectRefresh: false},
+		{name: "sliding_expiry", cookie: signCookie(rotated, time.Now().Add(-2*time.Hour)), expectedCode: http.StatusCreated, expectRefresh: true},
+		{name: "previous_key", cookie: signCookie(previous, time.Now()), expectedCode: http.StatusCreated, expectRefresh: true},
+		{name: "expired", cookie: signCookie(rotated, time.Now().Add(-25*time.Hour)), expectedCode: http.StatusCreated, expectRefresh: true, invalid: true},
+		{name: "unknown_key", cookie: signCookie(unknown, time.Now()), expectedCode: http.StatusCreated, expectRefresh: true, invalid: true},
+		{name: "forged_without_kid", cookie: &http.Cookie{Name: "token", Value: forged}, expectedCode: http.StatusCreated, expectRefresh: true, invalid: true},
+		{name: "unknown_user", cookie: signUserCookie(rotated, "999", time.Now()), expectedCode: http.StatusCreated, expectRefresh: true, invalid: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			configStore := NewTestConfigStore()
+			configStore.FlagLTS = tc.lts
+			storager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+			ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager, serverapi.WithSigningKeys(rotated)))
+			defer ts.Close()
+
+			if tc.invalid {
+				resp, _ := testRequest(t, ts, http.MethodGet, "/api/user/urls", nil, tc.cookie)
+				assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Маршруты пользователя не должны принимать недействительную куку")
+			}
+
+			resp, _ := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("google.com"), tc.cookie)
+			assert.Equal(t, tc.expectedCode, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+
+			var issued *http.Cookie
+			for _, cookie := range resp.Cookies() {
+				if cookie.Name == "token" {
+					issued = cookie
+				}
+			}
+			if !tc.expectRefresh {
+				assert.Nil(t, issued)
+				return
+			}
+			require.NotNil(t, issued)
+			assert.True(t, issued.HttpOnly)
+			assert.Equal(t, tc.lts, issued.Secure)
+			assert.Equal(t, http.SameSiteLaxMode, issued.SameSite)
+			assert.Equal(t, "/", issued.Path)
+			assert.WithinDuration(t, time.Now().Add(24*time.Hour), issued.Expires, time.Minute)
+
+			// перевыпущенная кука подписана активным ключом
+			claims := &serverapi.UserClaims{}
+			token, err := rotated.Parse(issued.Value, claims)
+			require.NoError(t, err)
+			assert.Equal(t, newKey.ID, auth.KeyID(token))
+			if tc.invalid {
+				assert.NotEqual(t, "1", claims.UserID, "Вместо недействительной куки выдается кука нового пользователя")
+				assert.NotEqual(t, "999", claims.UserID, "Вместо недействительной куки выдается кука нового пользователя")
+			}
+		})
+	}
+}
+
+func TestAuthModes(t *testing.T) {
+	configStore := NewTestConfigStore()
+	storager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+	// очередь не запускается: запросы на удаление отклоняются до постановки в нее
+	queue := deletion.NewQueue(storager, 1, deletion.DefaultBufferSize)
+	ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager, serverapi.WithDeleteQueue(queue)))
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, http.MethodPost, "/", strings.NewReader("google.com"), serverapi.GetTestCookie())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	users, err := storager.CountUsers(context.Background())
+	require.NoError(t, err)
+
+	client := ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	// переходы и служебные маршруты не создают пользователей и не выдают куки
+	for _, path := range []string{"/1MnZAnMm", "/unknown", "/ping"} {
+		resp, err := client.Get(ts.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Empty(t, resp.Cookies(), path)
+	}
+
+	// маршрутам пользователя нужна кука
+	for _, tc := range []struct{ method, path string }{
+		{http.MethodGet, "/api/user/urls"},
+		{http.MethodGet, "/api/user/urls/1MnZAnMm"},
+		{http.MethodDelete, "/api/user/urls"},
+		{http.MethodPost, "/api/user/keys"},
+	} {
+		resp, _ := testRequest(t, ts, tc.method, tc.path, strings.NewReader(`["1MnZAnMm"]`), nil)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, tc.method+" "+tc.path)
+		assert.Empty(t, resp.Cookies(), tc.method+" "+tc.path)
+	}
+
+	// запрос, который ничего не сохранил, пользователя не создает
+	resp, _ = testRequest(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(`{"url":""}`), nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Empty(t, resp.Cookies())
+
+	after, err := storager.CountUsers(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, users, after, "Пользователи не должны создаваться без записи")
+
+	// пользователь создается при первой записи
+	resp, _ = testRequest(t, ts, http.MethodPost, "/", strings.NewReader("yandex.ru"), nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.NotEmpty(t, resp.Cookies(), "Новому пользователю должна выдаваться кука")
+	after, err = storager.CountUsers(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, users+1, after)
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/api/user/urls", nil, resp.Cookies()[0])
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "eeILJFID")
+}
+
+func TestAPIKeys(t *testing.T) {
+	configStore := NewTestConfigStore()
+	storager := file.NewFileStoragerWithoutReadingData(configStore.FlagFile, false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+	// очередь не запускается: запросы на удаление отклоняются до постановки в нее
+	queue := deletion.NewQueue(storager, 1, deletion.DefaultBufferSize)
+	ts := httptest.NewServer(serverapi.MakeChiServ(configStore, storager, serverapi.WithDeleteQueue(queue)))
+	defer ts.Close()
+
+	bearerRequest := func(method, path, key string, body io.Reader) (*http.Response, string) {
+		req, err := http.NewRequest(method, ts.URL+path, body)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(respBody)
+	}
+
+	resp, body := testRequest(t, ts, http.MethodPost, "/api/user/keys", strings.NewReader(`{"name":"backend","scopes":["write"]}`), serverapi.GetTestCookie())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created models.APIKeyResponse
+	require.NoError(t, json.Unmarshal([]byte(body), &created))
+	assert.Equal(t, "backend", created.Name)
+	assert.Equal(t, []string{"write"}, created.Scopes)
+	require.NotEmpty(t, created.Key)
+
+	resp, _ = testRequest(t, ts, http.MethodPost, "/api/user/keys", strings.NewReader(`{"scopes":["admin"]}`), serverapi.GetTestCookie())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// ключ работает без куки и принадлежит тому же пользователю, что и кука
+	resp, _ = bearerRequest(http.MethodPost, "/api/shorten/batch", created.Key, strings.NewReader(`[{"correlation_id":"1","original_url":"yandex.ru"}]`))
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Empty(t, resp.Cookies(), "Запросам с ключом API кука не нужна")
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/api/user/urls", nil, serverapi.GetTestCookie())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "eeILJFID")
+
+	// области действия ключа ограничены записью
+	resp, _ = bearerRequest(http.MethodGet, "/api/user/urls", created.Key, nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, _ = bearerRequest(http.MethodDelete, "/api/user/urls", created.Key, strings.NewReader(`["eeILJFID"]`))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	// управлять ключами можно только с кукой
+	resp, _ = bearerRequest(http.MethodGet, "/api/user/keys", created.Key, nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/api/user/keys", nil, serverapi.GetTestCookie())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var listed []models.APIKeyResponse
+	require.NoError(t, json.Unmarshal([]byte(body), &listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, created.ID, listed[0].ID)
+	assert.Empty(t, listed[0].Key, "Ключ показывается только при создании")
+	assert.NotNil(t, listed[0].LastUsedAt)
+	assert.NotContains(t, body, "hash")
+
+	resp, _ = bearerRequest(http.MethodPost, "/api/shorten", "sk_0123456789abcdef_wrong", strings.NewReader(`{"url":"ya.ru"}`))
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "Bearer")
+
+	resp, _ = testRequest(t, ts, http.MethodDelete, "/api/user/keys/"+created.ID, nil, serverapi.GetTestCookie())
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = testRequest(t, ts, http.MethodDelete, "/api/user/keys/"+created.ID, nil, serverapi.GetTestCookie())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = bearerRequest(http.MethodPost, "/api/shorten", created.Key, strings.NewReader(`{"url":"ya.ru"}`))
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
 func TestSequenceHandler(t *testing.T) {
 	configStore := NewTestConfigStore()
 
@@ -0,0 +1,128 @@
+// Package analytics содержит асинхронную запись переходов по коротким ссылкам.
+package analytics
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultBufferSize размер очереди переходов по умолчанию.
+	DefaultBufferSize = 4096
+	// DefaultBatchSize максимальный размер пачки переходов для одной записи в хранилище.
+	DefaultBatchSize = 256
+	// DefaultFlushInterval максимальное время, которое переход может ждать записи.
+	DefaultFlushInterval = time.Second
+	// drainTimeout время на запись оставшихся переходов после остановки.
+	drainTimeout = 5 * time.Second
+)
+
+// Recorder буферизует переходы и пачками пишет их в хранилище в отдельной горутине,
+// чтобы запись не добавляла задержку к редиректу.
+type Recorder struct {
+	storager      storage.Storage
+	events        chan models.Click
+	batchSize     int
+	flushInterval time.Duration
+}
+
+// NewRecorder создает новый экземпляр Recorder с очередью размера bufferSize.
+func NewRecorder(storager storage.Storage, bufferSize int) *Recorder {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	return &Recorder{
+		storager:      storager,
+		events:        make(chan models.Click, bufferSize),
+		batchSize:     DefaultBatchSize,
+		flushInterval: DefaultFlushInterval,
+	}
+}
+
+// Record ставит переход в очередь и никогда не блокируется.
+// Если очередь переполнена, переход отбрасывается и возвращается false.
+func (recorder *Recorder) Record(click models.Click) bool {
+	select {
+	case recorder.events <- click:
+		return true
+	default:
+		logger.Log.Warn("Click queue is full, click is dropped", zap.String("ShortURL", click.ShortURL))
+		return false
+	}
+}
+
+// Run пишет переходы в хранилище, пока не будет отменен ctx.
+// После отмены записывает все, что осталось в очереди, и возвращает управление.
+func (recorder *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(recorder.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.Click, 0, recorder.batchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := recorder.storager.StoreClicks(ctx, batch); err != nil {
+			logger.Log.Error("Failed to store clicks", zap.Int("count", len(batch)), zap.Error(err))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case click := <-recorder.events:
+			batch = append(batch, click)
+			if len(batch) >= recorder.batchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			// контекст запроса уже отменен, поэтому дописываем с отдельным таймаутом
+			drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+			defer cancel()
+			for {
+				select {
+				case click := <-recorder.events:
+					batch = append(batch, click)
+					if len(batch) >= recorder.batchSize {
+						flush(drainCtx)
+					}
+				default:
+					flush(drainCtx)
+					logger.Log.Info("Click recorder is stopped")
+					return
+				}
+			}
+		}
+	}
+}
+
+// Длины префиксов сети, до которых обрезается адрес клиента перед хешированием.
+const (
+	ipv4PrefixLen = 24
+	ipv6PrefixLen = 48
+)
+
+// HashIP возвращает хеш сети клиента, чтобы не хранить адрес в открытом виде.
+// IPv4-адрес обрезается до /24, а IPv6 до /48: хеш полного IPv4-адреса восстанавливается
+// перебором всех адресов, а по хешу сети можно узнать только сеть.
+func HashIP(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		if v4 := parsed.To4(); v4 != nil {
+			ip = v4.Mask(net.CIDRMask(ipv4PrefixLen, 8*net.IPv4len)).String()
+		} else {
+			ip = parsed.Mask(net.CIDRMask(ipv6PrefixLen, 8*net.IPv6len)).String()
+		}
+	}
+	hash := sha256.Sum256([]byte(ip))
+	return hex.EncodeToString(hash[:])
+}
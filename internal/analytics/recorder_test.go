@@ -0,0 +1,16 @@
+package analytics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashIP(t *testing.T) {
+	assert.Equal(t, HashIP("203.0.113.7"), HashIP("203.0.113.200"), "Адреса одной сети /24 должны давать один хеш")
+	assert.NotEqual(t, HashIP("203.0.113.7"), HashIP("203.0.114.7"), "Разные сети /24 должны давать разные хеши")
+	assert.Equal(t, HashIP("2001:db8:1::1"), HashIP("2001:db8:1:ffff::2"), "Адреса одной сети /48 должны давать один хеш")
+	assert.NotEqual(t, HashIP("2001:db8:1::1"), HashIP("2001:db8:2::1"), "Разные сети /48 должны давать разные хеши")
+	assert.Equal(t, HashIP("::ffff:203.0.113.7"), HashIP("203.0.113.1"), "IPv4 в записи IPv6 должен хешироваться как IPv4")
+	assert.Len(t, HashIP("not an ip"), 64)
+}
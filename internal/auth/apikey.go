@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Области действия ключей API. Ключ без областей действует для всех.
+const (
+	ScopeRead   = "read"
+	ScopeWrite  = "write"
+	ScopeDelete = "delete"
+)
+
+// apiKeyPrefix префикс ключей API, по нему ключ легко найти в логах и конфигурации.
+const apiKeyPrefix = "sk_"
+
+var (
+	// ErrInvalidAPIKey возвращается, если ключ API не соответствует формату sk_<id>_<secret>.
+	ErrInvalidAPIKey = errors.New("invalid api key format")
+
+	knownScopes = map[string]struct{}{
+		ScopeRead:   {},
+		ScopeWrite:  {},
+		ScopeDelete: {},
+	}
+)
+
+// GenerateAPIKey создает новый ключ API вида sk_<id>_<secret>.
+// Возвращает идентификатор ключа, сам ключ, который показывается пользователю один раз, и его хеш для хранения.
+func GenerateAPIKey() (id string, key string, hash string, err error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	id = hex.EncodeToString(idBytes)
+	key = apiKeyPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return id, key, HashAPIKey(key), nil
+}
+
+// HashAPIKey возвращает хеш ключа API, под которым он хранится.
+// Ключ содержит 256 случайных бит, поэтому медленный хеш для паролей не нужен.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateAPIKey проверяет формат ключа API, не обращаясь к хранилищу.
+func ValidateAPIKey(key string) error {
+	_, err := APIKeyID(key)
+	return err
+}
+
+// APIKeyID возвращает идентификатор из ключа API, не обращаясь к хранилищу.
+func APIKeyID(key string) (string, error) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return "", ErrInvalidAPIKey
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(id) != 16 || secret == "" {
+		return "", ErrInvalidAPIKey
+	}
+	return id, nil
+}
+
+// NormalizeScopes проверяет области действия и убирает повторы, сохраняя порядок.
+func NormalizeScopes(scopes []string) ([]string, error) {
+	normalized := make([]string, 0, len(scopes))
+	seen := make(map[string]struct{}, len(scopes))
+	for _, scope := range scopes {
+		if _, ok := knownScopes[scope]; !ok {
+			return nil, fmt.Errorf("unknown scope %q, expected read, write or delete", scope)
+		}
+		if _, ok := seen[scope]; ok {
+			continue
+		}
+		seen[scope] = struct{}{}
+		normalized = append(normalized, scope)
+	}
+	return normalized, nil
+}
+
+// HasScope проверяет, что ключ с областями scopes может выполнять действие scope.
+// Пустой список означает ключ без ограничений.
+func HasScope(scopes []string, scope string) bool {
+	if len(scopes) == 0 {
+		return true
+	}
+	for _, allowed := range scopes {
+		if allowed == scope {
+			return true
+		}
+	}
+	return false
+}
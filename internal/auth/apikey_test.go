@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	id, key, hash, err := GenerateAPIKey()
+	require.NoError(t, err)
+
+	assert.Len(t, id, 16)
+	assert.Contains(t, key, "sk_"+id+"_")
+	assert.NoError(t, ValidateAPIKey(key))
+	keyID, err := APIKeyID(key)
+	require.NoError(t, err)
+	assert.Equal(t, id, keyID)
+	assert.Equal(t, HashAPIKey(key), hash)
+	assert.NotContains(t, hash, key)
+
+	_, other, _, err := GenerateAPIKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+
+	for _, invalid := range []string{"", "token", "sk_", "sk_0123456789abcdef", "sk_short_secret", "pk_0123456789abcdef_secret"} {
+		assert.ErrorIs(t, ValidateAPIKey(invalid), ErrInvalidAPIKey, invalid)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	scopes, err := NormalizeScopes([]string{ScopeWrite, ScopeRead, ScopeWrite})
+	require.NoError(t, err)
+	assert.Equal(t, []string{ScopeWrite, ScopeRead}, scopes)
+
+	_, err = NormalizeScopes([]string{"admin"})
+	assert.Error(t, err)
+
+	assert.True(t, HasScope(nil, ScopeDelete), "Ключ без областей действует для всех")
+	assert.True(t, HasScope(scopes, ScopeRead))
+	assert.False(t, HasScope(scopes, ScopeDelete))
+}
@@ -0,0 +1,174 @@
+// Package auth содержит ключи подписи JWT и работу с токенами пользователей.
+package auth
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// MinSecretLength минимальная длина секрета ключа в байтах, короче HMAC-SHA256 подбирается слишком легко.
+const MinSecretLength = 32
+
+var (
+	// ErrNoKeys возвращается, если не задано ни одного ключа.
+	ErrNoKeys = errors.New("no jwt signing keys")
+	// ErrUnknownKey возвращается, если токен подписан ключом, которого нет в KeySet.
+	ErrUnknownKey = errors.New("unknown jwt key id")
+)
+
+// Key ключ подписи HMAC-SHA256 с идентификатором, который записывается в заголовок kid токена.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// KeySet набор действующих ключей. Новые токены подписываются активным ключом,
+// проверяются токены, подписанные любым ключом набора. Так ключи можно менять,
+// не разлогинивая пользователей: новый ключ ставится первым, старый остается в наборе,
+// пока не истекут подписанные им токены.
+type KeySet struct {
+	active Key
+	keys   map[string][]byte
+}
+
+// NewKeySet создает набор из keys, первый ключ становится активным.
+func NewKeySet(keys ...Key) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	keySet := &KeySet{active: keys[0], keys: make(map[string][]byte, len(keys))}
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("jwt key id is empty")
+		}
+		if len(key.Secret) < MinSecretLength {
+			return nil, fmt.Errorf("jwt key %s is shorter than %d bytes", key.ID, MinSecretLength)
+		}
+		if _, ok := keySet.keys[key.ID]; ok {
+			return nil, fmt.Errorf("jwt key %s is duplicated", key.ID)
+		}
+		keySet.keys[key.ID] = key.Secret
+	}
+	return keySet, nil
+}
+
+// RandomKeySet создает набор из одного случайного ключа.
+// Подписанные им токены перестают действовать после перезапуска процесса.
+func RandomKeySet() *KeySet {
+	secret := make([]byte, MinSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	keySet, _ := NewKeySet(Key{ID: "random-" + hex.EncodeToString(secret[:4]), Secret: secret})
+	return keySet
+}
+
+// ActiveKeyID возвращает идентификатор ключа, которым подписываются новые токены.
+func (keySet *KeySet) ActiveKeyID() string {
+	return keySet.active.ID
+}
+
+// Sign подписывает claims активным ключом.
+func (keySet *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = keySet.active.ID
+	return token.SignedString(keySet.active.Secret)
+}
+
+// Parse проверяет подпись и срок действия токена и заполняет claims.
+// Ключ выбирается по заголовку kid, токены без kid не принимаются.
+func (keySet *KeySet) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		secret, ok := keySet.keys[kid]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		return secret, nil
+	})
+}
+
+// KeyID возвращает идентификатор ключа, которым подписан разобранный токен.
+func KeyID(token *jwt.Token) string {
+	kid, _ := token.Header["kid"].(string)
+	return kid
+}
+
+// ParseKeys разбирает ключи в формате "kid1:secret1,kid2:secret2".
+func ParseKeys(spec string) ([]Key, error) {
+	var keys []Key
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, err := parseKey(item)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ReadKeyFile читает ключи из файла, по одному "kid:secret" на строку.
+// Пустые строки и строки, начинающиеся с #, пропускаются.
+func ReadKeyFile(path string) ([]Key, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var keys []Key
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := parseKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, scanner.Err()
+}
+
+// LoadKeySet собирает набор из ключей spec и файла path, любой из них может быть пустым.
+// Ключи из spec идут первыми, так что активным становится первый ключ spec или, если spec пуст, первый ключ файла.
+func LoadKeySet(spec, path string) (*KeySet, error) {
+	keys, err := ParseKeys(spec)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		fileKeys, err := ReadKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+	}
+	return NewKeySet(keys...)
+}
+
+// parseKey разбирает один ключ "kid:secret", секрет может содержать двоеточия.
+func parseKey(item string) (Key, error) {
+	id, secret, ok := strings.Cut(item, ":")
+	if !ok {
+		return Key{}, errors.New("jwt key must be in kid:secret format")
+	}
+	return Key{ID: strings.TrimSpace(id), Secret: []byte(secret)}, nil
+}
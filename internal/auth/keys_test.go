@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func secret(c string) string {
+	return strings.Repeat(c, MinSecretLength)
+}
+
+func TestNewKeySet(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []Key
+		wantErr bool
+	}{
+		{name: "ok", keys: []Key{{ID: "a", Secret: []byte(secret("a"))}, {ID: "b", Secret: []byte(secret("b"))}}},
+		{name: "empty", keys: nil, wantErr: true},
+		{name: "without id", keys: []Key{{ID: "", Secret: []byte(secret("a"))}}, wantErr: true},
+		{name: "short secret", keys: []Key{{ID: "a", Secret: []byte("my-jwt-secret-key")}}, wantErr: true},
+		{name: "duplicated id", keys: []Key{{ID: "a", Secret: []byte(secret("a"))}, {ID: "a", Secret: []byte(secret("b"))}}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keySet, err := NewKeySet(test.keys...)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.keys[0].ID, keySet.ActiveKeyID())
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	claims := &jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+
+	oldKeys, err := NewKeySet(Key{ID: "old", Secret: []byte(secret("o"))})
+	require.NoError(t, err)
+	oldToken, err := oldKeys.Sign(claims)
+	require.NoError(t, err)
+
+	// новый ключ активен, старый еще принимается
+	rotated, err := NewKeySet(Key{ID: "new", Secret: []byte(secret("n"))}, Key{ID: "old", Secret: []byte(secret("o"))})
+	require.NoError(t, err)
+	token, err := rotated.Parse(oldToken, &jwt.RegisteredClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, "old", KeyID(token))
+
+	newToken, err := rotated.Sign(claims)
+	require.NoError(t, err)
+	token, err = rotated.Parse(newToken, &jwt.RegisteredClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, "new", KeyID(token))
+
+	// после удаления старого ключа его токены больше не действуют
+	retired, err := NewKeySet(Key{ID: "new", Secret: []byte(secret("n"))})
+	require.NoError(t, err)
+	_, err = retired.Parse(oldToken, &jwt.RegisteredClaims{})
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// тот же kid с другим секретом не проходит проверку подписи
+	forged, err := NewKeySet(Key{ID: "new", Secret: []byte(secret("f"))})
+	require.NoError(t, err)
+	forgedToken, err := forged.Sign(claims)
+	require.NoError(t, err)
+	_, err = rotated.Parse(forgedToken, &jwt.RegisteredClaims{})
+	assert.Error(t, err)
+
+	// токен без kid не принимается
+	withoutKid, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret("n")))
+	require.NoError(t, err)
+	_, err = rotated.Parse(withoutKid, &jwt.RegisteredClaims{})
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestLoadKeySet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	content := "# ключи для ротации\n\nfile1:" + secret("f") + "\nfile2:with:colons:" + secret("g") + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	keySet, err := LoadKeySet("env1:"+secret("e"), path)
+	require.NoError(t, err)
+	assert.Equal(t, "env1", keySet.ActiveKeyID())
+	assert.Len(t, keySet.keys, 3)
+	assert.Equal(t, "with:colons:"+secret("g"), string(keySet.keys["file2"]))
+
+	keySet, err = LoadKeySet("", path)
+	require.NoError(t, err)
+	assert.Equal(t, "file1", keySet.ActiveKeyID())
+
+	_, err = LoadKeySet("", "")
+	assert.ErrorIs(t, err, ErrNoKeys)
+	_, err = LoadKeySet("no-secret", "")
+	assert.Error(t, err)
+	_, err = LoadKeySet("", filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,146 @@
+package dbconnector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"go.uber.org/zap"
+
+	"github.com/lib/pq"
+)
+
+const (
+	insertAPIKeyStatement           = "INSERT INTO api_keys(id, userID, name, key_hash, scopes, created_at) VALUES($1, $2, $3, $4, $5, $6)"
+	selectAPIKeyByHashStatement     = "SELECT id, userID, name, key_hash, scopes, created_at, last_used_at, revoked_at FROM api_keys WHERE key_hash = $1"
+	selectAPIKeysForUserIDStatement = "SELECT id, userID, name, key_hash, scopes, created_at, last_used_at, revoked_at FROM api_keys WHERE userID = $1 ORDER BY created_at, id"
+	updateRevokedAPIKeyStatement    = "UPDATE api_keys SET revoked_at = $3 WHERE id = $1 AND userID = $2 AND revoked_at IS NULL"
+	updateAPIKeyLastUsedStatement   = "UPDATE api_keys SET last_used_at = $2 WHERE id = $1"
+)
+
+// InsertAPIKey сохраняет новый ключ API.
+func (dbConnector *DBConnector) InsertAPIKey(ctx context.Context, apiKey models.APIKey) error {
+	ctx, span := startSpan(ctx, "InsertAPIKey", insertAPIKeyStatement)
+	defer span.End()
+
+	_, err := dbConnector.DB.ExecContext(ctx, insertAPIKeyStatement,
+		apiKey.ID, apiKey.UserID, apiKey.Name, apiKey.Hash, pq.Array(apiKey.Scopes), apiKey.CreatedAt)
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to insert api key", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// SelectAPIKeyByHash возвращает ключ API по хешу.
+// Если ключа нет, возвращает false без ошибки.
+func (dbConnector *DBConnector) SelectAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	ctx, span := startSpan(ctx, "SelectAPIKeyByHash", selectAPIKeyByHashStatement)
+	defer span.End()
+
+	apiKey, err := scanAPIKey(dbConnector.DB.QueryRowContext(ctx, selectAPIKeyByHashStatement, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.APIKey{}, false, nil
+	}
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to read api key from database", zap.Error(err))
+		return models.APIKey{}, false, err
+	}
+	return apiKey, true, nil
+}
+
+// SelectAPIKeysForUserID возвращает ключи API пользователя в порядке создания.
+func (dbConnector *DBConnector) SelectAPIKeysForUserID(ctx context.Context, userID int) ([]models.APIKey, error) {
+	ctx, span := startSpan(ctx, "SelectAPIKeysForUserID", selectAPIKeysForUserIDStatement)
+	defer span.End()
+
+	rows, err := dbConnector.DB.QueryContext(ctx, selectAPIKeysForUserIDStatement, userID)
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to read api keys from database", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	apiKeys := []models.APIKey{}
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
+			span.RecordError(err)
+			logger.Log.Error("Failed to read api keys from database", zap.Error(err))
+			return nil, err
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to read api keys from database", zap.Error(err))
+		return nil, err
+	}
+	return apiKeys, nil
+}
+
+// UpdateRevokedAPIKey отзывает действующий ключ API пользователя.
+// Возвращает false, если такого ключа нет.
+func (dbConnector *DBConnector) UpdateRevokedAPIKey(ctx context.Context, id string, userID int, now time.Time) (bool, error) {
+	ctx, span := startSpan(ctx, "UpdateRevokedAPIKey", updateRevokedAPIKeyStatement)
+	defer span.End()
+
+	res, err := dbConnector.DB.ExecContext(ctx, updateRevokedAPIKeyStatement, id, userID, now)
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to revoke api key", zap.Error(err))
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// UpdateAPIKeyLastUsed запоминает время последнего использования ключа API.
+func (dbConnector *DBConnector) UpdateAPIKeyLastUsed(ctx context.Context, id string, now time.Time) error {
+	ctx, span := startSpan(ctx, "UpdateAPIKeyLastUsed", updateAPIKeyLastUsedStatement)
+	defer span.End()
+
+	_, err := dbConnector.DB.ExecContext(ctx, updateAPIKeyLastUsedStatement, id, now)
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to update api key last used time", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// rowScanner общий интерфейс sql.Row и sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAPIKey читает колонки id, userID, name, key_hash, scopes, created_at, last_used_at, revoked_at.
+func scanAPIKey(row rowScanner) (models.APIKey, error) {
+	var apiKey models.APIKey
+	var lastUsedAt, revokedAt sql.NullTime
+	var scopes pq.StringArray
+	err := row.Scan(&apiKey.ID, &apiKey.UserID, &apiKey.Name, &apiKey.Hash, &scopes, &apiKey.CreatedAt, &lastUsedAt, &revokedAt)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	apiKey.Scopes = []string(scopes)
+	if lastUsedAt.Valid {
+		apiKey.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		apiKey.RevokedAt = &revokedAt.Time
+	}
+	return apiKey, nil
+}
@@ -0,0 +1,204 @@
+package dbconnector
+
+import (
+	"context"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"github.com/theheadmen/urlShort/internal/tracing"
+	"go.uber.org/zap"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// временная таблица живет до конца транзакции, поэтому одновременные пачки друг другу не мешают
+	createImportTableStatement = `
+		CREATE TEMP TABLE urls_import (
+			ord INT,
+			shortURL TEXT,
+			originalURL TEXT,
+			expires_at TIMESTAMP WITH TIME ZONE,
+			alias BOOLEAN
+		) ON COMMIT DROP;
+	`
+	// пользовательские коды пачки блокируются в одном порядке, чтобы пересекающиеся пачки не ждали друг друга по кругу,
+	// см. lockAliasStatement
+	lockImportedAliasesStatement = `
+		SELECT pg_advisory_xact_lock($1, hashtext(shortURL))
+		FROM (SELECT DISTINCT shortURL FROM urls_import WHERE alias ORDER BY shortURL) aliases;
+	`
+	// из повторов короткого URL внутри пачки вставляется первый, уже сохраненные пользователем и занятые
+	// пользовательские коды пропускаются, а ON CONFLICT по индексу urls_originalurl_userid_idx пропускает
+	// полные URL, которые у пользователя уже есть под другим коротким URL, в том числе вставленные раньше в этой же пачке
+	insertImportedStatement = `
+		INSERT INTO urls (shortURL, originalURL, userID, expires_at)
+		SELECT shortURL, originalURL, $1, expires_at FROM (
+			SELECT DISTINCT ON (shortURL) ord, shortURL, originalURL, expires_at
+			FROM urls_import
+			WHERE NOT EXISTS (
+				SELECT 1 FROM urls
+				WHERE urls.shortURL = urls_import.shortURL
+				AND urls.userID = $1
+			)
+			AND NOT (urls_import.alias AND EXISTS (
+				SELECT 1 FROM (
+					SELECT urls.userID, urls.originalURL FROM urls
+					WHERE urls.shortURL = urls_import.shortURL
+					ORDER BY urls.id LIMIT 1
+				) first_owner
+				WHERE first_owner.userID <> $1 OR first_owner.originalURL <> urls_import.originalURL
+			))
+			ORDER BY shortURL, ord
+		) first_imported
+		ORDER BY ord
+		ON CONFLICT ((md5(originalURL)), userID) DO NOTHING
+		RETURNING shortURL;
+	`
+	// для каждого URL пачки находит короткий URL, под которым он сохранен у пользователя:
+	// сам короткий URL, если он у пользователя есть, иначе короткий URL того же полного URL,
+	// и проверяет, занят ли его пользовательский код, так же, как insertImportedStatement
+	selectImportedStatement = `
+		SELECT COALESCE(
+			(SELECT urls.shortURL FROM urls WHERE urls.shortURL = urls_import.shortURL AND urls.userID = $1),
+			(SELECT urls.shortURL FROM urls WHERE md5(urls.originalURL) = md5(urls_import.originalURL) AND urls.userID = $1),
+			urls_import.shortURL
+		), urls_import.alias AND EXISTS (
+			SELECT 1 FROM (
+				SELECT urls.userID, urls.originalURL FROM urls
+				WHERE urls.shortURL = urls_import.shortURL
+				ORDER BY urls.id LIMIT 1
+			) first_owner
+			WHERE first_owner.userID <> $1 OR first_owner.originalURL <> urls_import.originalURL
+		)
+		FROM urls_import
+		ORDER BY ord;
+	`
+)
+
+// CopySavedURLBatch вставляет несколько URL пользователя одной транзакцией: URL загружаются через COPY
+// во временную таблицу и переносятся в urls одним INSERT ... ON CONFLICT.
+// Возвращает для каждого URL из savedURLs короткий URL, под которым он сохранен у пользователя,
+// и признаки того, что он не был вставлен, потому что уже сохранен или его пользовательский код занят.
+func (dbConnector *DBConnector) CopySavedURLBatch(ctx context.Context, savedURLs []models.SavedURL, userID int) ([]storage.BatchResult, error) {
+	ctx, span := startSpan(ctx, "CopySavedURLBatch", createImportTableStatement+";"+lockImportedAliasesStatement+";"+insertImportedStatement+";"+selectImportedStatement)
+	defer span.End()
+
+	tx, err := dbConnector.DB.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to initiate transaction for DB", zap.Error(err))
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, createImportTableStatement); err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to create import table", zap.Error(err))
+		return nil, err
+	}
+
+	// имена колонок в COPY экранируются, поэтому пишутся так, как их хранит Postgres
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("urls_import", "ord", "shorturl", "originalurl", "expires_at", "alias"))
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to prepare copy for DB", zap.Error(err))
+		return nil, err
+	}
+	for i, savedURL := range savedURLs {
+		if _, err := stmt.ExecContext(ctx, i, savedURL.ShortURL, savedURL.OriginalURL, savedURL.ExpiresAt, savedURL.Alias); err != nil {
+			stmt.Close()
+			span.RecordError(err)
+			logger.Log.Error("Failed to copy urls to DB", zap.Error(err))
+			return nil, err
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		span.RecordError(err)
+		logger.Log.Error("Failed to copy urls to DB", zap.Error(err))
+		return nil, err
+	}
+	if err := stmt.Close(); err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to copy urls to DB", zap.Error(err))
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, lockImportedAliasesStatement, aliasLockClass); err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to lock custom aliases", zap.Error(err))
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, insertImportedStatement, userID)
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to insert urls from import table", zap.Error(err))
+		return nil, err
+	}
+	created := make(map[string]struct{}, len(savedURLs))
+	for rows.Next() {
+		var shortURL string
+		if err := rows.Scan(&shortURL); err != nil {
+			rows.Close()
+			span.RecordError(err)
+			logger.Log.Error("Failed to read inserted urls", zap.Error(err))
+			return nil, err
+		}
+		created[shortURL] = struct{}{}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to read inserted urls", zap.Error(err))
+		return nil, err
+	}
+
+	results := make([]storage.BatchResult, 0, len(savedURLs))
+	rows, err = tx.QueryContext(ctx, selectImportedStatement, userID)
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to select imported urls", zap.Error(err))
+		return nil, err
+	}
+	for rows.Next() {
+		var result storage.BatchResult
+		if err := rows.Scan(&result.ShortURL, &result.AliasTaken); err != nil {
+			rows.Close()
+			span.RecordError(err)
+			logger.Log.Error("Failed to read imported urls", zap.Error(err))
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to read imported urls", zap.Error(err))
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to commit transaction DB", zap.Error(err))
+		return nil, err
+	}
+
+	inserted := len(created)
+	// вставленным считается первое вхождение короткого URL, как в DISTINCT ON выше
+	for i, savedURL := range savedURLs {
+		if _, ok := created[savedURL.ShortURL]; ok {
+			delete(created, savedURL.ShortURL)
+			continue
+		}
+		results[i].Existed = true
+	}
+
+	span.SetAttributes(tracing.Int("db.rows", len(savedURLs)))
+	logger.Log.Info("Inserted new data to database", zap.Int("count", inserted), zap.Int("userID", userID))
+	return results, nil
+}
@@ -0,0 +1,36 @@
+package dbconnector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"go.uber.org/zap"
+)
+
+// reserveCodeCounterStatement сдвигает счетчик одним запросом, строка счетчика блокируется до конца запроса,
+// так что несколько реплик не зарезервируют одни и те же значения
+const reserveCodeCounterStatement = `
+	UPDATE short_code_counter
+	SET value = value + $1
+	RETURNING value - $1 + 1
+`
+
+// ReserveCodeCounter резервирует n следующих значений счетчика коротких кодов и возвращает первое из них.
+func (dbConnector *DBConnector) ReserveCodeCounter(ctx context.Context, n int) (uint64, error) {
+	ctx, span := startSpan(ctx, "ReserveCodeCounter", reserveCodeCounterStatement)
+	defer span.End()
+
+	var first int64
+	err := dbConnector.DB.QueryRowContext(ctx, reserveCodeCounterStatement, n).Scan(&first)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = errors.New("short_code_counter table is empty")
+	}
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to reserve short code counter", zap.Error(err))
+		return 0, err
+	}
+	return uint64(first), nil
+}
@@ -3,14 +3,57 @@ package dbconnector
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/theheadmen/urlShort/internal/dbconnector/migrations"
 	"github.com/theheadmen/urlShort/internal/logger"
 	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"github.com/theheadmen/urlShort/internal/tracing"
 	"go.uber.org/zap"
 
 	"github.com/lib/pq"
 )
 
+// SQL-запросы вынесены в константы, чтобы их же текст попадал в спаны.
+const (
+	insertSavedURLStatement = "INSERT INTO urls(shortURL, originalURL, userID, expires_at) VALUES($1, $2, $3, $4)"
+	updateDeletedStatement  = `
+		UPDATE urls
+		SET deleted = TRUE, deleted_at = now()
+		FROM unnest($1::text[], $2::int[]) AS batch(shortURL, userID)
+		WHERE urls.shortURL = batch.shortURL
+		AND urls.userID = batch.userID
+		AND urls.deleted = FALSE
+		RETURNING urls.shortURL, urls.userID;
+	`
+	updateDeletedExpiredStatement = `
+		UPDATE urls
+		SET deleted = TRUE, deleted_at = $1
+		WHERE deleted = FALSE
+		AND expires_at IS NOT NULL
+		AND expires_at <= $1;
+	`
+	insertClickStatement       = "INSERT INTO clicks(shortURL, userID, clicked_at, referer, user_agent, ip_hash) VALUES($1, $2, $3, $4, $5, $6)"
+	selectClickTotalsStatement = `
+		SELECT COUNT(*), COUNT(DISTINCT ip_hash)
+		FROM clicks
+		WHERE shortURL = $1 AND userID = $2
+	`
+	selectClickDailyStatement = `
+		SELECT to_char(clicked_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day, COUNT(*)
+		FROM clicks
+		WHERE shortURL = $1 AND userID = $2
+		GROUP BY day
+		ORDER BY day
+	`
+	countSavedURLsStatement = "SELECT COUNT(*) FROM urls WHERE deleted = FALSE"
+	countUsersStatement     = "SELECT COUNT(*) FROM users"
+)
+
 // DBConnector представляет собой структуру для работы с базой данных.
 type DBConnector struct {
 	DB *sql.DB
@@ -34,23 +77,16 @@ func NewDBConnector(ctx context.Context, psqlInfo string) (*DBConnector, error)
 		return nil, err
 	}
 
-	sqlStatement := `
-	CREATE TABLE IF NOT EXISTS urls (
-		id SERIAL PRIMARY KEY,
-		shortURL VARCHAR(255),
-		originalURL VARCHAR(255),
-		userID INT,
-		deleted BOOLEAN DEFAULT FALSE,
-		UNIQUE(originalURL, userID)
-	);
-	CREATE TABLE IF NOT EXISTS last_user_id (
-		id INT PRIMARY KEY DEFAULT 1
-	);
-	INSERT INTO last_user_id (id) VALUES (1) ON CONFLICT DO NOTHING;`
-	_, err = db.ExecContext(ctx, sqlStatement)
+	// схема создается и обновляется миграциями, несколько реплик могут запускаться одновременно
+	migrator, err := migrations.NewMigrator(db)
 	if err != nil {
-		logger.Log.Debug("Can't create urls table", zap.String("error", err.Error()))
-		db.Close() // Close the database connection if table creation fails.
+		logger.Log.Debug("Can't load migrations", zap.String("error", err.Error()))
+		db.Close()
+		return nil, err
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		logger.Log.Debug("Can't apply migrations", zap.String("error", err.Error()))
+		db.Close() // Close the database connection if migration fails.
 		return nil, err
 	}
 
@@ -59,17 +95,42 @@ func NewDBConnector(ctx context.Context, psqlInfo string) (*DBConnector, error)
 	}, nil
 }
 
+const (
+	// aliasLockClass первый ключ advisory lock, под которым занимаются пользовательские коды, второй ключ хеш кода
+	aliasLockClass = 1
+	// пользовательский код вставляется, только если его первый владелец тот же пользователь с тем же полным URL,
+	// см. storage.AliasTaken; одновременные вставки одного кода сериализует lockAliasStatement
+	insertAliasStatement = `
+		INSERT INTO urls(shortURL, originalURL, userID, expires_at)
+		SELECT $1::text, $2::text, $3::int, $4::timestamptz
+		WHERE NOT EXISTS (
+			SELECT 1 FROM (
+				SELECT userID, originalURL FROM urls WHERE shortURL = $1 ORDER BY id LIMIT 1
+			) first_owner
+			WHERE first_owner.userID <> $3 OR first_owner.originalURL <> $2
+		);
+	`
+	// без блокировки две транзакции, занимающие свободный код, не видят строк друг друга и вставляют обе
+	lockAliasStatement = "SELECT pg_advisory_xact_lock($1, hashtext($2))"
+)
+
 // InsertSavedURLBatch вставляет несколько URL в базу данных в рамках одной транзакции.
-// Если транзакция не удается, возвращает ошибку.
+// Если транзакция не удается, возвращает ошибку, а если у пользователя уже есть один из полных URL,
+// возвращает storage.ErrDuplicateURL. Если занят один из пользовательских кодов, возвращает storage.ErrAliasTaken.
 func (dbConnector *DBConnector) InsertSavedURLBatch(ctx context.Context, savedURLs []models.SavedURL, userID int) error {
+	ctx, span := startSpan(ctx, "InsertSavedURLBatch", insertSavedURLStatement+";"+lockAliasStatement+";"+insertAliasStatement)
+	defer span.End()
+
 	tx, err := dbConnector.DB.BeginTx(ctx, nil)
 	if err != nil {
+		span.RecordError(err)
 		logger.Log.Error("Failed to initiate transaction for DB", zap.Error(err))
 		return err
 	}
 
-	stmt, err := tx.PrepareContext(ctx, "INSERT INTO urls(shortURL, originalURL, userID) VALUES($1, $2, $3)")
+	stmt, err := tx.PrepareContext(ctx, insertSavedURLStatement)
 	if err != nil {
+		span.RecordError(err)
 		logger.Log.Error("Failed to prepate query for DB", zap.Error(err))
 		tx.Rollback()
 		return err
@@ -77,9 +138,24 @@ func (dbConnector *DBConnector) InsertSavedURLBatch(ctx context.Context, savedUR
 	defer stmt.Close()
 
 	for _, savedURL := range savedURLs {
-		_, err := stmt.ExecContext(ctx, savedURL.ShortURL, savedURL.OriginalURL, userID)
+		var err error
+		if savedURL.Alias {
+			err = insertAlias(ctx, tx, savedURL, userID)
+		} else {
+			_, err = stmt.ExecContext(ctx, savedURL.ShortURL, savedURL.OriginalURL, userID, savedURL.ExpiresAt)
+		}
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			tx.Rollback()
+			return storage.ErrDuplicateURL
+		}
+		if errors.Is(err, storage.ErrAliasTaken) {
+			tx.Rollback()
+			return err
+		}
 		if err != nil {
 			tx.Rollback()
+			span.RecordError(err)
 			logger.Log.Error("Failed to insert query for DB", zap.Error(err))
 			return err
 		}
@@ -88,6 +164,7 @@ func (dbConnector *DBConnector) InsertSavedURLBatch(ctx context.Context, savedUR
 
 	err = tx.Commit()
 	if err != nil {
+		span.RecordError(err)
 		logger.Log.Error("Failed to commit transaction DB", zap.Error(err))
 		return err
 	}
@@ -97,23 +174,114 @@ func (dbConnector *DBConnector) InsertSavedURLBatch(ctx context.Context, savedUR
 	return err
 }
 
-// SelectAllSavedURLs возвращает все сохраненные URL из базы данных.
+// insertAlias вставляет URL с пользовательским кодом в транзакции tx под advisory lock на этот код.
+// Если код занят, возвращает storage.ErrAliasTaken.
+func insertAlias(ctx context.Context, tx *sql.Tx, savedURL models.SavedURL, userID int) error {
+	if _, err := tx.ExecContext(ctx, lockAliasStatement, aliasLockClass, savedURL.ShortURL); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, insertAliasStatement, savedURL.ShortURL, savedURL.OriginalURL, userID, savedURL.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return storage.ErrAliasTaken
+	}
+	return nil
+}
+
+// SelectSavedURLsForUserID возвращает страницу сохраненных URL для определенного пользователя.
+// Страница выбирается по ключу (keyset): условие на позицию курсора и LIMIT вместо OFFSET,
+// так что стоимость запроса не растет с номером страницы.
 // Если чтение не удается, возвращает ошибку.
-func (dbConnector *DBConnector) SelectAllSavedURLs(ctx context.Context) ([]models.SavedURL, error) {
+func (dbConnector *DBConnector) SelectSavedURLsForUserID(ctx context.Context, userID int, query models.URLQuery) ([]models.SavedURL, error) {
+	sqlStatement, args := buildUserURLsQuery(userID, query)
+	return dbConnector.selectSavedURLs(ctx, "SelectSavedURLsForUserID", sqlStatement, args...)
+}
+
+// buildUserURLsQuery собирает запрос страницы URL пользователя. Значения передаются только параметрами.
+func buildUserURLsQuery(userID int, query models.URLQuery) (string, []interface{}) {
+	var sqlStatement strings.Builder
+	args := []interface{}{userID}
+	sqlStatement.WriteString("SELECT id, shortURL, originalURL, userID, deleted, expires_at, version, deleted_at FROM urls WHERE userID = $1")
+
+	if query.Deleted != nil {
+		args = append(args, *query.Deleted)
+		fmt.Fprintf(&sqlStatement, " AND deleted = $%d", len(args))
+	}
+	if query.Contains != "" {
+		args = append(args, query.Contains)
+		fmt.Fprintf(&sqlStatement, " AND strpos(originalURL, $%d) > 0", len(args))
+	}
+
+	columns := "id, shortURL"
+	if query.Sort == models.SortByShortURL {
+		columns = "shortURL, id"
+	}
+	direction, comparison := "ASC", ">"
+	if query.Desc {
+		direction, comparison = "DESC", "<"
+	}
+	if query.After != nil {
+		first, second := interface{}(query.After.UUID), interface{}(query.After.ShortURL)
+		if query.Sort == models.SortByShortURL {
+			first, second = second, first
+		}
+		args = append(args, first, second)
+		fmt.Fprintf(&sqlStatement, " AND (%s) %s ($%d, $%d)", columns, comparison, len(args)-1, len(args))
+	}
+
+	fmt.Fprintf(&sqlStatement, " ORDER BY %s", strings.ReplaceAll(columns, ",", " "+direction+","))
+	sqlStatement.WriteString(" " + direction)
+	if query.Limit > 0 {
+		args = append(args, query.Limit)
+		fmt.Fprintf(&sqlStatement, " LIMIT $%d", len(args))
+	}
+	return sqlStatement.String(), args
+}
+
+// SelectFirstSavedURLForShortURL возвращает URL первого владельца короткого URL, то есть сохраненный раньше остальных.
+// Первый владелец обслуживает редирект и получает переходы, как и в файловом хранилище, см. storage.OwnerIndex.
+// Если чтение не удается, возвращает ошибку.
+func (dbConnector *DBConnector) SelectFirstSavedURLForShortURL(ctx context.Context, shortURL string) ([]models.SavedURL, error) {
+	return dbConnector.selectSavedURLs(ctx, "SelectFirstSavedURLForShortURL", `SELECT id, shortURL, originalURL, userID, deleted, expires_at, version, deleted_at FROM urls where shortURL = $1 ORDER BY id LIMIT 1`, shortURL)
+}
+
+// SelectSavedURLsForShortURLAndUserID возвращает URL пользователя userID для определенного короткого URL.
+// Если чтение не удается, возвращает ошибку.
+func (dbConnector *DBConnector) SelectSavedURLsForShortURLAndUserID(ctx context.Context, shortURL string, userID int) ([]models.SavedURL, error) {
+	return dbConnector.selectSavedURLs(ctx, "SelectSavedURLsForShortURLAndUserID", `SELECT id, shortURL, originalURL, userID, deleted, expires_at, version, deleted_at FROM urls where shortURL = $1 AND userID = $2`, shortURL, userID)
+}
+
+// SelectSavedURLsForOriginalURLAndUserID возвращает URL пользователя с полным URL originalURL.
+func (dbConnector *DBConnector) SelectSavedURLsForOriginalURLAndUserID(ctx context.Context, originalURL string, userID int) ([]models.SavedURL, error) {
+	// сравнение по md5 использует уникальный индекс urls_originalurl_userid_idx
+	return dbConnector.selectSavedURLs(ctx, "SelectSavedURLsForOriginalURLAndUserID", `SELECT id, shortURL, originalURL, userID, deleted, expires_at, version, deleted_at FROM urls where md5(originalURL) = md5($1) AND userID = $2`, originalURL, userID)
+}
+
+// selectSavedURLs выполняет запрос, возвращающий колонки id, shortURL, originalURL, userID, deleted, expires_at, version, deleted_at.
+func (dbConnector *DBConnector) selectSavedURLs(ctx context.Context, operation string, sqlStatement string, args ...interface{}) ([]models.SavedURL, error) {
+	ctx, span := startSpan(ctx, operation, sqlStatement)
+	defer span.End()
+
 	var savedURLs []models.SavedURL
 
-	sqlStatement := `SELECT id, shortURL, originalURL, userID, deleted FROM urls`
-	rows, err := dbConnector.DB.QueryContext(ctx, sqlStatement)
+	rows, err := dbConnector.DB.QueryContext(ctx, sqlStatement, args...)
 	if err != nil {
+		span.RecordError(err)
 		logger.Log.Error("Failed to read from database", zap.Error(err))
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var savedURL models.SavedURL
-		err = rows.Scan(&savedURL.UUID, &savedURL.ShortURL, &savedURL.OriginalURL, &savedURL.UserID, &savedURL.Deleted)
+		savedURL, err := scanSavedURL(rows)
 		if err != nil {
+			span.RecordError(err)
 			logger.Log.Error("Failed to read from database", zap.Error(err))
 			return nil, err
 		}
@@ -122,159 +290,233 @@ func (dbConnector *DBConnector) SelectAllSavedURLs(ctx context.Context) ([]model
 
 	err = rows.Err()
 	if err != nil {
+		span.RecordError(err)
 		logger.Log.Error("Failed to read from database", zap.Error(err))
 		return nil, err
 	}
 
+	span.SetAttributes(tracing.Int("db.rows", len(savedURLs)))
 	return savedURLs, err
 }
 
-// SelectSavedURLsForUserID возвращает все сохраненные URL для определенного пользователя.
-// Если чтение не удается, возвращает ошибку.
-func (dbConnector *DBConnector) SelectSavedURLsForUserID(ctx context.Context, userID int) ([]models.SavedURL, error) {
-	var savedURLs []models.SavedURL
+// scanSavedURL читает текущую строку с колонками id, shortURL, originalURL, userID, deleted, expires_at, version, deleted_at.
+func scanSavedURL(rows *sql.Rows) (models.SavedURL, error) {
+	var savedURL models.SavedURL
+	var expiresAt, deletedAt sql.NullTime
+	err := rows.Scan(&savedURL.UUID, &savedURL.ShortURL, &savedURL.OriginalURL, &savedURL.UserID, &savedURL.Deleted, &expiresAt, &savedURL.Version, &deletedAt)
+	if err != nil {
+		return models.SavedURL{}, err
+	}
+	if expiresAt.Valid {
+		savedURL.ExpiresAt = &expiresAt.Time
+	}
+	if deletedAt.Valid {
+		savedURL.DeletedAt = &deletedAt.Time
+	}
+	return savedURL, nil
+}
 
-	sqlStatement := `SELECT id, shortURL, originalURL, userID, deleted FROM urls where userID = $1`
-	rows, err := dbConnector.DB.QueryContext(ctx, sqlStatement, userID)
+// UpdateDeletedSavedURLBatch помечает удаленными URL из keys одним запросом, каждый только у его владельца.
+// Возвращает ключи URL, которые были помечены этим запросом.
+// Если запрос не удается, возвращает ошибку.
+func (dbConnector *DBConnector) UpdateDeletedSavedURLBatch(ctx context.Context, keys []storage.URLMapKey) ([]storage.URLMapKey, error) {
+	deleted, err := dbConnector.queryURLMapKeys(ctx, "UpdateDeletedSavedURLBatch", updateDeletedStatement, keys)
 	if err != nil {
-		logger.Log.Error("Failed to read from database", zap.Error(err))
+		return nil, err
+	}
+	logger.Log.Info("Marked urls as deleted in database", zap.Int("count", len(deleted)))
+	return deleted, nil
+}
+
+// queryURLMapKeys выполняет запрос, который принимает keys массивами $1 (shortURL) и $2 (userID), а следующие
+// параметры из args, и возвращает колонки shortURL, userID обработанных строк.
+func (dbConnector *DBConnector) queryURLMapKeys(ctx context.Context, operation string, sqlStatement string, keys []storage.URLMapKey, args ...interface{}) ([]storage.URLMapKey, error) {
+	ctx, span := startSpan(ctx, operation, sqlStatement)
+	defer span.End()
+
+	shortURLs := make([]string, 0, len(keys))
+	userIDs := make([]int64, 0, len(keys))
+	for _, key := range keys {
+		shortURLs = append(shortURLs, key.ShortURL)
+		userIDs = append(userIDs, int64(key.UserID))
+	}
+
+	rows, err := dbConnector.DB.QueryContext(ctx, sqlStatement, append([]interface{}{pq.Array(shortURLs), pq.Array(userIDs)}, args...)...)
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to execute the statement: ", zap.Error(err))
 		return nil, err
 	}
 	defer rows.Close()
 
+	result := []storage.URLMapKey{}
 	for rows.Next() {
-		var savedURL models.SavedURL
-		err = rows.Scan(&savedURL.UUID, &savedURL.ShortURL, &savedURL.OriginalURL, &savedURL.UserID, &savedURL.Deleted)
-		if err != nil {
-			logger.Log.Error("Failed to read from database", zap.Error(err))
+		var key storage.URLMapKey
+		if err := rows.Scan(&key.ShortURL, &key.UserID); err != nil {
+			span.RecordError(err)
+			logger.Log.Error("Failed to read urls: ", zap.Error(err))
 			return nil, err
 		}
-		savedURLs = append(savedURLs, savedURL)
+		result = append(result, key)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to read urls: ", zap.Error(err))
+		return nil, err
 	}
 
-	err = rows.Err()
+	span.SetAttributes(tracing.Int("db.rows", len(result)))
+	return result, nil
+}
+
+// UpdateDeletedExpiredSavedURLs помечает удаленными все URL, срок действия которых истек к моменту now.
+// Возвращает количество помеченных URL.
+func (dbConnector *DBConnector) UpdateDeletedExpiredSavedURLs(ctx context.Context, now time.Time) (int, error) {
+	ctx, span := startSpan(ctx, "UpdateDeletedExpiredSavedURLs", updateDeletedExpiredStatement)
+	defer span.End()
+
+	res, err := dbConnector.DB.ExecContext(ctx, updateDeletedExpiredStatement, now)
 	if err != nil {
-		logger.Log.Error("Failed to read from database", zap.Error(err))
-		return nil, err
+		span.RecordError(err)
+		logger.Log.Error("Failed to execute the statement: ", zap.Error(err))
+		return 0, err
 	}
 
-	return savedURLs, err
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to get the number of rows affected: ", zap.Error(err))
+		return 0, err
+	}
+
+	return int(rowsAffected), nil
 }
 
-// SelectSavedURLsForUserID возвращает все сохраненные URL для определенного URL.
-// Если чтение не удается, возвращает ошибку.
-func (dbConnector *DBConnector) SelectSavedURLsForShortURL(ctx context.Context, shortURL string) ([]models.SavedURL, error) {
-	var savedURLs []models.SavedURL
+// InsertClickBatch вставляет несколько переходов в базу данных в рамках одной транзакции.
+// Если транзакция не удается, возвращает ошибку.
+func (dbConnector *DBConnector) InsertClickBatch(ctx context.Context, clicks []models.Click) error {
+	ctx, span := startSpan(ctx, "InsertClickBatch", insertClickStatement)
+	defer span.End()
 
-	sqlStatement := `SELECT id, shortURL, originalURL, userID, deleted FROM urls where shortURL = $1`
-	rows, err := dbConnector.DB.QueryContext(ctx, sqlStatement, shortURL)
+	tx, err := dbConnector.DB.BeginTx(ctx, nil)
 	if err != nil {
-		logger.Log.Error("Failed to read from database", zap.Error(err))
-		return nil, err
+		span.RecordError(err)
+		logger.Log.Error("Failed to initiate transaction for DB", zap.Error(err))
+		return err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var savedURL models.SavedURL
-		err = rows.Scan(&savedURL.UUID, &savedURL.ShortURL, &savedURL.OriginalURL, &savedURL.UserID, &savedURL.Deleted)
+	stmt, err := tx.PrepareContext(ctx, insertClickStatement)
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to prepate query for DB", zap.Error(err))
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, click := range clicks {
+		_, err := stmt.ExecContext(ctx, click.ShortURL, click.UserID, click.Timestamp, click.Referer, click.UserAgent, click.IPHash)
 		if err != nil {
-			logger.Log.Error("Failed to read from database", zap.Error(err))
-			return nil, err
+			tx.Rollback()
+			span.RecordError(err)
+			logger.Log.Error("Failed to insert query for DB", zap.Error(err))
+			return err
 		}
-		savedURLs = append(savedURLs, savedURL)
 	}
 
-	err = rows.Err()
+	err = tx.Commit()
 	if err != nil {
-		logger.Log.Error("Failed to read from database", zap.Error(err))
-		return nil, err
+		span.RecordError(err)
+		logger.Log.Error("Failed to commit transaction DB", zap.Error(err))
+		return err
 	}
 
-	return savedURLs, err
+	logger.Log.Info("Inserted new clicks to database", zap.Int("count", len(clicks)))
+
+	return err
 }
 
-// SelectSavedURLsForShortURL возвращает все сохраненные URL для определенного короткого URL.
+// SelectClickStats возвращает статистику переходов по короткому URL пользователя userID.
 // Если чтение не удается, возвращает ошибку.
-func (dbConnector *DBConnector) SelectSavedURLsForShortURLAndUserID(ctx context.Context, shortURL string, userID int) ([]models.SavedURL, error) {
-	var savedURLs []models.SavedURL
+func (dbConnector *DBConnector) SelectClickStats(ctx context.Context, shortURL string, userID int) (models.ClickStats, error) {
+	ctx, span := startSpan(ctx, "SelectClickStats", selectClickTotalsStatement+";"+selectClickDailyStatement)
+	defer span.End()
 
-	sqlStatement := `SELECT id, shortURL, originalURL, userID, deleted FROM urls where shortURL = $1 AND userID = $2`
-	rows, err := dbConnector.DB.QueryContext(ctx, sqlStatement, shortURL, userID)
+	stats := models.ClickStats{
+		ShortURL: shortURL,
+		Daily:    []models.DailyClicks{},
+	}
+
+	err := dbConnector.DB.QueryRowContext(ctx, selectClickTotalsStatement, shortURL, userID).Scan(&stats.TotalClicks, &stats.UniqueVisitors)
 	if err != nil {
+		span.RecordError(err)
 		logger.Log.Error("Failed to read from database", zap.Error(err))
-		return nil, err
+		return stats, err
+	}
+
+	rows, err := dbConnector.DB.QueryContext(ctx, selectClickDailyStatement, shortURL, userID)
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to read from database", zap.Error(err))
+		return stats, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var savedURL models.SavedURL
-		err = rows.Scan(&savedURL.UUID, &savedURL.ShortURL, &savedURL.OriginalURL, &savedURL.UserID, &savedURL.Deleted)
+		var daily models.DailyClicks
+		err = rows.Scan(&daily.Date, &daily.Clicks)
 		if err != nil {
+			span.RecordError(err)
 			logger.Log.Error("Failed to read from database", zap.Error(err))
-			return nil, err
+			return stats, err
 		}
-		savedURLs = append(savedURLs, savedURL)
+		stats.Daily = append(stats.Daily, daily)
 	}
 
 	err = rows.Err()
 	if err != nil {
+		span.RecordError(err)
 		logger.Log.Error("Failed to read from database", zap.Error(err))
-		return nil, err
+		return stats, err
 	}
 
-	return savedURLs, err
+	return stats, nil
 }
 
-// IncrementID увеличивает значение на 1 и возвращает новое значение и ошибку.
-func (dbConnector *DBConnector) IncrementID(ctx context.Context) (int, error) {
-	var newID int
-	err := dbConnector.DB.QueryRowContext(ctx, `
-		WITH updated AS (
-			UPDATE last_user_id
-			SET id = id + 1
-			RETURNING id
-		)
-		SELECT id FROM updated
-		UNION ALL
-		SELECT id FROM last_user_id WHERE NOT EXISTS (SELECT 1 FROM updated)
-	`).Scan(&newID)
+// CountSavedURLs возвращает количество неудаленных URL.
+func (dbConnector *DBConnector) CountSavedURLs(ctx context.Context) (int, error) {
+	ctx, span := startSpan(ctx, "CountSavedURLs", countSavedURLsStatement)
+	defer span.End()
+
+	var count int
+	err := dbConnector.DB.QueryRowContext(ctx, countSavedURLsStatement).Scan(&count)
 	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to count urls in database", zap.Error(err))
 		return 0, err
 	}
-
-	return newID, nil
+	return count, nil
 }
 
-// UpdateDeletedSavedURLBatch обновляет несколько URL в базе данных в рамках одной транзакции, помечая их как удаленные.
-// Если транзакция не удается, возвращает ошибку.
-func (dbConnector *DBConnector) UpdateDeletedSavedURLBatch(ctx context.Context, shortURLs []string, userID int) error {
-	stmt, err := dbConnector.DB.PrepareContext(ctx, `
-		UPDATE urls
-		SET deleted = TRUE
-		WHERE shortURL = ANY($1)
-		AND userID = $2;
-	`)
-	if err != nil {
-		logger.Log.Error("Failed to prepare the statement: ", zap.Error(err))
-		return err
-	}
-	defer stmt.Close()
+// CountUsers возвращает количество зарегистрированных пользователей.
+func (dbConnector *DBConnector) CountUsers(ctx context.Context) (int, error) {
+	ctx, span := startSpan(ctx, "CountUsers", countUsersStatement)
+	defer span.End()
 
-	// Execute the statement
-	res, err := stmt.ExecContext(ctx, pq.Array(shortURLs), userID)
+	var count int
+	err := dbConnector.DB.QueryRowContext(ctx, countUsersStatement).Scan(&count)
 	if err != nil {
-		logger.Log.Error("Failed to execute the statement: ", zap.Error(err))
-		return err
-	}
-
-	// Check how many rows were affected
-	rowsAffected, err := res.RowsAffected()
-	if err != nil {
-		logger.Log.Error("Failed to get the number of rows affected: ", zap.Error(err))
-		return err
+		span.RecordError(err)
+		logger.Log.Error("Failed to count users in database", zap.Error(err))
+		return 0, err
 	}
+	return count, nil
+}
 
-	logger.Log.Info("Inserted new data to database", zap.Int64("count", rowsAffected))
-
-	return nil
+// startSpan создает клиентский спан для SQL-операции operation с текстом запроса sqlStatement.
+func startSpan(ctx context.Context, operation string, sqlStatement string) (context.Context, *tracing.Span) {
+	return tracing.Start(ctx, "sql."+operation, tracing.SpanKindClient,
+		tracing.String("db.system", "postgresql"),
+		tracing.String("db.statement", strings.TrimSpace(sqlStatement)),
+	)
 }
@@ -0,0 +1,43 @@
+package dbconnector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/theheadmen/urlShort/internal/models"
+)
+
+func TestBuildUserURLsQuery(t *testing.T) {
+	deleted := false
+	tests := []struct {
+		name          string
+		query         models.URLQuery
+		wantStatement string
+		wantArgs      []interface{}
+	}{
+		{
+			name:          "all",
+			wantStatement: "SELECT id, shortURL, originalURL, userID, deleted, expires_at, version, deleted_at FROM urls WHERE userID = $1 ORDER BY id ASC, shortURL ASC",
+			wantArgs:      []interface{}{7},
+		},
+		{
+			name:          "created page after cursor",
+			query:         models.URLQuery{After: &models.URLCursor{UUID: 10, ShortURL: "abc"}, Limit: 3},
+			wantStatement: "SELECT id, shortURL, originalURL, userID, deleted, expires_at, version, deleted_at FROM urls WHERE userID = $1 AND (id, shortURL) > ($2, $3) ORDER BY id ASC, shortURL ASC LIMIT $4",
+			wantArgs:      []interface{}{7, 10, "abc", 3},
+		},
+		{
+			name:          "short url descending with filters",
+			query:         models.URLQuery{Sort: models.SortByShortURL, Desc: true, Deleted: &deleted, Contains: "docs", After: &models.URLCursor{UUID: 10, ShortURL: "abc"}, Limit: 2},
+			wantStatement: "SELECT id, shortURL, originalURL, userID, deleted, expires_at, version, deleted_at FROM urls WHERE userID = $1 AND deleted = $2 AND strpos(originalURL, $3) > 0 AND (shortURL, id) < ($4, $5) ORDER BY shortURL DESC, id DESC LIMIT $6",
+			wantArgs:      []interface{}{7, false, "docs", "abc", 10, 2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statement, args := buildUserURLsQuery(7, test.query)
+			assert.Equal(t, test.wantStatement, statement)
+			assert.Equal(t, test.wantArgs, args)
+		})
+	}
+}
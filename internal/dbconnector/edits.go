@@ -0,0 +1,138 @@
+package dbconnector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"go.uber.org/zap"
+
+	"github.com/lib/pq"
+)
+
+// uniqueViolation код ошибки Postgres при нарушении уникального индекса.
+const uniqueViolation = "23505"
+
+const (
+	selectSavedURLForUpdateStatement = "SELECT id, shortURL, originalURL, userID, deleted, expires_at, version, deleted_at FROM urls WHERE shortURL = $1 AND userID = $2 FOR UPDATE"
+	updateOriginalURLStatement       = "UPDATE urls SET originalURL = $2, version = $3 WHERE id = $1"
+	selectOtherOwnersStatement       = "SELECT EXISTS (SELECT 1 FROM urls WHERE shortURL = $1 AND userID <> $2)"
+	insertURLEditStatement           = "INSERT INTO url_edits(shortURL, userID, api_key_id, previous_url, original_url, version, edited_at) VALUES($1, $2, $3, $4, $5, $6, $7)"
+	selectURLEditsStatement          = "SELECT shortURL, userID, api_key_id, previous_url, original_url, version, edited_at FROM url_edits WHERE shortURL = $1 AND userID = $2 ORDER BY version"
+)
+
+// UpdateSavedURL меняет полный URL короткой ссылки и записывает изменение в историю в рамках одной транзакции.
+// Строка URL блокируется до конца транзакции, поэтому одновременные изменения проверяют версию по очереди.
+func (dbConnector *DBConnector) UpdateSavedURL(ctx context.Context, edit models.URLEdit, version int) (models.SavedURL, error) {
+	ctx, span := startSpan(ctx, "UpdateSavedURL", selectSavedURLForUpdateStatement+";"+selectOtherOwnersStatement+";"+updateOriginalURLStatement+";"+insertURLEditStatement)
+	defer span.End()
+
+	tx, err := dbConnector.DB.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to initiate transaction for DB", zap.Error(err))
+		return models.SavedURL{}, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, selectSavedURLForUpdateStatement, edit.ShortURL, edit.UserID)
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to read from database", zap.Error(err))
+		return models.SavedURL{}, err
+	}
+	var current models.SavedURL
+	found := rows.Next()
+	if found {
+		current, err = scanSavedURL(rows)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	rows.Close()
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to read from database", zap.Error(err))
+		return models.SavedURL{}, err
+	}
+
+	updated, edit, changed, err := storage.PrepareEdit(current, found, edit, version)
+	if err != nil || !changed {
+		return updated, err
+	}
+
+	var shared bool
+	if err := tx.QueryRowContext(ctx, selectOtherOwnersStatement, edit.ShortURL, edit.UserID).Scan(&shared); err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to read from database", zap.Error(err))
+		return models.SavedURL{}, err
+	}
+	if shared {
+		return models.SavedURL{}, storage.ErrSharedURL
+	}
+
+	_, err = tx.ExecContext(ctx, updateOriginalURLStatement, updated.UUID, updated.OriginalURL, updated.Version)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return models.SavedURL{}, storage.ErrDuplicateURL
+	}
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to update url", zap.Error(err))
+		return models.SavedURL{}, err
+	}
+
+	apiKeyID := sql.NullString{String: edit.APIKeyID, Valid: edit.APIKeyID != ""}
+	_, err = tx.ExecContext(ctx, insertURLEditStatement,
+		edit.ShortURL, edit.UserID, apiKeyID, edit.PreviousURL, edit.OriginalURL, edit.Version, edit.EditedAt)
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to insert url edit", zap.Error(err))
+		return models.SavedURL{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to commit transaction DB", zap.Error(err))
+		return models.SavedURL{}, err
+	}
+	logger.Log.Info("Updated url in database", zap.String("ShortURL", edit.ShortURL), zap.Int("userID", edit.UserID), zap.Int("version", edit.Version))
+	return updated, nil
+}
+
+// SelectURLEdits возвращает историю изменений короткого URL пользователя в порядке версий.
+func (dbConnector *DBConnector) SelectURLEdits(ctx context.Context, shortURL string, userID int) ([]models.URLEdit, error) {
+	ctx, span := startSpan(ctx, "SelectURLEdits", selectURLEditsStatement)
+	defer span.End()
+
+	rows, err := dbConnector.DB.QueryContext(ctx, selectURLEditsStatement, shortURL, userID)
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to read url edits from database", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []models.URLEdit{}
+	for rows.Next() {
+		var edit models.URLEdit
+		var apiKeyID sql.NullString
+		err := rows.Scan(&edit.ShortURL, &edit.UserID, &apiKeyID, &edit.PreviousURL, &edit.OriginalURL, &edit.Version, &edit.EditedAt)
+		if err != nil {
+			span.RecordError(err)
+			logger.Log.Error("Failed to read url edits from database", zap.Error(err))
+			return nil, err
+		}
+		edit.APIKeyID = apiKeyID.String
+		edits = append(edits, edit)
+	}
+	if err := rows.Err(); err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to read url edits from database", zap.Error(err))
+		return nil, err
+	}
+	return edits, nil
+}
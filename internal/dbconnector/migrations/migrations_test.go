@@ -0,0 +1,79 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadEmbedded(t *testing.T) {
+	migrations, err := Load(sqlFS)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, migration := range migrations {
+		assert.Equal(t, i+1, migration.Version, "Версии должны идти подряд без пропусков")
+		assert.NotEmpty(t, migration.Up)
+		assert.NotEmpty(t, migration.Down)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		want    []int
+		wantErr bool
+	}{
+		{
+			name: "sorted by version",
+			files: fstest.MapFS{
+				"sql/0010_b.up.sql":   {Data: []byte("b")},
+				"sql/0010_b.down.sql": {Data: []byte("b")},
+				"sql/0002_a.up.sql":   {Data: []byte("a")},
+				"sql/0002_a.down.sql": {Data: []byte("a")},
+			},
+			want: []int{2, 10},
+		},
+		{
+			name: "missing down",
+			files: fstest.MapFS{
+				"sql/0001_a.up.sql": {Data: []byte("a")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "names differ",
+			files: fstest.MapFS{
+				"sql/0001_a.up.sql":   {Data: []byte("a")},
+				"sql/0001_b.down.sql": {Data: []byte("b")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid version",
+			files: fstest.MapFS{
+				"sql/first_a.up.sql":   {Data: []byte("a")},
+				"sql/first_a.down.sql": {Data: []byte("a")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := Load(tt.files)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			var versions []int
+			for _, migration := range migrations {
+				versions = append(versions, migration.Version)
+			}
+			assert.Equal(t, tt.want, versions)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+//go:build postgres
+
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theheadmen/urlShort/internal/logger"
+
+	_ "github.com/lib/pq"
+)
+
+// localDSN база для локального запуска, переопределяется через TEST_DATABASE_DSN.
+const localDSN = "host=localhost port=5432 user=postgres password=example dbname=godb sslmode=disable"
+
+func TestMigratorPostgres(t *testing.T) {
+	require.NoError(t, logger.Initialize("error"))
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		dsn = localDSN
+	}
+	ctx := context.Background()
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		t.Skipf("postgres is not available: %v", err)
+	}
+
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+
+	// несколько реплик запускаются одновременно, каждая миграция применяется ровно один раз
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	total := 0
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			applied, err := migrator.Up(ctx)
+			assert.NoError(t, err)
+			mu.Lock()
+			total += applied
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.True(t, status.Applied, "Миграция %d не применена", status.Version)
+	}
+	assert.LessOrEqual(t, total, len(statuses))
+
+	reverted, err := migrator.Down(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, statuses[len(statuses)-1].Version, reverted.Version)
+
+	applied, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, applied)
+}
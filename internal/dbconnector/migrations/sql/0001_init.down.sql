@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS last_user_id;
+DROP TABLE IF EXISTS urls;
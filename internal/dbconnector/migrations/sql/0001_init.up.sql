@@ -0,0 +1,15 @@
+-- начальная схема, совпадает с той, что раньше создавалась при каждом запуске,
+-- поэтому для уже существующих баз миграция ничего не меняет
+CREATE TABLE IF NOT EXISTS urls (
+	id SERIAL PRIMARY KEY,
+	shortURL VARCHAR(255),
+	originalURL VARCHAR(255),
+	userID INT,
+	deleted BOOLEAN DEFAULT FALSE,
+	UNIQUE(originalURL, userID)
+);
+
+CREATE TABLE IF NOT EXISTS last_user_id (
+	id INT PRIMARY KEY DEFAULT 1
+);
+INSERT INTO last_user_id (id) VALUES (1) ON CONFLICT DO NOTHING;
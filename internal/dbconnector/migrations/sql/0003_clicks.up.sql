@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS clicks (
+	id BIGSERIAL PRIMARY KEY,
+	shortURL VARCHAR(255) NOT NULL,
+	clicked_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	referer TEXT,
+	user_agent TEXT,
+	ip_hash VARCHAR(64)
+);
+CREATE INDEX IF NOT EXISTS clicks_shorturl_idx ON clicks (shortURL);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS urls_originalurl_userid_idx;
+ALTER TABLE urls ALTER COLUMN originalURL TYPE VARCHAR(255);
+ALTER TABLE urls ADD CONSTRAINT urls_originalurl_userid_key UNIQUE (originalURL, userID);
@@ -0,0 +1,5 @@
+-- длинные URL не помещаются в VARCHAR(255), а обычный уникальный индекс по TEXT
+-- ограничен размером страницы, поэтому уникальность проверяется по хешу
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_originalurl_userid_key;
+ALTER TABLE urls ALTER COLUMN originalURL TYPE TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS urls_originalurl_userid_idx ON urls (md5(originalURL), userID);
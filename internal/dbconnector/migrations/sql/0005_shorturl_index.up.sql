@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS urls_shorturl_idx ON urls (shortURL);
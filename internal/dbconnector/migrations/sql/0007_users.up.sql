@@ -0,0 +1,13 @@
+-- пользователи хранятся отдельно от URL, чтобы пользователь без ссылок оставался действительным после перезапуска
+CREATE TABLE IF NOT EXISTS users (
+	id INT PRIMARY KEY,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+	last_seen TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+-- все идентификаторы до last_user_id уже могли быть выданы в куках
+INSERT INTO users (id)
+SELECT generate_series(1, (SELECT COALESCE(MAX(id), 0) FROM last_user_id))
+ON CONFLICT DO NOTHING;
+INSERT INTO users (id)
+SELECT DISTINCT userID FROM urls WHERE userID IS NOT NULL
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS urls_userid_shorturl_idx;
+DROP INDEX IF EXISTS urls_userid_id_idx;
@@ -0,0 +1,3 @@
+-- страницы URL пользователя выбираются по ключу в порядке сохранения или короткого кода
+CREATE INDEX IF NOT EXISTS urls_userid_id_idx ON urls (userID, id);
+CREATE INDEX IF NOT EXISTS urls_userid_shorturl_idx ON urls (userID, shortURL, id);
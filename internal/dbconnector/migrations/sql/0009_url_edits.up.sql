@@ -0,0 +1,13 @@
+-- версия URL для проверки If-Match и история изменений полного URL
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 0;
+CREATE TABLE IF NOT EXISTS url_edits (
+	id BIGSERIAL PRIMARY KEY,
+	shortURL VARCHAR(255) NOT NULL,
+	userID INT NOT NULL,
+	api_key_id VARCHAR(32),
+	previous_url TEXT NOT NULL,
+	original_url TEXT NOT NULL,
+	version INT NOT NULL,
+	edited_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS url_edits_shorturl_userid_idx ON url_edits (shortURL, userID, version);
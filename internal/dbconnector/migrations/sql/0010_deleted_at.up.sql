@@ -0,0 +1,5 @@
+-- время пометки удаленным нужно для окончательного удаления по сроку хранения,
+-- для уже удаленных URL срок отсчитывается от применения миграции
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+UPDATE urls SET deleted_at = now() WHERE deleted = TRUE AND deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS urls_deleted_at_idx ON urls (deleted_at) WHERE deleted = TRUE;
@@ -0,0 +1,2 @@
+-- количество неудаленных URL для статистики считается по частичному индексу без чтения всей таблицы
+CREATE INDEX IF NOT EXISTS urls_active_idx ON urls (id) WHERE deleted = FALSE;
@@ -0,0 +1,6 @@
+-- счетчик коротких кодов общий для всех экземпляров сервера и не начинается заново после перезапуска
+CREATE TABLE IF NOT EXISTS short_code_counter (
+	id INT PRIMARY KEY DEFAULT 1,
+	value BIGINT NOT NULL DEFAULT 0
+);
+INSERT INTO short_code_counter (id) VALUES (1) ON CONFLICT DO NOTHING;
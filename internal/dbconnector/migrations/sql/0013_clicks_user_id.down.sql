@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS clicks_shorturl_userid_idx;
+CREATE INDEX IF NOT EXISTS clicks_shorturl_idx ON clicks (shortURL);
+ALTER TABLE clicks DROP COLUMN IF EXISTS userID;
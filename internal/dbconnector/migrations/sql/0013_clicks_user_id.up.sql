@@ -0,0 +1,8 @@
+-- переходы учитываются у владельца, который обслужил редирект, чтобы совладельцы короткого URL
+-- не видели переходы друг друга; старые переходы отдаются первому владельцу, как и редирект
+ALTER TABLE clicks ADD COLUMN IF NOT EXISTS userID INT;
+UPDATE clicks SET userID = (
+	SELECT urls.userID FROM urls WHERE urls.shortURL = clicks.shortURL ORDER BY urls.id LIMIT 1
+) WHERE userID IS NULL;
+DROP INDEX IF EXISTS clicks_shorturl_idx;
+CREATE INDEX IF NOT EXISTS clicks_shorturl_userid_idx ON clicks (shortURL, userID);
@@ -0,0 +1,101 @@
+package dbconnector
+
+import (
+	"context"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	restoreStatement = `
+		UPDATE urls
+		SET deleted = FALSE, deleted_at = NULL
+		FROM unnest($1::text[], $2::int[]) AS batch(shortURL, userID)
+		WHERE urls.shortURL = batch.shortURL
+		AND urls.userID = batch.userID
+		AND urls.deleted = TRUE
+		AND (urls.expires_at IS NULL OR urls.expires_at > $3)
+		RETURNING urls.shortURL, urls.userID;
+	`
+	// история изменений удаляется в том же запросе, что и сами URL
+	purgeStatement = `
+		WITH purged AS (
+			DELETE FROM urls
+			USING unnest($1::text[], $2::int[]) AS batch(shortURL, userID)
+			WHERE urls.shortURL = batch.shortURL
+			AND urls.userID = batch.userID
+			RETURNING urls.shortURL, urls.userID
+		), edits AS (
+			DELETE FROM url_edits
+			USING purged
+			WHERE url_edits.shortURL = purged.shortURL
+			AND url_edits.userID = purged.userID
+		), clicks AS (
+			DELETE FROM clicks
+			USING purged
+			WHERE clicks.shortURL = purged.shortURL
+			AND clicks.userID = purged.userID
+		)
+		SELECT shortURL, userID FROM purged;
+	`
+	purgeDeletedStatement = `
+		WITH purged AS (
+			DELETE FROM urls
+			WHERE deleted = TRUE
+			AND deleted_at <= $1
+			RETURNING shortURL, userID
+		), edits AS (
+			DELETE FROM url_edits
+			USING purged
+			WHERE url_edits.shortURL = purged.shortURL
+			AND url_edits.userID = purged.userID
+		), clicks AS (
+			DELETE FROM clicks
+			USING purged
+			WHERE clicks.shortURL = purged.shortURL
+			AND clicks.userID = purged.userID
+		)
+		SELECT COUNT(*) FROM purged;
+	`
+)
+
+// RestoreSavedURLBatch снимает пометку удаления с URL из keys одним запросом, каждого только у его владельца.
+// URL, срок действия которых истек к моменту now, не восстанавливаются.
+// Возвращает ключи восстановленных URL.
+func (dbConnector *DBConnector) RestoreSavedURLBatch(ctx context.Context, keys []storage.URLMapKey, now time.Time) ([]storage.URLMapKey, error) {
+	restored, err := dbConnector.queryURLMapKeys(ctx, "RestoreSavedURLBatch", restoreStatement, keys, now)
+	if err != nil {
+		return nil, err
+	}
+	logger.Log.Info("Restored urls in database", zap.Int("count", len(restored)))
+	return restored, nil
+}
+
+// DeleteSavedURLBatch безвозвратно удаляет URL из keys вместе с историей изменений и переходами, каждый только у его владельца.
+// Возвращает ключи удаленных URL.
+func (dbConnector *DBConnector) DeleteSavedURLBatch(ctx context.Context, keys []storage.URLMapKey) ([]storage.URLMapKey, error) {
+	purged, err := dbConnector.queryURLMapKeys(ctx, "DeleteSavedURLBatch", purgeStatement, keys)
+	if err != nil {
+		return nil, err
+	}
+	logger.Log.Info("Purged urls from database", zap.Int("count", len(purged)))
+	return purged, nil
+}
+
+// DeleteDeletedSavedURLs безвозвратно удаляет URL, помеченные удаленными не позже before, вместе с историей изменений и переходами.
+// Возвращает количество удаленных URL.
+func (dbConnector *DBConnector) DeleteDeletedSavedURLs(ctx context.Context, before time.Time) (int, error) {
+	ctx, span := startSpan(ctx, "DeleteDeletedSavedURLs", purgeDeletedStatement)
+	defer span.End()
+
+	var count int
+	if err := dbConnector.DB.QueryRowContext(ctx, purgeDeletedStatement, before).Scan(&count); err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to purge deleted urls", zap.Error(err))
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,81 @@
+package dbconnector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// insertUserStatement выделяет идентификатор и сохраняет пользователя одним запросом,
+	// так что несколько реплик не выдадут один идентификатор дважды
+	insertUserStatement = `
+		WITH next AS (
+			UPDATE last_user_id
+			SET id = id + 1
+			RETURNING id
+		)
+		INSERT INTO users(id, created_at, last_seen)
+		SELECT id, $1, $1 FROM next
+		RETURNING id
+	`
+	selectUserStatement         = "SELECT id, created_at, last_seen FROM users WHERE id = $1"
+	updateUserLastSeenStatement = "UPDATE users SET last_seen = $2 WHERE id = $1 AND last_seen < $2"
+)
+
+// InsertUser регистрирует пользователя со следующим свободным идентификатором.
+func (dbConnector *DBConnector) InsertUser(ctx context.Context, now time.Time) (models.User, error) {
+	ctx, span := startSpan(ctx, "InsertUser", insertUserStatement)
+	defer span.End()
+
+	user := models.User{CreatedAt: now, LastSeen: now}
+	err := dbConnector.DB.QueryRowContext(ctx, insertUserStatement, now).Scan(&user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = errors.New("last_user_id table is empty")
+	}
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to insert user", zap.Error(err))
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// SelectUser возвращает пользователя по идентификатору.
+// Если пользователя нет, возвращает false без ошибки.
+func (dbConnector *DBConnector) SelectUser(ctx context.Context, userID int) (models.User, bool, error) {
+	ctx, span := startSpan(ctx, "SelectUser", selectUserStatement)
+	defer span.End()
+
+	var user models.User
+	err := dbConnector.DB.QueryRowContext(ctx, selectUserStatement, userID).Scan(&user.ID, &user.CreatedAt, &user.LastSeen)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, false, nil
+	}
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to read user from database", zap.Error(err))
+		return models.User{}, false, err
+	}
+	return user, true, nil
+}
+
+// UpdateUserLastSeen запоминает время последней активности пользователя.
+// Более раннее время, пришедшее от другой реплики, не перезаписывает более позднее.
+func (dbConnector *DBConnector) UpdateUserLastSeen(ctx context.Context, userID int, now time.Time) error {
+	ctx, span := startSpan(ctx, "UpdateUserLastSeen", updateUserLastSeenStatement)
+	defer span.End()
+
+	_, err := dbConnector.DB.ExecContext(ctx, updateUserLastSeenStatement, userID, now)
+	if err != nil {
+		span.RecordError(err)
+		logger.Log.Error("Failed to update user last seen", zap.Error(err))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+// Package deletion содержит очередь асинхронных задач удаления URL пользователей.
+package deletion
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultWorkers количество обработчиков очереди по умолчанию.
+	DefaultWorkers = 4
+	// DefaultBufferSize количество задач, которые могут ждать обработки.
+	DefaultBufferSize = 1024
+	// DefaultBatchSize сколько URL обработчик старается собрать из разных задач в один вызов хранилища.
+	DefaultBatchSize = 1000
+	// jobRetention сколько хранится состояние завершенной задачи.
+	jobRetention = time.Hour
+	// drainTimeout время на обработку оставшихся задач после остановки.
+	drainTimeout = 10 * time.Second
+)
+
+var (
+	// ErrQueueFull возвращается, если очередь задач переполнена.
+	ErrQueueFull = errors.New("delete queue is full")
+	// ErrQueueClosed возвращается, если очередь уже остановлена.
+	ErrQueueClosed = errors.New("delete queue is closed")
+)
+
+// Gauge учитывает задачи, которые еще не завершились. Реализуется metrics.Gauge.
+type Gauge interface {
+	Inc()
+	Dec()
+}
+
+// job задача удаления и ее состояние. Поля state меняются под Queue.mu.
+type job struct {
+	state  models.DeleteJob
+	userID int
+	keys   []storage.URLMapKey
+}
+
+// Queue принимает задачи удаления и обрабатывает их ограниченным числом обработчиков.
+// Обработчик объединяет задачи, накопившиеся в очереди, в один вызов storage.Storage.DeleteURLs.
+type Queue struct {
+	storager  storage.Storage
+	pending   chan *job
+	workers   int
+	batchSize int
+
+	mu        sync.Mutex
+	jobs      map[string]*job
+	lastSweep time.Time
+	closed    bool
+	waiting   Gauge
+}
+
+// NewQueue создает очередь с workers обработчиками и местом для bufferSize задач.
+func NewQueue(storager storage.Storage, workers int, bufferSize int) *Queue {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	return &Queue{
+		storager:  storager,
+		pending:   make(chan *job, bufferSize),
+		workers:   workers,
+		batchSize: DefaultBatchSize,
+		jobs:      make(map[string]*job),
+	}
+}
+
+// SetPendingGauge задает счетчик незавершенных задач.
+func (queue *Queue) SetPendingGauge(gauge Gauge) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.waiting = gauge
+}
+
+// Submit ставит в очередь удаление shortURLs пользователя userID и никогда не блокируется.
+// Повторы в shortURLs учитываются один раз.
+func (queue *Queue) Submit(userID int, shortURLs []string) (models.DeleteJob, error) {
+	id, err := newJobID()
+	if err != nil {
+		return models.DeleteJob{}, err
+	}
+
+	unique := make([]string, 0, len(shortURLs))
+	seen := make(map[string]struct{}, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		if _, ok := seen[shortURL]; ok {
+			continue
+		}
+		seen[shortURL] = struct{}{}
+		unique = append(unique, shortURL)
+	}
+
+	now := time.Now().UTC()
+	newJob := &job{
+		state:  models.DeleteJob{ID: id, Status: models.DeleteJobQueued, Total: len(unique), CreatedAt: now},
+		userID: userID,
+		keys:   storage.KeysForUser(unique, userID),
+	}
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if queue.closed {
+		return models.DeleteJob{}, ErrQueueClosed
+	}
+	select {
+	case queue.pending <- newJob:
+	default:
+		return models.DeleteJob{}, ErrQueueFull
+	}
+	queue.sweep(now)
+	queue.jobs[id] = newJob
+	if queue.waiting != nil {
+		queue.waiting.Inc()
+	}
+	return newJob.state, nil
+}
+
+// Get возвращает состояние задачи, если она принадлежит пользователю userID.
+func (queue *Queue) Get(id string, userID int) (models.DeleteJob, bool) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	found, ok := queue.jobs[id]
+	if !ok || found.userID != userID {
+		return models.DeleteJob{}, false
+	}
+	return found.state, true
+}
+
+// Run обрабатывает задачи, пока не будет отменен ctx.
+// После отмены новые задачи не принимаются, а оставшиеся в очереди дообрабатываются.
+// Через drainTimeout контекст обработки отменяется, и задачи, которые не успели, помечаются неудавшимися.
+func (queue *Queue) Run(ctx context.Context) {
+	// уже начатые удаления не должны прерываться вместе с ctx
+	workCtx, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < queue.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			queue.work(workCtx, stop)
+		}()
+	}
+
+	<-ctx.Done()
+	queue.mu.Lock()
+	queue.closed = true
+	queue.mu.Unlock()
+	close(stop)
+
+	timer := time.AfterFunc(drainTimeout, cancelWork)
+	defer timer.Stop()
+	wg.Wait()
+	logger.Log.Info("Delete queue is stopped")
+}
+
+// work обрабатывает задачи одного обработчика. После сигнала stop дообрабатывает очередь и завершается.
+func (queue *Queue) work(ctx context.Context, stop <-chan struct{}) {
+	for {
+		select {
+		case first := <-queue.pending:
+			queue.process(ctx, queue.collect(first))
+		case <-stop:
+			for {
+				batch := queue.collect(nil)
+				if len(batch) == 0 {
+					return
+				}
+				queue.process(ctx, batch)
+			}
+		}
+	}
+}
+
+// collect добирает к first задачи, уже ждущие в очереди, пока в пачке меньше batchSize URL.
+func (queue *Queue) collect(first *job) []*job {
+	var batch []*job
+	size := 0
+	if first != nil {
+		batch = append(batch, first)
+		size = len(first.keys)
+	}
+	for size < queue.batchSize {
+		select {
+		case next := <-queue.pending:
+			batch = append(batch, next)
+			size += len(next.keys)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// process удаляет URL всех задач пачки одним вызовом хранилища и раскладывает результат по задачам.
+func (queue *Queue) process(ctx context.Context, batch []*job) {
+	var keys []storage.URLMapKey
+	queue.mu.Lock()
+	for _, current := range batch {
+		current.state.Status = models.DeleteJobRunning
+		keys = append(keys, current.keys...)
+	}
+	queue.mu.Unlock()
+
+	deleted, err := queue.storager.DeleteURLs(ctx, keys)
+	if err != nil {
+		logger.Log.Error("Failed to delete urls", zap.Int("jobs", len(batch)), zap.Int("count", len(keys)), zap.Error(err))
+	} else {
+		logger.Log.Info("Deleted urls", zap.Int("jobs", len(batch)), zap.Int("count", len(deleted)))
+	}
+	marked := make(map[storage.URLMapKey]struct{}, len(deleted))
+	for _, key := range deleted {
+		marked[key] = struct{}{}
+	}
+
+	now := time.Now().UTC()
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	for _, current := range batch {
+		current.state.FinishedAt = &now
+		if err != nil {
+			current.state.Status = models.DeleteJobFailed
+			current.state.Error = err.Error()
+		} else {
+			current.state.Status = models.DeleteJobDone
+			for _, key := range current.keys {
+				if _, ok := marked[key]; ok {
+					current.state.Deleted++
+				}
+			}
+			current.state.Skipped = current.state.Total - current.state.Deleted
+		}
+		if queue.waiting != nil {
+			queue.waiting.Dec()
+		}
+	}
+}
+
+// sweep раз в минуту забывает задачи, завершенные раньше чем jobRetention назад. Вызывается под mu.
+func (queue *Queue) sweep(now time.Time) {
+	if now.Sub(queue.lastSweep) < time.Minute {
+		return
+	}
+	queue.lastSweep = now
+	for id, current := range queue.jobs {
+		if current.state.FinishedAt != nil && now.Sub(*current.state.FinishedAt) > jobRetention {
+			delete(queue.jobs, id)
+		}
+	}
+}
+
+// newJobID возвращает случайный идентификатор задачи.
+func newJobID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,108 @@
+package deletion
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"github.com/theheadmen/urlShort/internal/storage/memory"
+)
+
+// countingStorage считает вызовы DeleteURLs и может вернуть заданную ошибку.
+type countingStorage struct {
+	storage.Storage
+	mu    sync.Mutex
+	calls int
+	err   error
+}
+
+func (counting *countingStorage) DeleteURLs(ctx context.Context, keys []storage.URLMapKey) ([]storage.URLMapKey, error) {
+	counting.mu.Lock()
+	counting.calls++
+	counting.mu.Unlock()
+	if counting.err != nil {
+		return nil, counting.err
+	}
+	return counting.Storage.DeleteURLs(ctx, keys)
+}
+
+func TestQueueBatchesJobs(t *testing.T) {
+	ctx := context.Background()
+	storager := &countingStorage{Storage: memory.NewMemoryStorage()}
+	for _, savedURL := range []models.SavedURL{
+		{ShortURL: "a", OriginalURL: "a.ru", UserID: 1},
+		{ShortURL: "b", OriginalURL: "b.ru", UserID: 1},
+		{ShortURL: "c", OriginalURL: "c.ru", UserID: 2},
+	} {
+		_, err := storager.StoreURL(ctx, savedURL)
+		require.NoError(t, err)
+	}
+
+	queue := NewQueue(storager, 1, 10)
+	first, err := queue.Submit(1, []string{"a", "b", "a", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, models.DeleteJobQueued, first.Status)
+	assert.Equal(t, 3, first.Total, "Повторы не должны учитываться")
+	// пользователь 1 не может удалить URL пользователя 2
+	second, err := queue.Submit(1, []string{"c"})
+	require.NoError(t, err)
+	third, err := queue.Submit(2, []string{"c"})
+	require.NoError(t, err)
+
+	// задачи накоплены до запуска, поэтому единственный обработчик забирает их одной пачкой
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		queue.Run(runCtx)
+	}()
+	cancel()
+	<-done
+	assert.Equal(t, 1, storager.calls)
+
+	job, ok := queue.Get(first.ID, 1)
+	require.True(t, ok)
+	assert.Equal(t, models.DeleteJobDone, job.Status)
+	assert.Equal(t, 2, job.Deleted)
+	assert.Equal(t, 1, job.Skipped)
+	assert.NotNil(t, job.FinishedAt)
+
+	job, ok = queue.Get(second.ID, 1)
+	require.True(t, ok)
+	assert.Equal(t, 0, job.Deleted)
+	assert.Equal(t, 1, job.Skipped)
+
+	job, ok = queue.Get(third.ID, 2)
+	require.True(t, ok)
+	assert.Equal(t, 1, job.Deleted)
+
+	_, ok = queue.Get(third.ID, 1)
+	assert.False(t, ok, "Задачи других пользователей не должны быть видны")
+
+	_, err = queue.Submit(1, []string{"a"})
+	assert.ErrorIs(t, err, ErrQueueClosed)
+}
+
+func TestQueueFailedJob(t *testing.T) {
+	storager := &countingStorage{Storage: memory.NewMemoryStorage(), err: errors.New("storage is down")}
+	queue := NewQueue(storager, 1, 1)
+
+	submitted, err := queue.Submit(1, []string{"a"})
+	require.NoError(t, err)
+	_, err = queue.Submit(1, []string{"b"})
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	queue.Run(ctx)
+
+	job, ok := queue.Get(submitted.ID, 1)
+	require.True(t, ok)
+	assert.Equal(t, models.DeleteJobFailed, job.Status)
+	assert.Equal(t, "storage is down", job.Error)
+}
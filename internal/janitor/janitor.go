@@ -0,0 +1,50 @@
+// Package janitor содержит фоновую очистку хранилища от просроченных и давно удаленных ссылок.
+package janitor
+
+import (
+	"context"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Run раз в interval помечает удаленными просроченные ссылки, пока не будет отменен ctx.
+// Если retention больше нуля, ссылки, удаленные раньше чем retention назад, удаляются навсегда.
+// Блокирует вызывающего, поэтому должен запускаться в отдельной горутине.
+func Run(ctx context.Context, storager storage.Storage, interval time.Duration, retention time.Duration) {
+	if interval <= 0 {
+		logger.Log.Info("Janitor is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Janitor is stopped")
+			return
+		case now := <-ticker.C:
+			count, err := storager.DeleteExpired(ctx, now)
+			if err != nil {
+				logger.Log.Error("Failed to delete expired urls", zap.Error(err))
+			} else if count > 0 {
+				logger.Log.Info("Expired urls are deleted", zap.Int("count", count))
+			}
+			if retention <= 0 {
+				continue
+			}
+			count, err = storager.PurgeDeleted(ctx, now.Add(-retention))
+			if err != nil {
+				logger.Log.Error("Failed to purge deleted urls", zap.Error(err))
+				continue
+			}
+			if count > 0 {
+				logger.Log.Info("Deleted urls are purged", zap.Int("count", count))
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// unknownRoute значение метки route для запросов, не попавших ни в один маршрут.
+const unknownRoute = "unknown"
+
+// HTTPMetrics метрики HTTP-сервера.
+type HTTPMetrics struct {
+	requests *CounterVec
+	duration *HistogramVec
+	// PendingDeletions количество асинхронных удалений, которые еще не завершились.
+	PendingDeletions *Gauge
+	// Deletions количество запущенных асинхронных удалений.
+	Deletions *Counter
+}
+
+// NewHTTPMetrics регистрирует метрики HTTP-сервера в registry.
+func NewHTTPMetrics(registry *Registry) *HTTPMetrics {
+	return &HTTPMetrics{
+		requests:         registry.NewCounterVec("shortener_http_requests_total", "Number of HTTP requests by route pattern and status.", "method", "route", "status"),
+		duration:         registry.NewHistogramVec("shortener_http_request_duration_seconds", "HTTP request latency by route pattern and status.", DefBuckets, "method", "route", "status"),
+		PendingDeletions: registry.NewGauge("shortener_pending_deletions", "Number of async deletions that are not finished yet."),
+		Deletions:        registry.NewCounter("shortener_deletions_total", "Number of started async deletions."),
+	}
+}
+
+// Middleware учитывает запросы по шаблону маршрута chi, а не по пути,
+// чтобы количество рядов не зависело от количества коротких ссылок.
+func (httpMetrics *HTTPMetrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := unknownRoute
+		if routeContext := chi.RouteContext(r.Context()); routeContext != nil {
+			if pattern := routeContext.RoutePattern(); pattern != "" {
+				route = pattern
+			}
+		}
+		code := ww.Status()
+		if code == 0 {
+			// обработчик ничего не записал, net/http в этом случае отвечает 200
+			code = http.StatusOK
+		}
+		status := strconv.Itoa(code)
+		httpMetrics.requests.WithLabelValues(r.Method, route, status).Inc()
+		httpMetrics.duration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,396 @@
+// Package metrics реализует метрики в текстовом формате Prometheus:
+// счетчики, гейджи и гистограммы с метками, а также их отдачу по HTTP.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets границы гистограмм по умолчанию, в секундах.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// contentType тип ответа для текстового формата Prometheus.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// family одно семейство метрик с общими именем, описанием и типом.
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// Registry хранит зарегистрированные метрики и выводит их в текстовом формате Prometheus.
+type Registry struct {
+	mu       sync.Mutex
+	names    map[string]struct{}
+	families []family
+}
+
+// NewRegistry создает пустой Registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]struct{})}
+}
+
+// register добавляет семейство, имена метрик должны быть уникальны.
+func (registry *Registry) register(name string, f family) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, ok := registry.names[name]; ok {
+		panic(fmt.Sprintf("metrics: %s is already registered", name))
+	}
+	registry.names[name] = struct{}{}
+	registry.families = append(registry.families, f)
+}
+
+// WriteTo выводит все метрики в w в текстовом формате Prometheus.
+func (registry *Registry) WriteTo(w io.Writer) (int64, error) {
+	registry.mu.Lock()
+	families := append([]family(nil), registry.families...)
+	registry.mu.Unlock()
+
+	counter := &countingWriter{w: w}
+	writer := bufio.NewWriter(counter)
+	for _, f := range families {
+		f.write(writer)
+	}
+	err := writer.Flush()
+	return counter.n, err
+}
+
+// Handler возвращает обработчик, отдающий метрики.
+func (registry *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		registry.WriteTo(w)
+	})
+}
+
+// Counter монотонно растущий счетчик.
+type Counter struct {
+	bits uint64
+}
+
+// Inc увеличивает счетчик на единицу.
+func (counter *Counter) Inc() {
+	counter.Add(1)
+}
+
+// Add увеличивает счетчик на value, отрицательные значения игнорируются.
+func (counter *Counter) Add(value float64) {
+	if value < 0 {
+		return
+	}
+	addFloat(&counter.bits, value)
+}
+
+// Value возвращает текущее значение счетчика.
+func (counter *Counter) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&counter.bits))
+}
+
+// Gauge значение, которое может как расти, так и уменьшаться.
+type Gauge struct {
+	bits uint64
+}
+
+// Set устанавливает значение.
+func (gauge *Gauge) Set(value float64) {
+	atomic.StoreUint64(&gauge.bits, math.Float64bits(value))
+}
+
+// Inc увеличивает значение на единицу.
+func (gauge *Gauge) Inc() {
+	addFloat(&gauge.bits, 1)
+}
+
+// Dec уменьшает значение на единицу.
+func (gauge *Gauge) Dec() {
+	addFloat(&gauge.bits, -1)
+}
+
+// Value возвращает текущее значение.
+func (gauge *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&gauge.bits))
+}
+
+// Histogram распределение наблюдаемых значений по корзинам.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// Observe добавляет наблюдение.
+func (histogram *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(histogram.buckets, value)
+
+	histogram.mu.Lock()
+	if i < len(histogram.counts) {
+		histogram.counts[i]++
+	}
+	histogram.sum += value
+	histogram.count++
+	histogram.mu.Unlock()
+}
+
+// Count возвращает количество наблюдений.
+func (histogram *Histogram) Count() uint64 {
+	histogram.mu.Lock()
+	defer histogram.mu.Unlock()
+	return histogram.count
+}
+
+// vec набор метрик одного семейства, различающихся значениями меток.
+type vec[M any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	newM   func() *M
+	writeM func(w *bufio.Writer, name, labels string, m *M)
+
+	mu     sync.RWMutex
+	series map[string]*M
+	values map[string][]string
+}
+
+// with возвращает метрику для значений меток, создавая ее при первом обращении.
+func (v *vec[M]) with(values ...string) *M {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	m, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return m
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if m, ok := v.series[key]; ok {
+		return m
+	}
+	m = v.newM()
+	v.series[key] = m
+	v.values[key] = append([]string(nil), values...)
+	return m
+}
+
+func (v *vec[M]) write(w *bufio.Writer) {
+	writeHeader(w, v.name, v.help, v.kind)
+
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v.writeM(w, v.name, formatLabels(v.labels, v.values[key]), v.series[key])
+	}
+	v.mu.RUnlock()
+}
+
+// CounterVec счетчики с метками.
+type CounterVec struct {
+	vec[Counter]
+}
+
+// NewCounterVec регистрирует счетчики с метками labels.
+func (registry *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	counterVec := &CounterVec{vec[Counter]{
+		name: name, help: help, kind: "counter", labels: labels,
+		newM: func() *Counter { return &Counter{} },
+		writeM: func(w *bufio.Writer, name, labels string, counter *Counter) {
+			writeSample(w, name, labels, counter.Value())
+		},
+		series: make(map[string]*Counter),
+		values: make(map[string][]string),
+	}}
+	registry.register(name, counterVec)
+	return counterVec
+}
+
+// WithLabelValues возвращает счетчик для значений меток в порядке их объявления.
+func (counterVec *CounterVec) WithLabelValues(values ...string) *Counter {
+	return counterVec.with(values...)
+}
+
+// GaugeVec гейджи с метками.
+type GaugeVec struct {
+	vec[Gauge]
+}
+
+// NewGaugeVec регистрирует гейджи с метками labels.
+func (registry *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	gaugeVec := &GaugeVec{vec[Gauge]{
+		name: name, help: help, kind: "gauge", labels: labels,
+		newM: func() *Gauge { return &Gauge{} },
+		writeM: func(w *bufio.Writer, name, labels string, gauge *Gauge) {
+			writeSample(w, name, labels, gauge.Value())
+		},
+		series: make(map[string]*Gauge),
+		values: make(map[string][]string),
+	}}
+	registry.register(name, gaugeVec)
+	return gaugeVec
+}
+
+// WithLabelValues возвращает гейдж для значений меток в порядке их объявления.
+func (gaugeVec *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	return gaugeVec.with(values...)
+}
+
+// NewGauge регистрирует гейдж без меток.
+func (registry *Registry) NewGauge(name, help string) *Gauge {
+	return registry.NewGaugeVec(name, help).WithLabelValues()
+}
+
+// NewCounter регистрирует счетчик без меток.
+func (registry *Registry) NewCounter(name, help string) *Counter {
+	return registry.NewCounterVec(name, help).WithLabelValues()
+}
+
+// HistogramVec гистограммы с метками.
+type HistogramVec struct {
+	vec[Histogram]
+}
+
+// NewHistogramVec регистрирует гистограммы с границами корзин buckets и метками labels.
+func (registry *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	histogramVec := &HistogramVec{vec[Histogram]{
+		name: name, help: help, kind: "histogram", labels: labels,
+		newM: func() *Histogram {
+			return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+		},
+		writeM: writeHistogram,
+		series: make(map[string]*Histogram),
+		values: make(map[string][]string),
+	}}
+	registry.register(name, histogramVec)
+	return histogramVec
+}
+
+// WithLabelValues возвращает гистограмму для значений меток в порядке их объявления.
+func (histogramVec *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return histogramVec.with(values...)
+}
+
+// gaugeFunc гейдж, значение которого вычисляется при каждом выводе метрик.
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() (float64, bool)
+}
+
+// NewGaugeFunc регистрирует гейдж, значение которого возвращает fn.
+// Если fn возвращает false, значение не выводится.
+func (registry *Registry) NewGaugeFunc(name, help string, fn func() (float64, bool)) {
+	registry.register(name, &gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	if value, ok := g.fn(); ok {
+		writeSample(w, g.name, "", value)
+	}
+}
+
+// writeHistogram выводит корзины, сумму и количество наблюдений гистограммы.
+func writeHistogram(w *bufio.Writer, name, labels string, histogram *Histogram) {
+	histogram.mu.Lock()
+	counts := append([]uint64(nil), histogram.counts...)
+	sum, count := histogram.sum, histogram.count
+	histogram.mu.Unlock()
+
+	var cumulative uint64
+	for i, bound := range histogram.buckets {
+		cumulative += counts[i]
+		writeSample(w, name+"_bucket", joinLabels(labels, `le="`+formatFloat(bound)+`"`), float64(cumulative))
+	}
+	writeSample(w, name+"_bucket", joinLabels(labels, `le="+Inf"`), float64(count))
+	writeSample(w, name+"_sum", labels, sum)
+	writeSample(w, name+"_count", labels, float64(count))
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help), name, kind)
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+// labelReplacer экранирует значения меток по правилам текстового формата.
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelReplacer.Replace(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// addFloat атомарно прибавляет delta к float64, хранящемуся в bits.
+func addFloat(bits *uint64, delta float64) {
+	for {
+		old := atomic.LoadUint64(bits)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(bits, old, updated) {
+			return
+		}
+	}
+}
+
+// countingWriter считает количество записанных байт для WriteTo.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (writer *countingWriter) Write(p []byte) (int, error) {
+	n, err := writer.w.Write(p)
+	writer.n += int64(n)
+	return n, err
+}
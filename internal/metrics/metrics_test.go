@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("requests_total", "Requests.", "route", "status")
+	requests.WithLabelValues("/b", "200").Inc()
+	requests.WithLabelValues("/a", "200").Add(2)
+	requests.WithLabelValues(`/"q"`, "500").Inc()
+
+	pending := registry.NewGauge("pending", "Pending.")
+	pending.Inc()
+	pending.Inc()
+	pending.Dec()
+
+	duration := registry.NewHistogramVec("duration_seconds", "Duration.", []float64{0.1, 1}, "route")
+	duration.WithLabelValues("/a").Observe(0.05)
+	duration.WithLabelValues("/a").Observe(0.5)
+	duration.WithLabelValues("/a").Observe(5)
+
+	registry.NewGaugeFunc("urls", "Urls.", func() (float64, bool) { return 42, true })
+	registry.NewGaugeFunc("broken", "Broken.", func() (float64, bool) { return 0, false })
+
+	var out strings.Builder
+	_, err := registry.WriteTo(&out)
+	require.NoError(t, err)
+
+	expected := `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{route="/\"q\"",status="500"} 1
+requests_total{route="/a",status="200"} 2
+requests_total{route="/b",status="200"} 1
+# HELP pending Pending.
+# TYPE pending gauge
+pending 1
+# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="/a",le="0.1"} 1
+duration_seconds_bucket{route="/a",le="1"} 2
+duration_seconds_bucket{route="/a",le="+Inf"} 3
+duration_seconds_sum{route="/a"} 5.55
+duration_seconds_count{route="/a"} 3
+# HELP urls Urls.
+# TYPE urls gauge
+urls 42
+# HELP broken Broken.
+# TYPE broken gauge
+`
+	assert.Equal(t, expected, out.String())
+}
+
+func TestRegistryDuplicateName(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounter("requests_total", "Requests.")
+	assert.Panics(t, func() {
+		registry.NewGauge("requests_total", "Requests.")
+	})
+}
@@ -0,0 +1,294 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"go.uber.org/zap"
+)
+
+// storageBuckets границы гистограммы операций хранилища, в секундах.
+// Операции в памяти занимают микросекунды, поэтому нижние корзины мельче, чем в DefBuckets.
+var storageBuckets = []float64{.00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5}
+
+// countTimeout ограничивает подсчет ссылок и пользователей при выводе метрик.
+const countTimeout = 5 * time.Second
+
+// Storage декоратор storage.Storage, который учитывает длительность и ошибки каждой операции.
+type Storage struct {
+	storager storage.Storage
+	duration *HistogramVec
+	errors   *CounterVec
+}
+
+// NewStorage оборачивает storager и регистрирует метрики хранилища в registry.
+// Гейджи количества ссылок и пользователей считаются обернутым хранилищем без декораторов.
+func NewStorage(storager storage.Storage, registry *Registry) *Storage {
+	instrumented := &Storage{
+		storager: storager,
+		duration: registry.NewHistogramVec("shortener_storage_operation_duration_seconds", "Storage operation latency by method.", storageBuckets, "method"),
+		errors:   registry.NewCounterVec("shortener_storage_operation_errors_total", "Number of failed storage operations by method.", "method"),
+	}
+
+	counter := storage.Unwrap(storager)
+	registry.NewGaugeFunc("shortener_urls", "Number of stored short urls that are not deleted.", instrumented.gauge("CountURLs", counter.CountURLs))
+	registry.NewGaugeFunc("shortener_users", "Number of known users.", instrumented.gauge("CountUsers", counter.CountUsers))
+	return instrumented
+}
+
+// gauge возвращает функцию для гейджа, вызывающую count с учетом метрик операции.
+func (instrumented *Storage) gauge(method string, count func(ctx context.Context) (int, error)) func() (float64, bool) {
+	return func() (float64, bool) {
+		ctx, cancel := context.WithTimeout(context.Background(), countTimeout)
+		defer cancel()
+
+		start := time.Now()
+		value, err := count(ctx)
+		instrumented.observe(method, start, err)
+		if err != nil {
+			logger.Log.Error("Failed to count for metrics", zap.String("method", method), zap.Error(err))
+			return 0, false
+		}
+		return float64(value), true
+	}
+}
+
+// observe учитывает длительность и ошибку операции.
+func (instrumented *Storage) observe(method string, start time.Time, err error) {
+	instrumented.duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		instrumented.errors.WithLabelValues(method).Inc()
+	}
+}
+
+// Unwrap возвращает обернутое хранилище.
+func (instrumented *Storage) Unwrap() storage.Storage {
+	return instrumented.storager
+}
+
+// ReadAllData читает все данные из хранилища.
+func (instrumented *Storage) ReadAllData(ctx context.Context) error {
+	start := time.Now()
+	err := instrumented.storager.ReadAllData(ctx)
+	instrumented.observe("ReadAllData", start, err)
+	return err
+}
+
+// ReadAllDataForUserID читает страницу URL определенного пользователя из хранилища.
+func (instrumented *Storage) ReadAllDataForUserID(ctx context.Context, userID int, query models.URLQuery) ([]models.SavedURL, error) {
+	start := time.Now()
+	savedURLs, err := instrumented.storager.ReadAllDataForUserID(ctx, userID, query)
+	instrumented.observe("ReadAllDataForUserID", start, err)
+	return savedURLs, err
+}
+
+// StoreURL сохраняет URL в хранилище.
+func (instrumented *Storage) StoreURL(ctx context.Context, savedURL models.SavedURL) (bool, error) {
+	start := time.Now()
+	ok, err := instrumented.storager.StoreURL(ctx, savedURL)
+	instrumented.observe("StoreURL", start, err)
+	return ok, err
+}
+
+// StoreURLBatch сохраняет несколько URL в хранилище.
+func (instrumented *Storage) StoreURLBatch(ctx context.Context, forStore []models.SavedURL, userID int) ([]storage.BatchResult, error) {
+	start := time.Now()
+	results, err := instrumented.storager.StoreURLBatch(ctx, forStore, userID)
+	instrumented.observe("StoreURLBatch", start, err)
+	return results, err
+}
+
+// DeleteByUserID удаляет URL, принадлежащие определенному пользователю.
+func (instrumented *Storage) DeleteByUserID(ctx context.Context, shortURLs []string, userID int) error {
+	start := time.Now()
+	err := instrumented.storager.DeleteByUserID(ctx, shortURLs, userID)
+	instrumented.observe("DeleteByUserID", start, err)
+	return err
+}
+
+// DeleteURLs помечает удаленными URL из keys, каждый только у его владельца.
+func (instrumented *Storage) DeleteURLs(ctx context.Context, keys []storage.URLMapKey) ([]storage.URLMapKey, error) {
+	start := time.Now()
+	deleted, err := instrumented.storager.DeleteURLs(ctx, keys)
+	instrumented.observe("DeleteURLs", start, err)
+	return deleted, err
+}
+
+// RestoreURLs снимает пометку удаления с URL из keys, каждого только у его владельца.
+func (instrumented *Storage) RestoreURLs(ctx context.Context, keys []storage.URLMapKey, now time.Time) ([]storage.URLMapKey, error) {
+	start := time.Now()
+	restored, err := instrumented.storager.RestoreURLs(ctx, keys, now)
+	instrumented.observe("RestoreURLs", start, err)
+	return restored, err
+}
+
+// PurgeURLs безвозвратно удаляет URL из keys вместе с историей изменений.
+func (instrumented *Storage) PurgeURLs(ctx context.Context, keys []storage.URLMapKey) ([]storage.URLMapKey, error) {
+	start := time.Now()
+	purged, err := instrumented.storager.PurgeURLs(ctx, keys)
+	instrumented.observe("PurgeURLs", start, err)
+	return purged, err
+}
+
+// PurgeDeleted безвозвратно удаляет URL, помеченные удаленными не позже before.
+func (instrumented *Storage) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	start := time.Now()
+	count, err := instrumented.storager.PurgeDeleted(ctx, before)
+	instrumented.observe("PurgeDeleted", start, err)
+	return count, err
+}
+
+// UpdateURL меняет полный URL короткой ссылки и записывает изменение в историю.
+func (instrumented *Storage) UpdateURL(ctx context.Context, edit models.URLEdit, version int) (models.SavedURL, error) {
+	start := time.Now()
+	savedURL, err := instrumented.storager.UpdateURL(ctx, edit, version)
+	instrumented.observe("UpdateURL", start, err)
+	return savedURL, err
+}
+
+// ListURLEdits возвращает историю изменений короткого URL пользователя.
+func (instrumented *Storage) ListURLEdits(ctx context.Context, shortURL string, userID int) ([]models.URLEdit, error) {
+	start := time.Now()
+	edits, err := instrumented.storager.ListURLEdits(ctx, shortURL, userID)
+	instrumented.observe("ListURLEdits", start, err)
+	return edits, err
+}
+
+// DeleteExpired помечает удаленными все URL, срок действия которых истек к моменту now.
+func (instrumented *Storage) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	start := time.Now()
+	count, err := instrumented.storager.DeleteExpired(ctx, now)
+	instrumented.observe("DeleteExpired", start, err)
+	return count, err
+}
+
+// GetURLForAnyUserID получает URL, независимо от пользователя.
+func (instrumented *Storage) GetURLForAnyUserID(ctx context.Context, shortURL string) (models.SavedURL, bool, error) {
+	start := time.Now()
+	savedURL, ok, err := instrumented.storager.GetURLForAnyUserID(ctx, shortURL)
+	instrumented.observe("GetURLForAnyUserID", start, err)
+	return savedURL, ok, err
+}
+
+// GetURLForUserID получает URL, принадлежащий определенному пользователю.
+func (instrumented *Storage) GetURLForUserID(ctx context.Context, shortURL string, userID int) (models.SavedURL, bool, error) {
+	start := time.Now()
+	savedURL, ok, err := instrumented.storager.GetURLForUserID(ctx, shortURL, userID)
+	instrumented.observe("GetURLForUserID", start, err)
+	return savedURL, ok, err
+}
+
+// StoreClicks сохраняет пачку переходов по коротким URL.
+func (instrumented *Storage) StoreClicks(ctx context.Context, clicks []models.Click) error {
+	start := time.Now()
+	err := instrumented.storager.StoreClicks(ctx, clicks)
+	instrumented.observe("StoreClicks", start, err)
+	return err
+}
+
+// GetClickStats возвращает статистику переходов по короткому URL пользователя.
+func (instrumented *Storage) GetClickStats(ctx context.Context, shortURL string, userID int) (models.ClickStats, error) {
+	start := time.Now()
+	stats, err := instrumented.storager.GetClickStats(ctx, shortURL, userID)
+	instrumented.observe("GetClickStats", start, err)
+	return stats, err
+}
+
+// StoreAPIKey сохраняет новый ключ API.
+func (instrumented *Storage) StoreAPIKey(ctx context.Context, apiKey models.APIKey) error {
+	start := time.Now()
+	err := instrumented.storager.StoreAPIKey(ctx, apiKey)
+	instrumented.observe("StoreAPIKey", start, err)
+	return err
+}
+
+// GetAPIKeyByHash получает ключ API по хешу.
+func (instrumented *Storage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	start := time.Now()
+	apiKey, ok, err := instrumented.storager.GetAPIKeyByHash(ctx, hash)
+	instrumented.observe("GetAPIKeyByHash", start, err)
+	return apiKey, ok, err
+}
+
+// ListAPIKeys возвращает ключи API пользователя.
+func (instrumented *Storage) ListAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	start := time.Now()
+	apiKeys, err := instrumented.storager.ListAPIKeys(ctx, userID)
+	instrumented.observe("ListAPIKeys", start, err)
+	return apiKeys, err
+}
+
+// RevokeAPIKey отзывает ключ API пользователя.
+func (instrumented *Storage) RevokeAPIKey(ctx context.Context, id string, userID int, now time.Time) (bool, error) {
+	start := time.Now()
+	ok, err := instrumented.storager.RevokeAPIKey(ctx, id, userID, now)
+	instrumented.observe("RevokeAPIKey", start, err)
+	return ok, err
+}
+
+// TouchAPIKey запоминает время последнего использования ключа API.
+func (instrumented *Storage) TouchAPIKey(ctx context.Context, id string, now time.Time) error {
+	start := time.Now()
+	err := instrumented.storager.TouchAPIKey(ctx, id, now)
+	instrumented.observe("TouchAPIKey", start, err)
+	return err
+}
+
+// CreateUser регистрирует пользователя со следующим свободным идентификатором.
+func (instrumented *Storage) CreateUser(ctx context.Context, now time.Time) (models.User, error) {
+	start := time.Now()
+	user, err := instrumented.storager.CreateUser(ctx, now)
+	instrumented.observe("CreateUser", start, err)
+	return user, err
+}
+
+// GetUser получает зарегистрированного пользователя.
+func (instrumented *Storage) GetUser(ctx context.Context, userID int) (models.User, bool, error) {
+	start := time.Now()
+	user, ok, err := instrumented.storager.GetUser(ctx, userID)
+	instrumented.observe("GetUser", start, err)
+	return user, ok, err
+}
+
+// TouchUser запоминает время последней активности пользователя.
+func (instrumented *Storage) TouchUser(ctx context.Context, userID int, now time.Time) error {
+	start := time.Now()
+	err := instrumented.storager.TouchUser(ctx, userID, now)
+	instrumented.observe("TouchUser", start, err)
+	return err
+}
+
+// ReserveCodeCounter резервирует n следующих значений счетчика коротких кодов.
+func (instrumented *Storage) ReserveCodeCounter(ctx context.Context, n int) (uint64, error) {
+	start := time.Now()
+	first, err := instrumented.storager.ReserveCodeCounter(ctx, n)
+	instrumented.observe("ReserveCodeCounter", start, err)
+	return first, err
+}
+
+// CountURLs возвращает количество неудаленных коротких URL.
+func (instrumented *Storage) CountURLs(ctx context.Context) (int, error) {
+	start := time.Now()
+	count, err := instrumented.storager.CountURLs(ctx)
+	instrumented.observe("CountURLs", start, err)
+	return count, err
+}
+
+// CountUsers возвращает количество известных пользователей.
+func (instrumented *Storage) CountUsers(ctx context.Context) (int, error) {
+	start := time.Now()
+	count, err := instrumented.storager.CountUsers(ctx)
+	instrumented.observe("CountUsers", start, err)
+	return count, err
+}
+
+// PingContext проверяет соединение с хранилищем.
+func (instrumented *Storage) PingContext(ctx context.Context) error {
+	start := time.Now()
+	err := instrumented.storager.PingContext(ctx)
+	instrumented.observe("PingContext", start, err)
+	return err
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"github.com/theheadmen/urlShort/internal/storage/memory"
+	"github.com/theheadmen/urlShort/internal/storage/storagetest"
+)
+
+func TestStorageConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		return NewStorage(memory.NewMemoryStorage(), NewRegistry())
+	})
+}
+
+func TestStorageMetrics(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry()
+	inner := memory.NewMemoryStorage()
+	storager := NewStorage(inner, registry)
+	assert.Same(t, inner, storage.Unwrap(storager))
+
+	_, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: "a", OriginalURL: "https://example.com/a", UserID: 1})
+	require.NoError(t, err)
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "b", OriginalURL: "https://example.com/b", UserID: 2})
+	require.NoError(t, err)
+	require.NoError(t, storager.DeleteByUserID(ctx, []string{"b"}, 2))
+
+	assert.Equal(t, uint64(2), storager.duration.WithLabelValues("StoreURL").Count())
+	assert.Equal(t, float64(0), storager.errors.WithLabelValues("StoreURL").Value())
+
+	var out strings.Builder
+	_, err = registry.WriteTo(&out)
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "shortener_urls 1\n")
+	assert.Contains(t, out.String(), "shortener_users 2\n")
+	assert.Contains(t, out.String(), `shortener_storage_operation_duration_seconds_count{method="DeleteByUserID"} 1`)
+}
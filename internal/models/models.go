@@ -1,9 +1,17 @@
 // Package models содержит определения структур данных, используемых в приложении.
 package models
 
+import (
+	"strings"
+	"time"
+)
+
 // Request представляет собой структуру для запроса URL.
 type Request struct {
-	URL string `json:"url"`
+	URL         string     `json:"url"`
+	CustomAlias string     `json:"custom_alias,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	TTLSeconds  int64      `json:"ttl_seconds,omitempty"`
 }
 
 // Response представляет собой структуру для ответа с результатом обработки.
@@ -13,17 +21,33 @@ type Response struct {
 
 // SavedURL представляет собой структуру для сохраненного URL.
 type SavedURL struct {
-	UUID        int    `json:"uuid"`
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
-	UserID      int    `json:"user_id"`
-	Deleted     bool   `json:"deleted"`
+	UUID        int        `json:"uuid"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	UserID      int        `json:"user_id"`
+	Deleted     bool       `json:"deleted"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	// Version увеличивается при каждом изменении полного URL, у неизменявшихся URL равна нулю.
+	Version int `json:"version,omitempty"`
+	// DeletedAt время пометки удаленным, от него отсчитывается срок хранения удаленных URL.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Alias отмечает пользовательский код при сохранении: в отличие от сгенерированного, его не делят несколько пользователей.
+	// Не хранится, у прочитанных из хранилища URL всегда false.
+	Alias bool `json:"-"`
+}
+
+// IsExpired проверяет, истек ли срок действия URL к моменту now.
+func (savedURL SavedURL) IsExpired(now time.Time) bool {
+	return savedURL.ExpiresAt != nil && !savedURL.ExpiresAt.After(now)
 }
 
 // BatchRequest представляет собой структуру для пакетного запроса URL.
 type BatchRequest struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID string     `json:"correlation_id"`
+	OriginalURL   string     `json:"original_url"`
+	CustomAlias   string     `json:"custom_alias,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	TTLSeconds    int64      `json:"ttl_seconds,omitempty"`
 }
 
 // BatchResponse представляет собой структуру для пакетного ответа с сокращенным URL.
@@ -32,8 +56,213 @@ type BatchResponse struct {
 	ShortURL      string `json:"short_url"`
 }
 
+// BatchItemStatus представляет собой результат сохранения одного URL при потоковой пакетной загрузке.
+type BatchItemStatus string
+
+const (
+	// BatchItemCreated URL сохранен.
+	BatchItemCreated BatchItemStatus = "created"
+	// BatchItemConflict такой короткий URL уже был сохранен или его код занят.
+	BatchItemConflict BatchItemStatus = "conflict"
+	// BatchItemInvalid строку запроса не удалось разобрать или в ней некорректные данные.
+	BatchItemInvalid BatchItemStatus = "invalid"
+)
+
+// BatchItemResult представляет собой структуру для результата одного URL при потоковой пакетной загрузке.
+type BatchItemResult struct {
+	CorrelationID string          `json:"correlation_id"`
+	ShortURL      string          `json:"short_url,omitempty"`
+	Status        BatchItemStatus `json:"status"`
+	Error         string          `json:"error,omitempty"`
+}
+
 // BatchByUserIDResponse представляет собой структуру для пакетного ответа с URL, принадлежащих определенному пользователю.
+// Deleted выводится только для удаленных URL, чтобы ответ для остальных не менялся.
 type BatchByUserIDResponse struct {
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
+	Deleted     bool   `json:"deleted,omitempty"`
+	Version     int    `json:"version,omitempty"`
+}
+
+// UpdateURLRequest представляет собой структуру запроса на изменение полного URL короткой ссылки.
+type UpdateURLRequest struct {
+	OriginalURL string `json:"original_url"`
+}
+
+// RestoreResponse представляет собой структуру ответа на восстановление удаленных URL.
+// Skipped содержит ненайденные, чужие, неудаленные и просроченные URL.
+type RestoreResponse struct {
+	Restored []string `json:"restored"`
+	Skipped  []string `json:"skipped"`
+}
+
+// PurgeResponse представляет собой структуру ответа на окончательное удаление URL.
+// Skipped содержит ненайденные и чужие URL.
+type PurgeResponse struct {
+	Purged  []string `json:"purged"`
+	Skipped []string `json:"skipped"`
+}
+
+// URLEdit представляет собой структуру записи истории изменений полного URL.
+// Version версия URL после изменения, APIKeyID заполнен, если изменение сделано ключом API.
+type URLEdit struct {
+	ShortURL    string    `json:"short_url"`
+	UserID      int       `json:"user_id"`
+	APIKeyID    string    `json:"api_key_id,omitempty"`
+	PreviousURL string    `json:"previous_url"`
+	OriginalURL string    `json:"original_url"`
+	Version     int       `json:"version"`
+	EditedAt    time.Time `json:"edited_at"`
+}
+
+// URLSort поле, по которому упорядочиваются URL пользователя.
+type URLSort string
+
+const (
+	// SortByCreated упорядочивает URL в порядке сохранения.
+	SortByCreated URLSort = "created"
+	// SortByShortURL упорядочивает URL по короткому коду.
+	SortByShortURL URLSort = "short_url"
+)
+
+// URLCursor позиция последнего URL предыдущей страницы.
+// Следующая страница начинается сразу после него в порядке выборки.
+type URLCursor struct {
+	UUID     int    `json:"id"`
+	ShortURL string `json:"short_url"`
+}
+
+// URLQuery параметры выборки URL пользователя. Нулевое значение выбирает все URL в порядке сохранения.
+type URLQuery struct {
+	Sort URLSort
+	Desc bool
+	// Deleted, если задан, оставляет только удаленные или только неудаленные URL
+	Deleted *bool
+	// Contains, если не пуст, оставляет URL, исходный адрес которых содержит эту подстроку
+	Contains string
+	After    *URLCursor
+	// Limit ограничивает размер страницы, 0 означает без ограничения
+	Limit int
+}
+
+// Match проверяет, проходит ли URL фильтры выборки, не учитывая курсор.
+func (query URLQuery) Match(savedURL SavedURL) bool {
+	if query.Deleted != nil && savedURL.Deleted != *query.Deleted {
+		return false
+	}
+	return query.Contains == "" || strings.Contains(savedURL.OriginalURL, query.Contains)
+}
+
+// Less сравнивает два URL в порядке выборки.
+func (query URLQuery) Less(a, b URLCursor) bool {
+	var less bool
+	if query.Sort == SortByShortURL {
+		less = a.ShortURL < b.ShortURL || a.ShortURL == b.ShortURL && a.UUID < b.UUID
+	} else {
+		less = a.UUID < b.UUID || a.UUID == b.UUID && a.ShortURL < b.ShortURL
+	}
+	if query.Desc {
+		return !less && a != b
+	}
+	return less
+}
+
+// CursorOf возвращает позицию URL для курсора.
+func CursorOf(savedURL SavedURL) URLCursor {
+	return URLCursor{UUID: savedURL.UUID, ShortURL: savedURL.ShortURL}
+}
+
+// Click представляет собой структуру одного перехода по короткому URL.
+type Click struct {
+	ShortURL  string    `json:"short_url"`
+	UserID    int       `json:"user_id,omitempty"` // владелец короткого URL, который обслужил редирект
+	Timestamp time.Time `json:"timestamp"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IPHash    string    `json:"ip_hash"`
+}
+
+// DailyClicks представляет собой структуру с количеством переходов за один день (UTC).
+type DailyClicks struct {
+	Date   string `json:"date"`
+	Clicks int    `json:"clicks"`
+}
+
+// ClickStats представляет собой структуру для ответа со статистикой переходов по короткому URL.
+type ClickStats struct {
+	ShortURL       string        `json:"short_url"`
+	TotalClicks    int           `json:"total_clicks"`
+	UniqueVisitors int           `json:"unique_visitors"`
+	Daily          []DailyClicks `json:"daily"`
+}
+
+// InternalStats представляет собой структуру ответа со статистикой сервиса для внутренних систем.
+type InternalStats struct {
+	URLs  int `json:"urls"`
+	Users int `json:"users"`
+}
+
+// APIKey представляет собой структуру ключа API пользователя.
+// Сам ключ не хранится, по нему вычисляется Hash, которым ключ ищется при проверке.
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"hash"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// IsRevoked проверяет, отозван ли ключ.
+func (apiKey APIKey) IsRevoked() bool {
+	return apiKey.RevokedAt != nil
+}
+
+// APIKeyRequest представляет собой структуру запроса на создание ключа API.
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// APIKeyResponse представляет собой структуру ключа API в ответах сервера.
+// Key заполняется только при создании, позже получить ключ нельзя.
+type APIKeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Key        string     `json:"key,omitempty"`
+}
+
+// User представляет собой структуру пользователя, которому выдана кука.
+type User struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Статусы задачи удаления.
+const (
+	DeleteJobQueued  = "queued"
+	DeleteJobRunning = "running"
+	DeleteJobDone    = "done"
+	DeleteJobFailed  = "failed"
+)
+
+// DeleteJob представляет собой структуру состояния асинхронной задачи удаления URL.
+// Deleted считает URL, помеченные задачей, Skipped — ненайденные, чужие и уже удаленные.
+type DeleteJob struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Deleted    int        `json:"deleted"`
+	Skipped    int        `json:"skipped"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
 }
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval как часто MemoryBackend удаляет состояние ключей, лимит которых восстановился полностью.
+const sweepInterval = time.Minute
+
+// bucket состояние лимита одного ключа.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full момент, когда лимит восстановится полностью и состояние можно забыть
+	full time.Time
+}
+
+// MemoryBackend хранит состояние лимитов в памяти процесса.
+// Ключ, лимит которого восстановился полностью, ничем не отличается от нового, поэтому его состояние удаляется.
+type MemoryBackend struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewMemoryBackend создает пустой MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow тратит один запрос из лимита policy ключа key, если он еще есть.
+func (backend *MemoryBackend) Allow(ctx context.Context, key string, policy Policy, now time.Time) (Decision, error) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	if now.Sub(backend.lastSweep) >= sweepInterval {
+		backend.sweep(now)
+	}
+
+	capacity := float64(policy.Limit)
+	rate := policy.rate()
+	b, ok := backend.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		backend.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*rate)
+		b.updated = now
+	}
+
+	decision := Decision{Limit: policy.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = secondsToDuration((1 - b.tokens) / rate)
+	}
+	decision.Remaining = int(math.Floor(b.tokens))
+	decision.Reset = secondsToDuration((capacity - b.tokens) / rate)
+	b.full = now.Add(decision.Reset)
+	return decision, nil
+}
+
+// sweep удаляет состояние ключей, лимит которых восстановился полностью, вызывается под mu.
+func (backend *MemoryBackend) sweep(now time.Time) {
+	for key, b := range backend.buckets {
+		if !b.full.After(now) {
+			delete(backend.buckets, key)
+		}
+	}
+	backend.lastSweep = now
+}
+
+// secondsToDuration переводит дробное количество секунд в time.Duration.
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
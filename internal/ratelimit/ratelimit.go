@@ -0,0 +1,156 @@
+// Package ratelimit содержит ограничение частоты запросов по алгоритму token bucket.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Группы маршрутов, для которых задаются отдельные лимиты.
+const (
+	GroupShorten  = "shorten"
+	GroupBatch    = "batch"
+	GroupRedirect = "redirect"
+	GroupUser     = "user"
+)
+
+var knownGroups = map[string]struct{}{
+	GroupShorten:  {},
+	GroupBatch:    {},
+	GroupRedirect: {},
+	GroupUser:     {},
+}
+
+// ErrInvalidPolicy возвращается, если лимиты заданы не в формате group=limit/window.
+var ErrInvalidPolicy = errors.New("invalid rate limit policy")
+
+// Policy лимит группы маршрутов: Limit запросов за Window.
+// Все Limit запросов можно сделать подряд, дальше они восстанавливаются равномерно в течение Window.
+type Policy struct {
+	Limit  int
+	Window time.Duration
+}
+
+// rate скорость восстановления запросов в секунду.
+func (policy Policy) rate() float64 {
+	return float64(policy.Limit) / policy.Window.Seconds()
+}
+
+// Decision результат проверки лимита для одного запроса.
+type Decision struct {
+	// Allowed разрешен ли запрос.
+	Allowed bool
+	// Limit сколько запросов можно сделать подряд.
+	Limit int
+	// Remaining сколько запросов осталось сделать подряд.
+	Remaining int
+	// Reset через сколько лимит восстановится полностью.
+	Reset time.Duration
+	// RetryAfter через сколько можно повторить отклоненный запрос.
+	RetryAfter time.Duration
+}
+
+// Backend хранит состояние лимитов. MemoryBackend держит его в памяти процесса,
+// а общий для нескольких экземпляров сервера backend реализует тот же интерфейс.
+type Backend interface {
+	// Allow тратит один запрос из лимита policy ключа key, если он еще есть.
+	Allow(ctx context.Context, key string, policy Policy, now time.Time) (Decision, error)
+}
+
+// Limiter проверяет лимиты групп маршрутов для ключей клиентов.
+type Limiter struct {
+	backend  Backend
+	policies map[string]Policy
+	proxies  TrustedProxies
+}
+
+// NewLimiter создает Limiter с лимитами policies. Группы без лимита не ограничиваются.
+// proxies задает прокси, которым доверяется X-Forwarded-For при определении адреса клиента.
+func NewLimiter(backend Backend, policies map[string]Policy, proxies TrustedProxies) *Limiter {
+	return &Limiter{
+		backend:  backend,
+		policies: policies,
+		proxies:  proxies,
+	}
+}
+
+// Allow тратит один запрос из лимита группы group для ключа клиента key.
+// Возвращает false вторым значением, если для группы лимит не задан.
+func (limiter *Limiter) Allow(ctx context.Context, group string, key string) (Decision, bool, error) {
+	policy, ok := limiter.policies[group]
+	if !ok {
+		return Decision{}, false, nil
+	}
+	decision, err := limiter.backend.Allow(ctx, group+":"+key, policy, time.Now())
+	return decision, true, err
+}
+
+// ClientIP возвращает адрес клиента запроса, см. TrustedProxies.ClientIP.
+func (limiter *Limiter) ClientIP(r *http.Request) string {
+	return limiter.proxies.ClientIP(r)
+}
+
+// WriteHeaders пишет заголовки RateLimit-Limit, RateLimit-Remaining и RateLimit-Reset,
+// а для отклоненного запроса еще и Retry-After.
+func WriteHeaders(w http.ResponseWriter, decision Decision) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+	if !decision.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+	}
+}
+
+// ceilSeconds округляет d вверх до целых секунд.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ParsePolicies разбирает лимиты групп маршрутов вида shorten=60/1m,batch=10/1m.
+// Пустая строка означает, что лимитов нет.
+func ParsePolicies(spec string) (map[string]Policy, error) {
+	policies := make(map[string]Policy)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		group, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPolicy, item)
+		}
+		group = strings.TrimSpace(group)
+		if _, ok := knownGroups[group]; !ok {
+			return nil, fmt.Errorf("%w: unknown group %q", ErrInvalidPolicy, group)
+		}
+		policy, err := parsePolicy(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", err, item)
+		}
+		policies[group] = policy
+	}
+	return policies, nil
+}
+
+// parsePolicy разбирает лимит вида 60/1m.
+func parsePolicy(value string) (Policy, error) {
+	limit, window, ok := strings.Cut(strings.TrimSpace(value), "/")
+	if !ok {
+		return Policy{}, ErrInvalidPolicy
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 1 {
+		return Policy{}, ErrInvalidPolicy
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return Policy{}, ErrInvalidPolicy
+	}
+	return Policy{Limit: n, Window: d}, nil
+}
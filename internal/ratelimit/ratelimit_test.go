@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePolicies(t *testing.T) {
+	policies, err := ParsePolicies("shorten=60/1m, batch=10/1h,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Policy{
+		GroupShorten: {Limit: 60, Window: time.Minute},
+		GroupBatch:   {Limit: 10, Window: time.Hour},
+	}, policies)
+
+	policies, err = ParsePolicies("")
+	require.NoError(t, err)
+	assert.Empty(t, policies)
+
+	for _, invalid := range []string{"shorten", "shorten=60", "shorten=0/1m", "shorten=60/0s", "shorten=x/1m", "admin=1/1s"} {
+		_, err := ParsePolicies(invalid)
+		assert.ErrorIs(t, err, ErrInvalidPolicy, invalid)
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryBackend()
+	policy := Policy{Limit: 2, Window: 2 * time.Second}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	decision, err := backend.Allow(ctx, "a", policy, now)
+	require.NoError(t, err)
+	assert.Equal(t, Decision{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}, decision)
+
+	decision, err = backend.Allow(ctx, "a", policy, now)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, 0, decision.Remaining)
+
+	decision, err = backend.Allow(ctx, "a", policy, now)
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed, "Лимит исчерпан")
+	assert.Equal(t, time.Second, decision.RetryAfter)
+	assert.Equal(t, 2*time.Second, decision.Reset)
+
+	decision, err = backend.Allow(ctx, "b", policy, now)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed, "У другого ключа свой лимит")
+
+	// запросы восстанавливаются равномерно
+	decision, err = backend.Allow(ctx, "a", policy, now.Add(time.Second))
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, 0, decision.Remaining)
+
+	// восстановившиеся ключи забываются
+	_, err = backend.Allow(ctx, "c", policy, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, backend.buckets, 1)
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	require.NoError(t, err)
+	_, err = ParseTrustedProxies("proxy.local")
+	assert.Error(t, err)
+
+	testCases := []struct {
+		name          string
+		remoteAddr    string
+		forwardedFor  string
+		expectedValue string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.5:1234", expectedValue: "203.0.113.5"},
+		{name: "untrusted_proxy", remoteAddr: "203.0.113.5:1234", forwardedFor: "198.51.100.7", expectedValue: "203.0.113.5"},
+		{name: "trusted_proxy", remoteAddr: "192.168.1.1:1234", forwardedFor: "198.51.100.7", expectedValue: "198.51.100.7"},
+		{name: "proxy_chain", remoteAddr: "10.0.0.2:1234", forwardedFor: "1.1.1.1, 198.51.100.7, 10.0.0.1", expectedValue: "198.51.100.7"},
+		{name: "garbage", remoteAddr: "10.0.0.2:1234", forwardedFor: "1.1.1.1, unknown", expectedValue: "10.0.0.2"},
+		{name: "only_proxies", remoteAddr: "10.0.0.2:1234", forwardedFor: "10.0.0.1", expectedValue: "10.0.0.1"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			assert.Equal(t, tc.expectedValue, proxies.ClientIP(r))
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package serverapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/theheadmen/urlShort/internal/auth"
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// apiKeyTouchInterval как часто обновляется время последнего использования ключа API,
+	// чтобы не писать в хранилище на каждый запрос.
+	apiKeyTouchInterval = time.Minute
+	// maxAPIKeyNameLength максимальная длина названия ключа API.
+	maxAPIKeyNameLength = 100
+)
+
+var (
+	// errNoUser возвращается, если в запросе нет ни ключа API, ни куки пользователя.
+	errNoUser = errors.New("request is not authenticated")
+	// errUnknownAPIKey возвращается, если ключа API нет в хранилище или он отозван.
+	errUnknownAPIKey = errors.New("api key is unknown or revoked")
+)
+
+// principal пользователь, от имени которого выполняется запрос.
+// apiKey заполнен, если запрос подписан ключом API, а не кукой.
+type principal struct {
+	userID int
+	apiKey *models.APIKey
+}
+
+// principalKey ключ контекста для principal.
+type principalKey struct{}
+
+// withPrincipal сохраняет пользователя запроса в ctx.
+func withPrincipal(ctx context.Context, p principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalFromContext возвращает пользователя, которого authMiddleware сохранил в ctx.
+func principalFromContext(ctx context.Context) (principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(principal)
+	return p, ok
+}
+
+// getUserID возвращает идентификатор пользователя запроса: из ключа API или куки, проверенных authMiddleware,
+// либо, если обработчик вызван без authMiddleware, из куки запроса.
+func (dataStore *ServerDataStore) getUserID(r *http.Request) (int, error) {
+	if p, ok := principalFromContext(r.Context()); ok {
+		return p.userID, nil
+	}
+	token, userID, err := dataStore.getTokenAndUserID(r)
+	if err != nil {
+		return 0, err
+	}
+	if !token.Valid {
+		return 0, errNoUser
+	}
+	return userID, nil
+}
+
+// bearerToken извлекает ключ из заголовка Authorization: Bearer <key>.
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// authenticateAPIKey проверяет ключ API и передает запрос дальше от имени его владельца.
+// Куки для таких запросов не выдаются.
+func (dataStore *ServerDataStore) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	apiKey, err := dataStore.findAPIKey(r.Context(), key)
+	if errors.Is(err, auth.ErrInvalidAPIKey) || errors.Is(err, errUnknownAPIKey) {
+		unauthorizedAPIKey(w, err)
+		return
+	}
+	if err != nil {
+		logger.Log.Error("can't get api key", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyTouchInterval {
+		// запрос можно обслужить и без отметки об использовании, поэтому ошибка только логируется
+		if err := dataStore.storager.TouchAPIKey(r.Context(), apiKey.ID, now); err != nil {
+			logger.Log.Error("can't touch api key", zap.Error(err), zap.String("id", apiKey.ID))
+		}
+	}
+	logger.Log.Debug("API key is finded", zap.String("id", apiKey.ID), zap.Int("userID", apiKey.UserID))
+
+	next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), principal{userID: apiKey.UserID, apiKey: &apiKey})))
+}
+
+// findAPIKey проверяет ключ API по хранилищу и возвращает его запись.
+// Возвращает auth.ErrInvalidAPIKey для ключа неверного формата и errUnknownAPIKey для неизвестного или отозванного.
+func (dataStore *ServerDataStore) findAPIKey(ctx context.Context, key string) (models.APIKey, error) {
+	if err := auth.ValidateAPIKey(key); err != nil {
+		return models.APIKey{}, err
+	}
+	apiKey, ok, err := dataStore.storager.GetAPIKeyByHash(ctx, auth.HashAPIKey(key))
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	if !ok || apiKey.IsRevoked() {
+		return models.APIKey{}, errUnknownAPIKey
+	}
+	return apiKey, nil
+}
+
+// unauthorizedAPIKey отвечает 401 на запрос с неверным ключом API.
+func unauthorizedAPIKey(w http.ResponseWriter, err error) {
+	logger.Log.Error("invalid api key", zap.Error(err))
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// requireScope пропускает запросы с ключом API, только если ключ разрешает действие scope.
+// Запросы с кукой не ограничиваются.
+func (dataStore *ServerDataStore) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if p, ok := principalFromContext(r.Context()); ok && p.apiKey != nil && !auth.HasScope(p.apiKey.Scopes, scope) {
+				logger.Log.Info("API key scope is insufficient", zap.String("id", p.apiKey.ID), zap.String("scope", scope))
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireCookie пропускает только запросы с кукой. Ключами API нельзя управлять по ключу API,
+// иначе ключ с ограниченными областями мог бы выпустить себе ключ без ограничений.
+func (dataStore *ServerDataStore) requireCookie(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := principalFromContext(r.Context()); ok && p.apiKey != nil {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// createAPIKeyHandler обрабатывает POST-запросы для создания ключа API.
+// Ключ возвращается в ответе один раз, в хранилище сохраняется только его хеш.
+func (dataStore *ServerDataStore) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := dataStore.getUserID(r)
+	if err != nil {
+		logger.Log.Error("cannot find cookie", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logger.Log.Error("Error reading request body", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var request models.APIKeyRequest
+	if err := dataStore.json.Unmarshal(body, &request); err != nil {
+		logger.Log.Error("cannot decode request JSON body", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(request.Name) > maxAPIKeyNameLength {
+		http.Error(w, "name is too long", http.StatusBadRequest)
+		return
+	}
+	scopes, err := auth.NormalizeScopes(request.Scopes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, key, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		logger.Log.Error("cannot generate api key", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	apiKey := models.APIKey{
+		ID:        id,
+		UserID:    userID,
+		Name:      request.Name,
+		Hash:      hash,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := dataStore.storager.StoreAPIKey(r.Context(), apiKey); err != nil {
+		logger.Log.Error("cannot store api key", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	logger.Log.Info("API key is created", zap.String("id", id), zap.Int("userID", userID), zap.Strings("scopes", scopes))
+
+	response := newAPIKeyResponse(apiKey)
+	response.Key = key
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := dataStore.json.NewEncoder(w).Encode(response); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+// listAPIKeysHandler обрабатывает GET-запросы для получения ключей API пользователя, включая отозванные.
+func (dataStore *ServerDataStore) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := dataStore.getUserID(r)
+	if err != nil {
+		logger.Log.Error("cannot find cookie", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	apiKeys, err := dataStore.storager.ListAPIKeys(r.Context(), userID)
+	if err != nil {
+		logger.Log.Error("cannot list api keys", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]models.APIKeyResponse, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		response = append(response, newAPIKeyResponse(apiKey))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := dataStore.json.NewEncoder(w).Encode(response); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+// revokeAPIKeyHandler обрабатывает DELETE-запросы для отзыва ключа API пользователя.
+func (dataStore *ServerDataStore) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := dataStore.getUserID(r)
+	if err != nil {
+		logger.Log.Error("cannot find cookie", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	id := chi.URLParam(r, "keyID")
+	ok, err := dataStore.storager.RevokeAPIKey(r.Context(), id, userID, time.Now().UTC())
+	if err != nil {
+		logger.Log.Error("cannot revoke api key", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	logger.Log.Info("API key is revoked", zap.String("id", id), zap.Int("userID", userID))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newAPIKeyResponse переводит ключ API в ответ без хеша.
+func newAPIKeyResponse(apiKey models.APIKey) models.APIKeyResponse {
+	scopes := apiKey.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return models.APIKeyResponse{
+		ID:         apiKey.ID,
+		Name:       apiKey.Name,
+		Scopes:     scopes,
+		CreatedAt:  apiKey.CreatedAt,
+		LastUsedAt: apiKey.LastUsedAt,
+		RevokedAt:  apiKey.RevokedAt,
+	}
+}
@@ -0,0 +1,188 @@
+package serverapi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"go.uber.org/zap"
+)
+
+// urlETag возвращает ETag версии короткого URL.
+func urlETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// matchesIfMatch проверяет заголовок If-Match по правилам сильного сравнения:
+// подходит "*" или один из перечисленных ETag, слабые ETag не подходят никогда.
+func matchesIfMatch(header string, version int) bool {
+	etag := urlETag(version)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// getUserURLHandler обрабатывает GET-запросы для получения короткого URL пользователя.
+// Версия URL передается в заголовке ETag, ее можно вернуть в If-Match при изменении.
+func (dataStore *ServerDataStore) getUserURLHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := dataStore.getUserID(r)
+	if err != nil {
+		logger.Log.Error("cannot find cookie", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	shortURL := chi.URLParam(r, "shortUrl")
+	savedURL, ok, err := dataStore.storager.GetURLForUserID(r.Context(), shortURL, userID)
+	if err != nil {
+		logger.Log.Error("cannot get data for id", zap.String("id", shortURL), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		logger.Log.Info("url is not found for user", zap.String("id", shortURL), zap.Int("userID", userID))
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	dataStore.writeUserURL(w, savedURL)
+}
+
+// updateURLHandler обрабатывает PATCH-запросы для изменения полного URL короткой ссылки.
+// Менять URL может только его владелец. Если передан If-Match, URL меняется, только пока его версия
+// совпадает с переданной, иначе отвечается 412. Каждое изменение сохраняется в историю.
+func (dataStore *ServerDataStore) updateURLHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := dataStore.getUserID(r)
+	if err != nil {
+		logger.Log.Error("cannot find cookie", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var req models.UpdateURLRequest
+	if err := dataStore.json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Log.Error("cannot decode request JSON body", zap.Error(err))
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	if req.OriginalURL == "" {
+		logger.Log.Debug("after decoding JSON we don't have any URL")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	shortURL := chi.URLParam(r, "shortUrl")
+	version := storage.AnyVersion
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		current, ok, err := dataStore.storager.GetURLForUserID(r.Context(), shortURL, userID)
+		if err != nil {
+			logger.Log.Error("cannot get data for id", zap.String("id", shortURL), zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !ok || current.Deleted {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !matchesIfMatch(ifMatch, current.Version) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		// хранилище еще раз сверит версию, так что изменение между чтением и записью тоже не пройдет
+		version = current.Version
+	}
+
+	edit := models.URLEdit{
+		ShortURL:    shortURL,
+		UserID:      userID,
+		OriginalURL: req.OriginalURL,
+		EditedAt:    time.Now().UTC(),
+	}
+	if p, ok := principalFromContext(r.Context()); ok && p.apiKey != nil {
+		edit.APIKeyID = p.apiKey.ID
+	}
+
+	savedURL, err := dataStore.storager.UpdateURL(r.Context(), edit, version)
+	switch {
+	case errors.Is(err, storage.ErrURLNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, storage.ErrVersionMismatch):
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	case errors.Is(err, storage.ErrDuplicateURL), errors.Is(err, storage.ErrSharedURL):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		logger.Log.Error("cannot update url", zap.String("id", shortURL), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	logger.Log.Info("URL is updated", zap.String("id", shortURL), zap.Int("userID", userID), zap.Int("version", savedURL.Version))
+
+	dataStore.writeUserURL(w, savedURL)
+}
+
+// listURLEditsHandler обрабатывает GET-запросы для получения истории изменений короткого URL.
+// История доступна только владельцу короткого URL.
+func (dataStore *ServerDataStore) listURLEditsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := dataStore.getUserID(r)
+	if err != nil {
+		logger.Log.Error("cannot find cookie", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	shortURL := chi.URLParam(r, "shortUrl")
+	_, ok, err := dataStore.storager.GetURLForUserID(r.Context(), shortURL, userID)
+	if err != nil {
+		logger.Log.Error("cannot get data for id", zap.String("id", shortURL), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	edits, err := dataStore.storager.ListURLEdits(r.Context(), shortURL, userID)
+	if err != nil {
+		logger.Log.Error("cannot list url edits", zap.String("id", shortURL), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := dataStore.json.NewEncoder(w).Encode(edits); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+// writeUserURL отвечает коротким URL пользователя с его версией в ETag.
+func (dataStore *ServerDataStore) writeUserURL(w http.ResponseWriter, savedURL models.SavedURL) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", urlETag(savedURL.Version))
+	w.WriteHeader(http.StatusOK)
+	resp := models.BatchByUserIDResponse{
+		ShortURL:    dataStore.configStore.FlagShortRunAddr + "/" + savedURL.ShortURL,
+		OriginalURL: savedURL.OriginalURL,
+		Deleted:     savedURL.Deleted,
+		Version:     savedURL.Version,
+	}
+	if err := dataStore.json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+		return
+	}
+}
@@ -0,0 +1,410 @@
+package serverapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/shortcode"
+	"go.uber.org/zap"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	mediaTypeNDJSON = "application/x-ndjson"
+	mediaTypeCSV    = "text/csv"
+
+	// importChunkSize сколько URL потоковой загрузки сохраняется в хранилище за раз
+	importChunkSize = 1000
+	// maxImportLineSize наибольшая длина строки NDJSON
+	maxImportLineSize = 1 << 20
+)
+
+// errInvalidBatchItem возвращается, если строку потоковой загрузки не удалось разобрать.
+// Такая строка получает статус invalid, а загрузка продолжается.
+var errInvalidBatchItem = errors.New("invalid batch item")
+
+// csvResultHeader заголовок CSV-ответа потоковой загрузки.
+var csvResultHeader = []string{"correlation_id", "short_url", "status", "error"}
+
+// batchItemReader читает URL потоковой загрузки по одному, в конце возвращает io.EOF.
+type batchItemReader interface {
+	Read() (models.BatchRequest, error)
+}
+
+// batchResultWriter пишет результаты потоковой загрузки по одному.
+type batchResultWriter interface {
+	Write(result models.BatchItemResult) error
+	Flush() error
+}
+
+// postBatchHandler обрабатывает POST-запросы для пакетного сокращения URL.
+// JSON-массив разбирается и сохраняется целиком в прежнем формате ответа, а NDJSON и CSV читаются
+// и сохраняются потоково со статусом для каждого URL, см. importBatch.
+func (dataStore *ServerDataStore) postBatchHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mediaTypeNDJSON:
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineSize)
+		dataStore.importBatch(w, r, &ndjsonItemReader{scanner: scanner, json: dataStore.json},
+			&ndjsonResultWriter{writer: bufio.NewWriter(w), json: dataStore.json}, mediaTypeNDJSON)
+	case mediaTypeCSV:
+		reader, err := newCSVItemReader(r.Body)
+		if err != nil {
+			logger.Log.Debug("cannot read csv header", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		dataStore.importBatch(w, r, reader, &csvResultWriter{writer: csv.NewWriter(w)}, mediaTypeCSV+"; charset=utf-8")
+	default:
+		dataStore.postBatchJSONHandler(w, r)
+	}
+}
+
+// streamingBody разрешает читать тело NDJSON и CSV запросов после начала ответа: без этого HTTP/1.1 сервер
+// закрывает тело при первой записи в ответ. Должен стоять до middleware, чьи ResponseWriter не умеют Unwrap.
+func streamingBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == mediaTypeNDJSON || mediaType == mediaTypeCSV {
+			if err := http.NewResponseController(w).EnableFullDuplex(); err != nil {
+				logger.Log.Debug("cannot enable full duplex", zap.Error(err))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// pendingImport URL потоковой загрузки, который ждет сохранения вместе с остальными URL своей пачки.
+type pendingImport struct {
+	index    int
+	savedURL models.SavedURL
+}
+
+// importBatch читает URL из reader пачками по importChunkSize, сохраняет каждую пачку в хранилище
+// и сразу пишет в writer результаты ее строк в порядке запроса.
+// Ошибка в одной строке не прерывает загрузку: строка получает статус invalid или conflict.
+// Заголовки ответа отправляются вместе с результатами первой пачки, поэтому ошибка до этого момента
+// возвращается обычным кодом ответа, а после него загрузка обрывается и часть результатов отсутствует.
+func (dataStore *ServerDataStore) importBatch(w http.ResponseWriter, r *http.Request, reader batchItemReader, writer batchResultWriter, contentType string) {
+	userID, err := dataStore.ensureUserID(w, r)
+	if err != nil {
+		logger.Log.Error("cannot get user for request", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	servShortURL := dataStore.configStore.FlagShortRunAddr
+	results := make([]models.BatchItemResult, 0, importChunkSize)
+	pending := make([]pendingImport, 0, importChunkSize)
+	// короткие URL внутри пачки не должны пересекаться, с предыдущими пачками сверяется хранилище
+	inChunk := make(map[string]string, importChunkSize)
+	started := false
+	total := 0
+
+	flush := func() error {
+		if err := dataStore.storeImportChunk(r, userID, results, pending); err != nil {
+			return err
+		}
+		if !started {
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		for _, result := range results {
+			if err := writer.Write(result); err != nil {
+				return err
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		total += len(results)
+		results, pending = results[:0], pending[:0]
+		clear(inChunk)
+		return nil
+	}
+
+	now := time.Now()
+	for {
+		request, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		switch {
+		case errors.Is(err, errInvalidBatchItem):
+			results = append(results, models.BatchItemResult{CorrelationID: request.CorrelationID, Status: models.BatchItemInvalid, Error: err.Error()})
+		case err != nil:
+			dataStore.abortImport(w, started, err)
+			return
+		default:
+			result, savedURL, err := dataStore.prepareImportItem(r, request, userID, inChunk, now)
+			if err != nil {
+				dataStore.abortImport(w, started, err)
+				return
+			}
+			if result.Status == "" {
+				pending = append(pending, pendingImport{index: len(results), savedURL: savedURL})
+				result.ShortURL = servShortURL + "/" + savedURL.ShortURL
+			}
+			results = append(results, result)
+		}
+
+		if len(results) == importChunkSize {
+			if err := flush(); err != nil {
+				dataStore.abortImport(w, started, err)
+				return
+			}
+		}
+	}
+	if len(results) != 0 || !started {
+		if err := flush(); err != nil {
+			dataStore.abortImport(w, started, err)
+			return
+		}
+	}
+
+	logger.Log.Info("After POST batch import", zap.Int("count", total), zap.Int("userID", userID), zap.String("content-type", contentType))
+}
+
+// prepareImportItem проверяет URL потоковой загрузки и подбирает ему короткий URL.
+// Если URL сохранять не нужно, возвращает результат со статусом, иначе результат без статуса и URL для сохранения.
+// Ошибка возвращается только при сбое хранилища.
+func (dataStore *ServerDataStore) prepareImportItem(r *http.Request, request models.BatchRequest, userID int, inChunk map[string]string, now time.Time) (models.BatchItemResult, models.SavedURL, error) {
+	result := models.BatchItemResult{CorrelationID: request.CorrelationID}
+	if request.OriginalURL == "" {
+		result.Status, result.Error = models.BatchItemInvalid, errEmptyURL.Error()
+		return result, models.SavedURL{}, nil
+	}
+
+	expiresAt, err := resolveExpiresAt(request.ExpiresAt, request.TTLSeconds, now)
+	if err != nil {
+		result.Status, result.Error = models.BatchItemInvalid, err.Error()
+		return result, models.SavedURL{}, nil
+	}
+
+	shortURL, err := dataStore.resolveShortURL(r.Context(), request.OriginalURL, request.CustomAlias, userID)
+	switch {
+	case errors.Is(err, shortcode.ErrInvalidAlias), errors.Is(err, shortcode.ErrReservedAlias):
+		result.Status, result.Error = models.BatchItemInvalid, err.Error()
+		return result, models.SavedURL{}, nil
+	case errors.Is(err, errAliasTaken):
+		result.Status, result.Error = models.BatchItemConflict, err.Error()
+		return result, models.SavedURL{}, nil
+	case err != nil:
+		return result, models.SavedURL{}, err
+	}
+
+	if originalURL, ok := inChunk[shortURL]; ok {
+		result.Status = models.BatchItemConflict
+		if originalURL != request.OriginalURL {
+			result.Error = errAliasTaken.Error()
+		} else {
+			result.ShortURL = dataStore.configStore.FlagShortRunAddr + "/" + shortURL
+		}
+		return result, models.SavedURL{}, nil
+	}
+	inChunk[shortURL] = request.OriginalURL
+
+	return result, models.SavedURL{
+		OriginalURL: request.OriginalURL,
+		ShortURL:    shortURL,
+		ExpiresAt:   expiresAt,
+		Alias:       request.CustomAlias != "",
+	}, nil
+}
+
+// storeImportChunk сохраняет URL пачки одним вызовом хранилища и проставляет статусы их результатов:
+// URL, которые пользователь уже сохранял, получают статус conflict и уже сохраненный короткий URL,
+// а URL, чей пользовательский код успели занять, статус conflict с ошибкой.
+func (dataStore *ServerDataStore) storeImportChunk(r *http.Request, userID int, results []models.BatchItemResult, pending []pendingImport) error {
+	if len(pending) == 0 {
+		return nil
+	}
+	forStore := make([]models.SavedURL, 0, len(pending))
+	for _, item := range pending {
+		forStore = append(forStore, item.savedURL)
+	}
+	stored, err := dataStore.storager.StoreURLBatch(r.Context(), forStore, userID)
+	if err != nil {
+		return err
+	}
+	for i, item := range pending {
+		results[item.index].Status = models.BatchItemCreated
+		if stored[i].AliasTaken {
+			results[item.index].Status, results[item.index].ShortURL, results[item.index].Error = models.BatchItemConflict, "", errAliasTaken.Error()
+			continue
+		}
+		if stored[i].Existed {
+			results[item.index].Status = models.BatchItemConflict
+			// полный URL может быть уже сохранен у пользователя под другим коротким URL
+			results[item.index].ShortURL = dataStore.configStore.FlagShortRunAddr + "/" + stored[i].ShortURL
+		}
+	}
+	return nil
+}
+
+// abortImport прерывает потоковую загрузку. Если результаты еще не отправлялись, отвечает кодом ошибки.
+func (dataStore *ServerDataStore) abortImport(w http.ResponseWriter, started bool, err error) {
+	logger.Log.Error("batch import is aborted", zap.Bool("started", started), zap.Error(err))
+	if started {
+		return
+	}
+	if errors.Is(err, bufio.ErrTooLong) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// ndjsonItemReader читает URL потоковой загрузки из NDJSON, по одному объекту models.BatchRequest в строке.
+type ndjsonItemReader struct {
+	scanner *bufio.Scanner
+	json    jsoniter.API
+}
+
+// Read возвращает URL из следующей непустой строки.
+func (reader *ndjsonItemReader) Read() (models.BatchRequest, error) {
+	for reader.scanner.Scan() {
+		line := reader.scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var request models.BatchRequest
+		if err := reader.json.Unmarshal(line, &request); err != nil {
+			return models.BatchRequest{}, fmt.Errorf("%w: %v", errInvalidBatchItem, err)
+		}
+		return request, nil
+	}
+	if err := reader.scanner.Err(); err != nil {
+		return models.BatchRequest{}, err
+	}
+	return models.BatchRequest{}, io.EOF
+}
+
+// csvItemReader читает URL потоковой загрузки из CSV. Первая строка задает названия колонок:
+// обязательная original_url и необязательные correlation_id, custom_alias, expires_at (RFC 3339) и ttl_seconds.
+type csvItemReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+// newCSVItemReader читает заголовок CSV и возвращает читателя строк.
+func newCSVItemReader(body io.Reader) (*csvItemReader, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read csv header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["original_url"]; !ok {
+		return nil, errors.New("csv header must contain original_url column")
+	}
+	return &csvItemReader{reader: reader, columns: columns}, nil
+}
+
+// Read возвращает URL из следующей строки CSV.
+func (reader *csvItemReader) Read() (models.BatchRequest, error) {
+	record, err := reader.reader.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return models.BatchRequest{}, fmt.Errorf("%w: %v", errInvalidBatchItem, err)
+	}
+	if err != nil {
+		return models.BatchRequest{}, err
+	}
+
+	request := models.BatchRequest{
+		CorrelationID: reader.field(record, "correlation_id"),
+		OriginalURL:   reader.field(record, "original_url"),
+		CustomAlias:   reader.field(record, "custom_alias"),
+	}
+	if expiresAt := reader.field(record, "expires_at"); expiresAt != "" {
+		parsed, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return request, fmt.Errorf("%w: expires_at: %v", errInvalidBatchItem, err)
+		}
+		request.ExpiresAt = &parsed
+	}
+	if ttl := reader.field(record, "ttl_seconds"); ttl != "" {
+		parsed, err := strconv.ParseInt(ttl, 10, 64)
+		if err != nil {
+			return request, fmt.Errorf("%w: ttl_seconds: %v", errInvalidBatchItem, err)
+		}
+		request.TTLSeconds = parsed
+	}
+	return request, nil
+}
+
+// field возвращает значение колонки name из record или пустую строку, если колонки нет.
+func (reader *csvItemReader) field(record []string, name string) string {
+	i, ok := reader.columns[name]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// ndjsonResultWriter пишет результаты потоковой загрузки в NDJSON.
+type ndjsonResultWriter struct {
+	writer *bufio.Writer
+	json   jsoniter.API
+}
+
+// Write пишет результат отдельной строкой.
+func (writer *ndjsonResultWriter) Write(result models.BatchItemResult) error {
+	line, err := writer.json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	writer.writer.Write(line)
+	return writer.writer.WriteByte('\n')
+}
+
+// Flush отправляет накопленные результаты.
+func (writer *ndjsonResultWriter) Flush() error {
+	return writer.writer.Flush()
+}
+
+// csvResultWriter пишет результаты потоковой загрузки в CSV с заголовком csvResultHeader.
+type csvResultWriter struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+// Write пишет результат строкой CSV, перед первой строкой пишет заголовок.
+func (writer *csvResultWriter) Write(result models.BatchItemResult) error {
+	if !writer.headerWritten {
+		if err := writer.writer.Write(csvResultHeader); err != nil {
+			return err
+		}
+		writer.headerWritten = true
+	}
+	return writer.writer.Write([]string{result.CorrelationID, result.ShortURL, string(result.Status), result.Error})
+}
+
+// Flush отправляет накопленные результаты.
+func (writer *csvResultWriter) Flush() error {
+	writer.writer.Flush()
+	return writer.writer.Error()
+}
@@ -0,0 +1,53 @@
+package serverapi
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"go.uber.org/zap"
+)
+
+// realIPHeader заголовок, в котором прокси передает адрес клиента.
+const realIPHeader = "X-Real-IP"
+
+// isTrustedRequest проверяет, что адрес клиента из X-Real-IP принадлежит доверенной сети.
+// Если сеть не задана, доверенных клиентов нет.
+func (dataStore *ServerDataStore) isTrustedRequest(r *http.Request) bool {
+	if dataStore.trustedSubnet == nil {
+		return false
+	}
+	ip := net.ParseIP(strings.TrimSpace(r.Header.Get(realIPHeader)))
+	return ip != nil && dataStore.trustedSubnet.Contains(ip)
+}
+
+// internalStatsHandler обрабатывает GET-запросы на количество сокращенных URL и пользователей.
+// Доступен только клиентам из доверенной сети, см. WithTrustedSubnet.
+func (dataStore *ServerDataStore) internalStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if !dataStore.isTrustedRequest(r) {
+		logger.Log.Info("internal stats request is forbidden", zap.String("ip", r.Header.Get(realIPHeader)))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	urls, err := dataStore.storager.CountURLs(r.Context())
+	if err != nil {
+		logger.Log.Error("cannot count urls", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	users, err := dataStore.storager.CountUsers(r.Context())
+	if err != nil {
+		logger.Log.Error("cannot count users", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := dataStore.json.NewEncoder(w).Encode(models.InternalStats{URLs: urls, Users: users}); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+	}
+}
@@ -0,0 +1,172 @@
+package serverapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	mediaTypePlain     = "text/plain"
+	mediaTypeHTML      = "text/html"
+	mediaTypeJSON      = "application/json"
+	mediaTypeForm      = "application/x-www-form-urlencoded"
+	mediaTypeMultipart = "multipart/form-data"
+	// mediaTypeGzip присылают клиенты, сжимающие текстовое тело, см. Content-Encoding
+	mediaTypeGzip = "application/x-gzip"
+
+	// urlFormField имя поля с полным URL в формах и multipart-запросах
+	urlFormField = "url"
+	// maxMultipartMemory сколько multipart-данных держится в памяти, остальное уходит во временные файлы
+	maxMultipartMemory = 1 << 20
+)
+
+var (
+	// errUnsupportedMediaType возвращается, если Content-Type запроса не поддерживается.
+	errUnsupportedMediaType = errors.New("unsupported media type")
+	// errEmptyURL возвращается, если в запросе нет полного URL.
+	errEmptyURL = errors.New("url is empty")
+)
+
+// responseFormats форматы ответа POST / в порядке предпочтения сервера при равных q в Accept.
+var responseFormats = []string{mediaTypePlain, mediaTypeJSON, mediaTypeHTML}
+
+// readPostedURL достает полный URL из уже прочитанного тела POST-запроса по его Content-Type.
+// Без Content-Type тело считается текстом, как раньше.
+func (dataStore *ServerDataStore) readPostedURL(contentType string, body []byte) (string, error) {
+	if contentType == "" {
+		return string(body), nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errUnsupportedMediaType, contentType)
+	}
+
+	var postedURL string
+	switch mediaType {
+	case mediaTypePlain, mediaTypeGzip:
+		postedURL = string(body)
+	case mediaTypeForm:
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return "", err
+		}
+		postedURL = values.Get(urlFormField)
+	case mediaTypeJSON:
+		var req models.Request
+		if err := dataStore.json.Unmarshal(body, &req); err != nil {
+			return "", err
+		}
+		postedURL = req.URL
+	case mediaTypeMultipart:
+		form, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(maxMultipartMemory)
+		if err != nil {
+			return "", err
+		}
+		defer form.RemoveAll()
+		if values := form.Value[urlFormField]; len(values) != 0 {
+			postedURL = values[0]
+		}
+	default:
+		return "", fmt.Errorf("%w: %s", errUnsupportedMediaType, mediaType)
+	}
+
+	if postedURL == "" {
+		return "", errEmptyURL
+	}
+	return postedURL, nil
+}
+
+// negotiateFormat выбирает формат ответа POST / по заголовку Accept.
+// Возвращает пустую строку, если клиент согласен на любой формат: тогда ответ остается прежним.
+// Если ни один формат не подходит, возвращает false.
+func negotiateFormat(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return "", true
+	}
+
+	best, bestQ, bestExplicit := "", 0.0, false
+	for _, format := range responseFormats {
+		q, explicit := acceptQuality(accept, format)
+		if q > bestQ {
+			best, bestQ, bestExplicit = format, q, explicit
+		}
+	}
+	if bestQ == 0 {
+		return "", false
+	}
+	if !bestExplicit {
+		return "", true
+	}
+	return best, true
+}
+
+// acceptQuality возвращает вес формата format в заголовке Accept по самому точному подходящему диапазону
+// и признак того, что формат указан не только через */*.
+func acceptQuality(accept string, format string) (float64, bool) {
+	formatType, _, _ := strings.Cut(format, "/")
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		rangeSpecificity := -1
+		switch {
+		case mediaRange == format:
+			rangeSpecificity = 2
+		case mediaRange == formatType+"/*":
+			rangeSpecificity = 1
+		case mediaRange == "*/*":
+			rangeSpecificity = 0
+		}
+		if rangeSpecificity <= specificity {
+			continue
+		}
+		specificity = rangeSpecificity
+		q = 1
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+	}
+	return q, specificity > 0
+}
+
+// writeShortURL отвечает на POST / коротким URL в формате format.
+// Без выбранного формата ответ остается прежним: короткий URL текстом с Content-Type text/html.
+func (dataStore *ServerDataStore) writeShortURL(w http.ResponseWriter, format string, status int, shortURL string) {
+	switch format {
+	case mediaTypeJSON:
+		w.Header().Set("Content-Type", mediaTypeJSON)
+		w.WriteHeader(status)
+		if err := dataStore.json.NewEncoder(w).Encode(models.Response{Result: shortURL}); err != nil {
+			logger.Log.Error("error encoding response", zap.Error(err))
+		}
+	case mediaTypeHTML:
+		w.Header().Set("Content-Type", mediaTypeHTML+"; charset=utf-8")
+		w.WriteHeader(status)
+		escaped := html.EscapeString(shortURL)
+		fmt.Fprintf(w, `<a href="%s">%s</a>`, escaped, escaped)
+	case mediaTypePlain:
+		w.Header().Set("Content-Type", mediaTypePlain+"; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprint(w, shortURL)
+	default:
+		w.Header().Set("Content-Type", mediaTypeHTML)
+		w.WriteHeader(status)
+		fmt.Fprint(w, shortURL)
+	}
+}
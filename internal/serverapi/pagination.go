@@ -0,0 +1,95 @@
+package serverapi
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/theheadmen/urlShort/internal/models"
+)
+
+// maxUserURLsLimit наибольший размер страницы GET /api/user/urls.
+const maxUserURLsLimit = 1000
+
+// parseURLQuery разбирает параметры limit, cursor, sort, order, deleted и q запроса GET /api/user/urls.
+// Без limit возвращаются все URL, как до появления пагинации.
+func (dataStore *ServerDataStore) parseURLQuery(values url.Values) (models.URLQuery, error) {
+	var query models.URLQuery
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxUserURLsLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", maxUserURLsLimit)
+		}
+		query.Limit = n
+	}
+
+	switch sort := models.URLSort(values.Get("sort")); sort {
+	case "", models.SortByCreated, models.SortByShortURL:
+		query.Sort = sort
+	default:
+		return query, fmt.Errorf("sort must be %s or %s", models.SortByCreated, models.SortByShortURL)
+	}
+
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, fmt.Errorf("order must be asc or desc")
+	}
+
+	if deleted := values.Get("deleted"); deleted != "" {
+		value, err := strconv.ParseBool(deleted)
+		if err != nil {
+			return query, fmt.Errorf("deleted must be true or false")
+		}
+		query.Deleted = &value
+	}
+	query.Contains = values.Get("q")
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		after, err := dataStore.decodeCursor(cursor)
+		if err != nil {
+			return query, fmt.Errorf("invalid cursor")
+		}
+		query.After = &after
+	}
+	return query, nil
+}
+
+// encodeCursor кодирует позицию URL в непрозрачную для клиента строку.
+func (dataStore *ServerDataStore) encodeCursor(cursor models.URLCursor) (string, error) {
+	data, err := dataStore.json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor разбирает строку, полученную от encodeCursor.
+func (dataStore *ServerDataStore) decodeCursor(cursor string) (models.URLCursor, error) {
+	var after models.URLCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return after, err
+	}
+	err = dataStore.json.Unmarshal(data, &after)
+	return after, err
+}
+
+// setNextLink добавляет заголовок Link со ссылкой на следующую страницу.
+// Остальные параметры запроса сохраняются, меняется только cursor.
+func (dataStore *ServerDataStore) setNextLink(w http.ResponseWriter, r *http.Request, last models.SavedURL) error {
+	cursor, err := dataStore.encodeCursor(models.CursorOf(last))
+	if err != nil {
+		return err
+	}
+	values := r.URL.Query()
+	values.Set("cursor", cursor)
+	next := url.URL{Path: r.URL.Path, RawQuery: values.Encode()}
+	w.Header().Set("Link", "<"+next.String()+`>; rel="next"`)
+	return nil
+}
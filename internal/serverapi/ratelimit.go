@@ -0,0 +1,77 @@
+package serverapi
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/ratelimit"
+	"go.uber.org/zap"
+)
+
+// rateLimitMiddleware ограничивает частоту запросов каждой группы маршрутов, если задан ratelimit.Limiter.
+// Стоит до authMiddleware, чтобы запросы без куки отклонялись до того, как для них создан пользователь,
+// поэтому группа определяется по пути запроса, а клиент по ключу API, куке или адресу, см. rateLimitKey.
+// Если хранилище лимитов недоступно, запрос пропускается.
+func (dataStore *ServerDataStore) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := rateLimitGroup(r)
+		if group == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := dataStore.rateLimitKey(r)
+		decision, limited, err := dataStore.limiter.Allow(r.Context(), group, key)
+		if err != nil {
+			logger.Log.Error("can't check rate limit", zap.String("group", group), zap.Error(err))
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !limited {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ratelimit.WriteHeaders(w, decision)
+		if !decision.Allowed {
+			logger.Log.Info("rate limit exceeded", zap.String("group", group), zap.String("key", key))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitGroup возвращает группу маршрутов запроса или пустую строку, если запрос не ограничивается.
+func rateLimitGroup(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodPost && path == "/api/shorten/batch":
+		return ratelimit.GroupBatch
+	case r.Method == http.MethodPost && (path == "/" || path == "/api/shorten"):
+		return ratelimit.GroupShorten
+	case strings.HasPrefix(path, "/api/user/"):
+		return ratelimit.GroupUser
+	case r.Method == http.MethodGet && path != "/" && path != "/ping" && path != "/metrics" && !strings.HasPrefix(path, "/api/"):
+		return ratelimit.GroupRedirect
+	default:
+		return ""
+	}
+}
+
+// rateLimitKey возвращает ключ клиента для лимитов: идентификатор ключа API, пользователя из действующей куки
+// или адрес клиента. Ключ API проверяется по хранилищу, иначе клиент получал бы новый лимит
+// на каждый выдуманный ключ. Запрос с неизвестным ключом ограничивается по куке или адресу.
+func (dataStore *ServerDataStore) rateLimitKey(r *http.Request) string {
+	if key, ok := bearerToken(r); ok {
+		if apiKey, err := dataStore.findAPIKey(r.Context(), key); err == nil {
+			return "key:" + apiKey.ID
+		}
+	}
+	if _, userID, err := dataStore.getTokenAndUserID(r); err == nil {
+		return "user:" + strconv.Itoa(userID)
+	}
+	return "ip:" + dataStore.limiter.ClientIP(r)
+}
@@ -0,0 +1,112 @@
+package serverapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"go.uber.org/zap"
+)
+
+// restoreURLsHandler обрабатывает POST-запросы для восстановления удаленных коротких URL пользователя.
+// Принимает JSON-массив коротких URL, отвечает списками восстановленных и пропущенных:
+// пропускаются чужие, неудаленные и истекшие URL.
+func (dataStore *ServerDataStore) restoreURLsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, shortURLs, ok := dataStore.decodeShortURLs(w, r)
+	if !ok {
+		return
+	}
+
+	restored, err := dataStore.storager.RestoreURLs(r.Context(), storage.KeysForUser(shortURLs, userID), time.Now())
+	if err != nil {
+		logger.Log.Error("cannot restore urls", zap.Int("userID", userID), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	logger.Log.Info("URLs are restored", zap.Int("userID", userID), zap.Int("count", len(restored)))
+
+	resp := models.RestoreResponse{}
+	resp.Restored, resp.Skipped = splitProcessed(shortURLs, restored)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := dataStore.json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+// purgeURLsHandler обрабатывает POST-запросы для удаления коротких URL пользователя навсегда, вместе с историей изменений.
+// Принимает JSON-массив коротких URL, отвечает списками удаленных и пропущенных.
+func (dataStore *ServerDataStore) purgeURLsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, shortURLs, ok := dataStore.decodeShortURLs(w, r)
+	if !ok {
+		return
+	}
+
+	purged, err := dataStore.storager.PurgeURLs(r.Context(), storage.KeysForUser(shortURLs, userID))
+	if err != nil {
+		logger.Log.Error("cannot purge urls", zap.Int("userID", userID), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	logger.Log.Info("URLs are purged", zap.Int("userID", userID), zap.Int("count", len(purged)))
+
+	resp := models.PurgeResponse{}
+	resp.Purged, resp.Skipped = splitProcessed(shortURLs, purged)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := dataStore.json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+// decodeShortURLs читает из тела запроса JSON-массив коротких URL без повторов.
+// При ошибке сам отвечает клиенту и возвращает false.
+func (dataStore *ServerDataStore) decodeShortURLs(w http.ResponseWriter, r *http.Request) (int, []string, bool) {
+	userID, err := dataStore.getUserID(r)
+	if err != nil {
+		logger.Log.Error("cannot find cookie", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return 0, nil, false
+	}
+
+	var shortURLs []string
+	if err := dataStore.json.NewDecoder(r.Body).Decode(&shortURLs); err != nil {
+		logger.Log.Error("cannot decode request JSON body", zap.Error(err))
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return 0, nil, false
+	}
+
+	unique := make([]string, 0, len(shortURLs))
+	seen := make(map[string]struct{}, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		if _, ok := seen[shortURL]; !ok {
+			seen[shortURL] = struct{}{}
+			unique = append(unique, shortURL)
+		}
+	}
+	return userID, unique, true
+}
+
+// splitProcessed делит запрошенные короткие URL на обработанные хранилищем и пропущенные, сохраняя порядок запроса.
+func splitProcessed(shortURLs []string, processed []storage.URLMapKey) ([]string, []string) {
+	done := make(map[string]struct{}, len(processed))
+	for _, key := range processed {
+		done[key.ShortURL] = struct{}{}
+	}
+
+	ok, skipped := []string{}, []string{}
+	for _, shortURL := range shortURLs {
+		if _, found := done[shortURL]; found {
+			ok = append(ok, shortURL)
+		} else {
+			skipped = append(skipped, shortURL)
+		}
+	}
+	return ok, skipped
+}
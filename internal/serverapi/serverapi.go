@@ -2,12 +2,14 @@
 package serverapi
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
-	"crypto/sha256"
-	"encoding/base64"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -16,20 +18,50 @@ import (
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/theheadmen/urlShort/internal/analytics"
+	"github.com/theheadmen/urlShort/internal/auth"
+	"github.com/theheadmen/urlShort/internal/deletion"
 	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/metrics"
 	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/ratelimit"
 	config "github.com/theheadmen/urlShort/internal/serverconfig"
+	"github.com/theheadmen/urlShort/internal/shortcode"
 	"github.com/theheadmen/urlShort/internal/storage"
+	"github.com/theheadmen/urlShort/internal/tracing"
 	"go.uber.org/zap"
 
 	jsoniter "github.com/json-iterator/go"
 )
 
 const (
-	jwtSecretKey = "my-jwt-secret-key"
 	jwtCookieKey = "token"
+	// cookieTTL время жизни токена и куки, продлевается при активности пользователя
+	cookieTTL = 24 * time.Hour
+	// cookieRefreshAfter возраст токена, после которого он перевыпускается, чтобы не ставить куку на каждый запрос
+	cookieRefreshAfter = time.Hour
+	// userTouchInterval как часто обновляется время последней активности пользователя,
+	// чтобы не писать в хранилище на каждый запрос
+	userTouchInterval = time.Minute
+	// adminTokenHeader заголовок с токеном для административных запросов
+	adminTokenHeader = "X-Admin-Token"
 )
 
+// maxTTLSeconds максимальное время жизни ссылки, которое можно задать через ttl_seconds.
+const maxTTLSeconds = 100 * 365 * 24 * 60 * 60
+
+var (
+	// errAliasTaken возвращается, если пользовательский код уже занят другим пользователем или другим URL.
+	// Окончательно это проверяет хранилище при сохранении, см. storage.ErrAliasTaken.
+	errAliasTaken = storage.ErrAliasTaken
+	// errInvalidExpiration возвращается, если срок действия ссылки задан некорректно.
+	errInvalidExpiration = errors.New("expires_at must be in the future, ttl_seconds must be positive, and only one of them can be set")
+)
+
+// defaultKeys ключи подписи для ServerDataStore без WithSigningKeys.
+// Ключ случайный, поэтому выданные им куки не переживают перезапуск.
+var defaultKeys = auth.RandomKeySet()
+
 // UserClaims кастомная JWT структура
 type UserClaims struct {
 	UserID string `json:"userID"`
@@ -40,109 +72,253 @@ type UserClaims struct {
 type ServerDataStore struct {
 	configStore config.ConfigStore
 	storager    storage.Storage
-	json        jsoniter.API
+	generator   shortcode.Generator
+	recorder    *analytics.Recorder
+	registry    *metrics.Registry
+	httpMetrics *metrics.HTTPMetrics
+	keys        *auth.KeySet
+	deleteQueue *deletion.Queue
+	limiter     *ratelimit.Limiter
+	// trustedSubnet сеть, клиентам из которой доступна внутренняя статистика
+	trustedSubnet *net.IPNet
+	json          jsoniter.API
+}
+
+// Option задает необязательную зависимость ServerDataStore.
+type Option func(dataStore *ServerDataStore)
+
+// WithClickRecorder включает запись переходов по коротким ссылкам через recorder.
+func WithClickRecorder(recorder *analytics.Recorder) Option {
+	return func(dataStore *ServerDataStore) {
+		dataStore.recorder = recorder
+	}
+}
+
+// WithMetrics включает учет запросов и отдачу метрик из registry на /metrics.
+func WithMetrics(registry *metrics.Registry) Option {
+	return func(dataStore *ServerDataStore) {
+		dataStore.registry = registry
+		dataStore.httpMetrics = metrics.NewHTTPMetrics(registry)
+	}
+}
+
+// WithSigningKeys задает ключи, которыми подписываются и проверяются куки пользователей.
+func WithSigningKeys(keys *auth.KeySet) Option {
+	return func(dataStore *ServerDataStore) {
+		dataStore.keys = keys
+	}
+}
+
+// WithDeleteQueue задает очередь, в которой обрабатываются задачи удаления URL.
+// Очередь запускает и останавливает вызывающий через Queue.Run. Без нее маршруты удаления URL не регистрируются.
+func WithDeleteQueue(queue *deletion.Queue) Option {
+	return func(dataStore *ServerDataStore) {
+		dataStore.deleteQueue = queue
+	}
+}
+
+// WithRateLimiter включает ограничение частоты запросов по лимитам limiter.
+func WithRateLimiter(limiter *ratelimit.Limiter) Option {
+	return func(dataStore *ServerDataStore) {
+		dataStore.limiter = limiter
+	}
+}
+
+// WithTrustedSubnet открывает внутреннюю статистику клиентам из сети subnet.
+func WithTrustedSubnet(subnet *net.IPNet) Option {
+	return func(dataStore *ServerDataStore) {
+		dataStore.trustedSubnet = subnet
+	}
 }
 
 // NewServerDataStore создает новый экземпляр ServerDataStore с заданными конфигурацией и хранилищем.
-func NewServerDataStore(configStore *config.ConfigStore, storager storage.Storage) *ServerDataStore {
-	return &ServerDataStore{
+// Генератор коротких кодов выбирается по FlagStrategy, при неизвестной стратегии используется hash.
+func NewServerDataStore(configStore *config.ConfigStore, storager storage.Storage, opts ...Option) *ServerDataStore {
+	generator, err := shortcode.NewGenerator(configStore.FlagStrategy, storager)
+	if err != nil {
+		logger.Log.Error("Failed to create short url generator, fallback to hash", zap.Error(err))
+		generator = shortcode.NewHashGenerator(storager)
+	}
+
+	dataStore := &ServerDataStore{
 		configStore: *configStore,
 		storager:    storager,
+		generator:   generator,
+		keys:        defaultKeys,
 		json:        jsoniter.ConfigCompatibleWithStandardLibrary,
 	}
+	for _, opt := range opts {
+		opt(dataStore)
+	}
+	if dataStore.deleteQueue != nil && dataStore.httpMetrics != nil {
+		dataStore.deleteQueue.SetPendingGauge(dataStore.httpMetrics.PendingDeletions)
+	}
+	return dataStore
 }
 
 // MakeChiServ создает новый экземпляр Chi-маршрутизатора и настраивает необходимые middleware.
 // Он также определяет маршруты и их обработчики для сервера.
-func MakeChiServ(configStore *config.ConfigStore, storager storage.Storage) chi.Router {
-	dataStore := NewServerDataStore(configStore, storager)
+func MakeChiServ(configStore *config.ConfigStore, storager storage.Storage, opts ...Option) chi.Router {
+	dataStore := NewServerDataStore(configStore, storager, opts...)
 	router := chi.NewRouter()
 
+	// метрики учитываются первыми, чтобы в длительность попадали все остальные middleware
+	if dataStore.httpMetrics != nil {
+		router.Use(dataStore.httpMetrics.Middleware)
+	}
+	// серверный спан запроса, дальше по цепочке его контекст доступен из r.Context()
+	router.Use(tracing.Middleware)
+	// результаты потоковой загрузки пишутся, пока тело запроса еще читается
+	router.Use(streamingBody)
 	// midlleware для gzip
-	router.Use(middleware.Compress(5, "text/html", "application/json"))
-	// middleware для куки
-	router.Use(dataStore.authMiddleware)
+	router.Use(middleware.Compress(5, "text/html", "text/plain", "application/json"))
+	// лимиты проверяются до middleware для куки, чтобы не проверять куки и ключи API отклоненных запросов
+	if dataStore.limiter != nil {
+		router.Use(dataStore.rateLimitMiddleware)
+	}
 	// middleware для логов
 	router.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
 			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 			next.ServeHTTP(ww, r)
-			logger.Log.Info("Request processed",
+			fields := []zap.Field{
 				zap.String("method", r.Method),
 				zap.String("uri", r.RequestURI),
 				zap.Duration("duration", time.Since(start)),
 				zap.Int("status", ww.Status()),
 				zap.Int("size", ww.BytesWritten()),
-			)
+			}
+			if spanContext := tracing.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+				fields = append(fields, zap.String("trace_id", spanContext.TraceID.String()))
+			}
+			logger.Log.Info("Request processed", fields...)
 		})
 	})
 
+	// публичные маршруты пользователя не определяют: переходы по коротким URL не создают пользователей
 	router.Get("/", dataStore.GetHandler)
 	router.Get("/{shortUrl}", dataStore.GetHandler)
-	router.Post("/", dataStore.PostHandler)
-	router.Post("/api/shorten", dataStore.postJSONHandler)
 	router.Get("/ping", dataStore.pingHandler)
-	router.Post("/api/shorten/batch", dataStore.postBatchJSONHandler)
-	router.Get("/api/user/urls", dataStore.getByUserIDHandler)
-	router.Delete("/api/user/urls", dataStore.deleteByUserIDHandler)
+	router.Post("/api/admin/compact", dataStore.compactHandler)
+	router.Get("/api/internal/stats", dataStore.internalStatsHandler)
+	if dataStore.registry != nil {
+		router.Method(http.MethodGet, "/metrics", dataStore.registry.Handler())
+	}
+
+	// сокращать URL можно без куки, пользователь создается при первом сохранении
+	router.Group(func(router chi.Router) {
+		router.Use(dataStore.authMiddleware(authOptional), dataStore.requireScope(auth.ScopeWrite))
+		router.Post("/", dataStore.PostHandler)
+		router.Post("/api/shorten", dataStore.postJSONHandler)
+		router.Post("/api/shorten/batch", dataStore.postBatchHandler)
+	})
+
+	// маршруты пользователя работают только с его URL и ключами, без куки или ключа API они отклоняются
+	router.Group(func(router chi.Router) {
+		router.Use(dataStore.authMiddleware(authRequired))
+		router.With(dataStore.requireScope(auth.ScopeRead)).Get("/api/user/urls", dataStore.getByUserIDHandler)
+		router.With(dataStore.requireScope(auth.ScopeRead)).Get("/api/user/urls/{shortUrl}", dataStore.getUserURLHandler)
+		router.With(dataStore.requireScope(auth.ScopeWrite)).Patch("/api/user/urls/{shortUrl}", dataStore.updateURLHandler)
+		router.With(dataStore.requireScope(auth.ScopeRead)).Get("/api/user/urls/{shortUrl}/history", dataStore.listURLEditsHandler)
+		router.With(dataStore.requireScope(auth.ScopeRead)).Get("/api/user/urls/{shortUrl}/stats", dataStore.getStatsHandler)
+		if dataStore.deleteQueue != nil {
+			router.With(dataStore.requireScope(auth.ScopeDelete)).Delete("/api/user/urls", dataStore.deleteByUserIDHandler)
+			router.With(dataStore.requireScope(auth.ScopeDelete)).Get("/api/user/jobs/{jobID}", dataStore.getDeleteJobHandler)
+		}
+		router.With(dataStore.requireScope(auth.ScopeDelete)).Post("/api/user/urls/restore", dataStore.restoreURLsHandler)
+		router.With(dataStore.requireScope(auth.ScopeDelete)).Post("/api/user/urls/purge", dataStore.purgeURLsHandler)
+		router.With(dataStore.requireCookie).Post("/api/user/keys", dataStore.createAPIKeyHandler)
+		router.With(dataStore.requireCookie).Get("/api/user/keys", dataStore.listAPIKeysHandler)
+		router.With(dataStore.requireCookie).Delete("/api/user/keys/{keyID}", dataStore.revokeAPIKeyHandler)
+	})
 	return router
 }
 
 // PostHandler обрабатывает POST-запросы для сокращения URL.
-// Он читает тело запроса, декодирует его (если необходимо), генерирует сокращенный URL,
-// сохраняет его в хранилище и возвращает ответ с кодом статуса и сокращенным URL.
+// Он читает тело запроса, декодирует его (если необходимо), достает полный URL по Content-Type
+// (текст, форма, JSON или multipart), генерирует сокращенный URL, сохраняет его в хранилище
+// и возвращает ответ с кодом статуса и сокращенным URL в формате, выбранном по Accept.
 func (dataStore *ServerDataStore) PostHandler(w http.ResponseWriter, r *http.Request) {
+	format, ok := negotiateFormat(r.Header.Get("Accept"))
+	if !ok {
+		logger.Log.Debug("no acceptable response format", zap.String("accept", r.Header.Get("Accept")))
+		w.WriteHeader(http.StatusNotAcceptable)
+		return
+	}
+
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		logger.Log.Error("cannot read request body", zap.Error(err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	url := string(body)
 	if r.Header.Get("Content-Encoding") == "gzip" {
-		gz, err := gzip.NewReader(strings.NewReader(string(body)))
+		gz, err := gzip.NewReader(bytes.NewReader(body))
 		if err != nil {
 			logger.Log.Error("cannot decompress request body", zap.Error(err))
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		decompressed, err := io.ReadAll(gz)
+		body, err = io.ReadAll(gz)
 		if err != nil {
 			logger.Log.Error("cannot read decompressed request body", zap.Error(err))
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		url = string(decompressed)
 	}
 
-	token, userID, err := getTokenAndUserID(r)
-	if err != nil || !token.Valid {
-		logger.Log.Error("cannot find cookie", zap.Error(err))
-		w.WriteHeader(http.StatusBadRequest)
+	url, err := dataStore.readPostedURL(r.Header.Get("Content-Type"), body)
+	switch {
+	case errors.Is(err, errUnsupportedMediaType):
+		logger.Log.Debug("unsupported request content type", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	case err != nil:
+		logger.Log.Debug("cannot read url from request body", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		return
 	}
 
-	shortURL := GenerateShortURL(url)
+	shortURL, err := dataStore.generator.Generate(r.Context(), url)
+	if err != nil {
+		logger.Log.Error("cannot generate short url", zap.String("url", url), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-	isAlreadyStored, err := dataStore.storager.StoreURL(r.Context(), shortURL, url, userID)
+	userID, err := dataStore.ensureUserID(w, r)
+	if err != nil {
+		logger.Log.Error("cannot get user for request", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	isAlreadyStored, err := dataStore.storager.StoreURL(r.Context(), models.SavedURL{
+		ShortURL:    shortURL,
+		OriginalURL: url,
+		UserID:      userID,
+	})
+	var duplicate *storage.DuplicateURLError
+	if errors.As(err, &duplicate) {
+		// у пользователя уже есть короткий URL для этого полного URL, в ответе возвращается он
+		shortURL, isAlreadyStored, err = duplicate.ShortURL, true, nil
+	}
 	if err != nil {
 		logger.Log.Error("cannot store url", zap.String("url", url), zap.Error(err))
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/html")
 	headerStatus := http.StatusCreated
 	if isAlreadyStored {
 		headerStatus = http.StatusConflict
 	}
-	w.WriteHeader(headerStatus)
 	servShortURL := dataStore.configStore.FlagShortRunAddr
 
 	logger.Log.Info("After POST request", zap.String("body", url), zap.String("result", servShortURL+"/"+shortURL), zap.Int("userID", userID), zap.String("content-encoding", r.Header.Get("Content-Encoding")))
 
-	fmt.Fprintf(w, servShortURL+"/%s", shortURL)
+	dataStore.writeShortURL(w, format, headerStatus, servShortURL+"/"+shortURL)
 }
 
 // postJSONHandler обрабатывает POST-запросы в формате JSON для сокращения URL.
@@ -163,19 +339,48 @@ func (dataStore *ServerDataStore) postJSONHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	token, userID, err := getTokenAndUserID(r)
-	if err != nil || !token.Valid {
-		logger.Log.Error("cannot find cookie", zap.Error(err))
-		w.WriteHeader(http.StatusBadRequest)
+	expiresAt, err := resolveExpiresAt(req.ExpiresAt, req.TTLSeconds, time.Now())
+	if err != nil {
+		logger.Log.Debug("invalid expiration", zap.Error(err))
+		http.Error(w, err.Error(), statusForResolveError(err))
+		return
+	}
+
+	userID, err := dataStore.ensureUserID(w, r)
+	if err != nil {
+		logger.Log.Error("cannot get user for request", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	shortURL := GenerateShortURL(req.URL)
+	shortURL, err := dataStore.resolveShortURL(r.Context(), req.URL, req.CustomAlias, userID)
+	if err != nil {
+		logger.Log.Error("cannot resolve short url", zap.String("url", req.URL), zap.String("alias", req.CustomAlias), zap.Error(err))
+		http.Error(w, err.Error(), statusForResolveError(err))
+		return
+	}
 
-	isAlreadyStored, err := dataStore.storager.StoreURL(r.Context(), shortURL, req.URL, userID)
+	isAlreadyStored, err := dataStore.storager.StoreURL(r.Context(), models.SavedURL{
+		ShortURL:    shortURL,
+		OriginalURL: req.URL,
+		UserID:      userID,
+		ExpiresAt:   expiresAt,
+		Alias:       req.CustomAlias != "",
+	})
+	var duplicate *storage.DuplicateURLError
+	if errors.As(err, &duplicate) {
+		// у пользователя уже есть короткий URL для этого полного URL, в ответе возвращается он
+		shortURL, isAlreadyStored, err = duplicate.ShortURL, true, nil
+	}
+	if errors.Is(err, errAliasTaken) {
+		// код успели занять после проверки в resolveShortURL
+		logger.Log.Info("custom alias is taken", zap.String("alias", shortURL), zap.Int("userID", userID))
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		logger.Log.Error("cannot store url", zap.String("url", req.URL), zap.Error(err))
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
@@ -203,6 +408,8 @@ func (dataStore *ServerDataStore) postJSONHandler(w http.ResponseWriter, r *http
 // postBatchJSONHandler обрабатывает POST-запросы в формате JSON для сокращения нескольких URL.
 // Он декодирует тело запроса в формате JSON, генерирует сокращенные URL,
 // сохраняет их в хранилище и возвращает ответ с кодом статуса и сокращенными URL.
+// Пакет обрабатывается целиком: пустой URL или занятый код отклоняют весь запрос,
+// статусы по отдельным URL возвращает только потоковая загрузка в NDJSON и CSV, см. importBatch.
 func (dataStore *ServerDataStore) postBatchJSONHandler(w http.ResponseWriter, r *http.Request) {
 	var req []models.BatchRequest
 	dec := dataStore.json.NewDecoder(r.Body)
@@ -212,10 +419,10 @@ func (dataStore *ServerDataStore) postBatchJSONHandler(w http.ResponseWriter, r
 		return
 	}
 
-	token, userID, err := getTokenAndUserID(r)
-	if err != nil || !token.Valid {
-		logger.Log.Error("cannot find cookie", zap.Error(err))
-		w.WriteHeader(http.StatusBadRequest)
+	userID, err := dataStore.ensureUserID(w, r)
+	if err != nil {
+		logger.Log.Error("cannot get user for request", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
@@ -223,6 +430,9 @@ func (dataStore *ServerDataStore) postBatchJSONHandler(w http.ResponseWriter, r
 
 	var resp []models.BatchResponse
 	var savedURLs []models.SavedURL
+	// пользовательские коды внутри одного пакета тоже не должны пересекаться
+	batchAliases := make(map[string]string)
+	now := time.Now()
 	for _, request := range req {
 		if request.OriginalURL == "" {
 			logger.Log.Debug("after decoding JSON we don't have any URL")
@@ -230,12 +440,35 @@ func (dataStore *ServerDataStore) postBatchJSONHandler(w http.ResponseWriter, r
 			return
 		}
 
-		shortURL := GenerateShortURL(request.OriginalURL)
+		if request.CustomAlias != "" {
+			if originalURL, ok := batchAliases[request.CustomAlias]; ok && originalURL != request.OriginalURL {
+				logger.Log.Info("duplicate alias in batch", zap.String("alias", request.CustomAlias))
+				http.Error(w, errAliasTaken.Error(), http.StatusConflict)
+				return
+			}
+			batchAliases[request.CustomAlias] = request.OriginalURL
+		}
+
+		expiresAt, err := resolveExpiresAt(request.ExpiresAt, request.TTLSeconds, now)
+		if err != nil {
+			logger.Log.Debug("invalid expiration", zap.String("url", request.OriginalURL), zap.Error(err))
+			http.Error(w, err.Error(), statusForResolveError(err))
+			return
+		}
+
+		shortURL, err := dataStore.resolveShortURL(r.Context(), request.OriginalURL, request.CustomAlias, userID)
+		if err != nil {
+			logger.Log.Error("cannot resolve short url", zap.String("url", request.OriginalURL), zap.String("alias", request.CustomAlias), zap.Error(err))
+			http.Error(w, err.Error(), statusForResolveError(err))
+			return
+		}
 		savedURLs = append(savedURLs, models.SavedURL{
 			UUID:        0, /*не имеет смысла, вставится автоматически потом*/
 			OriginalURL: request.OriginalURL,
 			ShortURL:    shortURL,
 			Deleted:     false,
+			ExpiresAt:   expiresAt,
+			Alias:       request.CustomAlias != "",
 		})
 		resp = append(resp, models.BatchResponse{
 			CorrelationID: request.CorrelationID,
@@ -244,12 +477,20 @@ func (dataStore *ServerDataStore) postBatchJSONHandler(w http.ResponseWriter, r
 		logger.Log.Info("Readed from batch request", zap.String("body", request.OriginalURL), zap.String("result", servShortURL+"/"+shortURL), zap.Int("userID", userID))
 	}
 
-	err = dataStore.storager.StoreURLBatch(r.Context(), savedURLs, userID)
+	stored, err := dataStore.storager.StoreURLBatch(r.Context(), savedURLs, userID)
 	if err != nil {
 		logger.Log.Error("cannot store urls", zap.Error(err))
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	for i, result := range stored {
+		if result.AliasTaken {
+			// код успели занять после проверки в resolveShortURL, остальные URL пакета при этом уже сохранены
+			logger.Log.Info("custom alias is taken", zap.String("alias", savedURLs[i].ShortURL), zap.Int("userID", userID))
+			http.Error(w, errAliasTaken.Error(), http.StatusConflict)
+			return
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -262,31 +503,53 @@ func (dataStore *ServerDataStore) postBatchJSONHandler(w http.ResponseWriter, r
 	}
 }
 
-// getByUserIDHandler обрабатывает GET-запросы для получения всех сохраненных URL пользователя.
+// getByUserIDHandler обрабатывает GET-запросы для получения сохраненных URL пользователя.
 // Он извлекает идентификатор пользователя из токена, получает сохраненные URL из хранилища,
-// и возвращает их в формате JSON.
+// и возвращает их в формате JSON. Параметры limit и cursor задают страницу, sort и order порядок,
+// deleted и q фильтры. Ссылка на следующую страницу передается в заголовке Link.
 func (dataStore *ServerDataStore) getByUserIDHandler(w http.ResponseWriter, r *http.Request) {
-	token, userID, err := getTokenAndUserID(r)
-	if err != nil || !token.Valid {
+	userID, err := dataStore.getUserID(r)
+	if err != nil {
 		logger.Log.Error("cannot find cookie", zap.Error(err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	query, err := dataStore.parseURLQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// лишний URL показывает, что за страницей есть следующая
+	limit := query.Limit
+	if limit > 0 {
+		query.Limit++
+	}
+
 	servShortURL := dataStore.configStore.FlagShortRunAddr
 
 	var resp []models.BatchByUserIDResponse
-	savedURLs, err := dataStore.storager.ReadAllDataForUserID(r.Context(), userID)
+	savedURLs, err := dataStore.storager.ReadAllDataForUserID(r.Context(), userID, query)
 	if err != nil {
 		logger.Log.Error("cannot read data for user", zap.Error(err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if limit > 0 && len(savedURLs) > limit {
+		savedURLs = savedURLs[:limit]
+		if err := dataStore.setNextLink(w, r, savedURLs[limit-1]); err != nil {
+			logger.Log.Error("cannot encode cursor", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
 
 	for _, savedURL := range savedURLs {
 		resp = append(resp, models.BatchByUserIDResponse{
 			ShortURL:    servShortURL + "/" + savedURL.ShortURL,
 			OriginalURL: savedURL.OriginalURL,
+			Deleted:     savedURL.Deleted,
+			Version:     savedURL.Version,
 		})
 		logger.Log.Info("Readed from batch request", zap.String("body", savedURL.OriginalURL), zap.String("result", servShortURL+"/"+savedURL.ShortURL), zap.Int("userID", userID), zap.Bool("Deleted", savedURL.Deleted))
 	}
@@ -332,12 +595,80 @@ func (dataStore *ServerDataStore) GetHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if originalSavedURL.IsExpired(time.Now()) {
+		logger.Log.Info("this url is expired", zap.String("id", id))
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
 	logger.Log.Info("After GET request", zap.String("id", id), zap.String("originalURL", originalSavedURL.OriginalURL))
+	dataStore.recordClick(r, originalSavedURL)
 
 	w.Header().Set("Location", originalSavedURL.OriginalURL)
 	w.WriteHeader(http.StatusTemporaryRedirect)
 }
 
+// recordClick ставит переход по savedURL в очередь на запись, если запись переходов включена.
+// Переход учитывается у владельца savedURL, который обслужил редирект.
+func (dataStore *ServerDataStore) recordClick(r *http.Request, savedURL models.SavedURL) {
+	if dataStore.recorder == nil {
+		return
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	dataStore.recorder.Record(models.Click{
+		ShortURL:  savedURL.ShortURL,
+		UserID:    savedURL.UserID,
+		Timestamp: time.Now(),
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+		IPHash:    analytics.HashIP(ip),
+	})
+}
+
+// getStatsHandler обрабатывает GET-запросы для получения статистики переходов по короткому URL.
+// Статистика доступна только владельцу короткого URL.
+func (dataStore *ServerDataStore) getStatsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := dataStore.getUserID(r)
+	if err != nil {
+		logger.Log.Error("cannot find cookie", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	shortURL := chi.URLParam(r, "shortUrl")
+	_, ok, err := dataStore.storager.GetURLForUserID(r.Context(), shortURL, userID)
+	if err != nil {
+		logger.Log.Error("cannot get data for id", zap.String("id", shortURL), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		logger.Log.Info("url is not found for user", zap.String("id", shortURL), zap.Int("userID", userID))
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	stats, err := dataStore.storager.GetClickStats(r.Context(), shortURL, userID)
+	if err != nil {
+		logger.Log.Error("cannot get click stats", zap.String("id", shortURL), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := dataStore.json.NewEncoder(w).Encode(stats); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+		return
+	}
+}
+
 // pingHandler проверяет состояние сервера и возвращает ответ с кодом статуса.
 func (dataStore *ServerDataStore) pingHandler(w http.ResponseWriter, r *http.Request) {
 	err := dataStore.storager.PingContext(r.Context())
@@ -350,86 +681,187 @@ func (dataStore *ServerDataStore) pingHandler(w http.ResponseWriter, r *http.Req
 	w.WriteHeader(http.StatusOK)
 }
 
-// GenerateShortURL генерирует сокращенный URL на основе исходного URL.
-func GenerateShortURL(url string) string {
-	hash := sha256.Sum256([]byte(url))
-	encoded := base64.RawURLEncoding.EncodeToString(hash[:])
-	return encoded[:8]
-}
-
-// authMiddleware проверяет наличие и валидность токена в запросе.
-// Если токен недействителен или отсутствует, он устанавливает новый токен в ответе.
-func (dataStore *ServerDataStore) authMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get the JWT from the cookie
-		_, err := r.Cookie(jwtCookieKey)
-		// If any other error occurred, return a bad request error
-		if err != nil && err != http.ErrNoCookie {
-			logger.Log.Error("error with cookie", zap.Error(err))
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+// resolveShortURL возвращает короткий код для URL: пользовательский, если он задан, иначе сгенерированный.
+// Пользовательский код считается занятым, если он принадлежит другому пользователю или указывает на другой URL.
+// Проверка здесь лишь отсекает заведомо занятые коды до сохранения, окончательно занятость проверяет
+// хранилище в StoreURL и StoreURLBatch, поэтому сохранять код нужно с SavedURL.Alias.
+func (dataStore *ServerDataStore) resolveShortURL(ctx context.Context, originalURL string, alias string, userID int) (string, error) {
+	if alias == "" {
+		return dataStore.generator.Generate(ctx, originalURL)
+	}
+
+	if err := shortcode.ValidateAlias(alias); err != nil {
+		return "", err
+	}
+
+	savedURL, ok, err := dataStore.storager.GetURLForAnyUserID(ctx, alias)
+	if err != nil {
+		return "", err
+	}
+	if ok && (savedURL.UserID != userID || savedURL.OriginalURL != originalURL) {
+		return "", errAliasTaken
+	}
+
+	return alias, nil
+}
+
+// resolveExpiresAt возвращает момент истечения срока действия ссылки по expires_at или ttl_seconds.
+// Если не задано ни то, ни другое, ссылка бессрочная и возвращается nil.
+func resolveExpiresAt(expiresAt *time.Time, ttlSeconds int64, now time.Time) (*time.Time, error) {
+	if expiresAt != nil && ttlSeconds != 0 {
+		return nil, errInvalidExpiration
+	}
+
+	if ttlSeconds != 0 {
+		if ttlSeconds < 0 || ttlSeconds > maxTTLSeconds {
+			return nil, errInvalidExpiration
 		}
-		isBatchByUserID := r.Method == http.MethodGet && r.RequestURI == "/api/user/urls"
-		// If the cookie is not found, make a cookie
-		if err == http.ErrNoCookie {
-			if isBatchByUserID {
-				logger.Log.Error("No cookie and isBatchByUserID", zap.Error(err))
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		result := now.Add(time.Duration(ttlSeconds) * time.Second)
+		return &result, nil
+	}
+
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, errInvalidExpiration
+	}
+
+	return expiresAt, nil
+}
+
+// statusForResolveError возвращает код ответа для ошибки resolveShortURL.
+func statusForResolveError(err error) int {
+	switch {
+	case errors.Is(err, shortcode.ErrInvalidAlias), errors.Is(err, shortcode.ErrReservedAlias), errors.Is(err, errInvalidExpiration):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errAliasTaken):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// GenerateShortURL генерирует сокращенный URL на основе исходного URL без проверки коллизий.
+// Совпадает с первым кандидатом, который проверяет shortcode.HashGenerator.
+func GenerateShortURL(url string) string {
+	return shortcode.HashCode(url, 0, shortcode.DefaultLength)
+}
+
+// authMode требования маршрута к пользователю запроса.
+type authMode int
+
+const (
+	// authOptional пользователь определяется по ключу API или куке, если они есть.
+	// Запрос без них, а также с недействительной кукой или кукой неизвестного пользователя обслуживается анонимно,
+	// пользователя создает обработчик при первой записи, см. ensureUserID.
+	authOptional authMode = iota
+	// authRequired запрос без ключа API и действующей куки отклоняется с кодом 401.
+	authRequired
+)
+
+// authMiddleware проверяет ключ API из заголовка Authorization или токен из куки
+// и сохраняет пользователя запроса в контексте. Маршруты, которым пользователь не нужен,
+// регистрируются без authMiddleware, поэтому для них куки не проверяются и не выдаются.
+// Действующий токен перевыпускается, если он старше cookieRefreshAfter или подписан неактивным ключом,
+// так срок жизни куки продлевается при активности, а после смены ключа куки переподписываются новым.
+func (dataStore *ServerDataStore) authMiddleware(mode authMode) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// ключ API имеет приоритет над кукой, серверам не нужно хранить куки между запросами
+			if key, ok := bearerToken(r); ok {
+				dataStore.authenticateAPIKey(w, r, next, key)
 				return
 			}
 
-			lastUserID, err := dataStore.storager.GetLastUserID(r.Context())
+			// без действующей куки анонимный запрос обслуживается, а обработчик при записи выдаст новую куку
+			unauthenticated := func() {
+				if mode == authRequired {
+					logger.Log.Info("no valid cookie for authenticated route", zap.String("uri", r.RequestURI))
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, r)
+			}
+
+			_, err := r.Cookie(jwtCookieKey)
+			if err == http.ErrNoCookie {
+				unauthenticated()
+				return
+			}
 			if err != nil {
-				logger.Log.Error("can't get userID for cookie", zap.Error(err))
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				logger.Log.Error("error with cookie", zap.Error(err))
+				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			lastUserIDStr := strconv.Itoa(lastUserID)
-			setUserIDCookie(w, r, lastUserIDStr)
-			dataStore.storager.SaveUserID(lastUserID)
-			logger.Log.Info("Cookie is created! New user id", zap.Int("userID", lastUserID))
 
-			next.ServeHTTP(w, r)
-		} else {
 			// Parse and validate the JWT
-			token, userID, err := getTokenAndUserID(r)
-
-			if err != nil || !token.Valid || !dataStore.storager.IsItCorrectUserID(userID) {
+			token, claims, err := dataStore.parseUserToken(r)
+			userID := 0
+			if err == nil {
+				userID, err = strconv.Atoi(claims.UserID)
+			}
+			if err != nil || !token.Valid {
 				logger.Log.Error("invalid cookie", zap.Error(err), zap.Int("userID", userID))
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				unauthenticated()
+				return
+			}
+
+			user, ok, err := dataStore.storager.GetUser(r.Context(), userID)
+			if err != nil {
+				logger.Log.Error("can't get user for cookie", zap.Error(err), zap.Int("userID", userID))
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				logger.Log.Error("unknown user in cookie", zap.Int("userID", userID))
+				unauthenticated()
 				return
 			}
 			logger.Log.Info("Cookie is finded", zap.Int("userID", userID))
 
+			now := time.Now()
+			if now.Sub(user.LastSeen) >= userTouchInterval {
+				// запрос можно обслужить и без отметки об активности, поэтому ошибка только логируется
+				if err := dataStore.storager.TouchUser(r.Context(), userID, now.UTC()); err != nil {
+					logger.Log.Error("can't touch user", zap.Error(err), zap.Int("userID", userID))
+				}
+			}
+
+			if claims.IssuedAt == nil || time.Since(claims.IssuedAt.Time) >= cookieRefreshAfter || auth.KeyID(token) != dataStore.keys.ActiveKeyID() {
+				// старая кука еще действует, поэтому при ошибке запрос не прерывается
+				if err := dataStore.setUserIDCookie(w, r, claims.UserID); err != nil {
+					logger.Log.Error("can't refresh cookie", zap.Error(err), zap.Int("userID", userID))
+				}
+			}
+
 			// If the JWT is valid, proceed to the next handler
-			next.ServeHTTP(w, r)
-		}
-	})
+			next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), principal{userID: userID})))
+		})
+	}
 }
 
-// getTokenAndUserID извлекает токен из запроса и извлекает идентификатор пользователя из токена.
-func getTokenAndUserID(r *http.Request) (*jwt.Token, int, error) {
-	claims := &UserClaims{}
-	cookie, err := r.Cookie(jwtCookieKey)
-	// If any other error occurred, return a bad request error
-	if err != nil {
-		return nil, 0, err
+// ensureUserID возвращает пользователя запроса, а анонимному запросу создает нового пользователя
+// и выдает ему куку. Вызывается обработчиками записи до отправки заголовков ответа,
+// поэтому пользователи не создаются для запросов, которые ничего не сохраняют.
+func (dataStore *ServerDataStore) ensureUserID(w http.ResponseWriter, r *http.Request) (int, error) {
+	if p, ok := principalFromContext(r.Context()); ok {
+		return p.userID, nil
 	}
 
-	// Parse and validate the JWT
-	token, err := jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(jwtSecretKey), nil
-	})
-
+	user, err := dataStore.storager.CreateUser(r.Context(), time.Now().UTC())
 	if err != nil {
-		return nil, 0, err
+		return 0, fmt.Errorf("can't create user: %w", err)
 	}
+	if err := dataStore.setUserIDCookie(w, r, strconv.Itoa(user.ID)); err != nil {
+		return 0, fmt.Errorf("can't sign cookie: %w", err)
+	}
+	logger.Log.Info("Cookie is created! New user id", zap.Int("userID", user.ID))
+	return user.ID, nil
+}
 
-	if !token.Valid {
-		return token, 0, fmt.Errorf("token is invalid")
+// getTokenAndUserID извлекает токен из запроса и извлекает идентификатор пользователя из токена.
+func (dataStore *ServerDataStore) getTokenAndUserID(r *http.Request) (*jwt.Token, int, error) {
+	token, claims, err := dataStore.parseUserToken(r)
+	if err != nil {
+		return token, 0, err
 	}
 
 	userID, err := strconv.Atoi(claims.UserID)
@@ -440,63 +872,83 @@ func getTokenAndUserID(r *http.Request) (*jwt.Token, int, error) {
 	return token, userID, nil
 }
 
-func setUserIDCookie(w http.ResponseWriter, r *http.Request, userID string) {
-	// Create a new token object, specifying signing method and the claims
-	claims := UserClaims{
-		userID,
-		jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			Issuer:    "myServer",
-		},
+// parseUserToken извлекает токен из куки запроса и проверяет его подпись и срок действия.
+func (dataStore *ServerDataStore) parseUserToken(r *http.Request) (*jwt.Token, *UserClaims, error) {
+	claims := &UserClaims{}
+	cookie, err := r.Cookie(jwtCookieKey)
+	if err != nil {
+		return nil, nil, err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	// Sign and get the complete encoded token as a string using the secret
-	signedToken, err := token.SignedString([]byte(jwtSecretKey))
+	token, err := dataStore.keys.Parse(cookie.Value, claims)
 	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+		return nil, nil, err
 	}
 
-	newCookie := &http.Cookie{
-		Name:    jwtCookieKey,
-		Value:   signedToken,
-		Expires: time.Now().Add(24 * time.Hour),
+	if !token.Valid {
+		return token, nil, fmt.Errorf("token is invalid")
+	}
+
+	return token, claims, nil
+}
+
+// setUserIDCookie подписывает новый токен для userID активным ключом и ставит его в куку ответа.
+func (dataStore *ServerDataStore) setUserIDCookie(w http.ResponseWriter, r *http.Request, userID string) error {
+	newCookie, err := newUserCookie(dataStore.keys, userID, dataStore.configStore.FlagLTS)
+	if err != nil {
+		return err
 	}
 
 	r.AddCookie(newCookie)
 
 	// Set the JWT as a cookie
 	http.SetCookie(w, newCookie)
+	return nil
 }
 
-// GetTestCookie создает тестовый http.Cookie для использования в тестах.
-func GetTestCookie() *http.Cookie {
-	userID := "1"
+// newUserCookie создает куку с токеном для userID, подписанным активным ключом keys.
+// Кука недоступна из JavaScript, а secure ограничивает ее отправку соединениями HTTPS.
+func newUserCookie(keys *auth.KeySet, userID string, secure bool) (*http.Cookie, error) {
+	now := time.Now()
 	claims := UserClaims{
 		userID,
 		jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(cookieTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
 			Issuer:    "myServer",
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	// Sign and get the complete encoded token as a string using the secret
-	signedToken, _ := token.SignedString([]byte(jwtSecretKey))
-	return &http.Cookie{
-		Name:    jwtCookieKey,
-		Value:   signedToken,
-		Expires: time.Now().Add(24 * time.Hour),
+	// Sign and get the complete encoded token as a string using the active key
+	signedToken, err := keys.Sign(claims)
+	if err != nil {
+		return nil, err
 	}
+
+	return &http.Cookie{
+		Name:     jwtCookieKey,
+		Value:    signedToken,
+		Path:     "/",
+		Expires:  now.Add(cookieTTL),
+		MaxAge:   int(cookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}, nil
+}
+
+// GetTestCookie создает тестовый http.Cookie для пользователя 1 для использования в тестах.
+// Кука подписана ключами по умолчанию, то есть подходит серверу, созданному без WithSigningKeys.
+func GetTestCookie() *http.Cookie {
+	cookie, _ := newUserCookie(defaultKeys, "1", false)
+	return cookie
 }
 
-// deleteByUserIDHandler обрабатывает DELETE-запросы для удаления всех сохраненных URL пользователя.
-// Он извлекает идентификатор пользователя из токена, удаляет сохраненные URL из хранилища,
-// и возвращает ответ с кодом статуса.
+// deleteByUserIDHandler обрабатывает DELETE-запросы для удаления сохраненных URL пользователя.
+// URL удаляются асинхронно: в ответ возвращается задача удаления и ссылка на ее состояние в заголовке Location.
 func (dataStore *ServerDataStore) deleteByUserIDHandler(w http.ResponseWriter, r *http.Request) {
-	token, userID, err := getTokenAndUserID(r)
-	if err != nil || !token.Valid {
+	userID, err := dataStore.getUserID(r)
+	if err != nil {
 		logger.Log.Error("cannot find cookie", zap.Error(err))
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -519,20 +971,93 @@ func (dataStore *ServerDataStore) deleteByUserIDHandler(w http.ResponseWriter, r
 		return
 	}
 
-	// Print the URLs to the console
-	for _, URL := range slice {
-		logger.Log.Info("Try to delete", zap.String("ShortURL", URL), zap.Int("userID", userID))
+	job, err := dataStore.deleteQueue.Submit(userID, slice)
+	switch {
+	case errors.Is(err, deletion.ErrQueueFull):
+		logger.Log.Error("Can't submit delete job", zap.Error(err), zap.Int("userID", userID))
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, deletion.ErrQueueClosed):
+		logger.Log.Error("Can't submit delete job", zap.Error(err), zap.Int("userID", userID))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		logger.Log.Error("Can't submit delete job", zap.Error(err), zap.Int("userID", userID))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
+	logger.Log.Info("Delete job is queued", zap.String("id", job.ID), zap.Int("userID", userID), zap.Int("count", job.Total))
 
-	// Start a new goroutine to perform the deletion
-	go func() {
-		// чтобы не зависеть от контекста запроса
-		ctx := context.Background()
-		err := dataStore.storager.DeleteByUserID(ctx, slice, userID)
-		if err != nil {
-			logger.Log.Info("Can't delete by user id", zap.String("error", err.Error()))
-		}
-	}()
+	if dataStore.httpMetrics != nil {
+		dataStore.httpMetrics.Deletions.Inc()
+	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/user/jobs/"+job.ID)
 	w.WriteHeader(http.StatusAccepted)
+	if err := dataStore.json.NewEncoder(w).Encode(job); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+// getDeleteJobHandler обрабатывает GET-запросы для получения состояния задачи удаления.
+// Задачи других пользователей не видны, на них отвечается 404.
+func (dataStore *ServerDataStore) getDeleteJobHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := dataStore.getUserID(r)
+	if err != nil {
+		logger.Log.Error("cannot find cookie", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	job, ok := dataStore.deleteQueue.Get(chi.URLParam(r, "jobID"), userID)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := dataStore.json.NewEncoder(w).Encode(job); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+		return
+	}
+}
+
+// isAdminRequest проверяет токен административного запроса.
+// Если токен не задан в конфигурации, административные запросы запрещены.
+func (dataStore *ServerDataStore) isAdminRequest(r *http.Request) bool {
+	adminToken := dataStore.configStore.FlagAdminToken
+	if adminToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(adminTokenHeader)), []byte(adminToken)) == 1
+}
+
+// compactHandler обрабатывает POST-запросы на компактификацию хранилища.
+// Доступен только с административным токеном и только для хранилищ, которые поддерживают компактификацию.
+func (dataStore *ServerDataStore) compactHandler(w http.ResponseWriter, r *http.Request) {
+	if !dataStore.isAdminRequest(r) {
+		logger.Log.Info("admin request is forbidden", zap.String("uri", r.RequestURI))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	compactor, ok := storage.Unwrap(dataStore.storager).(storage.Compactor)
+	if !ok {
+		logger.Log.Info("storage does not support compaction")
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	if err := compactor.Compact(r.Context()); err != nil {
+		logger.Log.Error("cannot compact storage", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logger.Log.Info("Storage is compacted by admin request")
+	w.WriteHeader(http.StatusOK)
 }
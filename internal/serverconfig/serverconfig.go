@@ -5,29 +5,75 @@ import (
 	"encoding/json"
 	"flag"
 	"os"
+	"strconv"
+	"time"
+)
+
+// Возможные значения FlagStorage. Пустое значение означает автоматический выбор:
+// база данных, если к ней удалось подключиться, иначе файл.
+const (
+	StorageMemory   = "memory"
+	StorageFile     = "file"
+	StorageDatabase = "database"
 )
 
 // ConfigStore структура с всеми используемыми флагами
 type ConfigStore struct {
-	FlagRunAddr      string `json:"server_address"`
-	FlagShortRunAddr string `json:"base_url"`
-	FlagLogLevel     string `json:"-"`
-	FlagFile         string `json:"file_storage_path"`
-	FlagDB           string `json:"database_dsn"`
-	FlagLTS          bool   `json:"enable_https"`
-	FlagConfig       string `json:"-"`
+	FlagRunAddr         string        `json:"server_address"`
+	FlagShortRunAddr    string        `json:"base_url"`
+	FlagLogLevel        string        `json:"-"`
+	FlagFile            string        `json:"file_storage_path"`
+	FlagDB              string        `json:"database_dsn"`
+	FlagLTS             bool          `json:"enable_https"`
+	FlagConfig          string        `json:"-"`
+	FlagStrategy        string        `json:"short_url_strategy"`
+	FlagJanitorInterval time.Duration `json:"-"`
+	FlagStorage         string        `json:"storage"`
+	FlagAdminToken      string        `json:"admin_token"`
+	FlagCompactInterval time.Duration `json:"-"`
+	FlagCompactRatio    float64       `json:"compact_ratio"`
+	FlagCompactMaxSize  int64         `json:"compact_max_size"`
+	FlagFsync           string        `json:"fsync"`
+	FlagFsyncInterval   time.Duration `json:"-"`
+	FlagTraceExporter   string        `json:"trace_exporter"`
+	FlagTraceEndpoint   string        `json:"trace_endpoint"`
+	FlagJWTKeys         string        `json:"jwt_keys"`
+	FlagJWTKeyFile      string        `json:"jwt_key_file"`
+	FlagDeleteWorkers   int           `json:"delete_workers"`
+	FlagPurgeAfter      time.Duration `json:"-"`
+	FlagRateLimits      string        `json:"rate_limits"`
+	FlagTrustedProxies  string        `json:"trusted_proxies"`
+	FlagTrustedSubnet   string        `json:"trusted_subnet"`
 }
 
 // NewConfigStore возвращает ConfigStore с пустыми значениями всех флагов
 func NewConfigStore() *ConfigStore {
 	return &ConfigStore{
-		FlagRunAddr:      "",
-		FlagShortRunAddr: "",
-		FlagLogLevel:     "",
-		FlagFile:         "",
-		FlagDB:           "",
-		FlagLTS:          false,
-		FlagConfig:       "",
+		FlagRunAddr:         "",
+		FlagShortRunAddr:    "",
+		FlagLogLevel:        "",
+		FlagFile:            "",
+		FlagDB:              "",
+		FlagLTS:             false,
+		FlagConfig:          "",
+		FlagStrategy:        "",
+		FlagJanitorInterval: 0,
+		FlagStorage:         "",
+		FlagAdminToken:      "",
+		FlagCompactInterval: 0,
+		FlagCompactRatio:    0,
+		FlagCompactMaxSize:  0,
+		FlagFsync:           "",
+		FlagFsyncInterval:   0,
+		FlagTraceExporter:   "",
+		FlagTraceEndpoint:   "",
+		FlagJWTKeys:         "",
+		FlagJWTKeyFile:      "",
+		FlagDeleteWorkers:   0,
+		FlagPurgeAfter:      0,
+		FlagRateLimits:      "",
+		FlagTrustedProxies:  "",
+		FlagTrustedSubnet:   "",
 	}
 }
 
@@ -54,6 +100,11 @@ func (configStore *ConfigStore) ParseFlags() {
 	flagShortRunAddrDef := "http://localhost:8080"
 	flagFileDef := "/tmp/short-url-db.json"
 	flagDBDef := ""
+	flagStrategyDef := "hash"
+	flagCompactRatioDef := 2.0
+	flagFsyncDef := "batch"
+	flagTraceEndpointDef := "http://localhost:4318/v1/traces"
+	flagDeleteWorkersDef := 4
 
 	flag.StringVar(&configStore.FlagRunAddr, "a", flagRunAddrDef, "address and port to run server")
 	flag.StringVar(&configStore.FlagShortRunAddr, "b", flagShortRunAddrDef, "address and port to return short url")
@@ -63,6 +114,24 @@ func (configStore *ConfigStore) ParseFlags() {
 	flag.BoolVar(&configStore.FlagLTS, "s", false, "use LTS")
 	flag.StringVar(&configStore.FlagConfig, "c", "", "path to config file")
 	flag.StringVar(&configStore.FlagConfig, "config", "", "path to config file")
+	flag.StringVar(&configStore.FlagStrategy, "g", flagStrategyDef, "short url generation strategy: hash or counter")
+	flag.StringVar(&configStore.FlagStorage, "storage", "", "storage backend: memory, file or database, empty to choose automatically")
+	flag.DurationVar(&configStore.FlagJanitorInterval, "janitor-interval", time.Minute, "interval to delete expired urls, 0 to disable")
+	flag.StringVar(&configStore.FlagAdminToken, "admin-token", "", "token for admin endpoints, empty to disable them")
+	flag.DurationVar(&configStore.FlagCompactInterval, "compact-interval", time.Hour, "interval to compact storage file, 0 to disable")
+	flag.Float64Var(&configStore.FlagCompactRatio, "compact-ratio", flagCompactRatioDef, "compact storage file when records/live records exceeds ratio, 0 to disable")
+	flag.Int64Var(&configStore.FlagCompactMaxSize, "compact-max-size", 0, "compact storage file when it exceeds size in bytes, 0 to disable")
+	flag.StringVar(&configStore.FlagFsync, "fsync", flagFsyncDef, "when to fsync storage file: always, batch or never")
+	flag.DurationVar(&configStore.FlagFsyncInterval, "fsync-interval", 100*time.Millisecond, "interval to fsync storage file for batch policy")
+	flag.StringVar(&configStore.FlagTraceExporter, "trace-exporter", "", "where to export traces: stdout or otlp, empty to disable tracing")
+	flag.StringVar(&configStore.FlagTraceEndpoint, "trace-endpoint", flagTraceEndpointDef, "OTLP/HTTP endpoint for traces")
+	flag.StringVar(&configStore.FlagJWTKeys, "jwt-keys", "", "cookie signing keys as kid:secret,kid:secret, the first one signs new cookies")
+	flag.StringVar(&configStore.FlagJWTKeyFile, "jwt-key-file", "", "file with cookie signing keys, one kid:secret per line")
+	flag.IntVar(&configStore.FlagDeleteWorkers, "delete-workers", flagDeleteWorkersDef, "number of workers processing async delete jobs")
+	flag.DurationVar(&configStore.FlagPurgeAfter, "purge-after", 0, "retention of deleted urls before permanent purge, 0 to disable")
+	flag.StringVar(&configStore.FlagRateLimits, "rate-limits", "", "rate limits per route group as group=limit/window, groups: shorten, batch, redirect, user, empty to disable")
+	flag.StringVar(&configStore.FlagTrustedProxies, "trusted-proxies", "", "comma-separated IPs or CIDRs of proxies whose X-Forwarded-For is trusted")
+	flag.StringVar(&configStore.FlagTrustedSubnet, "t", "", "CIDR of clients allowed to read internal stats by X-Real-IP, empty to disable")
 	// парсим переданные серверу аргументы в зарегистрированные переменные
 	flag.Parse()
 
@@ -89,6 +158,48 @@ func (configStore *ConfigStore) ParseFlags() {
 		if !configStore.FlagLTS {
 			configStore.FlagLTS = tempConfig.FlagLTS
 		}
+		if configStore.FlagStrategy == flagStrategyDef && tempConfig.FlagStrategy != "" {
+			configStore.FlagStrategy = tempConfig.FlagStrategy
+		}
+		if configStore.FlagStorage == "" {
+			configStore.FlagStorage = tempConfig.FlagStorage
+		}
+		if configStore.FlagAdminToken == "" {
+			configStore.FlagAdminToken = tempConfig.FlagAdminToken
+		}
+		if configStore.FlagCompactRatio == flagCompactRatioDef && tempConfig.FlagCompactRatio != 0 {
+			configStore.FlagCompactRatio = tempConfig.FlagCompactRatio
+		}
+		if configStore.FlagCompactMaxSize == 0 {
+			configStore.FlagCompactMaxSize = tempConfig.FlagCompactMaxSize
+		}
+		if configStore.FlagFsync == flagFsyncDef && tempConfig.FlagFsync != "" {
+			configStore.FlagFsync = tempConfig.FlagFsync
+		}
+		if configStore.FlagTraceExporter == "" {
+			configStore.FlagTraceExporter = tempConfig.FlagTraceExporter
+		}
+		if configStore.FlagTraceEndpoint == flagTraceEndpointDef && tempConfig.FlagTraceEndpoint != "" {
+			configStore.FlagTraceEndpoint = tempConfig.FlagTraceEndpoint
+		}
+		if configStore.FlagJWTKeys == "" {
+			configStore.FlagJWTKeys = tempConfig.FlagJWTKeys
+		}
+		if configStore.FlagJWTKeyFile == "" {
+			configStore.FlagJWTKeyFile = tempConfig.FlagJWTKeyFile
+		}
+		if configStore.FlagDeleteWorkers == flagDeleteWorkersDef && tempConfig.FlagDeleteWorkers != 0 {
+			configStore.FlagDeleteWorkers = tempConfig.FlagDeleteWorkers
+		}
+		if configStore.FlagRateLimits == "" {
+			configStore.FlagRateLimits = tempConfig.FlagRateLimits
+		}
+		if configStore.FlagTrustedProxies == "" {
+			configStore.FlagTrustedProxies = tempConfig.FlagTrustedProxies
+		}
+		if configStore.FlagTrustedSubnet == "" {
+			configStore.FlagTrustedSubnet = tempConfig.FlagTrustedSubnet
+		}
 	}
 
 	// а затем в любом случае смотрим еще и переменные окружения
@@ -111,4 +222,78 @@ func (configStore *ConfigStore) ParseFlags() {
 	if envDB := os.Getenv("DATABASE_DSN"); envDB != "" {
 		configStore.FlagDB = envDB
 	}
+
+	if envStrategy := os.Getenv("SHORT_URL_STRATEGY"); envStrategy != "" {
+		configStore.FlagStrategy = envStrategy
+	}
+
+	if envStorage := os.Getenv("STORAGE"); envStorage != "" {
+		configStore.FlagStorage = envStorage
+	}
+
+	if envAdminToken := os.Getenv("ADMIN_TOKEN"); envAdminToken != "" {
+		configStore.FlagAdminToken = envAdminToken
+	}
+
+	if envJanitorInterval := os.Getenv("JANITOR_INTERVAL"); envJanitorInterval != "" {
+		if interval, err := time.ParseDuration(envJanitorInterval); err == nil {
+			configStore.FlagJanitorInterval = interval
+		}
+	}
+
+	if envCompactInterval := os.Getenv("COMPACT_INTERVAL"); envCompactInterval != "" {
+		if interval, err := time.ParseDuration(envCompactInterval); err == nil {
+			configStore.FlagCompactInterval = interval
+		}
+	}
+
+	if envFsync := os.Getenv("FSYNC"); envFsync != "" {
+		configStore.FlagFsync = envFsync
+	}
+
+	if envFsyncInterval := os.Getenv("FSYNC_INTERVAL"); envFsyncInterval != "" {
+		if interval, err := time.ParseDuration(envFsyncInterval); err == nil {
+			configStore.FlagFsyncInterval = interval
+		}
+	}
+
+	if envTraceExporter := os.Getenv("TRACE_EXPORTER"); envTraceExporter != "" {
+		configStore.FlagTraceExporter = envTraceExporter
+	}
+
+	if envTraceEndpoint := os.Getenv("TRACE_ENDPOINT"); envTraceEndpoint != "" {
+		configStore.FlagTraceEndpoint = envTraceEndpoint
+	}
+
+	if envJWTKeys := os.Getenv("JWT_KEYS"); envJWTKeys != "" {
+		configStore.FlagJWTKeys = envJWTKeys
+	}
+
+	if envJWTKeyFile := os.Getenv("JWT_KEY_FILE"); envJWTKeyFile != "" {
+		configStore.FlagJWTKeyFile = envJWTKeyFile
+	}
+
+	if envDeleteWorkers := os.Getenv("DELETE_WORKERS"); envDeleteWorkers != "" {
+		if workers, err := strconv.Atoi(envDeleteWorkers); err == nil {
+			configStore.FlagDeleteWorkers = workers
+		}
+	}
+
+	if envPurgeAfter := os.Getenv("PURGE_AFTER"); envPurgeAfter != "" {
+		if retention, err := time.ParseDuration(envPurgeAfter); err == nil {
+			configStore.FlagPurgeAfter = retention
+		}
+	}
+
+	if envRateLimits := os.Getenv("RATE_LIMITS"); envRateLimits != "" {
+		configStore.FlagRateLimits = envRateLimits
+	}
+
+	if envTrustedProxies := os.Getenv("TRUSTED_PROXIES"); envTrustedProxies != "" {
+		configStore.FlagTrustedProxies = envTrustedProxies
+	}
+
+	if envTrustedSubnet := os.Getenv("TRUSTED_SUBNET"); envTrustedSubnet != "" {
+		configStore.FlagTrustedSubnet = envTrustedSubnet
+	}
 }
@@ -0,0 +1,54 @@
+package shortcode
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+const (
+	// MinAliasLength минимальная длина пользовательского кода.
+	MinAliasLength = 3
+	// MaxAliasLength максимальная длина пользовательского кода.
+	MaxAliasLength = 64
+)
+
+var (
+	// ErrInvalidAlias возвращается, если пользовательский код содержит недопустимые символы или имеет неверную длину.
+	ErrInvalidAlias = errors.New("custom alias must be 3-64 characters of a-z, A-Z, 0-9, '-' or '_'")
+	// ErrReservedAlias возвращается, если пользовательский код совпадает с зарезервированным словом.
+	ErrReservedAlias = errors.New("custom alias is reserved")
+
+	aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+	// reservedAliases слова, которые заняты маршрутами сервера или могут быть заняты в будущем.
+	reservedAliases = map[string]struct{}{
+		"api":     {},
+		"ping":    {},
+		"admin":   {},
+		"metrics": {},
+		"health":  {},
+		"static":  {},
+		"user":    {},
+		"login":   {},
+		"logout":  {},
+	}
+)
+
+// ValidateAlias проверяет пользовательский короткий код на набор символов, длину и зарезервированные слова.
+func ValidateAlias(alias string) error {
+	if len(alias) < MinAliasLength || len(alias) > MaxAliasLength || !aliasPattern.MatchString(alias) {
+		return ErrInvalidAlias
+	}
+	if IsReserved(alias) {
+		return ErrReservedAlias
+	}
+	return nil
+}
+
+// IsReserved проверяет, совпадает ли короткий код с зарезервированным словом без учета регистра.
+// Такие коды не выдаются ни пользователям, ни генераторами, иначе редирект по ним перекрыл бы маршрут сервера.
+func IsReserved(code string) bool {
+	_, ok := reservedAliases[strings.ToLower(code)]
+	return ok
+}
@@ -0,0 +1,188 @@
+// Package shortcode предоставляет стратегии генерации коротких кодов для URL.
+package shortcode
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// StrategyHash стратегия на основе SHA-256 от исходного URL.
+	StrategyHash = "hash"
+	// StrategyCounter стратегия на основе возрастающего счетчика в base62.
+	StrategyCounter = "counter"
+
+	// DefaultLength длина короткого кода по умолчанию.
+	DefaultLength = 8
+	// maxHashLength максимальная длина кода, до которой может дорасти хеш-стратегия.
+	maxHashLength = 16
+	// attemptsPerLength количество попыток с разной солью для одной длины кода.
+	attemptsPerLength = 4
+	// maxCounterAttempts количество занятых кодов, которое может пропустить счетчик.
+	maxCounterAttempts = 100
+	// counterBlockSize сколько значений счетчика генератор резервирует в хранилище за раз.
+	// Не выданные до перезапуска значения блока пропускаются.
+	counterBlockSize = 100
+
+	base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+// ErrNoFreeCode возвращается, если генератор не смог подобрать свободный код.
+var ErrNoFreeCode = errors.New("cannot find free short code")
+
+// Generator определяет интерфейс генератора коротких кодов.
+type Generator interface {
+	// Generate возвращает короткий код для исходного URL, который не занят другим исходным URL.
+	Generate(ctx context.Context, originalURL string) (string, error)
+}
+
+// NewGenerator создает генератор для выбранной стратегии.
+// Пустая стратегия означает стратегию по умолчанию (hash).
+func NewGenerator(strategy string, storager storage.Storage) (Generator, error) {
+	switch strategy {
+	case "", StrategyHash:
+		return NewHashGenerator(storager), nil
+	case StrategyCounter:
+		return NewCounterGenerator(storager), nil
+	default:
+		return nil, fmt.Errorf("unknown short url strategy: %s", strategy)
+	}
+}
+
+// HashGenerator генерирует код из SHA-256 исходного URL.
+// При коллизии с другим URL добавляет к URL соль, а затем увеличивает длину кода.
+type HashGenerator struct {
+	storager storage.Storage
+}
+
+// NewHashGenerator создает новый экземпляр HashGenerator.
+func NewHashGenerator(storager storage.Storage) *HashGenerator {
+	return &HashGenerator{
+		storager: storager,
+	}
+}
+
+// Generate возвращает короткий код для исходного URL.
+// Для одного и того же URL без коллизий всегда возвращается один и тот же код.
+func (generator *HashGenerator) Generate(ctx context.Context, originalURL string) (string, error) {
+	for length := DefaultLength; length <= maxHashLength; length += 2 {
+		for salt := 0; salt < attemptsPerLength; salt++ {
+			code := HashCode(originalURL, salt, length)
+			free, err := isFreeFor(ctx, generator.storager, code, originalURL)
+			if err != nil {
+				return "", err
+			}
+			if free {
+				return code, nil
+			}
+			logger.Log.Warn("Short code collision", zap.String("code", code), zap.String("originalURL", originalURL), zap.Int("salt", salt))
+		}
+	}
+	return "", ErrNoFreeCode
+}
+
+// HashCode возвращает код заданной длины из SHA-256 исходного URL и соли.
+// Нулевая соль дает тот же результат, что и хеш без соли.
+func HashCode(originalURL string, salt int, length int) string {
+	value := originalURL
+	if salt != 0 {
+		value = originalURL + "#" + strconv.Itoa(salt)
+	}
+	hash := sha256.Sum256([]byte(value))
+	encoded := base64.RawURLEncoding.EncodeToString(hash[:])
+	return encoded[:length]
+}
+
+// CounterGenerator генерирует коды из возрастающего счетчика в base62.
+// Каждый вызов выдает новый код, даже для уже сокращенного URL.
+// Значения счетчика резервируются в хранилище блоками по counterBlockSize, поэтому счет продолжается
+// после перезапуска, а несколько экземпляров сервера с общим хранилищем не выдают одинаковые коды.
+type CounterGenerator struct {
+	storager storage.Storage
+	mu       sync.Mutex
+	// next следующее значение зарезервированного блока, limit первое значение за его концом
+	next  uint64
+	limit uint64
+}
+
+// NewCounterGenerator создает новый экземпляр CounterGenerator.
+// Занятые коды (например, выданные другой стратегией) пропускаются.
+func NewCounterGenerator(storager storage.Storage) *CounterGenerator {
+	return &CounterGenerator{
+		storager: storager,
+	}
+}
+
+// Generate возвращает следующий свободный код счетчика, пропуская зарезервированные слова.
+func (generator *CounterGenerator) Generate(ctx context.Context, originalURL string) (string, error) {
+	for attempt := 0; attempt < maxCounterAttempts; attempt++ {
+		value, err := generator.nextValue(ctx)
+		if err != nil {
+			return "", err
+		}
+		code := EncodeBase62(value)
+		if IsReserved(code) {
+			continue
+		}
+		free, err := isFreeFor(ctx, generator.storager, code, originalURL)
+		if err != nil {
+			return "", err
+		}
+		if free {
+			return code, nil
+		}
+	}
+	return "", ErrNoFreeCode
+}
+
+// nextValue возвращает следующее значение счетчика, резервируя новый блок, когда текущий закончился.
+func (generator *CounterGenerator) nextValue(ctx context.Context) (uint64, error) {
+	generator.mu.Lock()
+	defer generator.mu.Unlock()
+
+	if generator.next == generator.limit {
+		first, err := generator.storager.ReserveCodeCounter(ctx, counterBlockSize)
+		if err != nil {
+			logger.Log.Error("cannot reserve short code counter", zap.Error(err))
+			return 0, err
+		}
+		generator.next, generator.limit = first, first+counterBlockSize
+	}
+	value := generator.next
+	generator.next++
+	return value, nil
+}
+
+// EncodeBase62 кодирует число в строку base62.
+func EncodeBase62(value uint64) string {
+	if value == 0 {
+		return base62Alphabet[:1]
+	}
+	var buf [11]byte
+	i := len(buf)
+	for value > 0 {
+		i--
+		buf[i] = base62Alphabet[value%62]
+		value /= 62
+	}
+	return string(buf[i:])
+}
+
+// isFreeFor проверяет, что код не занят или занят тем же исходным URL.
+func isFreeFor(ctx context.Context, storager storage.Storage, code string, originalURL string) (bool, error) {
+	savedURL, ok, err := storager.GetURLForAnyUserID(ctx, code)
+	if err != nil {
+		logger.Log.Error("cannot check short code", zap.String("code", code), zap.Error(err))
+		return false, err
+	}
+	return !ok || savedURL.OriginalURL == originalURL, nil
+}
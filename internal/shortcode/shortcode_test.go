@@ -0,0 +1,104 @@
+package shortcode
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"github.com/theheadmen/urlShort/internal/storage/file"
+)
+
+func newTestStorage() *file.FileStorage {
+	return file.NewFileStoragerWithoutReadingData("", false /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+}
+
+func TestHashGeneratorCollision(t *testing.T) {
+	ctx := context.Background()
+	storager := newTestStorage()
+	generator := NewHashGenerator(storager)
+
+	code, err := generator.Generate(ctx, "google.com")
+	require.NoError(t, err)
+	assert.Equal(t, "1MnZAnMm", code, "Без коллизии код должен совпадать с обычным хешем")
+
+	// занимаем код google.com другим URL
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: code, OriginalURL: "other.com", UserID: 1})
+	require.NoError(t, err)
+
+	collided, err := generator.Generate(ctx, "google.com")
+	require.NoError(t, err)
+	assert.NotEqual(t, code, collided, "При коллизии код должен отличаться")
+	assert.Equal(t, HashCode("google.com", 1, DefaultLength), collided, "При коллизии должна добавляться соль")
+
+	// тот же URL, что уже лежит в хранилище, не считается коллизией
+	same, err := generator.Generate(ctx, "other.com")
+	require.NoError(t, err)
+	assert.Equal(t, HashCode("other.com", 0, DefaultLength), same)
+}
+
+func TestCounterGenerator(t *testing.T) {
+	ctx := context.Background()
+	storager := newTestStorage()
+	generator := NewCounterGenerator(storager)
+
+	_, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: "2", OriginalURL: "taken.com", UserID: 1})
+	require.NoError(t, err)
+
+	first, err := generator.Generate(ctx, "a.com")
+	require.NoError(t, err)
+	second, err := generator.Generate(ctx, "b.com")
+	require.NoError(t, err)
+
+	assert.Equal(t, "1", first)
+	assert.Equal(t, "3", second, "Занятый код должен пропускаться")
+
+	// генератор после перезапуска или на другом экземпляре продолжает счет после уже зарезервированных значений
+	restarted, err := NewCounterGenerator(storager).Generate(ctx, "c.com")
+	require.NoError(t, err)
+	assert.Equal(t, EncodeBase62(counterBlockSize+1), restarted)
+}
+
+func TestCounterGeneratorSkipsReserved(t *testing.T) {
+	ctx := context.Background()
+	generator := NewCounterGenerator(newTestStorage())
+
+	// значение счетчика, которое кодируется в "api": a = 10, p = 25, i = 18
+	api := uint64(10*62*62 + 25*62 + 18)
+	require.Equal(t, "api", EncodeBase62(api))
+	generator.next, generator.limit = api, api+2
+
+	code, err := generator.Generate(ctx, "a.com")
+	require.NoError(t, err)
+	assert.Equal(t, "apj", code, "Зарезервированное слово не должно выдаваться, иначе маршрут перекроет редирект")
+	assert.True(t, IsReserved("Metrics"))
+	assert.False(t, IsReserved("apj"))
+}
+
+func TestEncodeBase62(t *testing.T) {
+	tests := []struct {
+		value uint64
+		want  string
+	}{
+		{value: 0, want: "0"},
+		{value: 61, want: "Z"},
+		{value: 62, want: "10"},
+		{value: 3843, want: "ZZ"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, EncodeBase62(test.value))
+	}
+}
+
+func TestNewGenerator(t *testing.T) {
+	storager := newTestStorage()
+
+	_, err := NewGenerator("", storager)
+	assert.NoError(t, err)
+	_, err = NewGenerator(StrategyCounter, storager)
+	assert.NoError(t, err)
+	_, err = NewGenerator("unknown", storager)
+	assert.Error(t, err)
+}
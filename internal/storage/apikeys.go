@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/theheadmen/urlShort/internal/models"
+)
+
+// APIKeyIndex хранит ключи API в памяти с поиском по идентификатору, хешу и пользователю.
+// Не потокобезопасен, синхронизация остается на стороне хранилища.
+type APIKeyIndex struct {
+	byID     map[string]models.APIKey
+	byHash   map[string]string
+	byUserID map[int]map[string]struct{}
+}
+
+// NewAPIKeyIndex создает пустой APIKeyIndex.
+func NewAPIKeyIndex() *APIKeyIndex {
+	return &APIKeyIndex{
+		byID:     make(map[string]models.APIKey),
+		byHash:   make(map[string]string),
+		byUserID: make(map[int]map[string]struct{}),
+	}
+}
+
+// Put добавляет ключ или заменяет ключ с тем же идентификатором.
+func (index *APIKeyIndex) Put(apiKey models.APIKey) {
+	index.byID[apiKey.ID] = apiKey
+	index.byHash[apiKey.Hash] = apiKey.ID
+	ids, ok := index.byUserID[apiKey.UserID]
+	if !ok {
+		ids = make(map[string]struct{})
+		index.byUserID[apiKey.UserID] = ids
+	}
+	ids[apiKey.ID] = struct{}{}
+}
+
+// Get возвращает ключ по идентификатору.
+func (index *APIKeyIndex) Get(id string) (models.APIKey, bool) {
+	apiKey, ok := index.byID[id]
+	return apiKey, ok
+}
+
+// GetByHash возвращает ключ по хешу.
+func (index *APIKeyIndex) GetByHash(hash string) (models.APIKey, bool) {
+	id, ok := index.byHash[hash]
+	if !ok {
+		return models.APIKey{}, false
+	}
+	return index.byID[id], true
+}
+
+// List возвращает ключи пользователя в порядке создания.
+func (index *APIKeyIndex) List(userID int) []models.APIKey {
+	ids := index.byUserID[userID]
+	result := make([]models.APIKey, 0, len(ids))
+	for id := range ids {
+		result = append(result, index.byID[id])
+	}
+	sortAPIKeys(result)
+	return result
+}
+
+// All возвращает все ключи в порядке создания.
+func (index *APIKeyIndex) All() []models.APIKey {
+	result := make([]models.APIKey, 0, len(index.byID))
+	for _, apiKey := range index.byID {
+		result = append(result, apiKey)
+	}
+	sortAPIKeys(result)
+	return result
+}
+
+// sortAPIKeys упорядочивает ключи по времени создания, ключи, созданные одновременно, по идентификатору.
+func sortAPIKeys(apiKeys []models.APIKey) {
+	sort.Slice(apiKeys, func(i, j int) bool {
+		if apiKeys[i].CreatedAt.Equal(apiKeys[j].CreatedAt) {
+			return apiKeys[i].ID < apiKeys[j].ID
+		}
+		return apiKeys[i].CreatedAt.Before(apiKeys[j].CreatedAt)
+	})
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/theheadmen/urlShort/internal/models"
+)
+
+// clickDateLayout формат даты для дневной гистограммы переходов.
+const clickDateLayout = "2006-01-02"
+
+// ClickCounter агрегирует переходы по одному короткому URL в памяти.
+// Не потокобезопасен, синхронизация остается на стороне хранилища.
+type ClickCounter struct {
+	total    int
+	visitors map[string]struct{}
+	daily    map[string]int
+}
+
+// NewClickCounter создает пустой ClickCounter.
+func NewClickCounter() *ClickCounter {
+	return &ClickCounter{
+		visitors: make(map[string]struct{}),
+		daily:    make(map[string]int),
+	}
+}
+
+// Add учитывает один переход.
+func (counter *ClickCounter) Add(click models.Click) {
+	counter.total++
+	counter.visitors[click.IPHash] = struct{}{}
+	counter.daily[click.Timestamp.UTC().Format(clickDateLayout)]++
+}
+
+// Stats возвращает статистику переходов, дни отсортированы по возрастанию.
+func (counter *ClickCounter) Stats(shortURL string) models.ClickStats {
+	stats := models.ClickStats{
+		ShortURL:       shortURL,
+		TotalClicks:    counter.total,
+		UniqueVisitors: len(counter.visitors),
+		Daily:          make([]models.DailyClicks, 0, len(counter.daily)),
+	}
+	for date, clicks := range counter.daily {
+		stats.Daily = append(stats.Daily, models.DailyClicks{Date: date, Clicks: clicks})
+	}
+	sort.Slice(stats.Daily, func(i, j int) bool {
+		return stats.Daily[i].Date < stats.Daily[j].Date
+	})
+	return stats
+}
@@ -0,0 +1,37 @@
+//go:build postgres
+
+package database
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/theheadmen/urlShort/internal/dbconnector"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"github.com/theheadmen/urlShort/internal/storage/storagetest"
+)
+
+// localDSN строка подключения к локальной базе, можно переопределить через TEST_DATABASE_DSN.
+// Запуск: go test -tags postgres ./internal/storage/database/
+const localDSN = "host=localhost port=5432 user=postgres password=example dbname=godb sslmode=disable"
+
+func TestConformance(t *testing.T) {
+	dsn := localDSN
+	if envDSN := os.Getenv("TEST_DATABASE_DSN"); envDSN != "" {
+		dsn = envDSN
+	}
+
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		ctx := context.Background()
+		dbConnector, err := dbconnector.NewDBConnector(ctx, dsn)
+		require.NoError(t, err)
+		t.Cleanup(func() { dbConnector.DB.Close() })
+
+		_, err = dbConnector.DB.ExecContext(ctx, `TRUNCATE urls, clicks; UPDATE last_user_id SET id = 1;`)
+		require.NoError(t, err)
+
+		return NewDatabaseStorage(dbConnector)
+	})
+}
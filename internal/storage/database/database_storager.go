@@ -3,7 +3,8 @@ package database
 
 import (
 	"context"
-	"sync"
+	"errors"
+	"time"
 
 	"github.com/theheadmen/urlShort/internal/dbconnector"
 	"github.com/theheadmen/urlShort/internal/logger"
@@ -13,51 +14,29 @@ import (
 )
 
 // DatabaseStorage реализует интерфейс Storage для хранения данных в базе данных.
+// Пользователи кэшируются в памяти: проверка куки выполняется на каждый запрос,
+// а пользователь, однажды найденный в базе, из нее не удаляется.
 type DatabaseStorage struct {
-	URLMap      map[storage.URLMapKey]models.SavedURL
-	mu          sync.RWMutex
-	DB          *dbconnector.DBConnector
-	lastUserID  int
-	usedUserIDs []int
+	DB    *dbconnector.DBConnector
+	users *storage.UserRegistry
 }
 
-// NewDatabaseStorage создает новый экземпляр DatabaseStorage и читает данные из базы данных.
-func NewDatabaseStorage(URLMap map[storage.URLMapKey]models.SavedURL, dbConnector *dbconnector.DBConnector, ctx context.Context) *DatabaseStorage {
-	var empty []int
-
-	storager := &DatabaseStorage{
-		URLMap:      URLMap,
-		mu:          sync.RWMutex{},
-		DB:          dbConnector,
-		lastUserID:  0,
-		usedUserIDs: empty,
-	}
-	err := storager.ReadAllData(ctx)
-	if err != nil {
-		logger.Log.Error("Failed to read data", zap.Error(err))
+// NewDatabaseStorage создает новый экземпляр DatabaseStorage.
+func NewDatabaseStorage(dbConnector *dbconnector.DBConnector) *DatabaseStorage {
+	return &DatabaseStorage{
+		DB:    dbConnector,
+		users: storage.NewUserRegistry(),
 	}
-	return storager
 }
 
-// ReadAllData читает все данные из базы данных и заполняет их в DatabaseStorage.
+// ReadAllData ничего не делает, так как все чтения идут напрямую в базу данных.
 func (storager *DatabaseStorage) ReadAllData(ctx context.Context) error {
-	urls, err := storager.DB.SelectAllSavedURLs(ctx)
-	if err != nil {
-		logger.Log.Error("Failed to read from database", zap.Error(err))
-		return err
-	}
-
-	for _, url := range urls {
-		storager.usedUserIDs = append(storager.usedUserIDs, url.UserID)
-		logger.Log.Info("Read new data from database", zap.Int("UUID", url.UUID), zap.String("OriginalURL", url.OriginalURL), zap.String("ShortURL", url.ShortURL), zap.Int("UserID", url.UserID), zap.Bool("Deleted", url.Deleted))
-	}
-
-	return err
+	return nil
 }
 
-// ReadAllDataForUserID читает все данные для определенного пользователя из базы данных.
-func (storager *DatabaseStorage) ReadAllDataForUserID(ctx context.Context, userID int) ([]models.SavedURL, error) {
-	urls, err := storager.DB.SelectSavedURLsForUserID(ctx, userID)
+// ReadAllDataForUserID читает страницу URL определенного пользователя из базы данных.
+func (storager *DatabaseStorage) ReadAllDataForUserID(ctx context.Context, userID int, query models.URLQuery) ([]models.SavedURL, error) {
+	urls, err := storager.DB.SelectSavedURLsForUserID(ctx, userID, query)
 	if err != nil {
 		logger.Log.Error("Failed to read from database", zap.Error(err))
 		return []models.SavedURL{}, err
@@ -67,52 +46,47 @@ func (storager *DatabaseStorage) ReadAllDataForUserID(ctx context.Context, userI
 }
 
 // StoreURL сохраняет URL в DatabaseStorage и базу данных.
-func (storager *DatabaseStorage) StoreURL(ctx context.Context, shortURL string, originalURL string, userID int) (bool, error) {
-	_, ok, err := storager.GetURL(ctx, shortURL, userID)
+func (storager *DatabaseStorage) StoreURL(ctx context.Context, savedURL models.SavedURL) (bool, error) {
+	originalURL, ok, err := storager.GetURL(ctx, savedURL.ShortURL, savedURL.UserID)
 	if err != nil {
 		return false, err
 	}
 
+	// занятость кода другими пользователями проверяет сама вставка, см. DBConnector.InsertSavedURLBatch
+	if ok && savedURL.Alias && originalURL != savedURL.OriginalURL {
+		return false, storage.ErrAliasTaken
+	}
 	if ok {
-		logger.Log.Info("We already have data for this url", zap.String("OriginalURL", originalURL), zap.String("ShortURL", shortURL), zap.Bool("Deleted", false))
+		logger.Log.Info("We already have data for this url", zap.String("OriginalURL", savedURL.OriginalURL), zap.String("ShortURL", savedURL.ShortURL), zap.Bool("Deleted", false))
 		return true, nil
 	}
 
-	savedURL := models.SavedURL{
-		UUID:        0,
-		ShortURL:    shortURL,
-		OriginalURL: originalURL,
-		UserID:      userID,
-		Deleted:     false,
-	}
+	savedURL.UUID = 0
+	savedURL.Deleted = false
 
-	err = storager.DB.InsertSavedURLBatch(ctx, []models.SavedURL{savedURL}, userID)
+	err = storager.DB.InsertSavedURLBatch(ctx, []models.SavedURL{savedURL}, savedURL.UserID)
+	if !errors.Is(err, storage.ErrDuplicateURL) {
+		return false, err
+	}
 
-	return false, err
+	savedURLs, err := storager.DB.SelectSavedURLsForOriginalURLAndUserID(ctx, savedURL.OriginalURL, savedURL.UserID)
+	if err != nil {
+		return false, err
+	}
+	if len(savedURLs) == 0 {
+		// занявший полный URL короткий URL успели окончательно удалить
+		return false, storage.ErrDuplicateURL
+	}
+	return false, &storage.DuplicateURLError{ShortURL: savedURLs[0].ShortURL}
 }
 
 // StoreURLBatch сохраняет несколько URL в DatabaseStorage и базу данных.
-func (storager *DatabaseStorage) StoreURLBatch(ctx context.Context, forStore []models.SavedURL, userID int) error {
-	var filteredStore []models.SavedURL
-	for _, savedURL := range forStore {
-		_, ok, err := storager.GetURL(ctx, savedURL.ShortURL, userID)
-		if err != nil {
-			return err
-		}
-
-		if ok {
-			logger.Log.Info("We already have data for this url", zap.String("OriginalURL", savedURL.OriginalURL), zap.String("ShortURL", savedURL.ShortURL), zap.Int("UserID", userID), zap.Bool("Deleted", savedURL.Deleted))
-		} else {
-			filteredStore = append(filteredStore, savedURL)
-		}
-	}
-	// если у нас уже все и так было вставлено, нам не нужно ничего сохранять
-	if len(filteredStore) != 0 {
-		err := storager.DB.InsertSavedURLBatch(ctx, filteredStore, userID)
-		return err
+// Уже сохраненные URL отсеивает сама база, см. DBConnector.CopySavedURLBatch.
+func (storager *DatabaseStorage) StoreURLBatch(ctx context.Context, forStore []models.SavedURL, userID int) ([]storage.BatchResult, error) {
+	if len(forStore) == 0 {
+		return []storage.BatchResult{}, nil
 	}
-
-	return nil
+	return storager.DB.CopySavedURLBatch(ctx, forStore, userID)
 }
 
 // GetURL возвращает URL из DatabaseStorage.
@@ -131,64 +105,169 @@ func (storager *DatabaseStorage) GetURL(ctx context.Context, shortURL string, us
 }
 
 // GetURLForAnyUserID возвращает URL, независимо от пользователя.
+// Если у короткого URL несколько владельцев, возвращает URL первого из них.
 func (storager *DatabaseStorage) GetURLForAnyUserID(ctx context.Context, shortURL string) (models.SavedURL, bool, error) {
-	savedURLs, err := storager.DB.SelectSavedURLsForShortURL(ctx, shortURL)
+	savedURLs, err := storager.DB.SelectFirstSavedURLForShortURL(ctx, shortURL)
 	if err != nil {
 		return models.SavedURL{}, false, err
 	}
 
 	if len(savedURLs) == 0 {
 		return models.SavedURL{}, false, nil
-	} else {
-		// в теории и должно быть максимум одно значение, но для простоты используем массив
-		return savedURLs[0], true, nil
 	}
+	return savedURLs[0], true, nil
 }
 
-// IsItCorrectUserID проверяет, является ли идентификатор пользователя корректным.
-func (storager *DatabaseStorage) IsItCorrectUserID(userID int) bool {
-	storager.mu.RLock()
-	ok := storager.findUserID(userID)
-	storager.mu.RUnlock()
+// GetURLForUserID возвращает URL, принадлежащий определенному пользователю.
+func (storager *DatabaseStorage) GetURLForUserID(ctx context.Context, shortURL string, userID int) (models.SavedURL, bool, error) {
+	savedURLs, err := storager.DB.SelectSavedURLsForShortURLAndUserID(ctx, shortURL, userID)
+	if err != nil {
+		return models.SavedURL{}, false, err
+	}
 
-	return ok
+	if len(savedURLs) == 0 {
+		return models.SavedURL{}, false, nil
+	}
+	return savedURLs[0], true, nil
 }
 
-// findUserID ищет пользователя по заданному ID
-func (storager *DatabaseStorage) findUserID(userID int) bool {
-	for _, usedUserID := range storager.usedUserIDs {
-		if usedUserID == userID {
-			return true
-		}
+// StoreClicks сохраняет пачку переходов в базу данных.
+func (storager *DatabaseStorage) StoreClicks(ctx context.Context, clicks []models.Click) error {
+	if len(clicks) == 0 {
+		return nil
 	}
-	return false
+	return storager.DB.InsertClickBatch(ctx, clicks)
 }
 
-// GetLastUserID возвращает последний использованный идентификатор пользователя.
-func (storager *DatabaseStorage) GetLastUserID(ctx context.Context) (int, error) {
-	lastUserID, err := storager.DB.IncrementID(ctx)
+// GetClickStats возвращает статистику переходов по короткому URL пользователя.
+func (storager *DatabaseStorage) GetClickStats(ctx context.Context, shortURL string, userID int) (models.ClickStats, error) {
+	return storager.DB.SelectClickStats(ctx, shortURL, userID)
+}
+
+// StoreAPIKey сохраняет ключ API в базу данных.
+func (storager *DatabaseStorage) StoreAPIKey(ctx context.Context, apiKey models.APIKey) error {
+	return storager.DB.InsertAPIKey(ctx, apiKey)
+}
+
+// GetAPIKeyByHash возвращает ключ API по хешу.
+func (storager *DatabaseStorage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	return storager.DB.SelectAPIKeyByHash(ctx, hash)
+}
+
+// ListAPIKeys возвращает ключи API пользователя в порядке создания.
+func (storager *DatabaseStorage) ListAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	return storager.DB.SelectAPIKeysForUserID(ctx, userID)
+}
+
+// RevokeAPIKey отзывает действующий ключ API пользователя.
+func (storager *DatabaseStorage) RevokeAPIKey(ctx context.Context, id string, userID int, now time.Time) (bool, error) {
+	return storager.DB.UpdateRevokedAPIKey(ctx, id, userID, now)
+}
+
+// TouchAPIKey запоминает время последнего использования ключа API.
+func (storager *DatabaseStorage) TouchAPIKey(ctx context.Context, id string, now time.Time) error {
+	return storager.DB.UpdateAPIKeyLastUsed(ctx, id, now)
+}
+
+// CreateUser регистрирует пользователя со следующим свободным идентификатором.
+func (storager *DatabaseStorage) CreateUser(ctx context.Context, now time.Time) (models.User, error) {
+	user, err := storager.DB.InsertUser(ctx, now)
 	if err != nil {
-		logger.Log.Error("Failed to read last user id from database", zap.Error(err))
-		return lastUserID, err
+		return models.User{}, err
+	}
+	storager.users.Put(user)
+	return user, nil
+}
+
+// GetUser возвращает зарегистрированного пользователя из кэша, а при промахе из базы данных.
+// Время последней активности в кэше может отставать от базы, если пользователь ходит и через другие реплики.
+func (storager *DatabaseStorage) GetUser(ctx context.Context, userID int) (models.User, bool, error) {
+	if user, ok := storager.users.Get(userID); ok {
+		return user, true, nil
 	}
 
-	storager.lastUserID = lastUserID
-	return lastUserID, nil
+	user, ok, err := storager.DB.SelectUser(ctx, userID)
+	if err != nil || !ok {
+		return models.User{}, false, err
+	}
+	storager.users.Put(user)
+	return user, true, nil
 }
 
-// SaveUserID сохраняет идентификатор пользователя.
-func (storager *DatabaseStorage) SaveUserID(userID int) {
-	storager.mu.Lock()
-	storager.usedUserIDs = append(storager.usedUserIDs, userID)
-	storager.mu.Unlock()
+// TouchUser запоминает время последней активности пользователя.
+func (storager *DatabaseStorage) TouchUser(ctx context.Context, userID int, now time.Time) error {
+	if err := storager.DB.UpdateUserLastSeen(ctx, userID, now); err != nil {
+		return err
+	}
+	storager.users.Touch(userID, now)
+	return nil
 }
 
 // DeleteByUserID удаляет URL, принадлежащие определенному пользователю.
 func (storager *DatabaseStorage) DeleteByUserID(ctx context.Context, shortURLs []string, userID int) error {
-	err := storager.DB.UpdateDeletedSavedURLBatch(ctx, shortURLs, userID)
+	_, err := storager.DB.UpdateDeletedSavedURLBatch(ctx, storage.KeysForUser(shortURLs, userID))
 	return err
 }
 
+// DeleteURLs помечает удаленными URL из keys одним запросом к базе данных.
+func (storager *DatabaseStorage) DeleteURLs(ctx context.Context, keys []storage.URLMapKey) ([]storage.URLMapKey, error) {
+	if len(keys) == 0 {
+		return []storage.URLMapKey{}, nil
+	}
+	return storager.DB.UpdateDeletedSavedURLBatch(ctx, keys)
+}
+
+// RestoreURLs снимает пометку удаления с URL из keys одним запросом к базе данных.
+func (storager *DatabaseStorage) RestoreURLs(ctx context.Context, keys []storage.URLMapKey, now time.Time) ([]storage.URLMapKey, error) {
+	if len(keys) == 0 {
+		return []storage.URLMapKey{}, nil
+	}
+	return storager.DB.RestoreSavedURLBatch(ctx, keys, now)
+}
+
+// PurgeURLs безвозвратно удаляет URL из keys вместе с историей изменений и переходами одним запросом к базе данных.
+func (storager *DatabaseStorage) PurgeURLs(ctx context.Context, keys []storage.URLMapKey) ([]storage.URLMapKey, error) {
+	if len(keys) == 0 {
+		return []storage.URLMapKey{}, nil
+	}
+	return storager.DB.DeleteSavedURLBatch(ctx, keys)
+}
+
+// PurgeDeleted безвозвратно удаляет URL, помеченные удаленными не позже before, вместе с историей изменений и переходами.
+func (storager *DatabaseStorage) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	return storager.DB.DeleteDeletedSavedURLs(ctx, before)
+}
+
+// UpdateURL меняет полный URL короткой ссылки пользователя и записывает изменение в историю.
+func (storager *DatabaseStorage) UpdateURL(ctx context.Context, edit models.URLEdit, version int) (models.SavedURL, error) {
+	return storager.DB.UpdateSavedURL(ctx, edit, version)
+}
+
+// ListURLEdits возвращает историю изменений короткого URL пользователя.
+func (storager *DatabaseStorage) ListURLEdits(ctx context.Context, shortURL string, userID int) ([]models.URLEdit, error) {
+	return storager.DB.SelectURLEdits(ctx, shortURL, userID)
+}
+
+// DeleteExpired помечает удаленными все URL, срок действия которых истек к моменту now.
+func (storager *DatabaseStorage) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	return storager.DB.UpdateDeletedExpiredSavedURLs(ctx, now)
+}
+
+// ReserveCodeCounter резервирует n следующих значений счетчика коротких кодов и возвращает первое из них.
+func (storager *DatabaseStorage) ReserveCodeCounter(ctx context.Context, n int) (uint64, error) {
+	return storager.DB.ReserveCodeCounter(ctx, n)
+}
+
+// CountURLs возвращает количество неудаленных коротких URL.
+func (storager *DatabaseStorage) CountURLs(ctx context.Context) (int, error) {
+	return storager.DB.CountSavedURLs(ctx)
+}
+
+// CountUsers возвращает количество зарегистрированных пользователей.
+func (storager *DatabaseStorage) CountUsers(ctx context.Context) (int, error) {
+	return storager.DB.CountUsers(ctx)
+}
+
 // PingContext проверяет соединение с хранилищем.
 func (storager *DatabaseStorage) PingContext(ctx context.Context) error {
 	err := storager.DB.DB.PingContext(ctx)
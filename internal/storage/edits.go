@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"github.com/theheadmen/urlShort/internal/models"
+)
+
+// EditLog хранит историю изменений полных URL в памяти.
+// Не потокобезопасен, синхронизация остается на стороне хранилища.
+type EditLog struct {
+	edits map[URLMapKey][]models.URLEdit
+}
+
+// NewEditLog создает пустой EditLog.
+func NewEditLog() *EditLog {
+	return &EditLog{edits: make(map[URLMapKey][]models.URLEdit)}
+}
+
+// Add добавляет изменение в историю. Записи с той же или большей версией заменяются:
+// они остаются от изменений, которые не дошли до самого URL.
+func (editLog *EditLog) Add(edit models.URLEdit) {
+	key := URLMapKey{ShortURL: edit.ShortURL, UserID: edit.UserID}
+	edits := editLog.edits[key]
+	for len(edits) > 0 && edits[len(edits)-1].Version >= edit.Version {
+		edits = edits[:len(edits)-1]
+	}
+	editLog.edits[key] = append(edits, edit)
+}
+
+// List возвращает историю изменений URL с версией не больше version.
+func (editLog *EditLog) List(key URLMapKey, version int) []models.URLEdit {
+	result := []models.URLEdit{}
+	for _, edit := range editLog.edits[key] {
+		if edit.Version <= version {
+			result = append(result, edit)
+		}
+	}
+	return result
+}
+
+// Remove забывает историю изменений URL.
+func (editLog *EditLog) Remove(key URLMapKey) {
+	delete(editLog.edits, key)
+}
+
+// All возвращает всю историю изменений без фильтрации по версиям.
+func (editLog *EditLog) All() []models.URLEdit {
+	var result []models.URLEdit
+	for _, edits := range editLog.edits {
+		result = append(result, edits...)
+	}
+	return result
+}
+
+// PrepareEdit проверяет, можно ли применить edit к текущему состоянию URL current, и возвращает
+// новое состояние URL и заполненную запись истории. Если полный URL не меняется, возвращает false.
+// Проверки, что у пользователя нет другого короткого URL для того же полного URL и что короткий URL
+// не принадлежит другим пользователям, выполняет хранилище.
+func PrepareEdit(current models.SavedURL, found bool, edit models.URLEdit, version int) (models.SavedURL, models.URLEdit, bool, error) {
+	if !found || current.Deleted {
+		return models.SavedURL{}, models.URLEdit{}, false, ErrURLNotFound
+	}
+	if version != AnyVersion && version != current.Version {
+		return models.SavedURL{}, models.URLEdit{}, false, ErrVersionMismatch
+	}
+	if current.OriginalURL == edit.OriginalURL {
+		return current, models.URLEdit{}, false, nil
+	}
+
+	edit.PreviousURL = current.OriginalURL
+	edit.Version = current.Version + 1
+	current.OriginalURL = edit.OriginalURL
+	current.Version = edit.Version
+	return current, edit, true, nil
+}
@@ -0,0 +1,161 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"go.uber.org/zap"
+)
+
+// apiKeysFileSuffix суффикс файла с журналом ключей API рядом с основным файлом.
+// Каждое изменение ключа дописывается полной записью, при чтении действует последняя запись с тем же id.
+// Время последнего использования в журнал не дописывается, журнал переписывается целиком при компактификации и в Close.
+const apiKeysFileSuffix = ".apikeys"
+
+// StoreAPIKey дописывает ключ API в журнал ключей и сохраняет его в памяти.
+func (storager *FileStorage) StoreAPIKey(ctx context.Context, apiKey models.APIKey) error {
+	storager.apiKeysMu.Lock()
+	defer storager.apiKeysMu.Unlock()
+
+	return storager.putAPIKey(apiKey)
+}
+
+// GetAPIKeyByHash возвращает ключ API по хешу.
+func (storager *FileStorage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	storager.apiKeysMu.Lock()
+	defer storager.apiKeysMu.Unlock()
+
+	apiKey, ok := storager.apiKeys.GetByHash(hash)
+	return apiKey, ok, nil
+}
+
+// ListAPIKeys возвращает ключи API пользователя в порядке создания.
+func (storager *FileStorage) ListAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	storager.apiKeysMu.Lock()
+	defer storager.apiKeysMu.Unlock()
+
+	return storager.apiKeys.List(userID), nil
+}
+
+// RevokeAPIKey отзывает действующий ключ API пользователя.
+func (storager *FileStorage) RevokeAPIKey(ctx context.Context, id string, userID int, now time.Time) (bool, error) {
+	storager.apiKeysMu.Lock()
+	defer storager.apiKeysMu.Unlock()
+
+	apiKey, ok := storager.apiKeys.Get(id)
+	if !ok || apiKey.UserID != userID || apiKey.IsRevoked() {
+		return false, nil
+	}
+	apiKey.RevokedAt = &now
+	if err := storager.putAPIKey(apiKey); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// TouchAPIKey запоминает время последнего использования ключа API в памяти.
+// На диск оно попадает при следующей компактификации или в Close, поэтому после падения может откатиться назад.
+func (storager *FileStorage) TouchAPIKey(ctx context.Context, id string, now time.Time) error {
+	storager.apiKeysMu.Lock()
+	defer storager.apiKeysMu.Unlock()
+
+	apiKey, ok := storager.apiKeys.Get(id)
+	if !ok {
+		return nil
+	}
+	apiKey.LastUsedAt = &now
+	storager.apiKeys.Put(apiKey)
+	storager.apiKeysDirty = true
+	return nil
+}
+
+// putAPIKey сначала дописывает ключ в журнал, затем обновляет память. Вызывается под apiKeysMu.
+func (storager *FileStorage) putAPIKey(apiKey models.APIKey) error {
+	if storager.isWithFile {
+		if err := storager.appendJournal(apiKeysFileSuffix, apiKey); err != nil {
+			logger.Log.Error("Failed to write api keys file", zap.Error(err))
+			return err
+		}
+	}
+	storager.apiKeys.Put(apiKey)
+	return nil
+}
+
+// compactAPIKeys переписывает журнал ключей API по памяти, если с прошлой перезаписи менялось время их использования.
+// apiKeysMu держится до замены файла, чтобы изменение ключа не попало в уже замененный журнал.
+func (storager *FileStorage) compactAPIKeys() error {
+	if !storager.isWithFile {
+		return nil
+	}
+
+	storager.apiKeysMu.Lock()
+	defer storager.apiKeysMu.Unlock()
+
+	if !storager.apiKeysDirty {
+		return nil
+	}
+	apiKeys := storager.apiKeys.All()
+	records := make([]interface{}, 0, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		records = append(records, apiKey)
+	}
+	if err := storager.rewriteJournal(apiKeysFileSuffix, records); err != nil {
+		logger.Log.Error("Failed to compact api keys file", zap.Error(err))
+		return err
+	}
+	storager.apiKeysDirty = false
+	return nil
+}
+
+// appendJournal дописывает запись в журнал с суффиксом suffix и сбрасывает ее на диск, если политика fsync это требует.
+func (storager *FileStorage) appendJournal(suffix string, record interface{}) error {
+	data, err := storager.json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(storager.filePath+suffix, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	storager.fileMu.Lock()
+	syncPolicy := storager.syncPolicy
+	storager.fileMu.Unlock()
+	if syncPolicy == SyncNever {
+		return nil
+	}
+	return file.Sync()
+}
+
+// readAllAPIKeys восстанавливает ключи API из журнала ключей.
+func (storager *FileStorage) readAllAPIKeys() error {
+	file, err := os.Open(storager.filePath + apiKeysFileSuffix)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	storager.apiKeysMu.Lock()
+	defer storager.apiKeysMu.Unlock()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var apiKey models.APIKey
+		if err := storager.json.Unmarshal(scanner.Bytes(), &apiKey); err != nil {
+			logger.Log.Error("Failed unmarshal api key", zap.Error(err))
+			continue
+		}
+		storager.apiKeys.Put(apiKey)
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,77 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+)
+
+func TestAPIKeysSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), "storage.json")
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	storager := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	apiKey := models.APIKey{ID: "key1", UserID: 7, Name: "backend", Hash: strings.Repeat("a", 64), Scopes: []string{"write"}, CreatedAt: created}
+	require.NoError(t, storager.StoreAPIKey(ctx, apiKey))
+	require.NoError(t, storager.TouchAPIKey(ctx, "key1", created.Add(time.Hour)))
+	ok, err := storager.RevokeAPIKey(ctx, "key1", 7, created.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, storager.Close())
+
+	// Close переписывает журнал, поэтому время использования сохраняется вместе с отзывом
+	reopened := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	defer reopened.Close()
+	restored, ok, err := reopened.GetAPIKeyByHash(ctx, apiKey.Hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, 7, restored.UserID)
+	assert.Equal(t, []string{"write"}, restored.Scopes)
+	require.NotNil(t, restored.LastUsedAt)
+	assert.True(t, created.Add(time.Hour).Equal(*restored.LastUsedAt))
+	assert.True(t, restored.IsRevoked())
+
+	apiKeys, err := reopened.ListAPIKeys(ctx, 7)
+	require.NoError(t, err)
+	assert.Len(t, apiKeys, 1)
+}
+
+func TestTouchAPIKeyDoesNotGrowJournal(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), "storage.json")
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	storager := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	apiKey := models.APIKey{ID: "key1", UserID: 7, Name: "backend", Hash: strings.Repeat("a", 64), Scopes: []string{"write"}, CreatedAt: created}
+	require.NoError(t, storager.StoreAPIKey(ctx, apiKey))
+	for i := 1; i <= 100; i++ {
+		require.NoError(t, storager.TouchAPIKey(ctx, "key1", created.Add(time.Duration(i)*time.Minute)))
+	}
+	data, err := os.ReadFile(fname + apiKeysFileSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"), "Время использования не должно дописываться в журнал")
+
+	// компактификация переписывает журнал с последним временем использования
+	require.NoError(t, storager.Compact(ctx))
+	reopened := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	loaded, ok, err := reopened.GetAPIKeyByHash(ctx, apiKey.Hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NotNil(t, loaded.LastUsedAt)
+	assert.True(t, created.Add(100*time.Minute).Equal(*loaded.LastUsedAt))
+	require.NoError(t, reopened.Close())
+
+	data, err = os.ReadFile(fname + apiKeysFileSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"), "После перезаписи в журнале должна остаться одна запись на ключ")
+	require.NoError(t, storager.Close())
+}
@@ -0,0 +1,263 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"go.uber.org/zap"
+)
+
+// compactionSuffix суффикс временного файла, в который пишется снимок при компактификации.
+const compactionSuffix = ".compact-*"
+
+// CompactionPolicy задает пороги, при которых файл хранилища переписывается заново.
+// Нулевое значение порога отключает соответствующую проверку.
+type CompactionPolicy struct {
+	// MaxStaleRatio отношение количества записей в файле к количеству живых записей.
+	MaxStaleRatio float64
+	// MinRecords минимальное количество записей в файле для проверки MaxStaleRatio.
+	MinRecords int
+	// MaxFileSize размер файла в байтах, после которого файл переписывается, если в нем есть устаревшие записи.
+	MaxFileSize int64
+}
+
+// DefaultCompactionPolicy пороги компактификации по умолчанию.
+var DefaultCompactionPolicy = CompactionPolicy{
+	MaxStaleRatio: 2,
+	MinRecords:    1000,
+	MaxFileSize:   0,
+}
+
+// SetCompactionPolicy задает пороги автоматической компактификации.
+func (storager *FileStorage) SetCompactionPolicy(policy CompactionPolicy) {
+	storager.fileMu.Lock()
+	storager.compactionPolicy = policy
+	storager.fileMu.Unlock()
+}
+
+// RunCompaction выполняет компактификацию по сигналу о превышении порогов и раз в interval,
+// пока не будет отменен ctx. Нулевой interval отключает компактификацию по таймеру.
+func (storager *FileStorage) RunCompaction(ctx context.Context, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Compaction is stopped")
+			return
+		case <-tick:
+			if err := storager.Compact(ctx); err != nil {
+				logger.Log.Error("Failed to compact file by timer", zap.Error(err))
+			}
+		case <-storager.compactCh:
+			if err := storager.Compact(ctx); err != nil {
+				logger.Log.Error("Failed to compact file by threshold", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Compact переписывает файл хранилища так, чтобы в нем осталась ровно одна запись на каждый URL.
+// Снимок пишется во временный файл в той же директории без блокировки записи, затем к нему
+// дописываются записи, появившиеся за это время, и он атомарно переименовывается поверх старого файла.
+// Заодно переписываются журналы пользователей и ключей API, см. compactUsers и compactAPIKeys.
+func (storager *FileStorage) Compact(ctx context.Context) error {
+	if !storager.isWithFile {
+		return nil
+	}
+
+	storager.compactMu.Lock()
+	defer storager.compactMu.Unlock()
+
+	if err := storager.compactUsers(); err != nil {
+		return err
+	}
+	if err := storager.compactAPIKeys(); err != nil {
+		return err
+	}
+	return storager.compact(ctx)
+}
+
+// compact переписывает файл хранилища по текущему состоянию в памяти, вызывается под compactMu.
+func (storager *FileStorage) compact(ctx context.Context) error {
+	// снимок и смещение в файле должны соответствовать друг другу, поэтому берутся под writeMu
+	storager.writeMu.Lock()
+	storager.mu.RLock()
+	snapshot := make([]models.SavedURL, 0, len(storager.URLMap))
+	for _, savedURL := range storager.URLMap {
+		snapshot = append(snapshot, savedURL)
+	}
+	storager.mu.RUnlock()
+	storager.fileMu.Lock()
+	offset := storager.fileSize
+	recordsAtOffset := storager.recordsWritten
+	storager.fileMu.Unlock()
+	storager.writeMu.Unlock()
+
+	if len(snapshot) == recordsAtOffset {
+		logger.Log.Debug("Nothing to compact", zap.Int("records", len(snapshot)))
+		return nil
+	}
+
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].UUID < snapshot[j].UUID
+	})
+
+	tmp, size, err := storager.writeSnapshot(ctx, snapshot)
+	if err != nil {
+		return err
+	}
+	// после успешного переименования удалять уже нечего, ошибка игнорируется
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	storager.fileMu.Lock()
+	defer storager.fileMu.Unlock()
+
+	tail, err := storager.copyTail(tmp, offset)
+	if err != nil {
+		return err
+	}
+	if err := storager.replaceFile(tmp); err != nil {
+		return err
+	}
+
+	before := storager.recordsWritten
+	storager.recordsWritten = len(snapshot) + before - recordsAtOffset
+	storager.fileSize = size + tail
+	logger.Log.Info("File is compacted", zap.Int("before", before), zap.Int("after", storager.recordsWritten), zap.Int64("size", storager.fileSize))
+	return nil
+}
+
+// writeSnapshot пишет снимок во временный файл и возвращает его открытым вместе с размером записанного.
+func (storager *FileStorage) writeSnapshot(ctx context.Context, snapshot []models.SavedURL) (*os.File, int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(storager.filePath), filepath.Base(storager.filePath)+compactionSuffix)
+	if err != nil {
+		logger.Log.Error("Failed to create temp file for compaction", zap.Error(err))
+		return nil, 0, err
+	}
+	fail := func(err error) (*os.File, int64, error) {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, 0, err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	var size int64
+	for i, savedURL := range snapshot {
+		if i%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return fail(err)
+			}
+		}
+		savedURLJSON, err := storager.json.Marshal(savedURL)
+		if err != nil {
+			logger.Log.Error("Failed to marshal data for compaction", zap.Error(err))
+			return fail(err)
+		}
+		savedURLJSON = append(savedURLJSON, '\n')
+		n, err := writer.Write(savedURLJSON)
+		if err != nil {
+			logger.Log.Error("Failed to write compacted file", zap.Error(err))
+			return fail(err)
+		}
+		size += int64(n)
+	}
+
+	if err := writer.Flush(); err != nil {
+		logger.Log.Error("Failed to write compacted file", zap.Error(err))
+		return fail(err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		return fail(err)
+	}
+	return tmp, size, nil
+}
+
+// copyTail дописывает во временный файл записи старого файла начиная с offset, вызывается под fileMu.
+func (storager *FileStorage) copyTail(tmp *os.File, offset int64) (int64, error) {
+	if storager.fileSize == offset {
+		return 0, nil
+	}
+	old, err := os.Open(storager.filePath)
+	if err != nil {
+		logger.Log.Error("Failed to open file for compaction", zap.Error(err))
+		return 0, err
+	}
+	defer old.Close()
+
+	n, err := io.Copy(tmp, io.NewSectionReader(old, offset, storager.fileSize-offset))
+	if err != nil {
+		logger.Log.Error("Failed to copy tail to compacted file", zap.Error(err))
+		return 0, err
+	}
+	return n, nil
+}
+
+// replaceFile сбрасывает временный файл на диск, переименовывает его в файл хранилища
+// и закрывает старый дескриптор, следующая запись откроет новый файл, вызывается под fileMu.
+func (storager *FileStorage) replaceFile(tmp *os.File) error {
+	if err := tmp.Sync(); err != nil {
+		logger.Log.Error("Failed to sync compacted file", zap.Error(err))
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), storager.filePath); err != nil {
+		logger.Log.Error("Failed to replace file with compacted one", zap.Error(err))
+		return err
+	}
+	// старый дескриптор указывает на удаленный файл, все его записи уже скопированы
+	if storager.file != nil {
+		storager.file.Close()
+		storager.file = nil
+		storager.dirty = false
+	}
+	return syncDir(filepath.Dir(storager.filePath))
+}
+
+// needsCompaction проверяет пороги компактификации для live живых записей, вызывается под fileMu.
+func (storager *FileStorage) needsCompaction(live int) bool {
+	policy := storager.compactionPolicy
+	if storager.recordsWritten <= live {
+		return false
+	}
+	if policy.MaxFileSize > 0 && storager.fileSize >= policy.MaxFileSize {
+		return true
+	}
+	if policy.MaxStaleRatio > 0 && storager.recordsWritten >= policy.MinRecords {
+		return float64(storager.recordsWritten) >= policy.MaxStaleRatio*float64(max(live, 1))
+	}
+	return false
+}
+
+// signalCompaction сообщает RunCompaction, что пора переписать файл, не блокируясь.
+func (storager *FileStorage) signalCompaction() {
+	select {
+	case storager.compactCh <- struct{}{}:
+	default:
+	}
+}
+
+// syncDir сбрасывает на диск запись директории, чтобы переименование пережило падение.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
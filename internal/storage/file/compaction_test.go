@@ -0,0 +1,76 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+)
+
+// countLines возвращает количество строк в файле.
+func countLines(t *testing.T, fname string) int {
+	file, err := os.Open(fname)
+	require.NoError(t, err)
+	defer file.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines++
+	}
+	require.NoError(t, scanner.Err())
+	return lines
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), "storage.json")
+	storager := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+
+	for _, shortURL := range []string{"a", "b", "c"} {
+		_, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: shortURL, OriginalURL: "https://example.com/" + shortURL, UserID: 1})
+		require.NoError(t, err)
+	}
+	require.NoError(t, storager.DeleteByUserID(ctx, []string{"a", "b"}, 1))
+	require.Equal(t, 5, countLines(t, fname), "Удаление дописывает копии записей")
+
+	require.NoError(t, storager.Compact(ctx))
+	assert.Equal(t, 3, countLines(t, fname), "После компактификации должна остаться одна запись на URL")
+
+	// после перезапуска состояние должно совпадать
+	reloaded := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	urls, err := reloaded.ReadAllDataForUserID(ctx, 1, models.URLQuery{})
+	require.NoError(t, err)
+	require.Len(t, urls, 3)
+	for _, savedURL := range urls {
+		assert.Equal(t, savedURL.ShortURL != "c", savedURL.Deleted, "Неверный флаг удаления у %s", savedURL.ShortURL)
+	}
+
+	// в директории не должно остаться временных файлов
+	matches, err := filepath.Glob(fname + ".compact-*")
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+}
+
+func TestCompactionThreshold(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), "storage.json")
+	storager := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	storager.SetCompactionPolicy(CompactionPolicy{MaxStaleRatio: 2, MinRecords: 4})
+
+	_, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: "a", OriginalURL: "https://example.com/a", UserID: 1})
+	require.NoError(t, err)
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "b", OriginalURL: "https://example.com/b", UserID: 1})
+	require.NoError(t, err)
+	require.NoError(t, storager.DeleteByUserID(ctx, []string{"a"}, 1))
+	assert.Empty(t, storager.compactCh, "Порог еще не превышен")
+
+	require.NoError(t, storager.DeleteByUserID(ctx, []string{"b"}, 1))
+	assert.Len(t, storager.compactCh, 1, "При превышении порога должен появиться сигнал на компактификацию")
+}
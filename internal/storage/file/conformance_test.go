@@ -0,0 +1,18 @@
+package file
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"github.com/theheadmen/urlShort/internal/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		fname := filepath.Join(t.TempDir(), "storage.json")
+		return NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), context.Background())
+	})
+}
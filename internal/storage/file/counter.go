@@ -0,0 +1,75 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"go.uber.org/zap"
+)
+
+// counterFileSuffix суффикс файла со счетчиком коротких кодов рядом с основным файлом.
+// В файле хранится последнее зарезервированное значение, файл каждый раз переписывается целиком.
+const counterFileSuffix = ".counter"
+
+// ReserveCodeCounter резервирует n следующих значений счетчика коротких кодов и возвращает первое из них.
+// Новое значение счетчика сначала записывается в файл, поэтому после перезапуска значения не повторяются.
+func (storager *FileStorage) ReserveCodeCounter(ctx context.Context, n int) (uint64, error) {
+	storager.counterMu.Lock()
+	defer storager.counterMu.Unlock()
+
+	last := storager.codeCounter + uint64(n)
+	if storager.isWithFile {
+		if err := storager.writeCounter(last); err != nil {
+			logger.Log.Error("Failed to write counter file", zap.Error(err))
+			return 0, err
+		}
+	}
+	first := storager.codeCounter + 1
+	storager.codeCounter = last
+	return first, nil
+}
+
+// writeCounter атомарно заменяет файл счетчика, вызывается под counterMu.
+func (storager *FileStorage) writeCounter(value uint64) error {
+	path := storager.filePath + counterFileSuffix
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	// после успешного переименования удалять уже нечего, ошибка игнорируется
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(strconv.FormatUint(value, 10) + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readCounter восстанавливает счетчик коротких кодов из файла счетчика.
+func (storager *FileStorage) readCounter() error {
+	data, err := os.ReadFile(storager.filePath + counterFileSuffix)
+	if err != nil {
+		return err
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	storager.counterMu.Lock()
+	storager.codeCounter = value
+	storager.counterMu.Unlock()
+	return nil
+}
@@ -0,0 +1,28 @@
+package file
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+)
+
+func TestCodeCounterSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), "storage.json")
+
+	storager := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	first, err := storager.ReserveCodeCounter(ctx, 100)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), first)
+	require.NoError(t, storager.Close())
+
+	reopened := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	next, err := reopened.ReserveCodeCounter(ctx, 100)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(101), next, "После перезапуска счетчик должен продолжаться с зарезервированного значения")
+}
@@ -0,0 +1,87 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"os"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"go.uber.org/zap"
+)
+
+// editsFileSuffix суффикс файла с журналом изменений полных URL рядом с основным файлом.
+// Изменение пишется в журнал до записи самого URL, поэтому после сбоя в журнале может остаться
+// запись с версией больше, чем у URL. Такие записи не показываются и заменяются следующим изменением.
+const editsFileSuffix = ".edits"
+
+// UpdateURL меняет полный URL короткой ссылки пользователя, дописывая в журнал изменений
+// запись истории, а в основной файл новую версию URL.
+func (storager *FileStorage) UpdateURL(ctx context.Context, edit models.URLEdit, version int) (models.SavedURL, error) {
+	storager.writeMu.Lock()
+	defer storager.writeMu.Unlock()
+
+	key := storage.URLMapKey{ShortURL: edit.ShortURL, UserID: edit.UserID}
+	storager.mu.RLock()
+	current, found := storager.URLMap[key]
+	updated, edit, changed, err := storage.PrepareEdit(current, found, edit, version)
+	if err == nil && changed && storager.owners.Count(edit.ShortURL) > 1 {
+		err = storage.ErrSharedURL
+	}
+	if _, duplicate := storager.byOriginalURL[edit.UserID][edit.OriginalURL]; err == nil && changed && duplicate {
+		err = storage.ErrDuplicateURL
+	}
+	storager.mu.RUnlock()
+	if err != nil || !changed {
+		return updated, err
+	}
+
+	if storager.isWithFile {
+		if err := storager.appendJournal(editsFileSuffix, edit); err != nil {
+			logger.Log.Error("Failed to write edits file", zap.Error(err))
+			return models.SavedURL{}, err
+		}
+	}
+	if err := storager.apply([]models.SavedURL{updated}); err != nil {
+		return models.SavedURL{}, err
+	}
+
+	storager.mu.Lock()
+	storager.edits.Add(edit)
+	storager.mu.Unlock()
+	return updated, nil
+}
+
+// ListURLEdits возвращает историю изменений короткого URL пользователя.
+func (storager *FileStorage) ListURLEdits(ctx context.Context, shortURL string, userID int) ([]models.URLEdit, error) {
+	storager.mu.RLock()
+	defer storager.mu.RUnlock()
+
+	key := storage.URLMapKey{ShortURL: shortURL, UserID: userID}
+	return storager.edits.List(key, storager.URLMap[key].Version), nil
+}
+
+// readAllEdits восстанавливает историю изменений из журнала изменений.
+func (storager *FileStorage) readAllEdits() error {
+	file, err := os.Open(storager.filePath + editsFileSuffix)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	storager.mu.Lock()
+	defer storager.mu.Unlock()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var edit models.URLEdit
+		if err := storager.json.Unmarshal(scanner.Bytes(), &edit); err != nil {
+			logger.Log.Error("Failed unmarshal url edit", zap.Error(err))
+			continue
+		}
+		storager.edits.Add(edit)
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,52 @@
+package file
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+)
+
+func TestURLEditsSurviveRestartAndCompaction(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), "storage.json")
+	edited := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	storager := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	_, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: "flyer", OriginalURL: "http://tpyo.example", UserID: 1})
+	require.NoError(t, err)
+	_, err = storager.UpdateURL(ctx, models.URLEdit{ShortURL: "flyer", UserID: 1, OriginalURL: "http://typo.example", EditedAt: edited}, 0)
+	require.NoError(t, err)
+	require.NoError(t, storager.Compact(ctx))
+	// изменение, которое попало в журнал, но не дошло до основного файла
+	require.NoError(t, storager.appendJournal(editsFileSuffix, models.URLEdit{ShortURL: "flyer", UserID: 1, PreviousURL: "http://typo.example", OriginalURL: "http://lost.example", Version: 2, EditedAt: edited}))
+	require.NoError(t, storager.Close())
+
+	reopened := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	defer reopened.Close()
+
+	savedURL, ok, err := reopened.GetURLForUserID(ctx, "flyer", 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "http://typo.example", savedURL.OriginalURL)
+	assert.Equal(t, 1, savedURL.Version)
+
+	edits, err := reopened.ListURLEdits(ctx, "flyer", 1)
+	require.NoError(t, err)
+	require.Len(t, edits, 1, "Недошедшее изменение не должно попадать в историю")
+	assert.Equal(t, "http://tpyo.example", edits[0].PreviousURL)
+	assert.True(t, edited.Equal(edits[0].EditedAt))
+
+	// следующее изменение получает ту же версию и заменяет недошедшее
+	_, err = reopened.UpdateURL(ctx, models.URLEdit{ShortURL: "flyer", UserID: 1, OriginalURL: "http://final.example", EditedAt: edited}, 1)
+	require.NoError(t, err)
+	edits, err = reopened.ListURLEdits(ctx, "flyer", 1)
+	require.NoError(t, err)
+	require.Len(t, edits, 2)
+	assert.Equal(t, "http://final.example", edits[1].OriginalURL)
+}
@@ -5,8 +5,10 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"sync"
+	"time"
 
 	"encoding/json"
 
@@ -18,34 +20,105 @@ import (
 	jsoniter "github.com/json-iterator/go"
 )
 
+// clicksFileSuffix суффикс файла с журналом переходов рядом с основным файлом.
+const clicksFileSuffix = ".clicks"
+
 // FileStorage реализует интерфейс Storage для хранения данных в файле.
+// Все чтения обслуживаются из памяти: кроме URLMap поддерживаются индексы
+// по короткому коду и по пользователю, файл используется только для записи и восстановления.
 type FileStorage struct {
-	filePath    string
-	isWithFile  bool
-	URLMap      map[storage.URLMapKey]models.SavedURL
-	mu          sync.RWMutex
-	lastUserID  int
-	usedUserIDs []int
+	filePath   string
+	isWithFile bool
+	URLMap     map[storage.URLMapKey]models.SavedURL
+	owners     *storage.OwnerIndex
+	byUserID   map[int]map[string]struct{}
+	// byOriginalURL короткий URL каждого полного URL пользователя
+	byOriginalURL map[int]map[string]string
+	edits         *storage.EditLog
+	nextUUID      int
+	mu            sync.RWMutex
+	clicks        map[storage.URLMapKey]*storage.ClickCounter
+	clicksMu      sync.Mutex
+	apiKeys       *storage.APIKeyIndex
+	apiKeysMu     sync.Mutex
+	users         *storage.UserRegistry
+	// usersMu упорядочивает записи в журнал пользователей, чтение идет из users без блокировки
+	usersMu sync.Mutex
+	// apiKeysDirty выставляется, если время использования ключей API изменилось после перезаписи журнала, защищен apiKeysMu
+	apiKeysDirty bool
+	// usersDirty выставляется, если время активности пользователей изменилось после перезаписи журнала, защищен usersMu
+	usersDirty bool
+	// activeURLs количество неудаленных URL, обновляется в put и remove, защищен mu, см. CountURLs
+	activeURLs int
+	// codeCounter последнее зарезервированное значение счетчика коротких кодов, защищен counterMu
+	codeCounter uint64
+	counterMu   sync.Mutex
 	json        jsoniter.API
+
+	// writeMu упорядочивает изменения: запись сначала попадает в файл и только потом в память
+	writeMu sync.Mutex
+	// fileMu защищает дескриптор файла и счетчики ниже
+	fileMu           sync.Mutex
+	file             walFile
+	syncPolicy       SyncPolicy
+	syncStop         chan struct{}
+	dirty            bool
+	recordsWritten   int
+	fileSize         int64
+	tornTail         bool
+	compactionPolicy CompactionPolicy
+	compactCh        chan struct{}
+	// compactMu не дает запустить две компактификации одновременно
+	compactMu sync.Mutex
 }
 
 // NewFileStorage создает новый экземпляр FileStorage и читает данные из файла.
 func NewFileStorage(filePath string, isWithFile bool, URLMap map[storage.URLMapKey]models.SavedURL, ctx context.Context) *FileStorage {
-	var empty []int
-
 	storager := &FileStorage{
-		filePath:    filePath,
-		isWithFile:  isWithFile,
-		URLMap:      URLMap,
-		mu:          sync.RWMutex{},
-		lastUserID:  0,
-		usedUserIDs: empty,
-		json:        jsoniter.ConfigCompatibleWithStandardLibrary,
-	}
-	err := storager.ReadAllData(ctx)
+		filePath:   filePath,
+		isWithFile: isWithFile,
+		URLMap:     URLMap,
+		mu:         sync.RWMutex{},
+		edits:      storage.NewEditLog(),
+		clicks:     make(map[storage.URLMapKey]*storage.ClickCounter),
+		apiKeys:    storage.NewAPIKeyIndex(),
+		users:      storage.NewUserRegistry(),
+		json:       jsoniter.ConfigCompatibleWithStandardLibrary,
+
+		syncPolicy:       SyncAlways,
+		compactionPolicy: DefaultCompactionPolicy,
+		compactCh:        make(chan struct{}, 1),
+	}
+	storager.buildIndexes()
+	if !isWithFile {
+		return storager
+	}
+
+	// пользователи читаются первыми, чтобы владельцы URL без записи в журнале не заменили настоящие записи
+	err := storager.readAllUsers()
+	if err != nil && !os.IsNotExist(err) {
+		logger.Log.Error("Failed to read users", zap.Error(err))
+	}
+	err = storager.ReadAllData(ctx)
 	if err != nil {
 		logger.Log.Error("Failed to read data", zap.Error(err))
 	}
+	err = storager.readAllEdits()
+	if err != nil && !os.IsNotExist(err) {
+		logger.Log.Error("Failed to read url edits", zap.Error(err))
+	}
+	err = storager.readAllClicks()
+	if err != nil && !os.IsNotExist(err) {
+		logger.Log.Error("Failed to read clicks", zap.Error(err))
+	}
+	err = storager.readAllAPIKeys()
+	if err != nil && !os.IsNotExist(err) {
+		logger.Log.Error("Failed to read api keys", zap.Error(err))
+	}
+	err = storager.readCounter()
+	if err != nil && !os.IsNotExist(err) {
+		logger.Log.Error("Failed to read counter", zap.Error(err))
+	}
 	return storager
 }
 
@@ -60,18 +133,101 @@ func NewFileStoragerWithoutReadingData(filePath string, isWithFile bool, URLMap
 	// Кодируем map в JSON
 	json.Marshal(person)
 
-	return &FileStorage{
-		filePath:    filePath,
-		isWithFile:  isWithFile,
-		URLMap:      URLMap,
-		mu:          sync.RWMutex{},
-		lastUserID:  1,
-		usedUserIDs: []int{1},
-		json:        jsoniter.ConfigCompatibleWithStandardLibrary,
+	storager := &FileStorage{
+		filePath:   filePath,
+		isWithFile: isWithFile,
+		URLMap:     URLMap,
+		mu:         sync.RWMutex{},
+		edits:      storage.NewEditLog(),
+		clicks:     make(map[storage.URLMapKey]*storage.ClickCounter),
+		apiKeys:    storage.NewAPIKeyIndex(),
+		users:      storage.NewUserRegistry(),
+		json:       jsoniter.ConfigCompatibleWithStandardLibrary,
+
+		syncPolicy:       SyncAlways,
+		compactionPolicy: DefaultCompactionPolicy,
+		compactCh:        make(chan struct{}, 1),
+	}
+	storager.buildIndexes()
+	// тестовая кука выдается пользователю 1
+	storager.users.Ensure(1, time.Now().UTC())
+	return storager
+}
+
+// buildIndexes строит индексы по уже переданным в URLMap данным.
+func (storager *FileStorage) buildIndexes() {
+	storager.mu.Lock()
+	defer storager.mu.Unlock()
+
+	storager.owners = storage.NewOwnerIndex()
+	storager.byUserID = make(map[int]map[string]struct{})
+	storager.byOriginalURL = make(map[int]map[string]string)
+	storager.activeURLs = 0
+	for _, savedURL := range storager.URLMap {
+		storager.index(savedURL)
+		if !savedURL.Deleted {
+			storager.activeURLs++
+		}
+	}
+}
+
+// put сохраняет URL в URLMap и обновляет индексы, вызывается под mu.
+func (storager *FileStorage) put(savedURL models.SavedURL) {
+	savedURL.Alias = false
+	key := storage.URLMapKey{ShortURL: savedURL.ShortURL, UserID: savedURL.UserID}
+	previous, ok := storager.URLMap[key]
+	if ok && previous.OriginalURL != savedURL.OriginalURL {
+		storager.unindexOriginalURL(previous)
+	}
+	// удаление, восстановление и истечение срока приходят в put новой версией записи
+	if ok && !previous.Deleted {
+		storager.activeURLs--
+	}
+	if !savedURL.Deleted {
+		storager.activeURLs++
+	}
+	storager.URLMap[key] = savedURL
+	storager.index(savedURL)
+	// владелец URL всегда зарегистрированный пользователь, даже если файл записан до журнала пользователей
+	storager.users.Ensure(savedURL.UserID, time.Now().UTC())
+}
+
+// index добавляет URL в индексы, вызывается под mu.
+func (storager *FileStorage) index(savedURL models.SavedURL) {
+	key := storage.URLMapKey{ShortURL: savedURL.ShortURL, UserID: savedURL.UserID}
+	// после окончательного удаления URL их количество меньше наибольшего UUID, поэтому следующий UUID считается отдельно
+	if savedURL.UUID >= storager.nextUUID {
+		storager.nextUUID = savedURL.UUID + 1
+	}
+	storager.owners.Add(key, savedURL.UUID)
+	shortURLs, ok := storager.byUserID[savedURL.UserID]
+	if !ok {
+		shortURLs = make(map[string]struct{})
+		storager.byUserID[savedURL.UserID] = shortURLs
+	}
+	shortURLs[savedURL.ShortURL] = struct{}{}
+	originalURLs, ok := storager.byOriginalURL[savedURL.UserID]
+	if !ok {
+		originalURLs = make(map[string]string)
+		storager.byOriginalURL[savedURL.UserID] = originalURLs
+	}
+	// в файлах, записанных до появления ограничения, у пользователя может быть несколько коротких URL
+	// для одного полного URL, тогда в индексе остается первый
+	if _, ok := originalURLs[savedURL.OriginalURL]; !ok {
+		originalURLs[savedURL.OriginalURL] = savedURL.ShortURL
+	}
+}
+
+// unindexOriginalURL убирает полный URL из индекса, если он указывает на savedURL, вызывается под mu.
+func (storager *FileStorage) unindexOriginalURL(savedURL models.SavedURL) {
+	if storager.byOriginalURL[savedURL.UserID][savedURL.OriginalURL] == savedURL.ShortURL {
+		delete(storager.byOriginalURL[savedURL.UserID], savedURL.OriginalURL)
 	}
 }
 
 // ReadAllData читает все данные из файла и заполняет их в FileStorage.
+// Оборванная или нечитаемая запись в конце файла, оставшаяся после падения, отрезается,
+// чтобы следующие записи не склеились с ней. Нечитаемые записи в середине файла пропускаются.
 func (storager *FileStorage) ReadAllData(ctx context.Context) error {
 	// Read from file
 	file, err := os.Open(storager.filePath)
@@ -86,141 +242,209 @@ func (storager *FileStorage) ReadAllData(ctx context.Context) error {
 
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	curMax := storager.lastUserID
+	storager.writeMu.Lock()
+	defer storager.writeMu.Unlock()
+	storager.mu.Lock()
+	defer storager.mu.Unlock()
+	storager.fileMu.Lock()
+	defer storager.fileMu.Unlock()
+
+	reader := bufio.NewReader(file)
+	storager.recordsWritten = 0
+	// в файлах, записанных до появления deleted_at, срок хранения удаленных URL отсчитывается от загрузки
+	loadedAt := time.Now().UTC()
+	// offset указывает на конец последней целой записи, goodOffset на конец последней прочитанной
+	var offset, goodOffset int64
+	records := 0
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) != 0 {
+				logger.Log.Warn("Found torn record at the end of file", zap.Int64("offset", offset), zap.Int("size", len(line)))
+			}
+			break
+		}
+		if err != nil {
+			logger.Log.Error("Failed to read file", zap.Error(err))
+			return err
+		}
+		offset += int64(len(line))
+		records++
 
-	for scanner.Scan() {
 		var result models.SavedURL
-		err := storager.json.Unmarshal([]byte(scanner.Text()), &result)
-		if err != nil {
-			logger.Log.Error("Failed unmarshal data", zap.Error(err))
+		if err := storager.json.Unmarshal(line, &result); err != nil {
+			logger.Log.Error("Failed unmarshal data", zap.Int64("offset", offset-int64(len(line))), zap.Error(err))
+			continue
 		}
-		storager.URLMap[storage.URLMapKey{ShortURL: result.ShortURL, UserID: result.UserID}] = result
-		storager.usedUserIDs = append(storager.usedUserIDs, result.UserID)
-		// запоминаем максимальный userId, чтобы выдавать следующий за ним
-		if result.UserID > curMax {
-			curMax = result.UserID
+		goodOffset = offset
+		storager.recordsWritten = records
+		if result.Deleted && result.DeletedAt == nil {
+			result.DeletedAt = &loadedAt
 		}
-		logger.Log.Info("Read new data from file", zap.Int("UUID", result.UUID), zap.String("OriginalURL", result.OriginalURL), zap.String("ShortURL", result.ShortURL), zap.Int("UserID", result.UserID), zap.Bool("Deleted", result.Deleted))
-	}
-	storager.lastUserID = curMax
 
-	if err := scanner.Err(); err != nil {
-		logger.Log.Error("Failed to read file", zap.Error(err))
+		storager.put(result)
+		logger.Log.Info("Read new data from file", zap.Int("UUID", result.UUID), zap.String("OriginalURL", result.OriginalURL), zap.String("ShortURL", result.ShortURL), zap.Int("UserID", result.UserID), zap.Bool("Deleted", result.Deleted))
 	}
 
-	return err
-}
-
-// ReadAllDataForUserID читает все данные для определенного пользователя из файла.
-func (storager *FileStorage) ReadAllDataForUserID(ctx context.Context, userID int) ([]models.SavedURL, error) {
-	filteredData := []models.SavedURL{}
-	// Read from file
-	file, err := os.Open(storager.filePath)
+	info, err := file.Stat()
 	if err != nil {
-		if os.IsNotExist(err) {
-			logger.Log.Debug("File does not exist. Leaving SavedURLs empty.")
-		} else {
-			logger.Log.Error("Failed to open file", zap.Error(err))
-		}
-		return []models.SavedURL{}, err
+		logger.Log.Error("Failed to stat file", zap.Error(err))
+		return err
 	}
-
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		var result models.SavedURL
-		err := storager.json.Unmarshal([]byte(scanner.Text()), &result)
-		if err != nil {
-			logger.Log.Error("Failed unmarshal data", zap.Error(err))
-		}
-		// запоминаем только то, что связано с нужным пользователем
-		if result.UserID == userID {
-			filteredData = append(filteredData, result)
-			logger.Log.Info("Read new data from file", zap.Int("UUID", result.UUID), zap.String("OriginalURL", result.OriginalURL), zap.String("ShortURL", result.ShortURL), zap.Int("UserID", result.UserID), zap.Bool("Deleted", result.Deleted))
+	if info.Size() > goodOffset {
+		logger.Log.Warn("Truncate broken tail of file", zap.Int64("from", info.Size()), zap.Int64("to", goodOffset))
+		if err := os.Truncate(storager.filePath, goodOffset); err != nil {
+			logger.Log.Error("Failed to truncate file", zap.Error(err))
+			return err
 		}
 	}
+	storager.fileSize = goodOffset
 
-	if err := scanner.Err(); err != nil {
-		logger.Log.Error("Failed to read file", zap.Error(err))
+	return nil
+}
+
+// ReadAllDataForUserID возвращает страницу URL определенного пользователя из индекса в памяти.
+func (storager *FileStorage) ReadAllDataForUserID(ctx context.Context, userID int, query models.URLQuery) ([]models.SavedURL, error) {
+	storager.mu.RLock()
+	shortURLs := storager.byUserID[userID]
+	filteredData := make([]models.SavedURL, 0, len(shortURLs))
+	for shortURL := range shortURLs {
+		filteredData = append(filteredData, storager.URLMap[storage.URLMapKey{ShortURL: shortURL, UserID: userID}])
 	}
+	storager.mu.RUnlock()
 
-	return filteredData, err
+	return storage.SelectPage(filteredData, query), nil
 }
 
-// StoreURL сохраняет URL в FileStorage и файл.
-func (storager *FileStorage) StoreURL(ctx context.Context, shortURL string, originalURL string, userID int) (bool, error) {
-	_, ok := storager.GetURL(shortURL, userID)
+// StoreURL сохраняет URL в файл и FileStorage.
+// Если запись в файл не удалась, URL не сохраняется и возвращается ошибка.
+func (storager *FileStorage) StoreURL(ctx context.Context, savedURL models.SavedURL) (bool, error) {
+	storager.writeMu.Lock()
+	defer storager.writeMu.Unlock()
+
+	if storager.aliasTaken(savedURL, nil) {
+		return false, storage.ErrAliasTaken
+	}
+	_, ok := storager.GetURL(savedURL.ShortURL, savedURL.UserID)
 
 	if ok {
-		logger.Log.Info("We already have data for this url", zap.String("OriginalURL", originalURL), zap.String("ShortURL", shortURL), zap.Bool("Deleted", false))
+		logger.Log.Info("We already have data for this url", zap.String("OriginalURL", savedURL.OriginalURL), zap.String("ShortURL", savedURL.ShortURL), zap.Bool("Deleted", false))
 		return true, nil
 	}
 
-	savedURL := models.SavedURL{
-		UUID:        len(storager.URLMap),
-		ShortURL:    shortURL,
-		OriginalURL: originalURL,
-		UserID:      userID,
-		Deleted:     false,
+	savedURL.Deleted = false
+	storager.mu.RLock()
+	savedURL.UUID = storager.nextUUID
+	shortURL, duplicate := storager.byOriginalURL[savedURL.UserID][savedURL.OriginalURL]
+	storager.mu.RUnlock()
+	if duplicate {
+		return false, &storage.DuplicateURLError{ShortURL: shortURL}
 	}
 
-	storager.mu.Lock()
-	storager.URLMap[storage.URLMapKey{ShortURL: shortURL, UserID: userID}] = savedURL
-	storager.mu.Unlock()
-
-	storager.Save(savedURL)
+	if err := storager.apply([]models.SavedURL{savedURL}); err != nil {
+		return false, err
+	}
 	return false, nil
 }
 
-// StoreURLBatch сохраняет несколько URL в FileStorage и файл.
-func (storager *FileStorage) StoreURLBatch(ctx context.Context, forStore []models.SavedURL, userID int) error {
+// StoreURLBatch сохраняет несколько URL в файл и FileStorage.
+// Пакет пишется в файл целиком, при ошибке записи ни один URL не сохраняется.
+func (storager *FileStorage) StoreURLBatch(ctx context.Context, forStore []models.SavedURL, userID int) ([]storage.BatchResult, error) {
+	storager.writeMu.Lock()
+	defer storager.writeMu.Unlock()
+
+	storager.mu.RLock()
+	nextUUID := storager.nextUUID
+	storager.mu.RUnlock()
+
 	var filteredStore []models.SavedURL
-	for _, savedURL := range forStore {
+	results := make([]storage.BatchResult, len(forStore))
+	inBatch := make(map[string]string, len(forStore))
+	originalInBatch := make(map[string]string, len(forStore))
+	for i, savedURL := range forStore {
+		results[i] = storage.BatchResult{ShortURL: savedURL.ShortURL, Existed: true}
+		savedURL.UserID = userID
+		if storager.aliasTaken(savedURL, inBatch) {
+			results[i].AliasTaken = true
+			continue
+		}
 		_, ok := storager.GetURL(savedURL.ShortURL, userID)
-
-		if ok {
+		_, dup := inBatch[savedURL.ShortURL]
+		if ok || dup {
 			logger.Log.Info("We already have data for this url", zap.String("OriginalURL", savedURL.OriginalURL), zap.String("ShortURL", savedURL.ShortURL), zap.Int("UserID", userID), zap.Bool("Deleted", savedURL.Deleted))
-		} else {
-			storager.mu.Lock()
-			storager.URLMap[storage.URLMapKey{ShortURL: savedURL.ShortURL, UserID: userID}] = savedURL
-			storager.mu.Unlock()
-			filteredStore = append(filteredStore, savedURL)
+			continue
+		}
+		storager.mu.RLock()
+		shortURL, original := storager.byOriginalURL[userID][savedURL.OriginalURL]
+		storager.mu.RUnlock()
+		if !original {
+			shortURL, original = originalInBatch[savedURL.OriginalURL]
 		}
+		if original {
+			results[i].ShortURL = shortURL
+			continue
+		}
+		inBatch[savedURL.ShortURL] = savedURL.OriginalURL
+		originalInBatch[savedURL.OriginalURL] = savedURL.ShortURL
+		savedURL.UUID = nextUUID
+		nextUUID++
+		filteredStore = append(filteredStore, savedURL)
+		results[i].Existed = false
 	}
+
 	// если у нас уже все и так было вставлено, нам не нужно ничего сохранять
-	if len(filteredStore) != 0 {
-		if storager.isWithFile {
-			for _, savedURL := range filteredStore {
-				storager.Save(savedURL)
-			}
-		}
+	if err := storager.apply(filteredStore); err != nil {
+		return nil, err
 	}
+	return results, nil
+}
 
-	return nil
+// aliasTaken проверяет, занят ли пользовательский код savedURL, вызывается под writeMu.
+// inBatch короткие и полные URL того же пользователя, уже отобранные для записи в этой же пачке.
+// Для сгенерированных кодов всегда возвращает false: их могут делить несколько пользователей.
+func (storager *FileStorage) aliasTaken(savedURL models.SavedURL, inBatch map[string]string) bool {
+	if !savedURL.Alias {
+		return false
+	}
+	storager.mu.RLock()
+	first, ok := storager.findEntityByShortURL(savedURL.ShortURL)
+	storager.mu.RUnlock()
+	if ok {
+		return storage.AliasTaken(first, savedURL)
+	}
+	originalURL, ok := inBatch[savedURL.ShortURL]
+	return ok && originalURL != savedURL.OriginalURL
 }
 
-// Save сохраняет URL в файл.
-func (storager *FileStorage) Save(savedURL models.SavedURL) error {
-	savedURLJSON, err := storager.json.Marshal(savedURL)
-	if err != nil {
-		logger.Log.Error("Failed to marshal new data", zap.Error(err))
-		return err
+// apply пишет записи в файл и только после успешной записи применяет их в памяти, вызывается под writeMu.
+// Если после записи превышены пороги компактификации, сообщает об этом RunCompaction.
+func (storager *FileStorage) apply(savedURLs []models.SavedURL) error {
+	if len(savedURLs) == 0 {
+		return nil
 	}
-	file, err := os.OpenFile(storager.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		logger.Log.Error("Failed to open file for writing", zap.Error(err))
-		return err
+
+	if storager.isWithFile {
+		if err := storager.saveBatch(savedURLs); err != nil {
+			return err
+		}
 	}
-	defer file.Close()
 
-	savedURLJSON = append(savedURLJSON, '\n')
-	if _, err := file.Write(savedURLJSON); err != nil {
-		logger.Log.Error("Failed to write to file", zap.Error(err))
-		return err
+	storager.mu.Lock()
+	for _, savedURL := range savedURLs {
+		storager.put(savedURL)
+	}
+	live := len(storager.URLMap)
+	storager.mu.Unlock()
+
+	if storager.isWithFile {
+		storager.fileMu.Lock()
+		needsCompaction := storager.needsCompaction(live)
+		storager.fileMu.Unlock()
+		if needsCompaction {
+			storager.signalCompaction()
+		}
 	}
-	logger.Log.Info("Write new data to file", zap.Int("UUID", savedURL.UUID), zap.String("OriginalURL", savedURL.OriginalURL), zap.String("ShortURL", savedURL.ShortURL), zap.Int("UserID", savedURL.UserID))
 	return nil
 }
 
@@ -242,80 +466,187 @@ func (storager *FileStorage) GetURLForAnyUserID(ctx context.Context, shortURL st
 	return originalSavedURL, ok, nil
 }
 
-// findEntityByShortURL ищет первый полный URL для заданного короткого URL
+// GetURLForUserID возвращает URL, принадлежащий определенному пользователю.
+func (storager *FileStorage) GetURLForUserID(ctx context.Context, shortURL string, userID int) (models.SavedURL, bool, error) {
+	storager.mu.RLock()
+	savedURL, ok := storager.URLMap[storage.URLMapKey{ShortURL: shortURL, UserID: userID}]
+	storager.mu.RUnlock()
+
+	return savedURL, ok, nil
+}
+
+// findEntityByShortURL ищет первый полный URL для заданного короткого URL, вызывается под mu.
 func (storager *FileStorage) findEntityByShortURL(shortURL string) (models.SavedURL, bool) {
-	for key, value := range storager.URLMap {
-		if key.ShortURL == shortURL {
-			return value, true
+	key, ok := storager.owners.First(shortURL)
+	if !ok {
+		return models.SavedURL{}, false
+	}
+	return storager.URLMap[key], true
+}
+
+// DeleteByUserID удаляет URL, принадлежащие определенному пользователю.
+func (storager *FileStorage) DeleteByUserID(ctx context.Context, shortURLs []string, userID int) error {
+	_, err := storager.DeleteURLs(ctx, storage.KeysForUser(shortURLs, userID))
+	return err
+}
+
+// DeleteURLs помечает удаленными URL из keys, каждый только у его владельца.
+// Все помеченные URL дописываются в файл одной пачкой.
+func (storager *FileStorage) DeleteURLs(ctx context.Context, keys []storage.URLMapKey) ([]storage.URLMapKey, error) {
+	storager.writeMu.Lock()
+	defer storager.writeMu.Unlock()
+
+	now := time.Now().UTC()
+	deleted := []models.SavedURL{}
+	deletedKeys := []storage.URLMapKey{}
+	inBatch := make(map[storage.URLMapKey]struct{}, len(keys))
+	storager.mu.RLock()
+	for _, key := range keys {
+		// удалять можно только свои URL, поэтому ищем по паре код + пользователь
+		originalSavedURL, ok := storager.URLMap[key]
+		if _, dup := inBatch[key]; !ok || dup || originalSavedURL.Deleted {
+			continue
 		}
+		inBatch[key] = struct{}{}
+		originalSavedURL.Deleted = true
+		originalSavedURL.DeletedAt = &now
+		deleted = append(deleted, originalSavedURL)
+		deletedKeys = append(deletedKeys, key)
+	}
+	storager.mu.RUnlock()
+
+	// в файл дописываются копии записей с флагом удаления, старые убирает компактификация
+	if err := storager.apply(deleted); err != nil {
+		return nil, err
 	}
-	return models.SavedURL{}, false
+	return deletedKeys, nil
 }
 
-// IsItCorrectUserID проверяет, является ли идентификатор пользователя корректным.
-func (storager *FileStorage) IsItCorrectUserID(userID int) bool {
+// DeleteExpired помечает удаленными все URL, срок действия которых истек к моменту now.
+func (storager *FileStorage) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	storager.writeMu.Lock()
+	defer storager.writeMu.Unlock()
+
+	expired := []models.SavedURL{}
 	storager.mu.RLock()
-	ok := storager.findUserID(userID)
+	for _, savedURL := range storager.URLMap {
+		if !savedURL.Deleted && savedURL.IsExpired(now) {
+			savedURL.Deleted = true
+			savedURL.DeletedAt = &now
+			expired = append(expired, savedURL)
+		}
+	}
 	storager.mu.RUnlock()
 
-	return ok
+	if err := storager.apply(expired); err != nil {
+		return 0, err
+	}
+	return len(expired), nil
 }
 
-// findUserID ищет пользователя по заданному ID
-func (storager *FileStorage) findUserID(userID int) bool {
-	for _, usedUserID := range storager.usedUserIDs {
-		if usedUserID == userID {
-			return true
+// StoreClicks учитывает переходы в памяти и дописывает их в журнал переходов.
+// Запись идет под clicksMu, чтобы не потерять переходы, дописанные во время перезаписи журнала в purge.
+func (storager *FileStorage) StoreClicks(ctx context.Context, clicks []models.Click) error {
+	storager.clicksMu.Lock()
+	defer storager.clicksMu.Unlock()
+
+	for _, click := range clicks {
+		storager.addClick(click)
+	}
+
+	if !storager.isWithFile || len(clicks) == 0 {
+		return nil
+	}
+
+	file, err := os.OpenFile(storager.filePath+clicksFileSuffix, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.Log.Error("Failed to open clicks file for writing", zap.Error(err))
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, click := range clicks {
+		clickJSON, err := storager.json.Marshal(click)
+		if err != nil {
+			logger.Log.Error("Failed to marshal click", zap.Error(err))
+			return err
 		}
+		writer.Write(clickJSON)
+		writer.WriteByte('\n')
 	}
-	return false
+	if err := writer.Flush(); err != nil {
+		logger.Log.Error("Failed to write clicks file", zap.Error(err))
+		return err
+	}
+	return nil
 }
 
-// GetLastUserID возвращает последний использованный идентификатор пользователя.
-func (storager *FileStorage) GetLastUserID(ctx context.Context) (int, error) {
-	storager.lastUserID = storager.lastUserID + 1
-	return storager.lastUserID, nil
+// GetClickStats возвращает статистику переходов по короткому URL пользователя.
+func (storager *FileStorage) GetClickStats(ctx context.Context, shortURL string, userID int) (models.ClickStats, error) {
+	storager.clicksMu.Lock()
+	defer storager.clicksMu.Unlock()
+
+	counter, ok := storager.clicks[storage.URLMapKey{ShortURL: shortURL, UserID: userID}]
+	if !ok {
+		counter = storage.NewClickCounter()
+	}
+	return counter.Stats(shortURL), nil
 }
 
-// SaveUserID сохраняет идентификатор пользователя.
-func (storager *FileStorage) SaveUserID(userID int) {
-	storager.mu.Lock()
-	storager.usedUserIDs = append(storager.usedUserIDs, userID)
-	storager.mu.Unlock()
+// addClick учитывает один переход, вызывается под clicksMu.
+func (storager *FileStorage) addClick(click models.Click) {
+	key := storage.URLMapKey{ShortURL: click.ShortURL, UserID: click.UserID}
+	counter, ok := storager.clicks[key]
+	if !ok {
+		counter = storage.NewClickCounter()
+		storager.clicks[key] = counter
+	}
+	counter.Add(click)
 }
 
-// DeleteByUserID удаляет URL, принадлежащие определенному пользователю.
-func (storager *FileStorage) DeleteByUserID(ctx context.Context, shortURLs []string, userID int) error {
-	storager.mu.Lock()
-	for _, shortURL := range shortURLs {
-		originalSavedURL, ok := storager.findEntityByShortURL(shortURL)
-		if ok {
-			originalSavedURL.Deleted = true
-			storager.URLMap[storage.URLMapKey{ShortURL: shortURL, UserID: userID}] = originalSavedURL
-		}
+// readAllClicks восстанавливает статистику переходов из журнала переходов.
+// Переходы, записанные без владельца, отдаются владельцу, который сейчас обслуживает редирект.
+func (storager *FileStorage) readAllClicks() error {
+	file, err := os.Open(storager.filePath + clicksFileSuffix)
+	if err != nil {
+		return err
 	}
-	storager.mu.Unlock()
+	defer file.Close()
 
-	if storager.isWithFile {
-		// а что с файлом делать? Просто дописать?
-		logger.Log.Info("Update file")
-		filteredStore := []models.SavedURL{}
+	storager.mu.RLock()
+	defer storager.mu.RUnlock()
+	storager.clicksMu.Lock()
+	defer storager.clicksMu.Unlock()
 
-		storager.mu.RLock()
-		for _, shortURL := range shortURLs {
-			originalSavedURL, ok := storager.URLMap[storage.URLMapKey{ShortURL: shortURL, UserID: userID}]
-			if ok {
-				filteredStore = append(filteredStore, originalSavedURL)
-			}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var click models.Click
+		if err := storager.json.Unmarshal(scanner.Bytes(), &click); err != nil {
+			logger.Log.Error("Failed unmarshal click", zap.Error(err))
+			continue
 		}
-		storager.mu.RUnlock()
-
-		for _, savedURL := range filteredStore {
-			storager.Save(savedURL)
+		if click.UserID == 0 {
+			owner, _ := storager.owners.First(click.ShortURL)
+			click.UserID = owner.UserID
 		}
-		return nil
+		storager.addClick(click)
 	}
-	return nil
+
+	return scanner.Err()
+}
+
+// CountURLs возвращает количество неудаленных коротких URL.
+func (storager *FileStorage) CountURLs(ctx context.Context) (int, error) {
+	storager.mu.RLock()
+	defer storager.mu.RUnlock()
+
+	return storager.activeURLs, nil
+}
+
+// CountUsers возвращает количество зарегистрированных пользователей.
+func (storager *FileStorage) CountUsers(ctx context.Context) (int, error) {
+	return storager.users.Len(), nil
 }
 
 // PingContext проверяет соединение с хранилищем.
@@ -0,0 +1,252 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"go.uber.org/zap"
+)
+
+// RestoreURLs снимает пометку удаления с URL из keys, каждого только у его владельца.
+// Восстановленные URL дописываются в файл одной пачкой.
+func (storager *FileStorage) RestoreURLs(ctx context.Context, keys []storage.URLMapKey, now time.Time) ([]storage.URLMapKey, error) {
+	storager.writeMu.Lock()
+	defer storager.writeMu.Unlock()
+
+	restored := []models.SavedURL{}
+	restoredKeys := []storage.URLMapKey{}
+	inBatch := make(map[storage.URLMapKey]struct{}, len(keys))
+	storager.mu.RLock()
+	for _, key := range keys {
+		savedURL, ok := storager.URLMap[key]
+		if _, dup := inBatch[key]; !ok || dup || !savedURL.Deleted || savedURL.IsExpired(now) {
+			continue
+		}
+		inBatch[key] = struct{}{}
+		savedURL.Deleted = false
+		savedURL.DeletedAt = nil
+		restored = append(restored, savedURL)
+		restoredKeys = append(restoredKeys, key)
+	}
+	storager.mu.RUnlock()
+
+	if err := storager.apply(restored); err != nil {
+		return nil, err
+	}
+	return restoredKeys, nil
+}
+
+// PurgeURLs безвозвратно удаляет URL из keys вместе с историей изменений и переходами.
+// URL сразу убираются из памяти, а из файлов после их перезаписи, см. purge.
+func (storager *FileStorage) PurgeURLs(ctx context.Context, keys []storage.URLMapKey) ([]storage.URLMapKey, error) {
+	return storager.purge(ctx, func(savedURLs map[storage.URLMapKey]models.SavedURL) []storage.URLMapKey {
+		purged := []storage.URLMapKey{}
+		for _, key := range keys {
+			if _, ok := savedURLs[key]; ok {
+				purged = append(purged, key)
+			}
+		}
+		return purged
+	})
+}
+
+// PurgeDeleted безвозвратно удаляет URL, помеченные удаленными не позже before, вместе с историей изменений и переходами.
+func (storager *FileStorage) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	purged, err := storager.purge(ctx, func(savedURLs map[storage.URLMapKey]models.SavedURL) []storage.URLMapKey {
+		purged := []storage.URLMapKey{}
+		for key, savedURL := range savedURLs {
+			if savedURL.Deleted && savedURL.DeletedAt != nil && !savedURL.DeletedAt.After(before) {
+				purged = append(purged, key)
+			}
+		}
+		return purged
+	})
+	return len(purged), err
+}
+
+// purge удаляет из памяти URL, которые выбирает selectKeys, вместе с их переходами
+// и переписывает журналы изменений и переходов и файл хранилища без них.
+// Файл хранилища только дописывается, поэтому старые записи удаленных URL исчезают из него лишь при компактификации.
+// purge держит compactMu до конца перезаписи: компактификация, снимок которой снят до удаления из памяти,
+// вернула бы удаленные записи в файл.
+// Если перезапись не удалась, URL уже не видны, а из файла их уберет следующая компактификация.
+func (storager *FileStorage) purge(ctx context.Context, selectKeys func(map[storage.URLMapKey]models.SavedURL) []storage.URLMapKey) ([]storage.URLMapKey, error) {
+	storager.compactMu.Lock()
+	defer storager.compactMu.Unlock()
+
+	storager.writeMu.Lock()
+	storager.mu.Lock()
+	purged := selectKeys(storager.URLMap)
+	// переходы, записанные в журнал без владельца, учитывались у владельца, обслуживавшего редирект до удаления
+	redirectOwners := make(map[string]int, len(purged))
+	for _, key := range purged {
+		if owner, ok := storager.owners.First(key.ShortURL); ok {
+			redirectOwners[key.ShortURL] = owner.UserID
+		}
+	}
+	for _, key := range purged {
+		storager.remove(key)
+	}
+	var edits []models.URLEdit
+	if len(purged) != 0 {
+		edits = storager.edits.All()
+	}
+	storager.mu.Unlock()
+
+	if len(purged) == 0 {
+		storager.writeMu.Unlock()
+		return purged, nil
+	}
+	err := storager.purgeClicks(purged, redirectOwners)
+	if err == nil && storager.isWithFile {
+		// журнал изменений переписывается под writeMu, чтобы в него ничего не дописали между снимком и заменой
+		err = storager.rewriteEdits(edits)
+	}
+	storager.writeMu.Unlock()
+	if err != nil || !storager.isWithFile {
+		return purged, err
+	}
+
+	if err := storager.compact(ctx); err != nil {
+		return purged, err
+	}
+	logger.Log.Info("Urls are purged", zap.Int("count", len(purged)))
+	return purged, nil
+}
+
+// remove удаляет URL из URLMap, индексов и истории изменений, вызывается под mu.
+// Переходы по URL удаляются отдельно, см. purgeClicks.
+func (storager *FileStorage) remove(key storage.URLMapKey) {
+	savedURL := storager.URLMap[key]
+	if !savedURL.Deleted {
+		storager.activeURLs--
+	}
+	storager.unindexOriginalURL(savedURL)
+	delete(storager.URLMap, key)
+	storager.edits.Remove(key)
+	delete(storager.byUserID[key.UserID], key.ShortURL)
+	storager.owners.Remove(key)
+}
+
+// purgeClicks забывает переходы по URL из purged и переписывает без них журнал переходов.
+// Журнал переписывается под clicksMu, чтобы в него ничего не дописали между чтением и заменой.
+func (storager *FileStorage) purgeClicks(purged []storage.URLMapKey, redirectOwners map[string]int) error {
+	storager.clicksMu.Lock()
+	defer storager.clicksMu.Unlock()
+
+	purgedKeys := make(map[storage.URLMapKey]struct{}, len(purged))
+	for _, key := range purged {
+		delete(storager.clicks, key)
+		purgedKeys[key] = struct{}{}
+	}
+	if !storager.isWithFile {
+		return nil
+	}
+	return storager.rewriteClicks(purgedKeys, redirectOwners)
+}
+
+// rewriteClicks переписывает журнал переходов без переходов по URL из purged, вызывается под clicksMu.
+// Переходам по удаленным коротким URL без владельца проставляется владелец из redirectOwners,
+// чтобы после перезапуска они не достались следующему владельцу.
+func (storager *FileStorage) rewriteClicks(purged map[storage.URLMapKey]struct{}, redirectOwners map[string]int) error {
+	old, err := os.Open(storager.filePath + clicksFileSuffix)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		logger.Log.Error("Failed to open clicks file", zap.Error(err))
+		return err
+	}
+	defer old.Close()
+
+	return storager.replaceJournal(clicksFileSuffix, func(writer *bufio.Writer) error {
+		scanner := bufio.NewScanner(old)
+		for scanner.Scan() {
+			var click models.Click
+			if err := storager.json.Unmarshal(scanner.Bytes(), &click); err != nil {
+				// нечитаемые записи пропускаются и при чтении журнала
+				continue
+			}
+			if owner, ok := redirectOwners[click.ShortURL]; ok && click.UserID == 0 {
+				click.UserID = owner
+			}
+			if _, ok := purged[storage.URLMapKey{ShortURL: click.ShortURL, UserID: click.UserID}]; ok {
+				continue
+			}
+			if err := storager.writeRecord(writer, click); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	})
+}
+
+// rewriteEdits заменяет журнал изменений файлом, в котором есть только edits.
+func (storager *FileStorage) rewriteEdits(edits []models.URLEdit) error {
+	records := make([]interface{}, 0, len(edits))
+	for _, edit := range edits {
+		records = append(records, edit)
+	}
+	return storager.rewriteJournal(editsFileSuffix, records)
+}
+
+// rewriteJournal атомарно заменяет журнал с суффиксом suffix файлом, в котором есть только records.
+func (storager *FileStorage) rewriteJournal(suffix string, records []interface{}) error {
+	return storager.replaceJournal(suffix, func(writer *bufio.Writer) error {
+		for _, record := range records {
+			if err := storager.writeRecord(writer, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeRecord пишет запись журнала отдельной строкой.
+func (storager *FileStorage) writeRecord(writer *bufio.Writer, record interface{}) error {
+	recordJSON, err := storager.json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	writer.Write(recordJSON)
+	return writer.WriteByte('\n')
+}
+
+// replaceJournal атомарно заменяет журнал с суффиксом suffix файлом, содержимое которого пишет write.
+func (storager *FileStorage) replaceJournal(suffix string, write func(writer *bufio.Writer) error) error {
+	path := storager.filePath + suffix
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+compactionSuffix)
+	if err != nil {
+		logger.Log.Error("Failed to create temp file for journal", zap.String("journal", suffix), zap.Error(err))
+		return err
+	}
+	// после успешного переименования удалять уже нечего, ошибка игнорируется
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	writer := bufio.NewWriter(tmp)
+	if err := write(writer); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		logger.Log.Error("Failed to write journal", zap.String("journal", suffix), zap.Error(err))
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		logger.Log.Error("Failed to replace journal", zap.String("journal", suffix), zap.Error(err))
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
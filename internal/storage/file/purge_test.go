@@ -0,0 +1,73 @@
+package file
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+)
+
+func TestPurgeSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), "storage.json")
+	now := time.Now()
+
+	storager := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	for _, shortURL := range []string{"a", "b", "c"} {
+		_, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: shortURL, OriginalURL: "https://example.com/" + shortURL, UserID: 1})
+		require.NoError(t, err)
+	}
+	_, err := storager.UpdateURL(ctx, models.URLEdit{ShortURL: "a", UserID: 1, OriginalURL: "https://example.com/a2", EditedAt: now}, 0)
+	require.NoError(t, err)
+	_, err = storager.UpdateURL(ctx, models.URLEdit{ShortURL: "b", UserID: 1, OriginalURL: "https://example.com/b2", EditedAt: now}, 0)
+	require.NoError(t, err)
+	require.NoError(t, storager.DeleteByUserID(ctx, []string{"a", "c"}, 1))
+	// переход без владельца записан до того, как переходы стали учитываться по владельцам
+	require.NoError(t, storager.StoreClicks(ctx, []models.Click{
+		{ShortURL: "a", UserID: 1, Timestamp: now, IPHash: "x"},
+		{ShortURL: "a", Timestamp: now, IPHash: "y"},
+		{ShortURL: "b", UserID: 1, Timestamp: now, IPHash: "x"},
+	}))
+
+	purged, err := storager.PurgeURLs(ctx, []storage.URLMapKey{{ShortURL: "a", UserID: 1}})
+	require.NoError(t, err)
+	require.Len(t, purged, 1)
+	assert.Equal(t, 2, countLines(t, fname), "После удаления навсегда файл должен быть компактифицирован")
+	assert.Equal(t, 1, countLines(t, fname+editsFileSuffix), "История удаленного URL должна исчезнуть из журнала")
+	assert.Equal(t, 1, countLines(t, fname+clicksFileSuffix), "Переходы удаленного URL должны исчезнуть из журнала")
+	require.NoError(t, storager.Close())
+
+	reopened := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	_, ok, err := reopened.GetURLForUserID(ctx, "a", 1)
+	require.NoError(t, err)
+	assert.False(t, ok, "Удаленный навсегда URL не должен вернуться после перезапуска")
+	edits, err := reopened.ListURLEdits(ctx, "b", 1)
+	require.NoError(t, err)
+	assert.Len(t, edits, 1)
+	stats, err := reopened.GetClickStats(ctx, "b", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.TotalClicks)
+
+	// удаленный до перезапуска URL сохраняет время удаления
+	savedURL, ok, err := reopened.GetURLForUserID(ctx, "c", 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NotNil(t, savedURL.DeletedAt)
+
+	// новый URL не должен получить UUID уже существующего
+	_, err = reopened.StoreURL(ctx, models.SavedURL{ShortURL: "d", OriginalURL: "https://example.com/d", UserID: 1})
+	require.NoError(t, err)
+	uuids := make(map[int]string)
+	urls, err := reopened.ReadAllDataForUserID(ctx, 1, models.URLQuery{})
+	require.NoError(t, err)
+	for _, savedURL := range urls {
+		assert.NotContains(t, uuids, savedURL.UUID, "UUID %s совпадает с UUID %s", savedURL.ShortURL, uuids[savedURL.UUID])
+		uuids[savedURL.UUID] = savedURL.ShortURL
+	}
+	require.NoError(t, reopened.Close())
+}
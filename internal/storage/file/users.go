@@ -0,0 +1,109 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"go.uber.org/zap"
+)
+
+// usersFileSuffix суффикс файла с журналом пользователей рядом с основным файлом.
+// Каждый новый пользователь дописывается полной записью, при чтении действует последняя запись с тем же id.
+// Время последней активности в журнал не дописывается, журнал переписывается целиком при компактификации и в Close.
+const usersFileSuffix = ".users"
+
+// CreateUser регистрирует пользователя со следующим свободным идентификатором и дописывает его в журнал.
+func (storager *FileStorage) CreateUser(ctx context.Context, now time.Time) (models.User, error) {
+	user := models.User{ID: storager.users.Allocate(), CreatedAt: now, LastSeen: now}
+	if err := storager.putUser(user); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// GetUser возвращает зарегистрированного пользователя.
+func (storager *FileStorage) GetUser(ctx context.Context, userID int) (models.User, bool, error) {
+	user, ok := storager.users.Get(userID)
+	return user, ok, nil
+}
+
+// TouchUser запоминает время последней активности пользователя в памяти.
+// На диск оно попадает при следующей компактификации или в Close, поэтому после падения может откатиться назад.
+func (storager *FileStorage) TouchUser(ctx context.Context, userID int, now time.Time) error {
+	storager.usersMu.Lock()
+	defer storager.usersMu.Unlock()
+
+	if _, ok := storager.users.Touch(userID, now); ok {
+		storager.usersDirty = true
+	}
+	return nil
+}
+
+// putUser сначала дописывает пользователя в журнал, затем обновляет реестр.
+func (storager *FileStorage) putUser(user models.User) error {
+	storager.usersMu.Lock()
+	defer storager.usersMu.Unlock()
+
+	if storager.isWithFile {
+		if err := storager.appendJournal(usersFileSuffix, user); err != nil {
+			logger.Log.Error("Failed to write users file", zap.Error(err))
+			return err
+		}
+	}
+	storager.users.Put(user)
+	return nil
+}
+
+// compactUsers переписывает журнал пользователей по реестру, если с прошлой перезаписи менялось время активности.
+// usersMu держится до замены файла, чтобы новый пользователь не попал в уже замененный журнал.
+func (storager *FileStorage) compactUsers() error {
+	if !storager.isWithFile {
+		return nil
+	}
+
+	storager.usersMu.Lock()
+	defer storager.usersMu.Unlock()
+
+	if !storager.usersDirty {
+		return nil
+	}
+	users := storager.users.All()
+	records := make([]interface{}, 0, len(users))
+	for _, user := range users {
+		records = append(records, user)
+	}
+	if err := storager.rewriteJournal(usersFileSuffix, records); err != nil {
+		logger.Log.Error("Failed to compact users file", zap.Error(err))
+		return err
+	}
+	storager.usersDirty = false
+	return nil
+}
+
+// readAllUsers восстанавливает пользователей из журнала пользователей.
+func (storager *FileStorage) readAllUsers() error {
+	file, err := os.Open(storager.filePath + usersFileSuffix)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	storager.usersMu.Lock()
+	defer storager.usersMu.Unlock()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var user models.User
+		if err := storager.json.Unmarshal(scanner.Bytes(), &user); err != nil {
+			logger.Log.Error("Failed unmarshal user", zap.Error(err))
+			continue
+		}
+		storager.users.Put(user)
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,88 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+)
+
+func TestUsersSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), "storage.json")
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	storager := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	// пользователь, который еще ничего не сохранил
+	idle, err := storager.CreateUser(ctx, created)
+	require.NoError(t, err)
+	active, err := storager.CreateUser(ctx, created)
+	require.NoError(t, err)
+	require.NoError(t, storager.TouchUser(ctx, active.ID, created.Add(time.Hour)))
+	// владелец URL без записи в журнале пользователей, как в файлах, записанных до журнала
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "legacy", OriginalURL: "http://legacy.example", UserID: 10})
+	require.NoError(t, err)
+	require.NoError(t, storager.Close())
+
+	reopened := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	defer reopened.Close()
+
+	user, ok, err := reopened.GetUser(ctx, idle.ID)
+	require.NoError(t, err)
+	require.True(t, ok, "Пользователь без URL должен остаться после перезапуска")
+	assert.True(t, created.Equal(user.CreatedAt))
+
+	user, ok, err = reopened.GetUser(ctx, active.ID)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.True(t, created.Add(time.Hour).Equal(user.LastSeen))
+
+	_, ok, err = reopened.GetUser(ctx, 10)
+	require.NoError(t, err)
+	assert.True(t, ok, "Владелец URL должен считаться пользователем")
+
+	next, err := reopened.CreateUser(ctx, created)
+	require.NoError(t, err)
+	assert.Equal(t, 11, next.ID, "Новый идентификатор должен быть больше всех известных")
+
+	count, err := reopened.CountUsers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+}
+
+func TestTouchUserDoesNotGrowJournal(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), "storage.json")
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	storager := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	user, err := storager.CreateUser(ctx, created)
+	require.NoError(t, err)
+	for i := 1; i <= 100; i++ {
+		require.NoError(t, storager.TouchUser(ctx, user.ID, created.Add(time.Duration(i)*time.Minute)))
+	}
+	data, err := os.ReadFile(fname + usersFileSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"), "Время активности не должно дописываться в журнал")
+
+	// компактификация переписывает журнал с последним временем активности
+	require.NoError(t, storager.Compact(ctx))
+	reopened := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	loaded, ok, err := reopened.GetUser(ctx, user.ID)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.True(t, created.Add(100*time.Minute).Equal(loaded.LastSeen))
+	require.NoError(t, reopened.Close())
+
+	data, err = os.ReadFile(fname + usersFileSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"), "После перезаписи в журнале должна остаться одна запись на пользователя")
+	require.NoError(t, storager.Close())
+}
@@ -0,0 +1,223 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"go.uber.org/zap"
+)
+
+// SyncPolicy определяет, когда записи в файле сбрасываются на диск через fsync.
+type SyncPolicy string
+
+const (
+	// SyncAlways вызывает fsync после каждой записи, запись подтверждается только после попадания на диск.
+	SyncAlways SyncPolicy = "always"
+	// SyncBatch вызывает fsync в фоне не чаще, чем раз в заданный интервал.
+	SyncBatch SyncPolicy = "batch"
+	// SyncNever оставляет сброс на диск операционной системе.
+	SyncNever SyncPolicy = "never"
+)
+
+// walFile дескриптор файла хранилища, открытый для дописывания. В тестах подменяется, чтобы имитировать сбои записи.
+type walFile interface {
+	Write(p []byte) (int, error)
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+// DefaultSyncInterval интервал fsync для SyncBatch по умолчанию.
+const DefaultSyncInterval = 100 * time.Millisecond
+
+// ParseSyncPolicy разбирает политику fsync из строки конфигурации.
+func ParseSyncPolicy(value string) (SyncPolicy, error) {
+	switch SyncPolicy(value) {
+	case SyncAlways, SyncBatch, SyncNever:
+		return SyncPolicy(value), nil
+	default:
+		return "", fmt.Errorf("unknown fsync policy: %s", value)
+	}
+}
+
+// SetSyncPolicy задает политику fsync. Для SyncBatch запускает фоновый сброс раз в interval,
+// который останавливается в Close.
+func (storager *FileStorage) SetSyncPolicy(policy SyncPolicy, interval time.Duration) {
+	storager.fileMu.Lock()
+	defer storager.fileMu.Unlock()
+
+	if storager.syncStop != nil {
+		close(storager.syncStop)
+		storager.syncStop = nil
+	}
+	storager.syncPolicy = policy
+
+	if policy == SyncBatch {
+		if interval <= 0 {
+			interval = DefaultSyncInterval
+		}
+		storager.syncStop = make(chan struct{})
+		go storager.syncLoop(interval, storager.syncStop)
+	}
+}
+
+// syncLoop раз в interval сбрасывает на диск записанные, но еще не сброшенные данные.
+func (storager *FileStorage) syncLoop(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			storager.fileMu.Lock()
+			if storager.dirty && storager.file != nil {
+				if err := storager.file.Sync(); err != nil {
+					logger.Log.Error("Failed to sync file", zap.Error(err))
+				} else {
+					storager.dirty = false
+				}
+			}
+			storager.fileMu.Unlock()
+		}
+	}
+}
+
+// Save сохраняет URL в файл.
+func (storager *FileStorage) Save(savedURL models.SavedURL) error {
+	return storager.saveBatch([]models.SavedURL{savedURL})
+}
+
+// saveBatch дописывает записи в файл одним вызовом write и сбрасывает их на диск согласно политике fsync.
+// Запись в файл должна происходить до изменения данных в памяти: если она не удалась, изменение не применяется.
+func (storager *FileStorage) saveBatch(savedURLs []models.SavedURL) error {
+	if len(savedURLs) == 0 {
+		return nil
+	}
+
+	var data []byte
+	for _, savedURL := range savedURLs {
+		savedURLJSON, err := storager.json.Marshal(savedURL)
+		if err != nil {
+			logger.Log.Error("Failed to marshal new data", zap.Error(err))
+			return err
+		}
+		data = append(data, savedURLJSON...)
+		data = append(data, '\n')
+	}
+
+	storager.fileMu.Lock()
+	defer storager.fileMu.Unlock()
+
+	if err := storager.openFile(); err != nil {
+		return err
+	}
+
+	if _, err := storager.file.Write(data); err != nil {
+		logger.Log.Error("Failed to write to file", zap.Error(err))
+		return storager.rollbackWrite(err)
+	}
+	if storager.syncPolicy == SyncAlways {
+		if err := storager.file.Sync(); err != nil {
+			logger.Log.Error("Failed to sync file", zap.Error(err))
+			return storager.rollbackWrite(err)
+		}
+	}
+	storager.fileSize += int64(len(data))
+	storager.recordsWritten += len(savedURLs)
+	if storager.syncPolicy == SyncBatch {
+		storager.dirty = true
+	}
+
+	for _, savedURL := range savedURLs {
+		logger.Log.Info("Write new data to file", zap.Int("UUID", savedURL.UUID), zap.String("OriginalURL", savedURL.OriginalURL), zap.String("ShortURL", savedURL.ShortURL), zap.Int("UserID", savedURL.UserID))
+	}
+	return nil
+}
+
+// rollbackWrite отрезает от файла все, что попало в него при неудавшейся записи, и возвращает err.
+// Иначе следующая запись легла бы сразу за оборванной, и при чтении файла пропала бы вместе с ней.
+// Если отрезать не удалось, дескриптор закрывается: следующая запись откроет файл заново
+// и сначала отрежет хвост, об этом помнит tornTail, см. openFile. Вызывается под fileMu.
+func (storager *FileStorage) rollbackWrite(err error) error {
+	if truncErr := storager.file.Truncate(storager.fileSize); truncErr != nil {
+		logger.Log.Error("Failed to truncate file after failed write", zap.Int64("size", storager.fileSize), zap.Error(truncErr))
+		storager.file.Close()
+		storager.file = nil
+		storager.dirty = false
+		storager.tornTail = true
+	}
+	return err
+}
+
+// openFile открывает долгоживущий дескриптор файла для дописывания, вызывается под fileMu.
+// Если после неудавшейся записи остался хвост, он отрезается, иначе fileSize берется из размера файла.
+func (storager *FileStorage) openFile() error {
+	if storager.file != nil {
+		return nil
+	}
+	file, err := os.OpenFile(storager.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.Log.Error("Failed to open file for writing", zap.Error(err))
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		logger.Log.Error("Failed to stat file", zap.Error(err))
+		return err
+	}
+	if storager.tornTail && info.Size() > storager.fileSize {
+		if err := file.Truncate(storager.fileSize); err != nil {
+			file.Close()
+			logger.Log.Error("Failed to truncate file after failed write", zap.Int64("size", storager.fileSize), zap.Error(err))
+			return err
+		}
+	} else {
+		storager.fileSize = info.Size()
+	}
+	storager.tornTail = false
+	storager.file = file
+	return nil
+}
+
+// closeFile сбрасывает на диск и закрывает дескриптор файла, вызывается под fileMu.
+func (storager *FileStorage) closeFile() error {
+	if storager.file == nil {
+		return nil
+	}
+	syncErr := storager.file.Sync()
+	closeErr := storager.file.Close()
+	storager.file = nil
+	storager.dirty = false
+	if syncErr != nil {
+		return syncErr
+	}
+	return closeErr
+}
+
+// Close останавливает фоновый fsync, сбрасывает данные на диск и закрывает файл.
+// Перед закрытием переписывает журналы пользователей и ключей API, чтобы сохранить время их активности и использования.
+func (storager *FileStorage) Close() error {
+	usersErr := storager.compactUsers()
+	apiKeysErr := storager.compactAPIKeys()
+
+	storager.fileMu.Lock()
+	defer storager.fileMu.Unlock()
+
+	if storager.syncStop != nil {
+		close(storager.syncStop)
+		storager.syncStop = nil
+	}
+	if err := storager.closeFile(); err != nil {
+		return err
+	}
+	if usersErr != nil {
+		return usersErr
+	}
+	return apiKeysErr
+}
@@ -0,0 +1,152 @@
+package file
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+)
+
+func TestTornTailIsTruncated(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), "storage.json")
+	storager := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	for _, shortURL := range []string{"a", "b"} {
+		_, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: shortURL, OriginalURL: "https://example.com/" + shortURL, UserID: 1})
+		require.NoError(t, err)
+	}
+	require.NoError(t, storager.Close())
+
+	info, err := os.Stat(fname)
+	require.NoError(t, err)
+	goodSize := info.Size()
+
+	// имитируем падение посреди записи: в конце файла оборванная строка
+	file, err := os.OpenFile(fname, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"uuid":2,"short_url":"c","origi`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	reloaded := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	urls, err := reloaded.ReadAllDataForUserID(ctx, 1, models.URLQuery{})
+	require.NoError(t, err)
+	assert.Len(t, urls, 2, "Оборванная запись не должна попасть в хранилище")
+	_, ok, err := reloaded.GetURLForAnyUserID(ctx, "")
+	require.NoError(t, err)
+	assert.False(t, ok, "Не должно появиться пустой записи")
+
+	info, err = os.Stat(fname)
+	require.NoError(t, err)
+	assert.Equal(t, goodSize, info.Size(), "Оборванная запись должна быть отрезана")
+
+	// новая запись после восстановления читается целиком
+	_, err = reloaded.StoreURL(ctx, models.SavedURL{ShortURL: "c", OriginalURL: "https://example.com/c", UserID: 1})
+	require.NoError(t, err)
+	require.NoError(t, reloaded.Close())
+	assert.Equal(t, 3, countLines(t, fname))
+
+	again := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	urls, err = again.ReadAllDataForUserID(ctx, 1, models.URLQuery{})
+	require.NoError(t, err)
+	assert.Len(t, urls, 3)
+}
+
+func TestCountURLsAfterReplay(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), "storage.json")
+	storager := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	for _, shortURL := range []string{"a", "b", "c"} {
+		_, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: shortURL, OriginalURL: "https://example.com/" + shortURL, UserID: 1})
+		require.NoError(t, err)
+	}
+	require.NoError(t, storager.DeleteByUserID(ctx, []string{"b", "c"}, 1))
+	_, err := storager.RestoreURLs(ctx, []storage.URLMapKey{{ShortURL: "c", UserID: 1}}, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, storager.Close())
+
+	// в файле у b и c по нескольку версий, считаться должна только последняя
+	reloaded := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	count, err := reloaded.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+// partialFile записывает только половину первой записи и возвращает ошибку, как при нехватке места на диске.
+type partialFile struct {
+	*os.File
+	failed bool
+}
+
+func (file *partialFile) Write(p []byte) (int, error) {
+	if file.failed {
+		return file.File.Write(p)
+	}
+	file.failed = true
+	n, _ := file.File.Write(p[:len(p)/2])
+	return n, io.ErrShortWrite
+}
+
+func TestPartialWriteIsRolledBack(t *testing.T) {
+	ctx := context.Background()
+	fname := filepath.Join(t.TempDir(), "storage.json")
+	storager := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	_, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: "a", OriginalURL: "https://example.com/a", UserID: 1})
+	require.NoError(t, err)
+
+	storager.fileMu.Lock()
+	storager.file = &partialFile{File: storager.file.(*os.File)}
+	storager.fileMu.Unlock()
+
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "b", OriginalURL: "https://example.com/b", UserID: 1})
+	require.ErrorIs(t, err, io.ErrShortWrite)
+	// следующая запись должна лечь сразу за последней целой, а не за оборванной
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "c", OriginalURL: "https://example.com/c", UserID: 1})
+	require.NoError(t, err)
+	require.NoError(t, storager.Close())
+	assert.Equal(t, 2, countLines(t, fname), "Оборванная запись не должна остаться в файле")
+
+	reloaded := NewFileStorage(fname, true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL), ctx)
+	urls, err := reloaded.ReadAllDataForUserID(ctx, 1, models.URLQuery{})
+	require.NoError(t, err)
+	var shortURLs []string
+	for _, savedURL := range urls {
+		shortURLs = append(shortURLs, savedURL.ShortURL)
+	}
+	assert.ElementsMatch(t, []string{"a", "c"}, shortURLs, "Подтвержденная запись после неудавшейся не должна потеряться")
+}
+
+func TestWriteErrorIsReturned(t *testing.T) {
+	ctx := context.Background()
+	// путь к файлу указывает на директорию, открыть его для записи нельзя
+	storager := NewFileStoragerWithoutReadingData(t.TempDir(), true /*isWithFile*/, make(map[storage.URLMapKey]models.SavedURL))
+
+	_, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: "a", OriginalURL: "https://example.com/a", UserID: 1})
+	require.Error(t, err)
+	_, ok, err := storager.GetURLForAnyUserID(ctx, "a")
+	require.NoError(t, err)
+	assert.False(t, ok, "URL не должен сохраниться, если запись в файл не удалась")
+
+	_, err = storager.StoreURLBatch(ctx, []models.SavedURL{{ShortURL: "b", OriginalURL: "https://example.com/b"}}, 1)
+	require.Error(t, err)
+	count, err := storager.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, count, "Несохраненные URL не должны считаться")
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	for _, value := range []string{"always", "batch", "never"} {
+		policy, err := ParseSyncPolicy(value)
+		require.NoError(t, err)
+		assert.Equal(t, SyncPolicy(value), policy)
+	}
+	_, err := ParseSyncPolicy("sometimes")
+	assert.Error(t, err)
+}
@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/theheadmen/urlShort/internal/storage"
+	"github.com/theheadmen/urlShort/internal/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		return NewMemoryStorage()
+	})
+}
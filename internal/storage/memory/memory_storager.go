@@ -0,0 +1,444 @@
+// Package memory предоставляет реализацию хранилища данных, которая хранит все данные только в памяти процесса.
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"go.uber.org/zap"
+)
+
+// MemoryStorage реализует интерфейс Storage для хранения данных в памяти.
+// Поиск по короткому URL и по пользователю выполняется за O(1) через вторичные индексы.
+type MemoryStorage struct {
+	mu       sync.RWMutex
+	urls     map[storage.URLMapKey]models.SavedURL
+	owners   *storage.OwnerIndex
+	byUserID map[int]map[string]struct{}
+	// byOriginalURL короткий URL каждого полного URL пользователя
+	byOriginalURL map[int]map[string]string
+	lastUUID      int
+	clicks        map[storage.URLMapKey]*storage.ClickCounter
+	apiKeys       *storage.APIKeyIndex
+	users         *storage.UserRegistry
+	edits         *storage.EditLog
+	// activeURLs количество неудаленных URL, обновляется при каждом изменении, см. CountURLs
+	activeURLs int
+	// codeCounter последнее зарезервированное значение счетчика коротких кодов
+	codeCounter uint64
+}
+
+// NewMemoryStorage создает новый пустой экземпляр MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		urls:          make(map[storage.URLMapKey]models.SavedURL),
+		owners:        storage.NewOwnerIndex(),
+		byUserID:      make(map[int]map[string]struct{}),
+		byOriginalURL: make(map[int]map[string]string),
+		clicks:        make(map[storage.URLMapKey]*storage.ClickCounter),
+		apiKeys:       storage.NewAPIKeyIndex(),
+		users:         storage.NewUserRegistry(),
+		edits:         storage.NewEditLog(),
+	}
+}
+
+// ReadAllData ничего не делает, так как данные живут только в памяти.
+func (storager *MemoryStorage) ReadAllData(ctx context.Context) error {
+	return nil
+}
+
+// ReadAllDataForUserID возвращает страницу URL определенного пользователя.
+func (storager *MemoryStorage) ReadAllDataForUserID(ctx context.Context, userID int, query models.URLQuery) ([]models.SavedURL, error) {
+	storager.mu.RLock()
+	defer storager.mu.RUnlock()
+
+	shortURLs := storager.byUserID[userID]
+	result := make([]models.SavedURL, 0, len(shortURLs))
+	for shortURL := range shortURLs {
+		result = append(result, storager.urls[storage.URLMapKey{ShortURL: shortURL, UserID: userID}])
+	}
+	return storage.SelectPage(result, query), nil
+}
+
+// StoreURL сохраняет URL в памяти.
+func (storager *MemoryStorage) StoreURL(ctx context.Context, savedURL models.SavedURL) (bool, error) {
+	storager.mu.Lock()
+	defer storager.mu.Unlock()
+
+	if storager.aliasTaken(savedURL) {
+		return false, storage.ErrAliasTaken
+	}
+	if _, ok := storager.urls[storage.URLMapKey{ShortURL: savedURL.ShortURL, UserID: savedURL.UserID}]; ok {
+		logger.Log.Info("We already have data for this url", zap.String("OriginalURL", savedURL.OriginalURL), zap.String("ShortURL", savedURL.ShortURL), zap.Bool("Deleted", false))
+		return true, nil
+	}
+	if shortURL, ok := storager.byOriginalURL[savedURL.UserID][savedURL.OriginalURL]; ok {
+		return false, &storage.DuplicateURLError{ShortURL: shortURL}
+	}
+
+	savedURL.Deleted = false
+	storager.insert(savedURL)
+	return false, nil
+}
+
+// StoreURLBatch сохраняет несколько URL в памяти, пропуская уже сохраненные.
+func (storager *MemoryStorage) StoreURLBatch(ctx context.Context, forStore []models.SavedURL, userID int) ([]storage.BatchResult, error) {
+	storager.mu.Lock()
+	defer storager.mu.Unlock()
+
+	results := make([]storage.BatchResult, len(forStore))
+	for i, savedURL := range forStore {
+		results[i] = storage.BatchResult{ShortURL: savedURL.ShortURL, Existed: true}
+		savedURL.UserID = userID
+		// владельцы обновляются при каждой вставке, поэтому код, занятый раньше в этой же пачке, тоже найдется
+		if storager.aliasTaken(savedURL) {
+			results[i].AliasTaken = true
+			continue
+		}
+		if _, ok := storager.urls[storage.URLMapKey{ShortURL: savedURL.ShortURL, UserID: userID}]; ok {
+			logger.Log.Info("We already have data for this url", zap.String("OriginalURL", savedURL.OriginalURL), zap.String("ShortURL", savedURL.ShortURL), zap.Int("UserID", userID), zap.Bool("Deleted", savedURL.Deleted))
+			continue
+		}
+		// индекс обновляется при каждой вставке, поэтому повтор полного URL в этой же пачке тоже найдется
+		if shortURL, ok := storager.byOriginalURL[userID][savedURL.OriginalURL]; ok {
+			results[i].ShortURL = shortURL
+			continue
+		}
+		storager.insert(savedURL)
+		results[i].Existed = false
+	}
+	return results, nil
+}
+
+// aliasTaken проверяет, занят ли пользовательский код savedURL, вызывается под mu.
+// Для сгенерированных кодов всегда возвращает false: их могут делить несколько пользователей.
+func (storager *MemoryStorage) aliasTaken(savedURL models.SavedURL) bool {
+	if !savedURL.Alias {
+		return false
+	}
+	key, ok := storager.owners.First(savedURL.ShortURL)
+	return ok && storage.AliasTaken(storager.urls[key], savedURL)
+}
+
+// insert добавляет URL и обновляет индексы, вызывается под mu.
+func (storager *MemoryStorage) insert(savedURL models.SavedURL) {
+	storager.lastUUID++
+	savedURL.UUID = storager.lastUUID
+	savedURL.Alias = false
+
+	key := storage.URLMapKey{ShortURL: savedURL.ShortURL, UserID: savedURL.UserID}
+	storager.urls[key] = savedURL
+	if !savedURL.Deleted {
+		storager.activeURLs++
+	}
+	storager.owners.Add(key, savedURL.UUID)
+	shortURLs, ok := storager.byUserID[savedURL.UserID]
+	if !ok {
+		shortURLs = make(map[string]struct{})
+		storager.byUserID[savedURL.UserID] = shortURLs
+	}
+	shortURLs[savedURL.ShortURL] = struct{}{}
+	storager.indexOriginalURL(savedURL.UserID, savedURL.OriginalURL, savedURL.ShortURL)
+	// владелец URL всегда зарегистрированный пользователь
+	storager.users.Ensure(savedURL.UserID, time.Now().UTC())
+}
+
+// indexOriginalURL запоминает короткий URL полного URL пользователя, вызывается под mu.
+func (storager *MemoryStorage) indexOriginalURL(userID int, originalURL string, shortURL string) {
+	originalURLs, ok := storager.byOriginalURL[userID]
+	if !ok {
+		originalURLs = make(map[string]string)
+		storager.byOriginalURL[userID] = originalURLs
+	}
+	originalURLs[originalURL] = shortURL
+}
+
+// DeleteByUserID помечает удаленными URL, принадлежащие определенному пользователю.
+func (storager *MemoryStorage) DeleteByUserID(ctx context.Context, shortURLs []string, userID int) error {
+	_, err := storager.DeleteURLs(ctx, storage.KeysForUser(shortURLs, userID))
+	return err
+}
+
+// DeleteURLs помечает удаленными URL из keys, каждый только у его владельца.
+func (storager *MemoryStorage) DeleteURLs(ctx context.Context, keys []storage.URLMapKey) ([]storage.URLMapKey, error) {
+	storager.mu.Lock()
+	defer storager.mu.Unlock()
+
+	now := time.Now().UTC()
+	deleted := []storage.URLMapKey{}
+	for _, key := range keys {
+		if savedURL, ok := storager.urls[key]; ok && !savedURL.Deleted {
+			savedURL.Deleted = true
+			savedURL.DeletedAt = &now
+			storager.urls[key] = savedURL
+			storager.activeURLs--
+			deleted = append(deleted, key)
+		}
+	}
+	return deleted, nil
+}
+
+// UpdateURL меняет полный URL короткой ссылки пользователя и записывает изменение в историю.
+func (storager *MemoryStorage) UpdateURL(ctx context.Context, edit models.URLEdit, version int) (models.SavedURL, error) {
+	storager.mu.Lock()
+	defer storager.mu.Unlock()
+
+	key := storage.URLMapKey{ShortURL: edit.ShortURL, UserID: edit.UserID}
+	current, found := storager.urls[key]
+	updated, edit, changed, err := storage.PrepareEdit(current, found, edit, version)
+	if err != nil || !changed {
+		return updated, err
+	}
+	if storager.owners.Count(edit.ShortURL) > 1 {
+		return models.SavedURL{}, storage.ErrSharedURL
+	}
+	if _, ok := storager.byOriginalURL[edit.UserID][edit.OriginalURL]; ok {
+		return models.SavedURL{}, storage.ErrDuplicateURL
+	}
+
+	delete(storager.byOriginalURL[edit.UserID], current.OriginalURL)
+	storager.indexOriginalURL(edit.UserID, edit.OriginalURL, edit.ShortURL)
+	storager.urls[key] = updated
+	storager.edits.Add(edit)
+	return updated, nil
+}
+
+// ListURLEdits возвращает историю изменений короткого URL пользователя.
+func (storager *MemoryStorage) ListURLEdits(ctx context.Context, shortURL string, userID int) ([]models.URLEdit, error) {
+	storager.mu.RLock()
+	defer storager.mu.RUnlock()
+
+	key := storage.URLMapKey{ShortURL: shortURL, UserID: userID}
+	return storager.edits.List(key, storager.urls[key].Version), nil
+}
+
+// RestoreURLs снимает пометку удаления с URL из keys, каждого только у его владельца.
+func (storager *MemoryStorage) RestoreURLs(ctx context.Context, keys []storage.URLMapKey, now time.Time) ([]storage.URLMapKey, error) {
+	storager.mu.Lock()
+	defer storager.mu.Unlock()
+
+	restored := []storage.URLMapKey{}
+	for _, key := range keys {
+		if savedURL, ok := storager.urls[key]; ok && savedURL.Deleted && !savedURL.IsExpired(now) {
+			savedURL.Deleted = false
+			savedURL.DeletedAt = nil
+			storager.urls[key] = savedURL
+			storager.activeURLs++
+			restored = append(restored, key)
+		}
+	}
+	return restored, nil
+}
+
+// PurgeURLs безвозвратно удаляет URL из keys вместе с историей изменений и переходами.
+func (storager *MemoryStorage) PurgeURLs(ctx context.Context, keys []storage.URLMapKey) ([]storage.URLMapKey, error) {
+	storager.mu.Lock()
+	defer storager.mu.Unlock()
+
+	purged := []storage.URLMapKey{}
+	for _, key := range keys {
+		if _, ok := storager.urls[key]; ok {
+			storager.remove(key)
+			purged = append(purged, key)
+		}
+	}
+	return purged, nil
+}
+
+// PurgeDeleted безвозвратно удаляет URL, помеченные удаленными не позже before, вместе с историей изменений и переходами.
+func (storager *MemoryStorage) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	storager.mu.Lock()
+	defer storager.mu.Unlock()
+
+	count := 0
+	for key, savedURL := range storager.urls {
+		if savedURL.Deleted && savedURL.DeletedAt != nil && !savedURL.DeletedAt.After(before) {
+			storager.remove(key)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// remove удаляет URL из индексов вместе с историей изменений и переходами, вызывается под mu.
+func (storager *MemoryStorage) remove(key storage.URLMapKey) {
+	savedURL := storager.urls[key]
+	if !savedURL.Deleted {
+		storager.activeURLs--
+	}
+	delete(storager.byOriginalURL[key.UserID], savedURL.OriginalURL)
+	delete(storager.urls, key)
+	storager.edits.Remove(key)
+	delete(storager.byUserID[key.UserID], key.ShortURL)
+	storager.owners.Remove(key)
+	delete(storager.clicks, key)
+}
+
+// DeleteExpired помечает удаленными все URL, срок действия которых истек к моменту now.
+func (storager *MemoryStorage) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	storager.mu.Lock()
+	defer storager.mu.Unlock()
+
+	count := 0
+	for key, savedURL := range storager.urls {
+		if !savedURL.Deleted && savedURL.IsExpired(now) {
+			savedURL.Deleted = true
+			savedURL.DeletedAt = &now
+			storager.urls[key] = savedURL
+			storager.activeURLs--
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetURLForAnyUserID возвращает URL, независимо от пользователя.
+func (storager *MemoryStorage) GetURLForAnyUserID(ctx context.Context, shortURL string) (models.SavedURL, bool, error) {
+	storager.mu.RLock()
+	defer storager.mu.RUnlock()
+
+	key, ok := storager.owners.First(shortURL)
+	if !ok {
+		return models.SavedURL{}, false, nil
+	}
+	return storager.urls[key], true, nil
+}
+
+// GetURLForUserID возвращает URL, принадлежащий определенному пользователю.
+func (storager *MemoryStorage) GetURLForUserID(ctx context.Context, shortURL string, userID int) (models.SavedURL, bool, error) {
+	storager.mu.RLock()
+	defer storager.mu.RUnlock()
+
+	savedURL, ok := storager.urls[storage.URLMapKey{ShortURL: shortURL, UserID: userID}]
+	return savedURL, ok, nil
+}
+
+// StoreClicks учитывает переходы в памяти.
+func (storager *MemoryStorage) StoreClicks(ctx context.Context, clicks []models.Click) error {
+	storager.mu.Lock()
+	defer storager.mu.Unlock()
+
+	for _, click := range clicks {
+		key := storage.URLMapKey{ShortURL: click.ShortURL, UserID: click.UserID}
+		counter, ok := storager.clicks[key]
+		if !ok {
+			counter = storage.NewClickCounter()
+			storager.clicks[key] = counter
+		}
+		counter.Add(click)
+	}
+	return nil
+}
+
+// GetClickStats возвращает статистику переходов по короткому URL пользователя.
+func (storager *MemoryStorage) GetClickStats(ctx context.Context, shortURL string, userID int) (models.ClickStats, error) {
+	storager.mu.RLock()
+	defer storager.mu.RUnlock()
+
+	counter, ok := storager.clicks[storage.URLMapKey{ShortURL: shortURL, UserID: userID}]
+	if !ok {
+		counter = storage.NewClickCounter()
+	}
+	return counter.Stats(shortURL), nil
+}
+
+// StoreAPIKey сохраняет ключ API в памяти.
+func (storager *MemoryStorage) StoreAPIKey(ctx context.Context, apiKey models.APIKey) error {
+	storager.mu.Lock()
+	defer storager.mu.Unlock()
+
+	storager.apiKeys.Put(apiKey)
+	return nil
+}
+
+// GetAPIKeyByHash возвращает ключ API по хешу.
+func (storager *MemoryStorage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	storager.mu.RLock()
+	defer storager.mu.RUnlock()
+
+	apiKey, ok := storager.apiKeys.GetByHash(hash)
+	return apiKey, ok, nil
+}
+
+// ListAPIKeys возвращает ключи API пользователя в порядке создания.
+func (storager *MemoryStorage) ListAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	storager.mu.RLock()
+	defer storager.mu.RUnlock()
+
+	return storager.apiKeys.List(userID), nil
+}
+
+// RevokeAPIKey отзывает действующий ключ API пользователя.
+func (storager *MemoryStorage) RevokeAPIKey(ctx context.Context, id string, userID int, now time.Time) (bool, error) {
+	storager.mu.Lock()
+	defer storager.mu.Unlock()
+
+	apiKey, ok := storager.apiKeys.Get(id)
+	if !ok || apiKey.UserID != userID || apiKey.IsRevoked() {
+		return false, nil
+	}
+	apiKey.RevokedAt = &now
+	storager.apiKeys.Put(apiKey)
+	return true, nil
+}
+
+// TouchAPIKey запоминает время последнего использования ключа API.
+func (storager *MemoryStorage) TouchAPIKey(ctx context.Context, id string, now time.Time) error {
+	storager.mu.Lock()
+	defer storager.mu.Unlock()
+
+	if apiKey, ok := storager.apiKeys.Get(id); ok {
+		apiKey.LastUsedAt = &now
+		storager.apiKeys.Put(apiKey)
+	}
+	return nil
+}
+
+// CreateUser регистрирует пользователя со следующим свободным идентификатором.
+func (storager *MemoryStorage) CreateUser(ctx context.Context, now time.Time) (models.User, error) {
+	return storager.users.Create(now), nil
+}
+
+// GetUser возвращает зарегистрированного пользователя.
+func (storager *MemoryStorage) GetUser(ctx context.Context, userID int) (models.User, bool, error) {
+	user, ok := storager.users.Get(userID)
+	return user, ok, nil
+}
+
+// TouchUser запоминает время последней активности пользователя.
+func (storager *MemoryStorage) TouchUser(ctx context.Context, userID int, now time.Time) error {
+	storager.users.Touch(userID, now)
+	return nil
+}
+
+// ReserveCodeCounter резервирует n следующих значений счетчика коротких кодов и возвращает первое из них.
+func (storager *MemoryStorage) ReserveCodeCounter(ctx context.Context, n int) (uint64, error) {
+	storager.mu.Lock()
+	defer storager.mu.Unlock()
+
+	first := storager.codeCounter + 1
+	storager.codeCounter += uint64(n)
+	return first, nil
+}
+
+// CountURLs возвращает количество неудаленных коротких URL.
+func (storager *MemoryStorage) CountURLs(ctx context.Context) (int, error) {
+	storager.mu.RLock()
+	defer storager.mu.RUnlock()
+
+	return storager.activeURLs, nil
+}
+
+// CountUsers возвращает количество зарегистрированных пользователей.
+func (storager *MemoryStorage) CountUsers(ctx context.Context) (int, error) {
+	return storager.users.Len(), nil
+}
+
+// PingContext всегда успешен, так как хранилище находится в памяти процесса.
+func (storager *MemoryStorage) PingContext(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theheadmen/urlShort/internal/models"
+)
+
+func TestMemoryStorageLookups(t *testing.T) {
+	ctx := context.Background()
+	storager := NewMemoryStorage()
+
+	isAlreadyStored, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: "short", OriginalURL: "original", UserID: 1})
+	require.NoError(t, err)
+	assert.False(t, isAlreadyStored)
+
+	isAlreadyStored, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "short", OriginalURL: "original", UserID: 1})
+	require.NoError(t, err)
+	assert.True(t, isAlreadyStored, "Повторное сохранение должно определяться")
+
+	savedURL, ok, err := storager.GetURLForAnyUserID(ctx, "short")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "original", savedURL.OriginalURL)
+
+	// пользователь 2 не может удалить URL пользователя 1
+	require.NoError(t, storager.DeleteByUserID(ctx, []string{"short"}, 2))
+	savedURL, _, err = storager.GetURLForAnyUserID(ctx, "short")
+	require.NoError(t, err)
+	assert.False(t, savedURL.Deleted)
+
+	require.NoError(t, storager.DeleteByUserID(ctx, []string{"short"}, 1))
+	urls, err := storager.ReadAllDataForUserID(ctx, 1, models.URLQuery{})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.True(t, urls[0].Deleted)
+
+	// владелец URL регистрируется как пользователь
+	_, ok, err = storager.GetUser(ctx, 1)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	_, ok, err = storager.GetUser(ctx, 2)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
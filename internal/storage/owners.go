@@ -0,0 +1,67 @@
+package storage
+
+import "sort"
+
+// owner владелец короткого URL и UUID его записи.
+type owner struct {
+	userID int
+	uuid   int
+}
+
+// OwnerIndex хранит владельцев каждого короткого URL в порядке сохранения, то есть по возрастанию UUID.
+// Редирект обслуживает первый владелец, после удаления его записи редирект переходит к следующему.
+// Не потокобезопасен, синхронизация остается на стороне хранилища.
+type OwnerIndex struct {
+	owners map[string][]owner
+}
+
+// NewOwnerIndex создает пустой OwnerIndex.
+func NewOwnerIndex() *OwnerIndex {
+	return &OwnerIndex{owners: make(map[string][]owner)}
+}
+
+// Add добавляет владельца key с UUID uuid, если его еще нет.
+func (index *OwnerIndex) Add(key URLMapKey, uuid int) {
+	owners := index.owners[key.ShortURL]
+	for _, o := range owners {
+		if o.userID == key.UserID {
+			return
+		}
+	}
+	// новые записи почти всегда получают наибольший UUID, поэтому вставка обычно идет в конец
+	i := sort.Search(len(owners), func(i int) bool { return owners[i].uuid > uuid })
+	owners = append(owners, owner{})
+	copy(owners[i+1:], owners[i:])
+	owners[i] = owner{userID: key.UserID, uuid: uuid}
+	index.owners[key.ShortURL] = owners
+}
+
+// Remove убирает владельца key.
+func (index *OwnerIndex) Remove(key URLMapKey) {
+	owners := index.owners[key.ShortURL]
+	for i, o := range owners {
+		if o.userID != key.UserID {
+			continue
+		}
+		if len(owners) == 1 {
+			delete(index.owners, key.ShortURL)
+			return
+		}
+		index.owners[key.ShortURL] = append(owners[:i:i], owners[i+1:]...)
+		return
+	}
+}
+
+// First возвращает ключ владельца, который обслуживает редирект по shortURL.
+func (index *OwnerIndex) First(shortURL string) (URLMapKey, bool) {
+	owners := index.owners[shortURL]
+	if len(owners) == 0 {
+		return URLMapKey{}, false
+	}
+	return URLMapKey{ShortURL: shortURL, UserID: owners[0].userID}, true
+}
+
+// Count возвращает количество владельцев shortURL.
+func (index *OwnerIndex) Count(shortURL string) int {
+	return len(index.owners[shortURL])
+}
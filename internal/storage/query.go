@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/theheadmen/urlShort/internal/models"
+)
+
+// SelectPage применяет к URL пользователя фильтры, курсор, порядок и размер страницы из query.
+// Нужна хранилищам, которые держат все URL в памяти. urls может быть изменен.
+func SelectPage(urls []models.SavedURL, query models.URLQuery) []models.SavedURL {
+	selected := urls[:0]
+	for _, savedURL := range urls {
+		if !query.Match(savedURL) {
+			continue
+		}
+		if query.After != nil && !query.Less(*query.After, models.CursorOf(savedURL)) {
+			continue
+		}
+		selected = append(selected, savedURL)
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		return query.Less(models.CursorOf(selected[i]), models.CursorOf(selected[j]))
+	})
+	if query.Limit > 0 && len(selected) > query.Limit {
+		selected = selected[:query.Limit]
+	}
+	return selected
+}
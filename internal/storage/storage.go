@@ -3,45 +3,202 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/theheadmen/urlShort/internal/models"
 )
 
+// AnyVersion отключает проверку версии в UpdateURL.
+const AnyVersion = -1
+
+var (
+	// ErrURLNotFound возвращается, если у пользователя нет такого неудаленного короткого URL.
+	ErrURLNotFound = errors.New("url is not found")
+	// ErrVersionMismatch возвращается, если URL успели изменить после того, как клиент получил его версию.
+	ErrVersionMismatch = errors.New("url version does not match")
+	// ErrDuplicateURL возвращается, если у пользователя уже есть короткий URL для того же полного URL.
+	ErrDuplicateURL = errors.New("url is already shortened by user")
+	// ErrSharedURL возвращается, если короткий URL есть и у других пользователей: его редирект общий,
+	// поэтому изменение полного URL одним владельцем поменяло бы редирект для всех.
+	ErrSharedURL = errors.New("short url is shared with other users")
+	// ErrAliasTaken возвращается из StoreURL, если пользовательский код уже занят другим пользователем или другим URL.
+	ErrAliasTaken = errors.New("custom alias is already taken")
+)
+
+// DuplicateURLError возвращается из StoreURL, если у пользователя уже есть другой короткий URL
+// для того же полного URL. У пользователя может быть только один короткий URL для каждого полного URL.
+type DuplicateURLError struct {
+	ShortURL string // Уже сохраненный короткий URL
+}
+
+// Error возвращает текст ошибки.
+func (e *DuplicateURLError) Error() string {
+	return ErrDuplicateURL.Error() + ": " + e.ShortURL
+}
+
+// Unwrap позволяет сравнивать ошибку с ErrDuplicateURL через errors.Is.
+func (e *DuplicateURLError) Unwrap() error {
+	return ErrDuplicateURL
+}
+
+// AliasTaken проверяет, занят ли пользовательский код savedURL.ShortURL, если его первый владелец first.
+// Код свободен для savedURL, только если first тот же пользователь с тем же полным URL.
+// Хранилища проверяют это под той же блокировкой, что и вставку, чтобы два пользователя не заняли код одновременно.
+func AliasTaken(first models.SavedURL, savedURL models.SavedURL) bool {
+	return first.UserID != savedURL.UserID || first.OriginalURL != savedURL.OriginalURL
+}
+
+// BatchResult результат сохранения одного URL из пачки.
+type BatchResult struct {
+	ShortURL   string // Короткий URL, под которым полный URL сохранен у пользователя
+	Existed    bool   // true, если URL не сохранялся, потому что уже был у пользователя
+	AliasTaken bool   // true, если URL не сохранялся, потому что его пользовательский код занят, см. AliasTaken
+}
+
 // URLMapKey представляет собой структуру для ключа URL в хранилище.
 type URLMapKey struct {
 	ShortURL string // Сокращенный URL
 	UserID   int    // Идентификатор пользователя
 }
 
+// KeysForUser возвращает ключи коротких URL shortURLs пользователя userID.
+func KeysForUser(shortURLs []string, userID int) []URLMapKey {
+	keys := make([]URLMapKey, 0, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		keys = append(keys, URLMapKey{ShortURL: shortURL, UserID: userID})
+	}
+	return keys
+}
+
 // Storage определяет интерфейс для работы с хранилищем данных.
 type Storage interface {
 	// ReadAllData читает все данные из хранилища.
 	ReadAllData(ctx context.Context) error
 
-	// ReadAllDataForUserID читает все данные для определенного пользователя из хранилища.
-	ReadAllDataForUserID(ctx context.Context, userID int) ([]models.SavedURL, error)
+	// ReadAllDataForUserID читает URL определенного пользователя, подходящие под query, в порядке query.
+	// Если query.Limit задан, возвращается не больше query.Limit URL, начиная сразу после query.After.
+	ReadAllDataForUserID(ctx context.Context, userID int, query models.URLQuery) ([]models.SavedURL, error)
 
 	// StoreURL сохраняет URL в хранилище.
-	StoreURL(ctx context.Context, shortURL string, originalURL string, userID int) (bool, error)
+	// Возвращает true, если для этого пользователя такой короткий URL уже был сохранен,
+	// и *DuplicateURLError, если тот же полный URL у пользователя сохранен под другим коротким URL.
+	// Для пользовательского кода (savedURL.Alias) возвращает ErrAliasTaken, если код занят, см. AliasTaken.
+	StoreURL(ctx context.Context, savedURL models.SavedURL) (bool, error)
 
 	// StoreURLBatch сохраняет несколько URL в хранилище.
-	StoreURLBatch(ctx context.Context, forStore []models.SavedURL, userID int) error
-
-	// GetLastUserID получает последний использованный идентификатор пользователя.
-	GetLastUserID(ctx context.Context) (int, error)
+	// Возвращает для каждого URL из forStore результат: Existed, если для этого пользователя такой короткий URL
+	// уже был сохранен, в том числе раньше в этой же пачке, или тот же полный URL уже сохранен под другим
+	// коротким URL. Во втором случае ShortURL содержит уже сохраненный короткий URL.
+	// Занятые пользовательские коды не сохраняются и отмечаются AliasTaken.
+	StoreURLBatch(ctx context.Context, forStore []models.SavedURL, userID int) ([]BatchResult, error)
 
 	// DeleteByUserID удаляет URL, принадлежащие определенному пользователю.
 	DeleteByUserID(ctx context.Context, shortURLs []string, userID int) error
 
+	// DeleteURLs помечает удаленными URL из keys, каждый только у его владельца.
+	// Возвращает ключи URL, которые были помечены этим вызовом; ненайденные и уже удаленные пропускаются.
+	DeleteURLs(ctx context.Context, keys []URLMapKey) ([]URLMapKey, error)
+
+	// RestoreURLs снимает пометку удаления с URL из keys, каждого только у его владельца.
+	// Возвращает ключи восстановленных URL; ненайденные, неудаленные и просроченные к моменту now пропускаются.
+	RestoreURLs(ctx context.Context, keys []URLMapKey, now time.Time) ([]URLMapKey, error)
+
+	// PurgeURLs безвозвратно удаляет URL из keys вместе с историей изменений и переходами, каждый только у его владельца.
+	// Возвращает ключи удаленных URL, ненайденные пропускаются.
+	PurgeURLs(ctx context.Context, keys []URLMapKey) ([]URLMapKey, error)
+
+	// PurgeDeleted безвозвратно удаляет URL, помеченные удаленными не позже before, вместе с историей изменений и переходами.
+	// Возвращает количество удаленных URL.
+	PurgeDeleted(ctx context.Context, before time.Time) (int, error)
+
+	// UpdateURL меняет полный URL короткой ссылки edit.ShortURL пользователя edit.UserID на edit.OriginalURL
+	// и записывает изменение в историю. Если version не AnyVersion, URL меняется, только если его версия равна version.
+	// Возвращает измененный URL; если полный URL не изменился, версия не увеличивается и история не пополняется.
+	// Короткий URL, который есть и у других пользователей, не меняется, возвращается ErrSharedURL.
+	UpdateURL(ctx context.Context, edit models.URLEdit, version int) (models.SavedURL, error)
+
+	// ListURLEdits возвращает историю изменений короткого URL пользователя в порядке версий.
+	ListURLEdits(ctx context.Context, shortURL string, userID int) ([]models.URLEdit, error)
+
+	// DeleteExpired помечает удаленными все URL, срок действия которых истек к моменту now.
+	// Возвращает количество помеченных URL.
+	DeleteExpired(ctx context.Context, now time.Time) (int, error)
+
 	// GetURLForAnyUserID получает URL, независимо от пользователя.
+	// Если у короткого URL несколько владельцев, возвращает URL того, кто сохранил его первым.
 	GetURLForAnyUserID(ctx context.Context, shortURL string) (models.SavedURL, bool, error)
 
-	// IsItCorrectUserID проверяет, является ли идентификатор пользователя корректным.
-	IsItCorrectUserID(userID int) bool
+	// GetURLForUserID получает URL, принадлежащий определенному пользователю.
+	GetURLForUserID(ctx context.Context, shortURL string, userID int) (models.SavedURL, bool, error)
+
+	// StoreClicks сохраняет пачку переходов по коротким URL.
+	StoreClicks(ctx context.Context, clicks []models.Click) error
+
+	// GetClickStats возвращает статистику переходов по короткому URL пользователя userID.
+	// Учитываются только переходы, редирект для которых обслужил URL этого пользователя.
+	GetClickStats(ctx context.Context, shortURL string, userID int) (models.ClickStats, error)
+
+	// StoreAPIKey сохраняет новый ключ API.
+	StoreAPIKey(ctx context.Context, apiKey models.APIKey) error
+
+	// GetAPIKeyByHash получает ключ API по хешу, в том числе отозванный.
+	GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error)
+
+	// ListAPIKeys возвращает ключи API пользователя в порядке создания.
+	ListAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error)
+
+	// RevokeAPIKey отзывает ключ API пользователя в момент now.
+	// Возвращает false, если у пользователя нет такого действующего ключа.
+	RevokeAPIKey(ctx context.Context, id string, userID int, now time.Time) (bool, error)
+
+	// TouchAPIKey запоминает время последнего использования ключа API.
+	TouchAPIKey(ctx context.Context, id string, now time.Time) error
+
+	// CreateUser регистрирует пользователя со следующим свободным идентификатором.
+	// Выделение идентификатора и сохранение пользователя выполняются атомарно.
+	CreateUser(ctx context.Context, now time.Time) (models.User, error)
 
-	// SaveUserID сохраняет идентификатор пользователя.
-	SaveUserID(userID int)
+	// GetUser получает зарегистрированного пользователя.
+	GetUser(ctx context.Context, userID int) (models.User, bool, error)
+
+	// TouchUser запоминает время последней активности пользователя.
+	TouchUser(ctx context.Context, userID int, now time.Time) error
+
+	// ReserveCodeCounter резервирует n следующих значений счетчика коротких кодов и возвращает первое из них.
+	// Зарезервированные значения не выдаются повторно, в том числе после перезапуска
+	// и другим экземплярам сервера с тем же хранилищем.
+	ReserveCodeCounter(ctx context.Context, n int) (uint64, error)
+
+	// CountURLs возвращает количество неудаленных коротких URL.
+	CountURLs(ctx context.Context) (int, error)
+
+	// CountUsers возвращает количество известных пользователей.
+	CountUsers(ctx context.Context) (int, error)
 
 	// PingContext проверяет соединение с хранилищем.
 	PingContext(ctx context.Context) error
 }
+
+// Compactor реализуют хранилища, которые умеют переписывать свои данные без устаревших записей.
+type Compactor interface {
+	// Compact переписывает данные хранилища, оставляя только актуальные записи.
+	Compact(ctx context.Context) error
+}
+
+// Unwrapper реализуют декораторы хранилища.
+type Unwrapper interface {
+	// Unwrap возвращает обернутое хранилище.
+	Unwrap() Storage
+}
+
+// Unwrap снимает с хранилища все декораторы, чтобы проверить необязательные возможности исходного хранилища.
+func Unwrap(storager Storage) Storage {
+	for {
+		unwrapper, ok := storager.(Unwrapper)
+		if !ok {
+			return storager
+		}
+		storager = unwrapper.Unwrap()
+	}
+}
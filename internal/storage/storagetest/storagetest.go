@@ -0,0 +1,851 @@
+// Package storagetest содержит общий набор тестов, которому должна соответствовать любая реализация storage.Storage.
+package storagetest
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+)
+
+// Factory создает новое пустое хранилище для одного теста.
+// Освобождение ресурсов стоит регистрировать через t.Cleanup.
+type Factory func(t *testing.T) storage.Storage
+
+// RunConformance запускает общий набор тестов для хранилища, создаваемого factory.
+func RunConformance(t *testing.T, factory Factory) {
+	t.Run("StoreURL", func(t *testing.T) { testStoreURL(t, factory(t)) })
+	t.Run("StoreURLBatch", func(t *testing.T) { testStoreURLBatch(t, factory(t)) })
+	t.Run("OriginalURLUniqueness", func(t *testing.T) { testOriginalURLUniqueness(t, factory(t)) })
+	t.Run("Aliases", func(t *testing.T) { testAliases(t, factory(t)) })
+	t.Run("ReadAllDataForUserID", func(t *testing.T) { testReadAllDataForUserID(t, factory(t)) })
+	t.Run("UserURLPages", func(t *testing.T) { testUserURLPages(t, factory(t)) })
+	t.Run("DeleteByUserID", func(t *testing.T) { testDeleteByUserID(t, factory(t)) })
+	t.Run("DeleteURLs", func(t *testing.T) { testDeleteURLs(t, factory(t)) })
+	t.Run("DeleteExpired", func(t *testing.T) { testDeleteExpired(t, factory(t)) })
+	t.Run("RestoreAndPurge", func(t *testing.T) { testRestoreAndPurge(t, factory(t)) })
+	t.Run("UpdateURL", func(t *testing.T) { testUpdateURL(t, factory(t)) })
+	t.Run("Users", func(t *testing.T) { testUsers(t, factory(t)) })
+	t.Run("Counts", func(t *testing.T) { testCounts(t, factory(t)) })
+	t.Run("CodeCounter", func(t *testing.T) { testCodeCounter(t, factory(t)) })
+	t.Run("Clicks", func(t *testing.T) { testClicks(t, factory(t)) })
+	t.Run("APIKeys", func(t *testing.T) { testAPIKeys(t, factory(t)) })
+}
+
+func testStoreURL(t *testing.T, storager storage.Storage) {
+	ctx := context.Background()
+
+	isAlreadyStored, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: "short1", OriginalURL: "https://example.com/1", UserID: 1})
+	require.NoError(t, err)
+	assert.False(t, isAlreadyStored, "Новый URL не должен считаться сохраненным")
+
+	isAlreadyStored, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "short1", OriginalURL: "https://example.com/1", UserID: 1})
+	require.NoError(t, err)
+	assert.True(t, isAlreadyStored, "Повторное сохранение должно определяться")
+
+	savedURL, ok, err := storager.GetURLForAnyUserID(ctx, "short1")
+	require.NoError(t, err)
+	require.True(t, ok, "URL должен находиться по короткому коду")
+	assert.Equal(t, "https://example.com/1", savedURL.OriginalURL)
+	assert.Equal(t, 1, savedURL.UserID)
+	assert.False(t, savedURL.Deleted)
+
+	_, ok, err = storager.GetURLForAnyUserID(ctx, "unknown")
+	require.NoError(t, err)
+	assert.False(t, ok, "Неизвестный код не должен находиться")
+
+	_, ok, err = storager.GetURLForUserID(ctx, "short1", 1)
+	require.NoError(t, err)
+	assert.True(t, ok, "Владелец должен находить свой URL")
+
+	_, ok, err = storager.GetURLForUserID(ctx, "short1", 2)
+	require.NoError(t, err)
+	assert.False(t, ok, "Другой пользователь не должен находить чужой URL")
+}
+
+func testStoreURLBatch(t *testing.T, storager storage.Storage) {
+	ctx := context.Background()
+
+	batch := []models.SavedURL{
+		{ShortURL: "batch1", OriginalURL: "https://example.com/b1"},
+		{ShortURL: "batch2", OriginalURL: "https://example.com/b2"},
+	}
+	results, err := storager.StoreURLBatch(ctx, batch, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.BatchResult{{ShortURL: "batch1"}, {ShortURL: "batch2"}}, results)
+	// повторная пачка с уже сохраненным URL не должна приводить к ошибке или дублям,
+	// а повтор внутри пачки сохраняется один раз
+	batch = append(batch,
+		models.SavedURL{ShortURL: "batch3", OriginalURL: "https://example.com/b3"},
+		models.SavedURL{ShortURL: "batch3", OriginalURL: "https://example.com/b3"},
+	)
+	results, err = storager.StoreURLBatch(ctx, batch, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.BatchResult{
+		{ShortURL: "batch1", Existed: true},
+		{ShortURL: "batch2", Existed: true},
+		{ShortURL: "batch3"},
+		{ShortURL: "batch3", Existed: true},
+	}, results)
+
+	// пустая пачка ничего не сохраняет
+	results, err = storager.StoreURLBatch(ctx, nil, 1)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	for _, savedURL := range batch {
+		stored, ok, err := storager.GetURLForUserID(ctx, savedURL.ShortURL, 1)
+		require.NoError(t, err)
+		require.True(t, ok, "URL из пачки должен быть сохранен: %s", savedURL.ShortURL)
+		assert.Equal(t, savedURL.OriginalURL, stored.OriginalURL)
+		assert.Equal(t, 1, stored.UserID, "URL из пачки должен принадлежать пользователю")
+	}
+
+	urls, err := storager.ReadAllDataForUserID(ctx, 1, models.URLQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"batch1", "batch2", "batch3"}, shortURLs(urls), "В пачке не должно быть дублей")
+}
+
+func testOriginalURLUniqueness(t *testing.T, storager storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	_, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: "hashed", OriginalURL: "https://example.com/same", UserID: 1})
+	require.NoError(t, err)
+
+	// пользовательский код для уже сокращенного пользователем URL не сохраняется, возвращается имеющийся код
+	isAlreadyStored, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: "alias", OriginalURL: "https://example.com/same", UserID: 1})
+	var duplicate *storage.DuplicateURLError
+	require.ErrorAs(t, err, &duplicate)
+	assert.ErrorIs(t, err, storage.ErrDuplicateURL)
+	assert.Equal(t, "hashed", duplicate.ShortURL)
+	assert.False(t, isAlreadyStored)
+	_, ok, err := storager.GetURLForUserID(ctx, "alias", 1)
+	require.NoError(t, err)
+	assert.False(t, ok, "Второй код для того же URL не должен сохраняться")
+
+	// в пачке второй код для того же URL тоже не сохраняется, возвращается имеющийся код,
+	// в том числе для URL, сохраненного раньше в этой же пачке, а остальные URL пачки сохраняются
+	results, err := storager.StoreURLBatch(ctx, []models.SavedURL{
+		{ShortURL: "batched", OriginalURL: "https://example.com/same"},
+		{ShortURL: "fresh", OriginalURL: "https://example.com/fresh"},
+		{ShortURL: "fresh2", OriginalURL: "https://example.com/fresh"},
+	}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.BatchResult{
+		{ShortURL: "hashed", Existed: true},
+		{ShortURL: "fresh"},
+		{ShortURL: "fresh", Existed: true},
+	}, results)
+	for _, shortURL := range []string{"batched", "fresh2"} {
+		_, ok, err = storager.GetURLForUserID(ctx, shortURL, 1)
+		require.NoError(t, err)
+		assert.False(t, ok, "Второй код для того же URL не должен сохраняться из пачки: %s", shortURL)
+	}
+	_, ok, err = storager.GetURLForUserID(ctx, "fresh", 1)
+	require.NoError(t, err)
+	assert.True(t, ok, "Новый URL из пачки должен сохраняться")
+
+	// у другого пользователя свой код для того же URL
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "theirs", OriginalURL: "https://example.com/same", UserID: 2})
+	require.NoError(t, err)
+
+	// удаленный URL по-прежнему занимает полный URL, пока его не удалили навсегда
+	require.NoError(t, storager.DeleteByUserID(ctx, []string{"hashed"}, 1))
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "alias", OriginalURL: "https://example.com/same", UserID: 1})
+	require.ErrorAs(t, err, &duplicate)
+	assert.Equal(t, "hashed", duplicate.ShortURL)
+	_, err = storager.PurgeURLs(ctx, []storage.URLMapKey{{ShortURL: "hashed", UserID: 1}})
+	require.NoError(t, err)
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "alias", OriginalURL: "https://example.com/same", UserID: 1})
+	require.NoError(t, err)
+
+	// после изменения полного URL прежний URL снова свободен
+	_, err = storager.UpdateURL(ctx, models.URLEdit{ShortURL: "alias", UserID: 1, OriginalURL: "https://example.com/moved", EditedAt: now}, storage.AnyVersion)
+	require.NoError(t, err)
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "hashed", OriginalURL: "https://example.com/same", UserID: 1})
+	require.NoError(t, err)
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "other", OriginalURL: "https://example.com/moved", UserID: 1})
+	require.ErrorAs(t, err, &duplicate)
+	assert.Equal(t, "alias", duplicate.ShortURL)
+}
+
+func testAliases(t *testing.T, storager storage.Storage) {
+	ctx := context.Background()
+
+	isAlreadyStored, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: "spring-sale", OriginalURL: "https://example.com/spring", UserID: 1, Alias: true})
+	require.NoError(t, err)
+	assert.False(t, isAlreadyStored)
+
+	// повтор того же пользователя с тем же URL не ошибка
+	isAlreadyStored, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "spring-sale", OriginalURL: "https://example.com/spring", UserID: 1, Alias: true})
+	require.NoError(t, err)
+	assert.True(t, isAlreadyStored)
+
+	// занятый код не достается ни другому пользователю, ни другому URL того же пользователя
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "spring-sale", OriginalURL: "https://example.com/spring", UserID: 2, Alias: true})
+	assert.ErrorIs(t, err, storage.ErrAliasTaken)
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "spring-sale", OriginalURL: "https://example.com/autumn", UserID: 1, Alias: true})
+	assert.ErrorIs(t, err, storage.ErrAliasTaken)
+	_, ok, err := storager.GetURLForUserID(ctx, "spring-sale", 2)
+	require.NoError(t, err)
+	assert.False(t, ok, "Занятый код не должен сохраняться у другого пользователя")
+
+	// сгенерированный код по-прежнему могут делить пользователи с одним URL
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "hashed", OriginalURL: "https://example.com/shared", UserID: 1})
+	require.NoError(t, err)
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "hashed", OriginalURL: "https://example.com/shared", UserID: 2})
+	require.NoError(t, err)
+
+	// в пачке занятые коды отмечаются, в том числе занятые раньше в этой же пачке, а остальные URL сохраняются
+	results, err := storager.StoreURLBatch(ctx, []models.SavedURL{
+		{ShortURL: "spring-sale", OriginalURL: "https://example.com/spring", Alias: true},
+		{ShortURL: "summer-sale", OriginalURL: "https://example.com/summer", Alias: true},
+		{ShortURL: "summer-sale", OriginalURL: "https://example.com/winter", Alias: true},
+	}, 2)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.True(t, results[0].AliasTaken)
+	assert.Equal(t, storage.BatchResult{ShortURL: "summer-sale"}, results[1])
+	assert.True(t, results[2].AliasTaken)
+	savedURL, ok, err := storager.GetURLForAnyUserID(ctx, "summer-sale")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "https://example.com/summer", savedURL.OriginalURL)
+	assert.Equal(t, 2, savedURL.UserID)
+
+	// из пользователей, одновременно занимающих свободный код, его получает только один
+	const workers = 16
+	claimed := make(chan int, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(userID int) {
+			defer wg.Done()
+			_, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: "contested", OriginalURL: "https://example.com/contested", UserID: userID, Alias: true})
+			if err == nil {
+				claimed <- userID
+				return
+			}
+			assert.ErrorIs(t, err, storage.ErrAliasTaken)
+		}(10 + i)
+	}
+	wg.Wait()
+	close(claimed)
+	require.Len(t, claimed, 1, "Код должен достаться ровно одному пользователю")
+	savedURL, ok, err = storager.GetURLForAnyUserID(ctx, "contested")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, <-claimed, savedURL.UserID)
+}
+
+func testReadAllDataForUserID(t *testing.T, storager storage.Storage) {
+	ctx := context.Background()
+
+	for _, savedURL := range []models.SavedURL{
+		{ShortURL: "user1a", OriginalURL: "https://example.com/1a", UserID: 1},
+		{ShortURL: "user1b", OriginalURL: "https://example.com/1b", UserID: 1},
+		{ShortURL: "user2a", OriginalURL: "https://example.com/2a", UserID: 2},
+	} {
+		_, err := storager.StoreURL(ctx, savedURL)
+		require.NoError(t, err)
+	}
+
+	urls, err := storager.ReadAllDataForUserID(ctx, 1, models.URLQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1a", "user1b"}, shortURLs(urls))
+
+	urls, err = storager.ReadAllDataForUserID(ctx, 2, models.URLQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user2a"}, shortURLs(urls))
+
+	urls, err = storager.ReadAllDataForUserID(ctx, 3, models.URLQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, urls, "У пользователя без URL список должен быть пустым")
+}
+
+func testUserURLPages(t *testing.T, storager storage.Storage) {
+	ctx := context.Background()
+
+	// коды сохраняются не в алфавитном порядке, чтобы порядки created и short_url различались
+	for _, savedURL := range []models.SavedURL{
+		{ShortURL: "c", OriginalURL: "https://example.com/docs", UserID: 1},
+		{ShortURL: "a", OriginalURL: "https://example.com/blog", UserID: 1},
+		{ShortURL: "e", OriginalURL: "https://other.org/docs", UserID: 1},
+		{ShortURL: "b", OriginalURL: "https://example.com/shop", UserID: 1},
+		{ShortURL: "d", OriginalURL: "https://other.org/blog", UserID: 1},
+		{ShortURL: "z", OriginalURL: "https://example.com/other-user", UserID: 2},
+	} {
+		_, err := storager.StoreURL(ctx, savedURL)
+		require.NoError(t, err)
+	}
+	require.NoError(t, storager.DeleteByUserID(ctx, []string{"b"}, 1))
+
+	// pages проходит все страницы, передавая позицию последнего URL в следующий запрос
+	pages := func(query models.URLQuery) [][]string {
+		var result [][]string
+		for i := 0; i < 10; i++ {
+			urls, err := storager.ReadAllDataForUserID(ctx, 1, query)
+			require.NoError(t, err)
+			if len(urls) == 0 {
+				return result
+			}
+			page := make([]string, 0, len(urls))
+			for _, savedURL := range urls {
+				page = append(page, savedURL.ShortURL)
+			}
+			result = append(result, page)
+			cursor := models.CursorOf(urls[len(urls)-1])
+			query.After = &cursor
+		}
+		t.Fatal("Слишком много страниц")
+		return nil
+	}
+
+	assert.Equal(t, [][]string{{"c", "a"}, {"e", "b"}, {"d"}}, pages(models.URLQuery{Limit: 2}))
+	assert.Equal(t, [][]string{{"d", "b", "e"}, {"a", "c"}}, pages(models.URLQuery{Limit: 3, Desc: true}))
+	assert.Equal(t, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}, pages(models.URLQuery{Sort: models.SortByShortURL, Limit: 2}))
+	assert.Equal(t, [][]string{{"e", "d", "c", "b", "a"}}, pages(models.URLQuery{Sort: models.SortByShortURL, Desc: true}))
+
+	deleted, alive := true, false
+	assert.Equal(t, [][]string{{"b"}}, pages(models.URLQuery{Deleted: &deleted}))
+	assert.Equal(t, [][]string{{"c", "a"}, {"e", "d"}}, pages(models.URLQuery{Deleted: &alive, Limit: 2}))
+	assert.Equal(t, [][]string{{"c", "e"}}, pages(models.URLQuery{Contains: "/docs"}))
+	assert.Equal(t, [][]string{{"a"}, {"c"}}, pages(models.URLQuery{Contains: "example.com/", Sort: models.SortByShortURL, Deleted: &alive, Limit: 1}))
+}
+
+func testDeleteByUserID(t *testing.T, storager storage.Storage) {
+	ctx := context.Background()
+
+	_, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: "owned", OriginalURL: "https://example.com/owned", UserID: 1})
+	require.NoError(t, err)
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "kept", OriginalURL: "https://example.com/kept", UserID: 1})
+	require.NoError(t, err)
+
+	// чужой пользователь не может удалить URL
+	require.NoError(t, storager.DeleteByUserID(ctx, []string{"owned"}, 2))
+	savedURL, ok, err := storager.GetURLForAnyUserID(ctx, "owned")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.False(t, savedURL.Deleted, "Чужой пользователь не должен удалять URL")
+	urls, err := storager.ReadAllDataForUserID(ctx, 2, models.URLQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, urls, "Удаление не должно создавать записи у чужого пользователя")
+
+	require.NoError(t, storager.DeleteByUserID(ctx, []string{"owned", "unknown"}, 1))
+	savedURL, ok, err = storager.GetURLForAnyUserID(ctx, "owned")
+	require.NoError(t, err)
+	require.True(t, ok, "Удаленный URL остается в хранилище с флагом удаления")
+	assert.True(t, savedURL.Deleted)
+
+	urls, err = storager.ReadAllDataForUserID(ctx, 1, models.URLQuery{})
+	require.NoError(t, err)
+	require.Len(t, urls, 2, "Удаление не должно дублировать записи")
+	for _, savedURL := range urls {
+		assert.Equal(t, savedURL.ShortURL == "owned", savedURL.Deleted, "Неверный флаг удаления у %s", savedURL.ShortURL)
+	}
+}
+
+func testDeleteURLs(t *testing.T, storager storage.Storage) {
+	ctx := context.Background()
+
+	for _, savedURL := range []models.SavedURL{
+		{ShortURL: "shared", OriginalURL: "https://example.com/shared1", UserID: 1},
+		{ShortURL: "own", OriginalURL: "https://example.com/own", UserID: 1},
+		{ShortURL: "shared", OriginalURL: "https://example.com/shared2", UserID: 2},
+	} {
+		_, err := storager.StoreURL(ctx, savedURL)
+		require.NoError(t, err)
+	}
+
+	// пачка из запросов разных пользователей: чужой URL и повтор пропускаются
+	deleted, err := storager.DeleteURLs(ctx, []storage.URLMapKey{
+		{ShortURL: "shared", UserID: 1},
+		{ShortURL: "shared", UserID: 2},
+		{ShortURL: "own", UserID: 2},
+		{ShortURL: "shared", UserID: 1},
+		{ShortURL: "missing", UserID: 1},
+	})
+	require.NoError(t, err)
+	sort.Slice(deleted, func(i, j int) bool { return deleted[i].UserID < deleted[j].UserID })
+	assert.Equal(t, []storage.URLMapKey{{ShortURL: "shared", UserID: 1}, {ShortURL: "shared", UserID: 2}}, deleted)
+
+	savedURL, ok, err := storager.GetURLForUserID(ctx, "own", 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.False(t, savedURL.Deleted, "Чужой пользователь не может удалить URL")
+
+	// уже удаленные URL повторно не помечаются
+	deleted, err = storager.DeleteURLs(ctx, []storage.URLMapKey{{ShortURL: "shared", UserID: 1}})
+	require.NoError(t, err)
+	assert.Empty(t, deleted)
+}
+
+func testDeleteExpired(t *testing.T, storager storage.Storage) {
+	ctx := context.Background()
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	for _, savedURL := range []models.SavedURL{
+		{ShortURL: "expired", OriginalURL: "https://example.com/expired", UserID: 1, ExpiresAt: &past},
+		{ShortURL: "alive", OriginalURL: "https://example.com/alive", UserID: 1, ExpiresAt: &future},
+		{ShortURL: "forever", OriginalURL: "https://example.com/forever", UserID: 1},
+	} {
+		_, err := storager.StoreURL(ctx, savedURL)
+		require.NoError(t, err)
+	}
+
+	count, err := storager.DeleteExpired(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	count, err = storager.DeleteExpired(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count, "Уже удаленные URL не должны учитываться повторно")
+
+	for shortURL, deleted := range map[string]bool{"expired": true, "alive": false, "forever": false} {
+		savedURL, ok, err := storager.GetURLForAnyUserID(ctx, shortURL)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, deleted, savedURL.Deleted, "Неверный флаг удаления у %s", shortURL)
+	}
+}
+
+func testRestoreAndPurge(t *testing.T, storager storage.Storage) {
+	ctx := context.Background()
+	now := time.Now()
+	past := now.Add(-time.Hour)
+
+	for _, savedURL := range []models.SavedURL{
+		{ShortURL: "shared", OriginalURL: "https://example.com/shared1", UserID: 1},
+		{ShortURL: "shared", OriginalURL: "https://example.com/shared2", UserID: 2},
+		{ShortURL: "shared", OriginalURL: "https://example.com/shared3", UserID: 3},
+		{ShortURL: "alive", OriginalURL: "https://example.com/alive", UserID: 1},
+		{ShortURL: "expired", OriginalURL: "https://example.com/expired", UserID: 1, ExpiresAt: &past},
+		{ShortURL: "edited", OriginalURL: "https://example.com/tpyo", UserID: 1},
+	} {
+		_, err := storager.StoreURL(ctx, savedURL)
+		require.NoError(t, err)
+	}
+	_, err := storager.UpdateURL(ctx, models.URLEdit{ShortURL: "edited", UserID: 1, OriginalURL: "https://example.com/typo", EditedAt: now}, 0)
+	require.NoError(t, err)
+	require.NoError(t, storager.StoreClicks(ctx, []models.Click{
+		{ShortURL: "shared", UserID: 1, Timestamp: now, IPHash: "a"},
+		{ShortURL: "shared", UserID: 2, Timestamp: now, IPHash: "b"},
+		{ShortURL: "edited", UserID: 1, Timestamp: now, IPHash: "c"},
+	}))
+	_, err = storager.DeleteURLs(ctx, []storage.URLMapKey{{ShortURL: "shared", UserID: 1}, {ShortURL: "expired", UserID: 1}, {ShortURL: "edited", UserID: 1}})
+	require.NoError(t, err)
+
+	savedURL, ok, err := storager.GetURLForUserID(ctx, "shared", 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NotNil(t, savedURL.DeletedAt, "Удаленный URL должен хранить время удаления")
+
+	// неудаленный, истекший, чужой и неизвестный URL не восстанавливаются
+	restored, err := storager.RestoreURLs(ctx, []storage.URLMapKey{
+		{ShortURL: "shared", UserID: 1},
+		{ShortURL: "alive", UserID: 1},
+		{ShortURL: "expired", UserID: 1},
+		{ShortURL: "edited", UserID: 2},
+		{ShortURL: "missing", UserID: 1},
+	}, now)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.URLMapKey{{ShortURL: "shared", UserID: 1}}, restored)
+
+	savedURL, ok, err = storager.GetURLForUserID(ctx, "shared", 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.False(t, savedURL.Deleted)
+	assert.Nil(t, savedURL.DeletedAt)
+
+	// удаление навсегда убирает URL вместе с историей, редирект переходит к следующему по времени сохранения владельцу
+	purged, err := storager.PurgeURLs(ctx, []storage.URLMapKey{{ShortURL: "shared", UserID: 1}, {ShortURL: "missing", UserID: 1}})
+	require.NoError(t, err)
+	assert.Equal(t, []storage.URLMapKey{{ShortURL: "shared", UserID: 1}}, purged)
+	_, ok, err = storager.GetURLForUserID(ctx, "shared", 1)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	savedURL, ok, err = storager.GetURLForAnyUserID(ctx, "shared")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, 2, savedURL.UserID)
+	stats, err := storager.GetClickStats(ctx, "shared", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.TotalClicks, "Переходы удаленного навсегда URL должны удаляться вместе с ним")
+	stats, err = storager.GetClickStats(ctx, "shared", 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.TotalClicks, "Переходы другого владельца должны остаться")
+
+	// удаленные URL старше границы хранения удаляются навсегда, неудаленные остаются
+	count, err := storager.PurgeDeleted(ctx, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, count, "URL, удаленные позже границы, должны остаться")
+	count, err = storager.PurgeDeleted(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	for _, shortURL := range []string{"expired", "edited"} {
+		_, ok, err := storager.GetURLForUserID(ctx, shortURL, 1)
+		require.NoError(t, err)
+		assert.False(t, ok, "URL %s должен быть удален навсегда", shortURL)
+	}
+	edits, err := storager.ListURLEdits(ctx, "edited", 1)
+	require.NoError(t, err)
+	assert.Empty(t, edits)
+	stats, err = storager.GetClickStats(ctx, "edited", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.TotalClicks)
+	_, ok, err = storager.GetURLForUserID(ctx, "alive", 1)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// короткий URL после удаления навсегда можно сохранить заново без старой истории
+	_, err = storager.StoreURL(ctx, models.SavedURL{ShortURL: "edited", OriginalURL: "https://example.com/again", UserID: 1})
+	require.NoError(t, err)
+	edits, err = storager.ListURLEdits(ctx, "edited", 1)
+	require.NoError(t, err)
+	assert.Empty(t, edits)
+}
+
+func testUpdateURL(t *testing.T, storager storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for _, savedURL := range []models.SavedURL{
+		{ShortURL: "flyer", OriginalURL: "https://example.com/tpyo", UserID: 1},
+		{ShortURL: "other", OriginalURL: "https://example.com/other", UserID: 1},
+		{ShortURL: "gone", OriginalURL: "https://example.com/gone", UserID: 1},
+	} {
+		_, err := storager.StoreURL(ctx, savedURL)
+		require.NoError(t, err)
+	}
+	require.NoError(t, storager.DeleteByUserID(ctx, []string{"gone"}, 1))
+
+	edit := models.URLEdit{ShortURL: "flyer", UserID: 1, OriginalURL: "https://example.com/typo", EditedAt: now}
+	updated, err := storager.UpdateURL(ctx, edit, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/typo", updated.OriginalURL)
+	assert.Equal(t, 1, updated.Version)
+
+	savedURL, ok, err := storager.GetURLForAnyUserID(ctx, "flyer")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "https://example.com/typo", savedURL.OriginalURL, "Редирект должен вести на новый URL")
+	assert.Equal(t, 1, savedURL.Version)
+
+	// устаревшая версия, чужой, удаленный и неизвестный URL
+	_, err = storager.UpdateURL(ctx, models.URLEdit{ShortURL: "flyer", UserID: 1, OriginalURL: "https://example.com/v2", EditedAt: now}, 0)
+	assert.ErrorIs(t, err, storage.ErrVersionMismatch)
+	_, err = storager.UpdateURL(ctx, models.URLEdit{ShortURL: "flyer", UserID: 2, OriginalURL: "https://example.com/v2", EditedAt: now}, storage.AnyVersion)
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+	_, err = storager.UpdateURL(ctx, models.URLEdit{ShortURL: "gone", UserID: 1, OriginalURL: "https://example.com/v2", EditedAt: now}, storage.AnyVersion)
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+	_, err = storager.UpdateURL(ctx, models.URLEdit{ShortURL: "missing", UserID: 1, OriginalURL: "https://example.com/v2", EditedAt: now}, storage.AnyVersion)
+	assert.ErrorIs(t, err, storage.ErrURLNotFound)
+	// у пользователя уже есть короткий URL для этого полного URL
+	_, err = storager.UpdateURL(ctx, models.URLEdit{ShortURL: "flyer", UserID: 1, OriginalURL: "https://example.com/other", EditedAt: now}, storage.AnyVersion)
+	assert.ErrorIs(t, err, storage.ErrDuplicateURL)
+
+	// общий с другим пользователем короткий URL не меняется ни у одного из владельцев
+	for _, savedURL := range []models.SavedURL{
+		{ShortURL: "common", OriginalURL: "https://example.com/common", UserID: 1},
+		{ShortURL: "common", OriginalURL: "https://example.com/common", UserID: 2},
+	} {
+		_, err := storager.StoreURL(ctx, savedURL)
+		require.NoError(t, err)
+	}
+	for _, userID := range []int{1, 2} {
+		_, err = storager.UpdateURL(ctx, models.URLEdit{ShortURL: "common", UserID: userID, OriginalURL: "https://example.com/hijacked", EditedAt: now}, storage.AnyVersion)
+		assert.ErrorIs(t, err, storage.ErrSharedURL)
+	}
+	savedURL, ok, err = storager.GetURLForAnyUserID(ctx, "common")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "https://example.com/common", savedURL.OriginalURL, "Редирект общего URL не должен меняться")
+
+	// тот же URL не создает новой версии
+	updated, err = storager.UpdateURL(ctx, models.URLEdit{ShortURL: "flyer", UserID: 1, OriginalURL: "https://example.com/typo", EditedAt: now}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, updated.Version)
+
+	updated, err = storager.UpdateURL(ctx, models.URLEdit{ShortURL: "flyer", UserID: 1, APIKeyID: "0123456789abcdef", OriginalURL: "https://example.com/final", EditedAt: now.Add(time.Minute)}, storage.AnyVersion)
+	require.NoError(t, err)
+	assert.Equal(t, 2, updated.Version)
+
+	edits, err := storager.ListURLEdits(ctx, "flyer", 1)
+	require.NoError(t, err)
+	require.Len(t, edits, 2)
+	assert.Equal(t, "https://example.com/tpyo", edits[0].PreviousURL)
+	assert.Equal(t, "https://example.com/typo", edits[0].OriginalURL)
+	assert.Equal(t, 1, edits[0].Version)
+	assert.Equal(t, 1, edits[0].UserID)
+	assert.Empty(t, edits[0].APIKeyID)
+	assert.True(t, now.Equal(edits[0].EditedAt))
+	assert.Equal(t, "https://example.com/typo", edits[1].PreviousURL)
+	assert.Equal(t, "https://example.com/final", edits[1].OriginalURL)
+	assert.Equal(t, "0123456789abcdef", edits[1].APIKeyID)
+
+	edits, err = storager.ListURLEdits(ctx, "flyer", 2)
+	require.NoError(t, err)
+	assert.Empty(t, edits, "История видна только владельцу")
+}
+
+func testCounts(t *testing.T, storager storage.Storage) {
+	ctx := context.Background()
+
+	urls, err := storager.CountURLs(ctx)
+	require.NoError(t, err)
+	users, err := storager.CountUsers(ctx)
+	require.NoError(t, err)
+
+	first, err := storager.CreateUser(ctx, time.Now().UTC())
+	require.NoError(t, err)
+	second, err := storager.CreateUser(ctx, time.Now().UTC())
+	require.NoError(t, err)
+	for _, savedURL := range []models.SavedURL{
+		{ShortURL: "shared", OriginalURL: "https://example.com/shared1", UserID: first.ID},
+		{ShortURL: "own", OriginalURL: "https://example.com/own", UserID: first.ID},
+		{ShortURL: "shared", OriginalURL: "https://example.com/shared2", UserID: second.ID},
+	} {
+		_, err := storager.StoreURL(ctx, savedURL)
+		require.NoError(t, err)
+	}
+
+	count, err := storager.CountURLs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, urls+3, count, "Короткие URL разных пользователей считаются отдельно")
+	count, err = storager.CountUsers(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, users+2, count)
+
+	// удаленные URL не считаются, повторное удаление счетчик не меняет
+	for i := 0; i < 2; i++ {
+		_, err = storager.DeleteURLs(ctx, []storage.URLMapKey{{ShortURL: "shared", UserID: second.ID}})
+		require.NoError(t, err)
+	}
+	assertURLCount(t, storager, urls+2, "Удаленный URL не должен считаться")
+
+	now := time.Now().UTC()
+	_, err = storager.RestoreURLs(ctx, []storage.URLMapKey{{ShortURL: "shared", UserID: second.ID}}, now)
+	require.NoError(t, err)
+	assertURLCount(t, storager, urls+3, "Восстановленный URL должен считаться")
+
+	expiresAt := now.Add(time.Hour)
+	_, err = storager.StoreURLBatch(ctx, []models.SavedURL{
+		{ShortURL: "batched", OriginalURL: "https://example.com/batched"},
+		{ShortURL: "expiring", OriginalURL: "https://example.com/expiring", ExpiresAt: &expiresAt},
+		{ShortURL: "own", OriginalURL: "https://example.com/own"},
+	}, first.ID)
+	require.NoError(t, err)
+	assertURLCount(t, storager, urls+5, "Считаются только новые URL из пачки")
+
+	_, err = storager.DeleteExpired(ctx, expiresAt)
+	require.NoError(t, err)
+	assertURLCount(t, storager, urls+4, "URL с истекшим сроком не должен считаться")
+
+	// окончательное удаление уже удаленного URL счетчик не меняет, а неудаленного уменьшает
+	_, err = storager.PurgeURLs(ctx, []storage.URLMapKey{{ShortURL: "expiring", UserID: first.ID}, {ShortURL: "batched", UserID: first.ID}})
+	require.NoError(t, err)
+	assertURLCount(t, storager, urls+3, "Окончательно удаленный URL не должен считаться")
+
+	_, err = storager.DeleteURLs(ctx, []storage.URLMapKey{{ShortURL: "own", UserID: first.ID}})
+	require.NoError(t, err)
+	_, err = storager.PurgeDeleted(ctx, time.Now().UTC().Add(time.Hour))
+	require.NoError(t, err)
+	assertURLCount(t, storager, urls+2, "Окончательно удаленный URL не должен считаться")
+}
+
+// assertURLCount проверяет количество неудаленных URL в хранилище.
+func assertURLCount(t *testing.T, storager storage.Storage, expected int, msg string) {
+	t.Helper()
+	count, err := storager.CountURLs(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, expected, count, msg)
+}
+
+func testCodeCounter(t *testing.T, storager storage.Storage) {
+	ctx := context.Background()
+
+	first, err := storager.ReserveCodeCounter(ctx, 10)
+	require.NoError(t, err)
+	second, err := storager.ReserveCodeCounter(ctx, 5)
+	require.NoError(t, err)
+	assert.Equal(t, first+10, second, "Блоки должны идти подряд и не пересекаться")
+
+	// блоки, зарезервированные параллельно, не должны пересекаться
+	const workers = 16
+	starts := make(chan uint64, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start, err := storager.ReserveCodeCounter(ctx, 3)
+			assert.NoError(t, err)
+			starts <- start
+		}()
+	}
+	wg.Wait()
+	close(starts)
+	seen := make(map[uint64]struct{})
+	for start := range starts {
+		assert.GreaterOrEqual(t, start, second+5)
+		for value := start; value < start+3; value++ {
+			assert.NotContains(t, seen, value)
+			seen[value] = struct{}{}
+		}
+	}
+}
+
+func testUsers(t *testing.T, storager storage.Storage) {
+	ctx := context.Background()
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	first, err := storager.CreateUser(ctx, created)
+	require.NoError(t, err)
+	second, err := storager.CreateUser(ctx, created)
+	require.NoError(t, err)
+	assert.Greater(t, second.ID, first.ID, "Идентификаторы пользователей должны возрастать")
+
+	user, ok, err := storager.GetUser(ctx, second.ID)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.True(t, created.Equal(user.CreatedAt))
+	assert.True(t, created.Equal(user.LastSeen))
+
+	_, ok, err = storager.GetUser(ctx, second.ID+1000)
+	require.NoError(t, err)
+	assert.False(t, ok, "Незарегистрированный пользователь не должен находиться")
+
+	seen := created.Add(time.Hour)
+	require.NoError(t, storager.TouchUser(ctx, first.ID, seen))
+	user, ok, err = storager.GetUser(ctx, first.ID)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.True(t, seen.Equal(user.LastSeen))
+	assert.True(t, created.Equal(user.CreatedAt))
+
+	// идентификаторы, выданные параллельно, не должны повторяться
+	const workers = 16
+	ids := make(chan int, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user, err := storager.CreateUser(ctx, created)
+			assert.NoError(t, err)
+			ids <- user.ID
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	unique := make(map[int]struct{}, workers)
+	for id := range ids {
+		unique[id] = struct{}{}
+	}
+	assert.Len(t, unique, workers)
+}
+
+func testClicks(t *testing.T, storager storage.Storage) {
+	ctx := context.Background()
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	require.NoError(t, storager.StoreClicks(ctx, []models.Click{
+		{ShortURL: "clicked", UserID: 1, Timestamp: day, IPHash: "a"},
+		{ShortURL: "clicked", UserID: 1, Timestamp: day.Add(time.Hour), IPHash: "a"},
+		{ShortURL: "clicked", UserID: 1, Timestamp: day.Add(24 * time.Hour), IPHash: "b"},
+		{ShortURL: "clicked", UserID: 2, Timestamp: day, IPHash: "d"},
+		{ShortURL: "other", UserID: 1, Timestamp: day, IPHash: "c"},
+	}))
+
+	stats, err := storager.GetClickStats(ctx, "clicked", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.TotalClicks)
+	assert.Equal(t, 2, stats.UniqueVisitors)
+	assert.Equal(t, []models.DailyClicks{{Date: "2024-05-01", Clicks: 2}, {Date: "2024-05-02", Clicks: 1}}, stats.Daily)
+
+	// совладелец того же короткого URL видит только переходы, которые обслужил его URL
+	stats, err = storager.GetClickStats(ctx, "clicked", 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.TotalClicks)
+	assert.Equal(t, []models.DailyClicks{{Date: "2024-05-01", Clicks: 1}}, stats.Daily)
+
+	stats, err = storager.GetClickStats(ctx, "never", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.TotalClicks)
+	assert.Empty(t, stats.Daily)
+}
+
+func testAPIKeys(t *testing.T, storager storage.Storage) {
+	ctx := context.Background()
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	first := models.APIKey{ID: "key1", UserID: 1, Name: "backend", Hash: strings.Repeat("a", 64), Scopes: []string{"read", "write"}, CreatedAt: created}
+	second := models.APIKey{ID: "key2", UserID: 1, Name: "cron", Hash: strings.Repeat("b", 64), Scopes: []string{}, CreatedAt: created.Add(time.Minute)}
+	foreign := models.APIKey{ID: "key3", UserID: 2, Name: "other", Hash: strings.Repeat("c", 64), Scopes: []string{}, CreatedAt: created}
+	for _, apiKey := range []models.APIKey{second, first, foreign} {
+		require.NoError(t, storager.StoreAPIKey(ctx, apiKey))
+	}
+
+	apiKey, ok, err := storager.GetAPIKeyByHash(ctx, first.Hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "key1", apiKey.ID)
+	assert.Equal(t, 1, apiKey.UserID)
+	assert.Equal(t, []string{"read", "write"}, apiKey.Scopes)
+	assert.Nil(t, apiKey.LastUsedAt)
+
+	_, ok, err = storager.GetAPIKeyByHash(ctx, strings.Repeat("d", 64))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	apiKeys, err := storager.ListAPIKeys(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, apiKeys, 2)
+	assert.Equal(t, "key1", apiKeys[0].ID, "Ключи должны идти в порядке создания")
+	assert.Equal(t, "key2", apiKeys[1].ID)
+
+	used := created.Add(time.Hour)
+	require.NoError(t, storager.TouchAPIKey(ctx, "key1", used))
+	apiKey, _, err = storager.GetAPIKeyByHash(ctx, first.Hash)
+	require.NoError(t, err)
+	require.NotNil(t, apiKey.LastUsedAt)
+	assert.True(t, used.Equal(*apiKey.LastUsedAt))
+
+	// чужой ключ отозвать нельзя
+	ok, err = storager.RevokeAPIKey(ctx, "key3", 1, used)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = storager.RevokeAPIKey(ctx, "key1", 1, used)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = storager.RevokeAPIKey(ctx, "key1", 1, used)
+	require.NoError(t, err)
+	assert.False(t, ok, "Повторный отзыв не должен ничего менять")
+
+	apiKey, ok, err = storager.GetAPIKeyByHash(ctx, first.Hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.True(t, apiKey.IsRevoked())
+}
+
+// shortURLs возвращает отсортированные короткие коды, чтобы не зависеть от порядка хранилища.
+func shortURLs(urls []models.SavedURL) []string {
+	result := make([]string, 0, len(urls))
+	for _, savedURL := range urls {
+		result = append(result, savedURL.ShortURL)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/models"
+)
+
+// UserRegistry хранит зарегистрированных пользователей в памяти и выдает новые идентификаторы.
+// В отличие от остальных индексов потокобезопасен: проверка пользователя выполняется на каждый запрос
+// и не должна ждать блокировок, под которыми хранилище пишет URL.
+type UserRegistry struct {
+	mu     sync.RWMutex
+	users  map[int]models.User
+	lastID int
+}
+
+// NewUserRegistry создает пустой UserRegistry.
+func NewUserRegistry() *UserRegistry {
+	return &UserRegistry{users: make(map[int]models.User)}
+}
+
+// Allocate резервирует следующий свободный идентификатор пользователя.
+// Зарезервированный, но так и не сохраненный через Put идентификатор повторно не выдается.
+func (registry *UserRegistry) Allocate() int {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.lastID++
+	return registry.lastID
+}
+
+// Create регистрирует пользователя со следующим свободным идентификатором.
+func (registry *UserRegistry) Create(now time.Time) models.User {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.lastID++
+	user := models.User{ID: registry.lastID, CreatedAt: now, LastSeen: now}
+	registry.users[user.ID] = user
+	return user
+}
+
+// Put добавляет пользователя или заменяет пользователя с тем же идентификатором.
+// Следующие идентификаторы выдаются после наибольшего известного.
+func (registry *UserRegistry) Put(user models.User) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.users[user.ID] = user
+	if user.ID > registry.lastID {
+		registry.lastID = user.ID
+	}
+}
+
+// Ensure регистрирует пользователя userID, если его еще нет. Нужен для данных,
+// сохраненных до появления реестра, в которых пользователь известен только как владелец URL.
+func (registry *UserRegistry) Ensure(userID int, now time.Time) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, ok := registry.users[userID]; ok {
+		return
+	}
+	registry.users[userID] = models.User{ID: userID, CreatedAt: now, LastSeen: now}
+	if userID > registry.lastID {
+		registry.lastID = userID
+	}
+}
+
+// Get возвращает пользователя по идентификатору.
+func (registry *UserRegistry) Get(userID int) (models.User, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	user, ok := registry.users[userID]
+	return user, ok
+}
+
+// Touch запоминает время последней активности пользователя и возвращает обновленного пользователя.
+func (registry *UserRegistry) Touch(userID int, now time.Time) (models.User, bool) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	user, ok := registry.users[userID]
+	if !ok {
+		return models.User{}, false
+	}
+	user.LastSeen = now
+	registry.users[userID] = user
+	return user, true
+}
+
+// All возвращает всех зарегистрированных пользователей в порядке идентификаторов.
+func (registry *UserRegistry) All() []models.User {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	users := make([]models.User, 0, len(registry.users))
+	for _, user := range registry.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	return users
+}
+
+// Len возвращает количество зарегистрированных пользователей.
+func (registry *UserRegistry) Len() int {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	return len(registry.users)
+}
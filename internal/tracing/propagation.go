@@ -0,0 +1,109 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// TraceparentHeader заголовок W3C Trace Context.
+const TraceparentHeader = "traceparent"
+
+// errInvalidTraceparent возвращается, если заголовок traceparent не соответствует формату W3C.
+var errInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent разбирает заголовок вида 00-<trace-id>-<parent-id>-<flags>.
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	// версия ff запрещена, у версии 00 ровно четыре поля, будущие версии могут добавлять поля
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	for _, part := range parts[:4] {
+		if strings.ToLower(part) != part {
+			return SpanContext{}, errInvalidTraceparent
+		}
+	}
+
+	var spanContext SpanContext
+	if _, err := hex.Decode(spanContext.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	if _, err := hex.Decode(spanContext.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	if !spanContext.IsValid() {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	spanContext.Sampled = flags[0]&1 == 1
+	return spanContext, nil
+}
+
+// FormatTraceparent формирует заголовок traceparent версии 00.
+func FormatTraceparent(spanContext SpanContext) string {
+	flags := "00"
+	if spanContext.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", spanContext.TraceID, spanContext.SpanID, flags)
+}
+
+// Inject записывает контекст текущего спана в заголовки исходящего запроса или ответа.
+func Inject(span *Span, header http.Header) {
+	if spanContext := span.SpanContext(); spanContext.IsValid() {
+		header.Set(TraceparentHeader, FormatTraceparent(spanContext))
+	}
+}
+
+// Middleware создает серверный спан на каждый запрос. Если пришел корректный traceparent,
+// спан продолжает трассу вызывающего сервиса. Контекст спана возвращается в заголовке ответа traceparent.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if remote, err := ParseTraceparent(r.Header.Get(TraceparentHeader)); err == nil {
+			ctx = ContextWithRemoteSpanContext(ctx, remote)
+		}
+
+		ctx, span := Start(ctx, r.Method, SpanKindServer,
+			String("http.method", r.Method),
+			String("http.target", r.URL.Path),
+		)
+		if span == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer span.End()
+
+		Inject(span, w.Header())
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		// шаблон маршрута известен только после того, как chi выбрал обработчик
+		if routeContext := chi.RouteContext(r.Context()); routeContext != nil {
+			if pattern := routeContext.RoutePattern(); pattern != "" {
+				span.SetAttributes(String("http.route", pattern))
+				span.SetName(r.Method + " " + pattern)
+			}
+		}
+		span.SetAttributes(Int("http.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(StatusError, http.StatusText(status))
+		}
+	})
+}
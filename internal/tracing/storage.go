@@ -0,0 +1,285 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+)
+
+// Storage декоратор storage.Storage, который создает спан на каждую операцию хранилища.
+type Storage struct {
+	storager storage.Storage
+}
+
+// NewStorage оборачивает storager.
+func NewStorage(storager storage.Storage) *Storage {
+	return &Storage{storager: storager}
+}
+
+// start создает спан операции method.
+func (traced *Storage) start(ctx context.Context, method string, attributes ...Attribute) (context.Context, *Span) {
+	return Start(ctx, "storage."+method, SpanKindInternal, attributes...)
+}
+
+// finish фиксирует ошибку и завершает спан.
+func finish(span *Span, err error) {
+	span.RecordError(err)
+	span.End()
+}
+
+// Unwrap возвращает обернутое хранилище.
+func (traced *Storage) Unwrap() storage.Storage {
+	return traced.storager
+}
+
+// ReadAllData читает все данные из хранилища.
+func (traced *Storage) ReadAllData(ctx context.Context) error {
+	ctx, span := traced.start(ctx, "ReadAllData")
+	err := traced.storager.ReadAllData(ctx)
+	finish(span, err)
+	return err
+}
+
+// ReadAllDataForUserID читает страницу URL определенного пользователя из хранилища.
+func (traced *Storage) ReadAllDataForUserID(ctx context.Context, userID int, query models.URLQuery) ([]models.SavedURL, error) {
+	ctx, span := traced.start(ctx, "ReadAllDataForUserID", Int("user.id", userID), String("query.sort", string(query.Sort)), Int("query.limit", query.Limit))
+	savedURLs, err := traced.storager.ReadAllDataForUserID(ctx, userID, query)
+	span.SetAttributes(Int("result.count", len(savedURLs)))
+	finish(span, err)
+	return savedURLs, err
+}
+
+// StoreURL сохраняет URL в хранилище.
+func (traced *Storage) StoreURL(ctx context.Context, savedURL models.SavedURL) (bool, error) {
+	ctx, span := traced.start(ctx, "StoreURL", String("short_url", savedURL.ShortURL), Int("user.id", savedURL.UserID))
+	ok, err := traced.storager.StoreURL(ctx, savedURL)
+	span.SetAttributes(Bool("already_stored", ok))
+	finish(span, err)
+	return ok, err
+}
+
+// StoreURLBatch сохраняет несколько URL в хранилище.
+func (traced *Storage) StoreURLBatch(ctx context.Context, forStore []models.SavedURL, userID int) ([]storage.BatchResult, error) {
+	ctx, span := traced.start(ctx, "StoreURLBatch", Int("batch.size", len(forStore)), Int("user.id", userID))
+	results, err := traced.storager.StoreURLBatch(ctx, forStore, userID)
+	alreadyStored, aliasTaken := 0, 0
+	for _, result := range results {
+		switch {
+		case result.AliasTaken:
+			aliasTaken++
+		case result.Existed:
+			alreadyStored++
+		}
+	}
+	span.SetAttributes(Int("already_stored", alreadyStored), Int("alias_taken", aliasTaken))
+	finish(span, err)
+	return results, err
+}
+
+// DeleteByUserID удаляет URL, принадлежащие определенному пользователю.
+func (traced *Storage) DeleteByUserID(ctx context.Context, shortURLs []string, userID int) error {
+	ctx, span := traced.start(ctx, "DeleteByUserID", Int("batch.size", len(shortURLs)), Int("user.id", userID))
+	err := traced.storager.DeleteByUserID(ctx, shortURLs, userID)
+	finish(span, err)
+	return err
+}
+
+// DeleteURLs помечает удаленными URL из keys, каждый только у его владельца.
+func (traced *Storage) DeleteURLs(ctx context.Context, keys []storage.URLMapKey) ([]storage.URLMapKey, error) {
+	ctx, span := traced.start(ctx, "DeleteURLs", Int("batch.size", len(keys)))
+	deleted, err := traced.storager.DeleteURLs(ctx, keys)
+	span.SetAttributes(Int("result.count", len(deleted)))
+	finish(span, err)
+	return deleted, err
+}
+
+// RestoreURLs снимает пометку удаления с URL из keys, каждого только у его владельца.
+func (traced *Storage) RestoreURLs(ctx context.Context, keys []storage.URLMapKey, now time.Time) ([]storage.URLMapKey, error) {
+	ctx, span := traced.start(ctx, "RestoreURLs", Int("batch.size", len(keys)))
+	restored, err := traced.storager.RestoreURLs(ctx, keys, now)
+	span.SetAttributes(Int("result.count", len(restored)))
+	finish(span, err)
+	return restored, err
+}
+
+// PurgeURLs безвозвратно удаляет URL из keys вместе с историей изменений.
+func (traced *Storage) PurgeURLs(ctx context.Context, keys []storage.URLMapKey) ([]storage.URLMapKey, error) {
+	ctx, span := traced.start(ctx, "PurgeURLs", Int("batch.size", len(keys)))
+	purged, err := traced.storager.PurgeURLs(ctx, keys)
+	span.SetAttributes(Int("result.count", len(purged)))
+	finish(span, err)
+	return purged, err
+}
+
+// PurgeDeleted безвозвратно удаляет URL, помеченные удаленными не позже before.
+func (traced *Storage) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	ctx, span := traced.start(ctx, "PurgeDeleted")
+	count, err := traced.storager.PurgeDeleted(ctx, before)
+	span.SetAttributes(Int("result.count", count))
+	finish(span, err)
+	return count, err
+}
+
+// UpdateURL меняет полный URL короткой ссылки и записывает изменение в историю.
+func (traced *Storage) UpdateURL(ctx context.Context, edit models.URLEdit, version int) (models.SavedURL, error) {
+	ctx, span := traced.start(ctx, "UpdateURL", String("short_url", edit.ShortURL), Int("user.id", edit.UserID), Int("version", version))
+	savedURL, err := traced.storager.UpdateURL(ctx, edit, version)
+	span.SetAttributes(Int("result.version", savedURL.Version))
+	finish(span, err)
+	return savedURL, err
+}
+
+// ListURLEdits возвращает историю изменений короткого URL пользователя.
+func (traced *Storage) ListURLEdits(ctx context.Context, shortURL string, userID int) ([]models.URLEdit, error) {
+	ctx, span := traced.start(ctx, "ListURLEdits", String("short_url", shortURL), Int("user.id", userID))
+	edits, err := traced.storager.ListURLEdits(ctx, shortURL, userID)
+	span.SetAttributes(Int("result.count", len(edits)))
+	finish(span, err)
+	return edits, err
+}
+
+// DeleteExpired помечает удаленными все URL, срок действия которых истек к моменту now.
+func (traced *Storage) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	ctx, span := traced.start(ctx, "DeleteExpired")
+	count, err := traced.storager.DeleteExpired(ctx, now)
+	span.SetAttributes(Int("result.count", count))
+	finish(span, err)
+	return count, err
+}
+
+// GetURLForAnyUserID получает URL, независимо от пользователя.
+func (traced *Storage) GetURLForAnyUserID(ctx context.Context, shortURL string) (models.SavedURL, bool, error) {
+	ctx, span := traced.start(ctx, "GetURLForAnyUserID", String("short_url", shortURL))
+	savedURL, ok, err := traced.storager.GetURLForAnyUserID(ctx, shortURL)
+	span.SetAttributes(Bool("found", ok))
+	finish(span, err)
+	return savedURL, ok, err
+}
+
+// GetURLForUserID получает URL, принадлежащий определенному пользователю.
+func (traced *Storage) GetURLForUserID(ctx context.Context, shortURL string, userID int) (models.SavedURL, bool, error) {
+	ctx, span := traced.start(ctx, "GetURLForUserID", String("short_url", shortURL), Int("user.id", userID))
+	savedURL, ok, err := traced.storager.GetURLForUserID(ctx, shortURL, userID)
+	span.SetAttributes(Bool("found", ok))
+	finish(span, err)
+	return savedURL, ok, err
+}
+
+// StoreClicks сохраняет пачку переходов по коротким URL.
+func (traced *Storage) StoreClicks(ctx context.Context, clicks []models.Click) error {
+	ctx, span := traced.start(ctx, "StoreClicks", Int("batch.size", len(clicks)))
+	err := traced.storager.StoreClicks(ctx, clicks)
+	finish(span, err)
+	return err
+}
+
+// GetClickStats возвращает статистику переходов по короткому URL пользователя.
+func (traced *Storage) GetClickStats(ctx context.Context, shortURL string, userID int) (models.ClickStats, error) {
+	ctx, span := traced.start(ctx, "GetClickStats", String("short_url", shortURL), Int("user_id", userID))
+	stats, err := traced.storager.GetClickStats(ctx, shortURL, userID)
+	finish(span, err)
+	return stats, err
+}
+
+// StoreAPIKey сохраняет новый ключ API.
+func (traced *Storage) StoreAPIKey(ctx context.Context, apiKey models.APIKey) error {
+	ctx, span := traced.start(ctx, "StoreAPIKey", String("api_key.id", apiKey.ID), Int("user_id", apiKey.UserID))
+	err := traced.storager.StoreAPIKey(ctx, apiKey)
+	finish(span, err)
+	return err
+}
+
+// GetAPIKeyByHash получает ключ API по хешу. Хеш не попадает в атрибуты спана.
+func (traced *Storage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	ctx, span := traced.start(ctx, "GetAPIKeyByHash")
+	apiKey, ok, err := traced.storager.GetAPIKeyByHash(ctx, hash)
+	span.SetAttributes(Bool("found", ok))
+	finish(span, err)
+	return apiKey, ok, err
+}
+
+// ListAPIKeys возвращает ключи API пользователя.
+func (traced *Storage) ListAPIKeys(ctx context.Context, userID int) ([]models.APIKey, error) {
+	ctx, span := traced.start(ctx, "ListAPIKeys", Int("user_id", userID))
+	apiKeys, err := traced.storager.ListAPIKeys(ctx, userID)
+	finish(span, err)
+	return apiKeys, err
+}
+
+// RevokeAPIKey отзывает ключ API пользователя.
+func (traced *Storage) RevokeAPIKey(ctx context.Context, id string, userID int, now time.Time) (bool, error) {
+	ctx, span := traced.start(ctx, "RevokeAPIKey", String("api_key.id", id), Int("user_id", userID))
+	ok, err := traced.storager.RevokeAPIKey(ctx, id, userID, now)
+	finish(span, err)
+	return ok, err
+}
+
+// TouchAPIKey запоминает время последнего использования ключа API.
+func (traced *Storage) TouchAPIKey(ctx context.Context, id string, now time.Time) error {
+	ctx, span := traced.start(ctx, "TouchAPIKey", String("api_key.id", id))
+	err := traced.storager.TouchAPIKey(ctx, id, now)
+	finish(span, err)
+	return err
+}
+
+// CreateUser регистрирует пользователя со следующим свободным идентификатором.
+func (traced *Storage) CreateUser(ctx context.Context, now time.Time) (models.User, error) {
+	ctx, span := traced.start(ctx, "CreateUser")
+	user, err := traced.storager.CreateUser(ctx, now)
+	span.SetAttributes(Int("user.id", user.ID))
+	finish(span, err)
+	return user, err
+}
+
+// GetUser получает зарегистрированного пользователя.
+func (traced *Storage) GetUser(ctx context.Context, userID int) (models.User, bool, error) {
+	ctx, span := traced.start(ctx, "GetUser", Int("user.id", userID))
+	user, ok, err := traced.storager.GetUser(ctx, userID)
+	span.SetAttributes(Bool("found", ok))
+	finish(span, err)
+	return user, ok, err
+}
+
+// TouchUser запоминает время последней активности пользователя.
+func (traced *Storage) TouchUser(ctx context.Context, userID int, now time.Time) error {
+	ctx, span := traced.start(ctx, "TouchUser", Int("user.id", userID))
+	err := traced.storager.TouchUser(ctx, userID, now)
+	finish(span, err)
+	return err
+}
+
+// ReserveCodeCounter резервирует n следующих значений счетчика коротких кодов.
+func (traced *Storage) ReserveCodeCounter(ctx context.Context, n int) (uint64, error) {
+	ctx, span := traced.start(ctx, "ReserveCodeCounter", Int("count", n))
+	first, err := traced.storager.ReserveCodeCounter(ctx, n)
+	finish(span, err)
+	return first, err
+}
+
+// CountURLs возвращает количество неудаленных коротких URL.
+func (traced *Storage) CountURLs(ctx context.Context) (int, error) {
+	ctx, span := traced.start(ctx, "CountURLs")
+	count, err := traced.storager.CountURLs(ctx)
+	span.SetAttributes(Int("result.count", count))
+	finish(span, err)
+	return count, err
+}
+
+// CountUsers возвращает количество известных пользователей.
+func (traced *Storage) CountUsers(ctx context.Context) (int, error) {
+	ctx, span := traced.start(ctx, "CountUsers")
+	count, err := traced.storager.CountUsers(ctx)
+	span.SetAttributes(Int("result.count", count))
+	finish(span, err)
+	return count, err
+}
+
+// PingContext проверяет соединение с хранилищем.
+func (traced *Storage) PingContext(ctx context.Context) error {
+	ctx, span := traced.start(ctx, "PingContext")
+	err := traced.storager.PingContext(ctx)
+	finish(span, err)
+	return err
+}
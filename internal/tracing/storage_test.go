@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theheadmen/urlShort/internal/models"
+	"github.com/theheadmen/urlShort/internal/storage"
+	"github.com/theheadmen/urlShort/internal/storage/memory"
+	"github.com/theheadmen/urlShort/internal/storage/storagetest"
+)
+
+func TestStorageConformance(t *testing.T) {
+	storagetest.RunConformance(t, func(t *testing.T) storage.Storage {
+		return NewStorage(memory.NewMemoryStorage())
+	})
+}
+
+func TestStorageSpans(t *testing.T) {
+	_, collect := setupTracer(t)
+
+	inner := memory.NewMemoryStorage()
+	storager := NewStorage(inner)
+	assert.Same(t, inner, storage.Unwrap(storager))
+
+	ctx, parent := Start(context.Background(), "POST /", SpanKindServer)
+	_, err := storager.StoreURL(ctx, models.SavedURL{ShortURL: "a", OriginalURL: "https://example.com/a", UserID: 1})
+	require.NoError(t, err)
+	_, ok, err := storager.GetURLForAnyUserID(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	parent.End()
+
+	spans := collect()
+	require.Len(t, spans, 3)
+	assert.Equal(t, "storage.StoreURL", spans[0].Name)
+	assert.Equal(t, "storage.GetURLForAnyUserID", spans[1].Name)
+	for _, span := range spans[:2] {
+		assert.Equal(t, SpanKindInternal, span.Kind)
+		assert.Equal(t, parent.SpanContext().SpanID, span.ParentSpanID)
+		assert.Equal(t, StatusUnset, span.StatusCode)
+	}
+}
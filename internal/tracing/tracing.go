@@ -0,0 +1,373 @@
+// Package tracing реализует распределенную трассировку в духе OpenTelemetry:
+// спаны для HTTP-запросов, операций хранилища и SQL, распространение контекста
+// через заголовок W3C traceparent и выгрузку спанов через подключаемый Exporter.
+//
+// Пока глобальный Tracer не задан через SetTracer, Start возвращает nil вместо спана
+// и трассировка ничего не стоит.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/theheadmen/urlShort/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultBatchSize количество спанов, после которого они отправляются в Exporter.
+	DefaultBatchSize = 512
+	// DefaultFlushInterval интервал, с которым спаны отправляются в Exporter, даже если пачка не набралась.
+	DefaultFlushInterval = time.Second
+	// defaultQueueSize размер очереди завершенных спанов, при переполнении спаны отбрасываются.
+	defaultQueueSize = 4096
+)
+
+// TraceID идентификатор трассы.
+type TraceID [16]byte
+
+// String возвращает идентификатор в шестнадцатеричном виде.
+func (traceID TraceID) String() string {
+	return hex.EncodeToString(traceID[:])
+}
+
+// IsValid проверяет, что идентификатор не нулевой.
+func (traceID TraceID) IsValid() bool {
+	return traceID != TraceID{}
+}
+
+// MarshalText кодирует идентификатор в шестнадцатеричном виде.
+func (traceID TraceID) MarshalText() ([]byte, error) {
+	return []byte(traceID.String()), nil
+}
+
+// SpanID идентификатор спана.
+type SpanID [8]byte
+
+// String возвращает идентификатор в шестнадцатеричном виде.
+func (spanID SpanID) String() string {
+	return hex.EncodeToString(spanID[:])
+}
+
+// IsValid проверяет, что идентификатор не нулевой.
+func (spanID SpanID) IsValid() bool {
+	return spanID != SpanID{}
+}
+
+// MarshalText кодирует идентификатор в шестнадцатеричном виде, нулевой идентификатор кодируется пустой строкой.
+func (spanID SpanID) MarshalText() ([]byte, error) {
+	if !spanID.IsValid() {
+		return []byte{}, nil
+	}
+	return []byte(spanID.String()), nil
+}
+
+// SpanContext неизменяемая часть спана, которая передается между сервисами.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid проверяет, что контекст содержит идентификаторы трассы и спана.
+func (spanContext SpanContext) IsValid() bool {
+	return spanContext.TraceID.IsValid() && spanContext.SpanID.IsValid()
+}
+
+// SpanKind роль спана в трассе.
+type SpanKind int
+
+// Значения SpanKind совпадают с кодами OTLP.
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// StatusCode итог операции спана.
+type StatusCode int
+
+// Значения StatusCode совпадают с кодами OTLP.
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attribute пара ключ-значение, описывающая спан. Value может быть string, int, int64, float64 или bool.
+type Attribute struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+// String создает строковый атрибут.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int создает целочисленный атрибут.
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Bool создает логический атрибут.
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// SpanData завершенный спан, который передается в Exporter.
+type SpanData struct {
+	TraceID       TraceID     `json:"trace_id"`
+	SpanID        SpanID      `json:"span_id"`
+	ParentSpanID  SpanID      `json:"parent_span_id,omitempty"`
+	Name          string      `json:"name"`
+	Kind          SpanKind    `json:"kind"`
+	Start         time.Time   `json:"start"`
+	End           time.Time   `json:"end"`
+	Attributes    []Attribute `json:"attributes,omitempty"`
+	StatusCode    StatusCode  `json:"status_code"`
+	StatusMessage string      `json:"status_message,omitempty"`
+}
+
+// Exporter отправляет завершенные спаны во внешнюю систему.
+type Exporter interface {
+	// ExportSpans отправляет пачку спанов.
+	ExportSpans(ctx context.Context, spans []SpanData) error
+	// Shutdown освобождает ресурсы экспортера.
+	Shutdown(ctx context.Context) error
+}
+
+// Span операция, которая выполняется в рамках трассы.
+// Методы безопасно вызывать на nil, это позволяет не проверять, включена ли трассировка.
+type Span struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+// SpanContext возвращает контекст спана для передачи дальше.
+func (span *Span) SpanContext() SpanContext {
+	if span == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: span.data.TraceID, SpanID: span.data.SpanID, Sampled: true}
+}
+
+// SetName меняет имя спана.
+func (span *Span) SetName(name string) {
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	span.data.Name = name
+	span.mu.Unlock()
+}
+
+// SetAttributes добавляет атрибуты спану.
+func (span *Span) SetAttributes(attributes ...Attribute) {
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	span.data.Attributes = append(span.data.Attributes, attributes...)
+	span.mu.Unlock()
+}
+
+// SetStatus задает итог операции.
+func (span *Span) SetStatus(code StatusCode, message string) {
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	span.data.StatusCode = code
+	span.data.StatusMessage = message
+	span.mu.Unlock()
+}
+
+// RecordError помечает спан ошибочным, nil игнорируется.
+func (span *Span) RecordError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+	span.SetStatus(StatusError, err.Error())
+}
+
+// End завершает спан и ставит его в очередь на отправку. Повторные вызовы игнорируются.
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+	span.mu.Lock()
+	if span.ended {
+		span.mu.Unlock()
+		return
+	}
+	span.ended = true
+	span.data.End = time.Now()
+	data := span.data
+	span.mu.Unlock()
+
+	span.tracer.enqueue(data)
+}
+
+// Tracer создает спаны и пачками отправляет завершенные спаны в Exporter.
+type Tracer struct {
+	exporter Exporter
+	queue    chan SpanData
+	stop     chan struct{}
+	done     chan struct{}
+	dropped  atomic.Int64
+	stopOnce sync.Once
+}
+
+// NewTracer создает Tracer и запускает фоновую отправку спанов в exporter.
+// Отправка останавливается в Shutdown.
+func NewTracer(exporter Exporter) *Tracer {
+	tracer := &Tracer{
+		exporter: exporter,
+		queue:    make(chan SpanData, defaultQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go tracer.run()
+	return tracer
+}
+
+// Start создает дочерний спан для спана из ctx или новую трассу, если в ctx спана нет.
+func (tracer *Tracer) Start(ctx context.Context, name string, kind SpanKind, attributes ...Attribute) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+	data := SpanData{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: attributes,
+	}
+	if parent.IsValid() {
+		data.TraceID = parent.TraceID
+		data.ParentSpanID = parent.SpanID
+	} else {
+		rand.Read(data.TraceID[:])
+	}
+	rand.Read(data.SpanID[:])
+
+	span := &Span{tracer: tracer, data: data}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// enqueue ставит завершенный спан в очередь, не блокируясь.
+func (tracer *Tracer) enqueue(data SpanData) {
+	select {
+	case tracer.queue <- data:
+	default:
+		tracer.dropped.Add(1)
+	}
+}
+
+// run собирает спаны в пачки и отправляет их в Exporter.
+func (tracer *Tracer) run() {
+	defer close(tracer.done)
+
+	ticker := time.NewTicker(DefaultFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, DefaultBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if dropped := tracer.dropped.Swap(0); dropped != 0 {
+			logger.Log.Warn("Spans are dropped, queue is full", zap.Int64("count", dropped))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := tracer.exporter.ExportSpans(ctx, batch); err != nil {
+			logger.Log.Error("Failed to export spans", zap.Int("count", len(batch)), zap.Error(err))
+		}
+		cancel()
+		batch = make([]SpanData, 0, DefaultBatchSize)
+	}
+
+	for {
+		select {
+		case data := <-tracer.queue:
+			batch = append(batch, data)
+			if len(batch) >= DefaultBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-tracer.stop:
+			// дописываем все, что успело попасть в очередь
+			for {
+				select {
+				case data := <-tracer.queue:
+					batch = append(batch, data)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Shutdown отправляет оставшиеся спаны и останавливает Exporter.
+func (tracer *Tracer) Shutdown(ctx context.Context) error {
+	tracer.stopOnce.Do(func() {
+		close(tracer.stop)
+	})
+	select {
+	case <-tracer.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return tracer.exporter.Shutdown(ctx)
+}
+
+// globalTracer Tracer, который используется функцией Start.
+var globalTracer atomic.Pointer[Tracer]
+
+// SetTracer задает глобальный Tracer, nil отключает трассировку.
+func SetTracer(tracer *Tracer) {
+	globalTracer.Store(tracer)
+}
+
+// Start создает спан глобальным Tracer. Если трассировка отключена, возвращает ctx и nil.
+func Start(ctx context.Context, name string, kind SpanKind, attributes ...Attribute) (context.Context, *Span) {
+	tracer := globalTracer.Load()
+	if tracer == nil {
+		return ctx, nil
+	}
+	return tracer.Start(ctx, name, kind, attributes...)
+}
+
+// spanKey ключ контекста для текущего спана.
+type spanKey struct{}
+
+// remoteKey ключ контекста для контекста спана, полученного от вызывающего сервиса.
+type remoteKey struct{}
+
+// SpanFromContext возвращает текущий спан из ctx или nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SpanContextFromContext возвращает контекст текущего спана или контекст, полученный от вызывающего сервиса.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext()
+	}
+	spanContext, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return spanContext
+}
+
+// ContextWithRemoteSpanContext сохраняет в ctx контекст спана вызывающего сервиса,
+// новые спаны станут его потомками.
+func ContextWithRemoteSpanContext(ctx context.Context, spanContext SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, spanContext)
+}
@@ -0,0 +1,218 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryExporter собирает выгруженные спаны в памяти.
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (exporter *memoryExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	exporter.spans = append(exporter.spans, spans...)
+	return nil
+}
+
+func (exporter *memoryExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// setupTracer задает глобальный Tracer на время теста. Спаны доступны после вызова возвращенной функции.
+func setupTracer(t *testing.T) (*memoryExporter, func() []SpanData) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer(exporter)
+	SetTracer(tracer)
+	t.Cleanup(func() { SetTracer(nil) })
+
+	return exporter, func() []SpanData {
+		require.NoError(t, tracer.Shutdown(context.Background()))
+		exporter.mu.Lock()
+		defer exporter.mu.Unlock()
+		return exporter.spans
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+		sampled bool
+	}{
+		{name: "sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sampled: true},
+		{name: "not sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+		{name: "future version with extra field", value: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", sampled: true},
+		{name: "empty", value: "", wantErr: true},
+		{name: "forbidden version", value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "extra field in version 00", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", wantErr: true},
+		{name: "zero trace id", value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", wantErr: true},
+		{name: "zero span id", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", wantErr: true},
+		{name: "upper case", value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "not hex", value: "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spanContext, err := ParseTraceparent(test.value)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spanContext.TraceID.String())
+			assert.Equal(t, "00f067aa0ba902b7", spanContext.SpanID.String())
+			assert.Equal(t, test.sampled, spanContext.Sampled)
+
+			formatted, err := ParseTraceparent(FormatTraceparent(spanContext))
+			require.NoError(t, err)
+			assert.Equal(t, spanContext, formatted)
+		})
+	}
+}
+
+func TestStartWithoutTracer(t *testing.T) {
+	ctx, span := Start(context.Background(), "noop", SpanKindInternal)
+	assert.Nil(t, span)
+	assert.Equal(t, context.Background(), ctx)
+
+	// методы nil-спана ничего не делают
+	span.SetAttributes(String("key", "value"))
+	span.RecordError(errors.New("boom"))
+	span.End()
+	assert.False(t, span.SpanContext().IsValid())
+}
+
+func TestChildSpans(t *testing.T) {
+	_, collect := setupTracer(t)
+
+	ctx, parent := Start(context.Background(), "parent", SpanKindServer)
+	_, child := Start(ctx, "child", SpanKindInternal, Int("rows", 3))
+	child.RecordError(errors.New("boom"))
+	child.End()
+	child.End()
+	parent.End()
+
+	spans := collect()
+	require.Len(t, spans, 2)
+	childData, parentData := spans[0], spans[1]
+
+	assert.Equal(t, "parent", parentData.Name)
+	assert.False(t, parentData.ParentSpanID.IsValid())
+	assert.Equal(t, StatusUnset, parentData.StatusCode)
+
+	assert.Equal(t, "child", childData.Name)
+	assert.Equal(t, parentData.TraceID, childData.TraceID)
+	assert.Equal(t, parentData.SpanID, childData.ParentSpanID)
+	assert.NotEqual(t, parentData.SpanID, childData.SpanID)
+	assert.Equal(t, []Attribute{Int("rows", 3)}, childData.Attributes)
+	assert.Equal(t, StatusError, childData.StatusCode)
+	assert.Equal(t, "boom", childData.StatusMessage)
+	assert.False(t, childData.End.Before(childData.Start))
+}
+
+func TestMiddleware(t *testing.T) {
+	_, collect := setupTracer(t)
+
+	var handlerSpanContext SpanContext
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Get("/{shortUrl}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "storage.GetURLForAnyUserID", SpanKindInternal)
+		handlerSpanContext = span.SpanContext()
+		span.End()
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	remote := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	request := httptest.NewRequest(http.MethodGet, "/1MnZAnMm", nil)
+	request.Header.Set(TraceparentHeader, remote)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	responseContext, err := ParseTraceparent(recorder.Header().Get(TraceparentHeader))
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", responseContext.TraceID.String())
+
+	spans := collect()
+	require.Len(t, spans, 2)
+	storageSpan, serverSpan := spans[0], spans[1]
+
+	assert.Equal(t, "GET /{shortUrl}", serverSpan.Name)
+	assert.Equal(t, SpanKindServer, serverSpan.Kind)
+	assert.Equal(t, responseContext.SpanID, serverSpan.SpanID)
+	assert.Equal(t, "00f067aa0ba902b7", serverSpan.ParentSpanID.String())
+	assert.Equal(t, StatusError, serverSpan.StatusCode)
+	assert.Contains(t, serverSpan.Attributes, String("http.route", "/{shortUrl}"))
+	assert.Contains(t, serverSpan.Attributes, Int("http.status_code", http.StatusInternalServerError))
+
+	assert.Equal(t, serverSpan.SpanID, storageSpan.ParentSpanID)
+	assert.Equal(t, handlerSpanContext.SpanID, storageSpan.SpanID)
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		data, _ := io.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(data, &body))
+	}))
+	defer server.Close()
+
+	span := SpanData{
+		TraceID:    TraceID{1},
+		SpanID:     SpanID{2},
+		Name:       "sql.CountUsers",
+		Kind:       SpanKindClient,
+		Attributes: []Attribute{String("db.system", "postgresql"), Int("db.rows", 1)},
+	}
+	exporter := NewOTLPExporter(server.URL)
+	require.NoError(t, exporter.ExportSpans(context.Background(), []SpanData{span}))
+	require.NoError(t, exporter.Shutdown(context.Background()))
+
+	resourceSpans := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	scopeSpans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})
+	exported := scopeSpans["spans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "01000000000000000000000000000000", exported["traceId"])
+	assert.Equal(t, "0200000000000000", exported["spanId"])
+	assert.NotContains(t, exported, "parentSpanId")
+	assert.Equal(t, "sql.CountUsers", exported["name"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"key": "db.system", "value": map[string]interface{}{"stringValue": "postgresql"}},
+		map[string]interface{}{"key": "db.rows", "value": map[string]interface{}{"intValue": "1"}},
+	}, exported["attributes"])
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	assert.Error(t, NewOTLPExporter(failing.URL).ExportSpans(context.Background(), []SpanData{span}))
+}
+
+func TestStdoutExporter(t *testing.T) {
+	var out bytes.Buffer
+	exporter := NewStdoutExporter(&out)
+	require.NoError(t, exporter.ExportSpans(context.Background(), []SpanData{{TraceID: TraceID{1}, SpanID: SpanID{2}, Name: "root"}}))
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, "01000000000000000000000000000000", decoded["trace_id"])
+	assert.Equal(t, "0200000000000000", decoded["span_id"])
+	assert.Equal(t, "", decoded["parent_span_id"])
+	assert.Equal(t, "root", decoded["name"])
+}